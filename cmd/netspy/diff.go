@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/mcp"
+)
+
+// runDiff implements `netspy diff`, which compares two time windows or two recorded cassettes
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	var (
+		ebpfServerURL = flags.String("server", "http://localhost:8080", "eBPF server URL")
+		configFile    = flags.String("config", "", "Path to JSON configuration file")
+		historyDir    = flags.String("history-dir", "", "Recorded history store (default: ~/.netspy/history)")
+		pid           = flags.Int("pid", 0, "Process ID to compare")
+		processName   = flags.String("process", "", "Process name to compare")
+		duration      = flags.Int("duration", 300, "Length of each window in seconds")
+		split         = flags.String("split", "", "Boundary between the windows (RFC 3339 or duration ago, e.g. 30m)")
+		beforeStart   = flags.String("before-start", "", "Explicit start of the before window")
+		beforeEnd     = flags.String("before-end", "", "Explicit end of the before window")
+		afterStart    = flags.String("after-start", "", "Explicit start of the after window")
+		afterEnd      = flags.String("after-end", "", "Explicit end of the after window")
+		before        = flags.String("before", "", "Cassette file to use as the before window")
+		after         = flags.String("after", "", "Cassette file to use as the after window")
+		output        = flags.String("output", "text", "Output format: text or json")
+		verbose       = flags.Bool("verbose", false, "Enable verbose logging")
+	)
+	flags.Usage = showDiffHelp
+	flags.Parse(args)
+
+	if *output != "text" && *output != "json" {
+		log.Fatalf("Invalid output format %q: use text or json", *output)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *historyDir != "" {
+		cfg.History.Dir = *historyDir
+	}
+
+	arguments := map[string]any{"duration": *duration}
+	if *pid > 0 {
+		arguments["pid"] = *pid
+	}
+	stringArguments := map[string]string{
+		"process_name":    *processName,
+		"split":           *split,
+		"before_start":    *beforeStart,
+		"before_end":      *beforeEnd,
+		"after_start":     *afterStart,
+		"after_end":       *afterEnd,
+		"before_cassette": *before,
+		"after_cassette":  *after,
+	}
+	for key, value := range stringArguments {
+		if value != "" {
+			arguments[key] = value
+		}
+	}
+
+	mcpClient := mcp.NewMCPClientWithConfig(*ebpfServerURL, *verbose, cfg)
+	result, err := mcpClient.RunSingleCommand(context.Background(), "compare_windows", arguments)
+	if err != nil {
+		log.Fatalf("Window comparison failed: %v", err)
+	}
+
+	if *output == "json" && result.StructuredContent != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result.StructuredContent); err != nil {
+			log.Fatalf("Failed to encode comparison: %v", err)
+		}
+		return
+	}
+
+	for _, content := range result.Content {
+		if textContent, ok := content.(*mcpsdk.TextContent); ok {
+			fmt.Println(textContent.Text)
+		}
+	}
+	// Errors carry no structured content; fail so scripts notice
+	if result.StructuredContent == nil {
+		os.Exit(1)
+	}
+}
+
+func showDiffHelp() {
+	fmt.Println("Usage:")
+	fmt.Println("  netspy diff [OPTIONS]")
+	fmt.Println()
+	fmt.Println("Compares two time windows, or two recorded cassettes, and reports new and vanished destinations,")
+	fmt.Println("per-process rate changes, new drop reasons and new failing destinations.")
+	fmt.Println("By default the last --duration seconds are compared with the window before them.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --server URL          eBPF server URL (default: http://localhost:8080)")
+	fmt.Println("  --config FILE         JSON configuration file")
+	fmt.Println("  --history-dir DIR     Recorded history store (default: ~/.netspy/history)")
+	fmt.Println("  --pid PID             Process ID to compare")
+	fmt.Println("  --process NAME        Process name to compare")
+	fmt.Println("  --duration SECONDS    Length of each window (default: 300)")
+	fmt.Println("  --split TIME          Boundary between the windows, e.g. the deploy time (RFC 3339 or 30m ago)")
+	fmt.Println("  --before-start TIME   Explicit before window start (also --before-end, --after-start, --after-end)")
+	fmt.Println("  --before FILE         Cassette to use as the before window")
+	fmt.Println("  --after FILE          Cassette to use as the after window")
+	fmt.Println("  --output FORMAT       text or json (default: text)")
+	fmt.Println("  --verbose             Enable verbose logging")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  netspy diff --split 2025-01-01T12:00:00Z --duration 900")
+	fmt.Println("  netspy diff --process api --split 30m --output json")
+	fmt.Println("  netspy diff --before pre-deploy.json --after post-deploy.json")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/srodi/netspy/internal/fakeserver"
+)
+
+// runFakeServer implements `netspy fake-server`, which serves the eBPF server API from a scenario
+func runFakeServer(args []string) {
+	flags := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	var (
+		addr         = flags.String("addr", "localhost:8080", "Address to listen on")
+		scenarioFile = flags.String("scenario", "", "JSON scenario file (default: built-in demo scenario)")
+		latency      = flags.Duration("latency", 0, "Delay every API response")
+		errorRate    = flags.Float64("error-rate", 0, "Fraction of API requests answered with HTTP 500 (0-1)")
+		malformed    = flags.Float64("malformed-rate", 0, "Fraction of API requests answered with malformed JSON (0-1)")
+		verbose      = flags.Bool("verbose", false, "Log every request")
+	)
+	flags.Usage = showFakeServerHelp
+	flags.Parse(args)
+
+	scenario := fakeserver.DemoScenario()
+	if *scenarioFile != "" {
+		var err error
+		if scenario, err = fakeserver.LoadScenario(*scenarioFile); err != nil {
+			log.Fatalf("Failed to load scenario: %v", err)
+		}
+	}
+
+	if *errorRate < 0 || *errorRate > 1 || *malformed < 0 || *malformed > 1 {
+		log.Fatalf("Error rates must be between 0 and 1")
+	}
+
+	fake := fakeserver.New(scenario)
+	fake.SetVerbose(*verbose)
+	if *latency > 0 {
+		fake.AddFault(fakeserver.Fault{Latency: fakeserver.Duration(*latency)})
+	}
+	if *errorRate > 0 {
+		fake.AddFault(fakeserver.Fault{Status: http.StatusInternalServerError, Rate: *errorRate})
+	}
+	if *malformed > 0 {
+		fake.AddFault(fakeserver.Fault{Malformed: true, Rate: *malformed})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: *addr, Handler: fake}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Fake eBPF server serving scenario %q on http://%s", scenario.Name, *addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Fake server failed: %v", err)
+	}
+}
+
+func showFakeServerHelp() {
+	fmt.Println("Usage:")
+	fmt.Println("  netspy fake-server [OPTIONS]")
+	fmt.Println()
+	fmt.Println("Serves the eBPF server HTTP API from a scenario timeline, for demos and development without eBPF.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --addr ADDR             Address to listen on (default: localhost:8080)")
+	fmt.Println("  --scenario FILE         JSON scenario file (default: built-in demo scenario)")
+	fmt.Println("  --latency DURATION      Delay every API response")
+	fmt.Println("  --error-rate RATE       Fraction of API requests answered with HTTP 500 (0-1)")
+	fmt.Println("  --malformed-rate RATE   Fraction of API requests answered with malformed JSON (0-1)")
+	fmt.Println("  --verbose               Log every request")
+}
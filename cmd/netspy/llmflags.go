@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// llmFlags are the AI settings shared by the main command and `netspy chat`
+type llmFlags struct {
+	provider      *string
+	model         *string
+	baseURL       *string
+	noStream      *bool
+	maxToolRounds *int
+	maxToolCalls  *int
+	timeout       *time.Duration
+	tokenBudget   *int
+	toolWorkers   *int
+	toolTimeout   *time.Duration
+	maxToolOutput *int
+	maxContext    *int
+	allowTools    *string
+	denyTools     *string
+	traceDir      *string
+}
+
+// addLLMFlags registers the AI settings on a flag set
+func addLLMFlags(flags *flag.FlagSet) *llmFlags {
+	return &llmFlags{
+		provider:      flags.String("llm-provider", "", "LLM backend for AI tools: openai, azure, ollama, anthropic or offline (default: openai)"),
+		model:         flags.String("llm-model", "", "Model for AI tools (default: per provider)"),
+		baseURL:       flags.String("llm-base-url", "", "LLM API base URL, e.g. a vLLM, LM Studio or Azure OpenAI endpoint"),
+		noStream:      flags.Bool("no-stream", false, "Wait for complete AI answers instead of printing them as they are generated"),
+		maxToolRounds: flags.Int("max-tool-rounds", 0, "Maximum tool-calling rounds per AI query, -1 for no limit (default: 8)"),
+		maxToolCalls:  flags.Int("max-tool-calls", 0, "Maximum tool calls per AI query, -1 for no limit (default: 24)"),
+		timeout:       flags.Duration("llm-timeout", 0, "Time before an AI query must answer with the data gathered so far (default: 2m)"),
+		tokenBudget:   flags.Int("token-budget", 0, "Maximum tokens per AI query, -1 for no limit (default: 150000)"),
+		toolWorkers:   flags.Int("tool-workers", 0, "Tool calls from one AI turn run concurrently (default: 4)"),
+		toolTimeout:   flags.Duration("tool-timeout", 0, "Time allowed for each AI tool call (default: 30s)"),
+		maxToolOutput: flags.Int("max-tool-output-tokens", 0, "Cut larger AI tool outputs to their head, tail and statistics, -1 for no limit (default: 4000)"),
+		maxContext:    flags.Int("max-context-tokens", 0, "Summarize older AI conversation turns beyond this size, -1 for no limit (default: 32000)"),
+		allowTools:    flags.String("allow-tools", "", "Comma-separated tools the AI analyst may call (default: all)"),
+		denyTools:     flags.String("deny-tools", "", "Comma-separated tools hidden from the AI analyst, \"none\" to hide nothing (default: contextual_analysis,ai_insights)"),
+		traceDir:      flags.String("trace-dir", "", "Save a JSON trace of every contextual analysis to this directory"),
+	}
+}
+
+// apply overrides the LLM settings of cfg with the flags that were set
+func (f *llmFlags) apply(cfg *config.Config) {
+	if *f.provider != "" {
+		cfg.LLM.Provider = *f.provider
+	}
+	if *f.model != "" {
+		cfg.LLM.Model = *f.model
+	}
+	if *f.baseURL != "" {
+		cfg.LLM.BaseURL = *f.baseURL
+	}
+	if *f.noStream {
+		cfg.LLM.DisableStreaming = true
+	}
+	if *f.maxToolRounds != 0 {
+		cfg.LLM.MaxToolRounds = *f.maxToolRounds
+	}
+	if *f.maxToolCalls != 0 {
+		cfg.LLM.MaxToolCalls = *f.maxToolCalls
+	}
+	if *f.timeout > 0 {
+		cfg.LLM.TimeoutSeconds = int((*f.timeout + time.Second - 1) / time.Second)
+	}
+	if *f.tokenBudget != 0 {
+		cfg.LLM.TokenBudget = *f.tokenBudget
+	}
+	if *f.toolWorkers > 0 {
+		cfg.LLM.ToolWorkers = *f.toolWorkers
+	}
+	if *f.toolTimeout > 0 {
+		cfg.LLM.ToolTimeoutSeconds = int((*f.toolTimeout + time.Second - 1) / time.Second)
+	}
+	if *f.maxToolOutput != 0 {
+		cfg.LLM.MaxToolResultTokens = *f.maxToolOutput
+	}
+	if *f.maxContext != 0 {
+		cfg.LLM.MaxContextTokens = *f.maxContext
+	}
+	if *f.allowTools != "" || *f.denyTools != "" {
+		filter := cfg.LLM.ToolFilter(openai.ConsumerContextualAnalysis)
+		if *f.allowTools != "" {
+			filter.Allow = openai.ParseToolList(*f.allowTools)
+		}
+		if *f.denyTools == "none" {
+			filter.Deny = []string{}
+		} else if *f.denyTools != "" {
+			filter.Deny = openai.ParseToolList(*f.denyTools)
+		}
+		if cfg.LLM.Tools == nil {
+			cfg.LLM.Tools = make(map[string]openai.ToolFilter)
+		}
+		cfg.LLM.Tools[openai.ConsumerContextualAnalysis] = filter
+	}
+	if *f.traceDir != "" {
+		cfg.LLM.TraceDir = *f.traceDir
+	}
+}
+
+// validateLLM exits when the LLM settings select an unknown or incomplete provider
+func validateLLM(cfg *config.Config) {
+	if _, err := openai.NewProvider(cfg.LLM.ProviderConfig()); err != nil {
+		log.Fatalf("Invalid LLM settings: %v", err)
+	}
+}
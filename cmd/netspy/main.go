@@ -5,25 +5,75 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 
-	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/config"
 	"github.com/srodi/netspy/internal/mcp"
+	"github.com/srodi/netspy/internal/openai"
 )
 
 func main() {
+	// Subcommands have their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "record":
+			runRecord(os.Args[2:])
+			return
+		case "scrub":
+			runScrub(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "fake-server":
+			runFakeServer(os.Args[2:])
+			return
+		case "chat":
+			runChat(os.Args[2:])
+			return
+		case "sessions":
+			runSessions(os.Args[2:])
+			return
+		}
+	}
+
 	var (
 		ebpfServerURL = flag.String("server", "http://localhost:8080", "eBPF server URL")
 		verbose       = flag.Bool("verbose", false, "Enable verbose logging")
-		mcpTool       = flag.String("tool", "", "Run a specific MCP tool (get_network_summary, list_connections, analyze_patterns, ai_insights, contextual_analysis)")
+		mcpTool       = flag.String("tool", "", "Run a specific MCP tool (get_network_summary, list_connections, analyze_patterns, detect_anomalies, compare_to_baseline, compare_windows, ai_insights, contextual_analysis)")
 		pid           = flag.Int("pid", 0, "Process ID to monitor")
 		processName   = flag.String("process", "", "Process name to monitor")
 		duration      = flag.Int("duration", 60, "Duration in seconds for monitoring")
 		maxEvents     = flag.Int("max-events", 100, "Maximum number of events to retrieve")
 		summaryText   = flag.String("summary-text", "", "Summary text for AI insights")
 		query         = flag.String("query", "", "Natural language query for intelligent analysis")
+		namespace     = flag.String("namespace", "", "Kubernetes namespace to filter by")
+		pod           = flag.String("pod", "", "Kubernetes pod name to filter by")
+		scope         = flag.String("scope", "", "Filter connections by destination class (public, private, loopback, cloud-metadata, ...)")
+		groupBy       = flag.String("group-by", "", "Group results by namespace, pod, container, process, hostname, domain, asn, country or service")
+		configFile    = flag.String("config", "", "Path to JSON configuration file")
+		podMetadata   = flag.String("pod-metadata", "", "Kubelet pods JSON or static container mapping file for pod attribution")
+		noRDNS        = flag.Bool("no-rdns", false, "Disable reverse DNS lookups for destinations (hosts file only)")
+		geoIP         = flag.String("geoip", "", "Comma-separated MaxMind DB (.mmdb) or CSV IP-range files for country/ASN enrichment")
+		minSeverity   = flag.String("min-severity", "", "Minimum anomaly severity to report (low, medium, high)")
+		learn         = flag.Bool("learn", false, "Add the compared window to the baseline (compare_to_baseline)")
+		split         = flag.String("split", "", "Boundary between compared windows, as RFC 3339 or a duration ago (compare_windows)")
+		baselineFile  = flag.String("baseline-file", "", "Baseline store (default: ~/.netspy/baselines.json)")
+		historyDir    = flag.String("history-dir", "", "Recorded history store (default: ~/.netspy/history)")
+		noHistory     = flag.Bool("no-history", false, "Only query the live eBPF server, ignoring recorded history")
+		servicesFile  = flag.String("services-file", "", "Services database for port names (default: /etc/services)")
+		recordFile    = flag.String("record-cassette", "", "Record all eBPF server traffic to a cassette file")
+		replayFile    = flag.String("replay", "", "Replay eBPF server responses from a cassette file instead of calling the server")
+		scrub         = flag.String("scrub", "", "Redact from recorded cassettes: ips, processes or all (comma-separated)")
+		keepTimes     = flag.Bool("replay-keep-times", false, "Replay event times as recorded instead of shifting them to the present")
+		trace         = flag.Bool("trace", false, "Print the LLM requests and tool calls behind a contextual_analysis answer")
+		traceFile     = flag.String("trace-file", "", "Save the contextual_analysis trace as JSON to this file")
+		output        = flag.String("output", "text", "contextual_analysis output: text, or json for a findings report")
 		help          = flag.Bool("help", false, "Show help information")
 	)
 
+	llm := addLLMFlags(flag.CommandLine)
 	flag.Parse()
 
 	if *help {
@@ -31,26 +81,87 @@ func main() {
 		return
 	}
 
+	// Load configuration, letting flags override file settings
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *podMetadata != "" {
+		cfg.Kubernetes.MetadataFile = *podMetadata
+	}
+	if *noRDNS {
+		cfg.DNS.DisableReverseLookups = true
+	}
+	if *geoIP != "" {
+		cfg.GeoIP.Databases = strings.Split(*geoIP, ",")
+	}
+	if *servicesFile != "" {
+		cfg.Services.File = *servicesFile
+	}
+	if *baselineFile != "" {
+		cfg.Baseline.File = *baselineFile
+	}
+	if *historyDir != "" {
+		cfg.History.Dir = *historyDir
+	}
+	if *noHistory {
+		cfg.History.Disabled = true
+	}
+	if *recordFile != "" {
+		cfg.Cassette.Record = *recordFile
+	}
+	if *replayFile != "" {
+		cfg.Cassette.Replay = *replayFile
+	}
+	if *scrub != "" {
+		cfg.Cassette.Scrub = *scrub
+	}
+	if *keepTimes {
+		cfg.Cassette.KeepTimes = true
+	}
+	if _, err := cfg.Cassette.Transport(); err != nil {
+		log.Fatalf("Invalid cassette settings: %v", err)
+	}
+	llm.apply(cfg)
+	validateLLM(cfg)
+	switch *output {
+	case "text":
+	case "json":
+		if *mcpTool != "contextual_analysis" {
+			log.Fatalf("--output json requires --tool contextual_analysis")
+		}
+	default:
+		log.Fatalf("Invalid --output %q: use text or json", *output)
+	}
+
 	// Setup context
 	ctx := context.Background()
 
 	// Create MCP client
-	mcpClient := mcp.NewMCPClient(*ebpfServerURL, *verbose)
+	mcpClient := mcp.NewMCPClientWithConfig(*ebpfServerURL, *verbose, cfg)
 
 	// If a specific tool is requested, run it and exit
 	if *mcpTool != "" {
-		arguments := buildMCPArguments(*pid, *processName, *duration, *maxEvents, *summaryText, *query)
+		arguments := buildMCPArguments(*pid, *processName, *duration, *maxEvents, *summaryText, *query, *namespace, *pod, *groupBy, *scope, *minSeverity, *split, *learn)
+		if *mcpTool == "contextual_analysis" {
+			arguments["trace"] = *trace
+			arguments["output"] = *output
+		}
 		result, err := mcpClient.RunSingleCommand(ctx, *mcpTool, arguments)
 		if err != nil {
 			log.Fatalf("MCP tool execution failed: %v", err)
 		}
-
-		// Print result
-		for _, content := range result.Content {
-			if textContent, ok := content.(*mcpsdk.TextContent); ok {
-				fmt.Println(textContent.Text)
+		if *traceFile != "" {
+			run, ok := result.StructuredContent.(*openai.RunResult)
+			if !ok {
+				log.Fatalf("No analysis trace to save: --trace-file requires --tool contextual_analysis")
+			}
+			if err := openai.SaveTrace(*traceFile, run); err != nil {
+				log.Fatalf("Failed to save trace: %v", err)
 			}
 		}
+
+		mcpClient.PrintResult(result)
 		return
 	}
 
@@ -70,10 +181,43 @@ func showHelp() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  netspy [OPTIONS]")
+	fmt.Println("  netspy record [OPTIONS]   Record eBPF server events into the history store (see netspy record --help)")
+	fmt.Println("  netspy scrub [OPTIONS] IN OUT   Redact IPs and process names from a cassette before sharing")
+	fmt.Println("  netspy diff [OPTIONS]     Compare two time windows or cassettes (see netspy diff --help)")
+	fmt.Println("  netspy fake-server [OPTIONS]   Serve the eBPF server API from a scenario file (see netspy fake-server --help)")
+	fmt.Println("  netspy chat [OPTIONS] [QUESTION]   Multi-turn AI conversation saved as a resumable session (see netspy chat --help)")
+	fmt.Println("  netspy sessions list|show|delete   Manage saved chat sessions")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  --server URL          eBPF server URL (default: http://localhost:8080)")
 	fmt.Println("  --verbose             Enable verbose logging")
+	fmt.Println("  --config FILE         JSON configuration file")
+	fmt.Println("  --pod-metadata FILE   Kubelet pods JSON or static container mapping for pod attribution")
+	fmt.Println("  --no-rdns             Disable reverse DNS lookups (use hosts file only)")
+	fmt.Println("  --geoip FILES         Comma-separated .mmdb or CSV IP-range databases for country/ASN enrichment")
+	fmt.Println("  --services-file FILE  Services database for port names (default: /etc/services)")
+	fmt.Println("  --baseline-file FILE  Baseline store (default: ~/.netspy/baselines.json)")
+	fmt.Println("  --history-dir DIR     Recorded history store (default: ~/.netspy/history)")
+	fmt.Println("  --no-history          Only query the live eBPF server")
+	fmt.Println("  --record-cassette FILE  Record all eBPF server traffic to a cassette file")
+	fmt.Println("  --replay FILE         Serve eBPF server responses from a cassette (works offline)")
+	fmt.Println("  --scrub LIST          Redact ips, processes or all from recorded cassettes")
+	fmt.Println("  --replay-keep-times   Keep recorded event times instead of shifting them to now")
+	fmt.Println("  --llm-provider NAME   LLM backend for AI tools: openai, azure, ollama, anthropic or offline")
+	fmt.Println("  --llm-model MODEL     Model for AI tools (default: per provider)")
+	fmt.Println("  --llm-base-url URL    LLM API base URL (vLLM, LM Studio, Azure OpenAI, remote Ollama)")
+	fmt.Println("  --no-stream           Wait for complete AI answers instead of printing them as they are generated")
+	fmt.Println("  --max-tool-rounds N   Maximum tool-calling rounds per AI query, -1 for no limit (default: 8)")
+	fmt.Println("  --max-tool-calls N    Maximum tool calls per AI query, -1 for no limit (default: 24)")
+	fmt.Println("  --llm-timeout DUR     Force an AI answer after this long, e.g. 90s (default: 2m)")
+	fmt.Println("  --token-budget N      Maximum tokens per AI query, -1 for no limit (default: 150000)")
+	fmt.Println("  --tool-workers N      Tool calls from one AI turn run concurrently (default: 4)")
+	fmt.Println("  --tool-timeout DUR    Time allowed for each AI tool call (default: 30s)")
+	fmt.Println("  --max-tool-output-tokens N  Cut larger AI tool outputs to head, tail and statistics, -1 for no limit (default: 4000)")
+	fmt.Println("  --max-context-tokens N      Summarize older AI conversation turns beyond this size, -1 for no limit (default: 32000)")
+	fmt.Println("  --allow-tools LIST    Tools the AI analyst may call, e.g. get_network_summary,list_* (default: all)")
+	fmt.Println("  --deny-tools LIST     Tools hidden from the AI analyst, or none (default: contextual_analysis,ai_insights)")
+	fmt.Println("  --trace-dir DIR       Save a JSON trace of every contextual analysis for auditing")
 	fmt.Println("  --help                Show this help message")
 	fmt.Println()
 	fmt.Println("Tool Execution (run specific tool and exit):")
@@ -83,6 +227,9 @@ func showHelp() {
 	fmt.Println("                          get_packet_drop_summary")
 	fmt.Println("                          list_packet_drops")
 	fmt.Println("                          analyze_patterns")
+	fmt.Println("                          detect_anomalies")
+	fmt.Println("                          compare_to_baseline")
+	fmt.Println("                          compare_windows")
 	fmt.Println("                          ai_insights")
 	fmt.Println("                          contextual_analysis")
 	fmt.Println()
@@ -93,6 +240,16 @@ func showHelp() {
 	fmt.Println("  --max-events COUNT    Maximum events to retrieve (default: 100)")
 	fmt.Println("  --summary-text TEXT   Summary text for AI insights")
 	fmt.Println("  --query TEXT          Natural language query for contextual analysis")
+	fmt.Println("  --namespace NS        Kubernetes namespace to filter by")
+	fmt.Println("  --pod NAME            Kubernetes pod name to filter by")
+	fmt.Println("  --scope LIST          Filter connections by destination class (comma-separated)")
+	fmt.Println("  --group-by KEY        Group by namespace, pod, container, process, hostname, domain, asn, country or service")
+	fmt.Println("  --min-severity LEVEL  Minimum anomaly severity to report: low, medium or high")
+	fmt.Println("  --learn               Add the compared window to the baseline")
+	fmt.Println("  --split TIME          Boundary between compared windows (RFC 3339 or duration ago, e.g. 30m)")
+	fmt.Println("  --trace               Print the LLM requests and tool calls behind a contextual analysis")
+	fmt.Println("  --trace-file FILE     Save the contextual analysis trace as JSON")
+	fmt.Println("  --output FORMAT       Contextual analysis output: text, or json for a findings report (default: text)")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  # Interactive mode")
@@ -101,26 +258,47 @@ func showHelp() {
 	fmt.Println("  # Run specific tool")
 	fmt.Println("  netspy --tool get_network_summary --process curl --duration 120")
 	fmt.Println("  netspy --tool list_connections --pid 1234")
+	fmt.Println("  netspy --tool list_connections --scope cloud-metadata,public")
 	fmt.Println("  netspy --tool get_packet_drop_summary --process nginx --duration 300")
 	fmt.Println("  netspy --tool list_packet_drops --pid 1234")
+	fmt.Println("  netspy --tool analyze_patterns --namespace payments --group-by pod --pod-metadata pods.json")
+	fmt.Println("  netspy --tool detect_anomalies --min-severity medium")
+	fmt.Println("  netspy --tool compare_to_baseline --process nginx --learn")
+	fmt.Println("  netspy --tool compare_windows --split 30m --duration 900")
 	fmt.Println("  netspy --tool ai_insights --summary-text \"High network activity detected\"")
 	fmt.Println("  netspy --tool contextual_analysis --query \"Analyze nginx network behavior\"")
 	fmt.Println("  netspy --tool contextual_analysis --query \"Are there any connection issues?\"")
+	fmt.Println("  netspy --tool contextual_analysis --output json --query \"Any failing connections?\" > findings.json")
+	fmt.Println()
+	fmt.Println("  # Record history in the background")
+	fmt.Println("  netspy record --interval 15s --health-addr :9090")
+	fmt.Println()
+	fmt.Println("  # Capture an incident and analyse it offline")
+	fmt.Println("  netspy --record-cassette incident.json --scrub all --tool analyze_patterns")
+	fmt.Println("  netspy --replay incident.json --tool contextual_analysis --query \"What failed?\"")
+	fmt.Println()
+	fmt.Println("  # Keep telemetry on-site with a local model or the offline rules")
+	fmt.Println("  netspy --llm-provider ollama --llm-model llama3.1 --tool contextual_analysis --query \"Any failing connections?\"")
+	fmt.Println("  netspy --llm-provider offline --tool contextual_analysis --query \"health check\" --duration 300")
 	fmt.Println()
 	fmt.Println("Interactive Commands:")
-	fmt.Println("  summary [--pid PID] [--process NAME] [--duration SECONDS]")
-	fmt.Println("  list [--pid PID] [--process NAME] [--max-events COUNT]")
+	fmt.Println("  summary [--pid PID] [--process NAME] [--duration SECONDS] [--namespace NS] [--pod NAME] [--group-by KEY]")
+	fmt.Println("  list [--pid PID] [--process NAME] [--max-events COUNT] [--scope LIST]")
 	fmt.Println("  dropsummary [--pid PID] [--process NAME] [--duration SECONDS]")
 	fmt.Println("  droplist [--pid PID] [--process NAME] [--max-events COUNT]")
-	fmt.Println("  analyze [--pid PID] [--process NAME]")
+	fmt.Println("  analyze [--pid PID] [--process NAME] [--namespace NS] [--pod NAME] [--group-by KEY]")
+	fmt.Println("  anomalies [--pid PID] [--process NAME] [--duration SECONDS] [--min-severity LEVEL]")
+	fmt.Println("  baseline [--pid PID] [--process NAME] [--duration SECONDS] [--learn]")
+	fmt.Println("  diff [--pid PID] [--process NAME] [--duration SECONDS] [--split TIME]")
 	fmt.Println("  insights <summary_text>")
-	fmt.Println("  contextual <query>     AI analysis with automatic tool usage")
+	fmt.Println("  contextual [--trace] <query>  AI analysis with automatic tool usage")
+	fmt.Println("  chat [question]        Multi-turn AI conversation (/reset, /history, /trace, /exit)")
 	fmt.Println("  tools                  Show available MCP tools")
 	fmt.Println("  help                  Show command help")
 	fmt.Println("  quit/exit             Exit interactive mode")
 }
 
-func buildMCPArguments(pid int, processName string, duration, maxEvents int, summaryText, query string) map[string]any {
+func buildMCPArguments(pid int, processName string, duration, maxEvents int, summaryText, query, namespace, pod, groupBy, scope, minSeverity, split string, learn bool) map[string]any {
 	arguments := make(map[string]any)
 
 	if pid > 0 {
@@ -141,6 +319,27 @@ func buildMCPArguments(pid int, processName string, duration, maxEvents int, sum
 	if query != "" {
 		arguments["query"] = query
 	}
+	if namespace != "" {
+		arguments["namespace"] = namespace
+	}
+	if pod != "" {
+		arguments["pod"] = pod
+	}
+	if groupBy != "" {
+		arguments["group_by"] = groupBy
+	}
+	if scope != "" {
+		arguments["scope"] = scope
+	}
+	if minSeverity != "" {
+		arguments["min_severity"] = minSeverity
+	}
+	if split != "" {
+		arguments["split"] = split
+	}
+	if learn {
+		arguments["learn"] = true
+	}
 
 	return arguments
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/k8s"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/recorder"
+	"github.com/srodi/netspy/internal/store"
+)
+
+// runRecord implements `netspy record`, which polls the eBPF server into the history store until signalled
+func runRecord(args []string) {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	var (
+		ebpfServerURL = flags.String("server", "http://localhost:8080", "eBPF server URL")
+		interval      = flags.Duration("interval", 10*time.Second, "Polling interval")
+		configFile    = flags.String("config", "", "Path to JSON configuration file")
+		historyDir    = flags.String("history-dir", "", "Recorded history store (default: ~/.netspy/history)")
+		podMetadata   = flags.String("pod-metadata", "", "Kubelet pods JSON or static container mapping file for pod attribution")
+		healthAddr    = flags.String("health-addr", "", "Serve /healthz and /status on this address (e.g. :9090)")
+		status        = flags.Bool("status", false, "Print the health of a running recorder and exit")
+		verbose       = flags.Bool("verbose", false, "Enable verbose logging")
+	)
+	flags.Usage = showRecordHelp
+	flags.Parse(args)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *historyDir != "" {
+		cfg.History.Dir = *historyDir
+	}
+	if *podMetadata != "" {
+		cfg.Kubernetes.MetadataFile = *podMetadata
+	}
+	healthFile := filepath.Join(cfg.HistoryDir(), "recorder-health.json")
+
+	if *status {
+		health, err := recorder.ReadHealth(healthFile)
+		if err != nil {
+			log.Fatalf("No recorder health available: %v", err)
+		}
+		health = health.Current(time.Now())
+		printRecorderHealth(health)
+		if !health.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	history, err := store.Open(cfg.HistoryDir(), cfg.History.Options())
+	if err != nil {
+		log.Fatalf("Failed to open history store: %v", err)
+	}
+
+	opts := recorder.DefaultOptions()
+	opts.Interval = *interval
+	opts.HealthFile = healthFile
+	opts.Verbose = *verbose
+
+	// Attribute pods while recording, since the process cgroup is gone by the time history is queried
+	var metadataSource k8s.MetadataSource
+	if cfg.Kubernetes.MetadataFile != "" {
+		fileSource, err := k8s.NewFileSource(cfg.Kubernetes.MetadataFile)
+		if err != nil {
+			log.Printf("Warning: pod metadata unavailable: %v", err)
+		} else {
+			metadataSource = fileSource
+		}
+	}
+	opts.Annotate = k8s.NewAttributorWithProcRoot(cfg.Kubernetes.ProcRoot, metadataSource).Annotate
+
+	rec := recorder.New(netclient.NewClientWithVerbose(*ebpfServerURL, *verbose), history, opts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var server *http.Server
+	if *healthAddr != "" {
+		server = &http.Server{Addr: *healthAddr, Handler: rec.Handler()}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Health endpoint failed: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("Recording %s every %s into %s", *ebpfServerURL, *interval, history.Dir())
+	if err := rec.Run(ctx); err != nil {
+		log.Fatalf("Recorder failed: %v", err)
+	}
+
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}
+
+	health := rec.Health()
+	log.Printf("Recorder stopped: %d connections and %d drops recorded", health.ConnectionsRecorded, health.DropsRecorded)
+}
+
+// printRecorderHealth prints the health of a recorder as read from its health file
+func printRecorderHealth(health recorder.Health) {
+	fmt.Printf("Status:               %s\n", health.Status)
+	fmt.Printf("Started:              %s\n", health.StartedAt.Format(time.RFC3339))
+	if !health.LastSuccess.IsZero() {
+		fmt.Printf("Last successful poll: %s (%.0fs ago)\n", health.LastSuccess.Format(time.RFC3339), health.LagSeconds)
+	}
+	if health.Status == recorder.StatusStale {
+		fmt.Println("                      the health file is out of date; is the recorder running?")
+	}
+	if health.LastError != "" {
+		fmt.Printf("Last error:           %s (%d consecutive failures)\n", health.LastError, health.ConsecutiveFailures)
+	}
+	fmt.Printf("Polls:                %d (%d failed)\n", health.Polls, health.Failures)
+	fmt.Printf("Recorded:             %d connections, %d drops (%d duplicates skipped)\n",
+		health.ConnectionsRecorded, health.DropsRecorded, health.DuplicatesSkipped)
+	if !health.NewestEvent.IsZero() {
+		fmt.Printf("Newest event:         %s (%s ago)\n", health.NewestEvent.Format(time.RFC3339), time.Since(health.NewestEvent).Round(time.Second))
+	}
+}
+
+func showRecordHelp() {
+	fmt.Println("Usage:")
+	fmt.Println("  netspy record [OPTIONS]")
+	fmt.Println()
+	fmt.Println("Polls the eBPF server and records connections and packet drops into the local history store.")
+	fmt.Println("Keeps running while the eBPF server restarts and stops cleanly on SIGINT or SIGTERM.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --server URL          eBPF server URL (default: http://localhost:8080)")
+	fmt.Println("  --interval DURATION   Polling interval (default: 10s)")
+	fmt.Println("  --config FILE         JSON configuration file")
+	fmt.Println("  --history-dir DIR     Recorded history store (default: ~/.netspy/history)")
+	fmt.Println("  --pod-metadata FILE   Kubelet pods JSON or static container mapping for pod attribution")
+	fmt.Println("  --health-addr ADDR    Serve /healthz and /status on this address")
+	fmt.Println("  --status              Print the health of a running recorder and exit")
+	fmt.Println("  --verbose             Enable verbose logging")
+}
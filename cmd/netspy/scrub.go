@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// runScrub implements `netspy scrub`, which redacts an existing cassette before it is shared
+func runScrub(args []string) {
+	flags := flag.NewFlagSet("scrub", flag.ExitOnError)
+	var (
+		ips       = flags.Bool("ips", false, "Replace IP addresses with placeholders")
+		processes = flags.Bool("processes", false, "Replace process names with placeholders")
+	)
+	flags.Usage = func() {
+		fmt.Println("Usage:")
+		fmt.Println("  netspy scrub [--ips] [--processes] IN OUT")
+		fmt.Println()
+		fmt.Println("Redacts a recorded cassette. Without options, both IPs and process names are replaced.")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := netclient.ScrubOptions{IPs: *ips, Processes: *processes}
+	if !opts.IPs && !opts.Processes {
+		opts = netclient.ScrubOptions{IPs: true, Processes: true}
+	}
+
+	cassette, err := netclient.LoadCassette(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to load cassette: %v", err)
+	}
+	if err := cassette.Scrub(opts).Save(flags.Arg(1)); err != nil {
+		log.Fatalf("Failed to save cassette: %v", err)
+	}
+	fmt.Printf("Scrubbed %d interactions into %s\n", len(cassette.Interactions), flags.Arg(1))
+}
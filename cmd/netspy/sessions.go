@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/mcp"
+	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/session"
+)
+
+// toolResultPreviewLines bounds each tool result printed by `netspy sessions show` without --full
+const toolResultPreviewLines = 8
+
+// runChat implements `netspy chat`, a multi-turn conversation with the AI analyst that is saved
+// as a session and can be resumed later
+func runChat(args []string) {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	var (
+		ebpfServerURL = flags.String("server", "http://localhost:8080", "eBPF server URL")
+		configFile    = flags.String("config", "", "Path to JSON configuration file")
+		replayFile    = flags.String("replay", "", "Replay eBPF server responses from a cassette file instead of calling the server")
+		sessionDir    = flags.String("session-dir", "", "Saved chat sessions (default: ~/.netspy/sessions)")
+		resume        = flags.String("resume", "", "Continue a saved session by ID or unique ID prefix")
+		verbose       = flags.Bool("verbose", false, "Enable verbose logging")
+	)
+	llm := addLLMFlags(flags)
+	flags.Usage = showChatHelp
+	flags.Parse(args)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *sessionDir != "" {
+		cfg.Sessions.Dir = *sessionDir
+	}
+	if *replayFile != "" {
+		cfg.Cassette.Replay = *replayFile
+	}
+	if _, err := cfg.Cassette.Transport(); err != nil {
+		log.Fatalf("Invalid cassette settings: %v", err)
+	}
+
+	// A resumed session runs with its saved LLM settings unless flags override them
+	var resumed *session.Session
+	if *resume != "" {
+		resumed, err = session.NewStore(cfg.SessionsDir()).Load(*resume)
+		if err != nil {
+			log.Fatalf("Failed to resume session: %v", err)
+		}
+		cfg.LLM = resumed.LLM
+	}
+	llm.apply(cfg)
+	validateLLM(cfg)
+	if err := mcp.CheckChatProvider(openai.ConfiguredProvider(cfg.LLM.ProviderConfig())); err != nil {
+		log.Fatalf("Cannot start chat: %v", err)
+	}
+
+	client := mcp.NewMCPClientWithConfig(*ebpfServerURL, *verbose, cfg)
+	if err := client.StartChat(context.Background(), resumed, strings.Join(flags.Args(), " ")); err != nil {
+		log.Fatalf("Chat failed: %v", err)
+	}
+}
+
+func showChatHelp() {
+	fmt.Println("Usage:")
+	fmt.Println("  netspy chat [OPTIONS] [QUESTION]")
+	fmt.Println()
+	fmt.Println("Starts a conversation with the AI analyst. Follow-up questions keep the earlier context, and")
+	fmt.Println("the conversation is saved after every answer so it can be resumed with --resume.")
+	fmt.Println("Inside chat: /reset, /history, /trace, /help and /exit.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --resume ID           Continue a saved session (ID or unique prefix, see netspy sessions list)")
+	fmt.Println("  --session-dir DIR     Saved chat sessions (default: ~/.netspy/sessions)")
+	fmt.Println("  --server URL          eBPF server URL (default: http://localhost:8080)")
+	fmt.Println("  --config FILE         JSON configuration file")
+	fmt.Println("  --replay FILE         Replay eBPF server responses from a cassette")
+	fmt.Println("  --verbose             Enable verbose logging")
+	fmt.Println("  --llm-provider, --llm-model, --llm-base-url and the other AI options of netspy --help")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  netspy chat \"Why are connections from api to postgres failing?\"")
+	fmt.Println("  netspy chat --resume 20250102-1504")
+}
+
+// runSessions implements `netspy sessions list|show|delete`
+func runSessions(args []string) {
+	flags := flag.NewFlagSet("sessions", flag.ExitOnError)
+	var (
+		configFile = flags.String("config", "", "Path to JSON configuration file")
+		sessionDir = flags.String("session-dir", "", "Saved chat sessions (default: ~/.netspy/sessions)")
+		full       = flags.Bool("full", false, "Show complete tool results (show)")
+		asJSON     = flags.Bool("json", false, "Print the saved session as JSON (show)")
+	)
+	flags.Usage = showSessionsHelp
+	if len(args) == 0 {
+		showSessionsHelp()
+		os.Exit(2)
+	}
+	command := args[0]
+	flags.Parse(args[1:])
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *sessionDir != "" {
+		cfg.Sessions.Dir = *sessionDir
+	}
+	store := session.NewStore(cfg.SessionsDir())
+
+	switch command {
+	case "list":
+		sessions, err := store.List()
+		if err != nil {
+			log.Fatalf("Failed to list sessions: %v", err)
+		}
+		if len(sessions) == 0 {
+			fmt.Printf("No saved sessions in %s\n", store.Dir())
+			return
+		}
+		fmt.Printf("%-22s  %-16s  %9s  %-28s  %s\n", "ID", "UPDATED", "QUESTIONS", "MODEL", "TITLE")
+		for _, s := range sessions {
+			title := strings.Join(strings.Fields(s.Title), " ")
+			if len(title) > 60 {
+				title = title[:60] + "…"
+			}
+			fmt.Printf("%-22s  %-16s  %9d  %-28s  %s\n", s.ID, s.UpdatedAt.Local().Format("2006-01-02 15:04"),
+				s.Questions(), s.Provider+" "+s.Model, title)
+		}
+
+	case "show":
+		if flags.NArg() != 1 {
+			showSessionsHelp()
+			os.Exit(2)
+		}
+		s, err := store.Load(flags.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to load session: %v", err)
+		}
+		if *asJSON {
+			data, err := json.MarshalIndent(s, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode session: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printSession(s, *full)
+
+	case "delete":
+		if flags.NArg() == 0 {
+			showSessionsHelp()
+			os.Exit(2)
+		}
+		for _, id := range flags.Args() {
+			deleted, err := store.Delete(id)
+			if err != nil {
+				log.Fatalf("Failed to delete session: %v", err)
+			}
+			fmt.Printf("Deleted session %s\n", deleted)
+		}
+
+	default:
+		showSessionsHelp()
+		os.Exit(2)
+	}
+}
+
+// printSession prints a saved conversation with its tool calls and the evidence they returned
+func printSession(s *session.Session, full bool) {
+	fmt.Printf("Session %s\n", s.ID)
+	fmt.Printf("  Title:     %s\n", s.Title)
+	fmt.Printf("  Model:     %s %s\n", s.Provider, s.Model)
+	fmt.Printf("  Created:   %s\n", s.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Updated:   %s\n", s.UpdatedAt.Local().Format("2006-01-02 15:04:05"))
+	tokens := 0
+	for _, run := range s.Runs {
+		tokens += run.Usage.TotalTokens
+	}
+	fmt.Printf("  Questions: %d (%d tokens used)\n", s.Questions(), tokens)
+
+	for _, message := range s.Messages {
+		text := ""
+		if message.Content != nil {
+			text = strings.TrimSpace(*message.Content)
+		}
+		switch message.Role {
+		case "system":
+			// The analyst's system prompt is the same in every session
+			if message.IsSummary() {
+				fmt.Printf("\n📝 %s\n", text)
+			}
+		case "user":
+			fmt.Printf("\n🧑 %s\n", text)
+		case "assistant":
+			if text != "" {
+				fmt.Printf("\n🤖 %s\n", text)
+			}
+			for _, call := range message.ToolCalls {
+				fmt.Printf("🔧 %s %s\n", call.Function.Name, call.Function.Arguments)
+			}
+		case "tool":
+			lines := strings.Split(text, "\n")
+			if !full && len(lines) > toolResultPreviewLines {
+				lines = append(lines[:toolResultPreviewLines], fmt.Sprintf("… %d more lines (use --full)", len(lines)-toolResultPreviewLines))
+			}
+			for _, line := range lines {
+				fmt.Printf("   │ %s\n", line)
+			}
+		}
+	}
+}
+
+func showSessionsHelp() {
+	fmt.Println("Usage:")
+	fmt.Println("  netspy sessions list [OPTIONS]")
+	fmt.Println("  netspy sessions show [OPTIONS] ID")
+	fmt.Println("  netspy sessions delete [OPTIONS] ID...")
+	fmt.Println()
+	fmt.Println("Lists, prints or removes chat sessions saved by netspy chat. IDs may be shortened to a unique prefix.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --session-dir DIR     Saved chat sessions (default: ~/.netspy/sessions)")
+	fmt.Println("  --config FILE         JSON configuration file")
+	fmt.Println("  --full                Show complete tool results (show)")
+	fmt.Println("  --json                Print the saved session as JSON, including traces (show)")
+}
@@ -0,0 +1,440 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// Severity ranks how urgently an anomaly should be looked at
+type Severity string
+
+const (
+	SeverityLow    Severity = "low"
+	SeverityMedium Severity = "medium"
+	SeverityHigh   Severity = "high"
+)
+
+// severityRank orders severities for filtering and sorting
+var severityRank = map[Severity]int{SeverityLow: 1, SeverityMedium: 2, SeverityHigh: 3}
+
+// ParseSeverity converts a severity name, returning false for unknown names
+func ParseSeverity(name string) (Severity, bool) {
+	severity := Severity(name)
+	_, ok := severityRank[severity]
+	return severity, ok
+}
+
+// AtLeast reports whether the severity is at or above the given minimum
+func (s Severity) AtLeast(min Severity) bool {
+	return severityRank[s] >= severityRank[min]
+}
+
+// Anomaly types reported by DetectAnomalies
+const (
+	AnomalyHorizontalScan  = "horizontal_scan"
+	AnomalyVerticalScan    = "vertical_scan"
+	AnomalyBurst           = "burst"
+	AnomalyConnectionStorm = "connection_storm"
+)
+
+// errInProgress is -EINPROGRESS, returned by non-blocking connects that have not failed
+const errInProgress = -115
+
+// AnomalyOptions tunes anomaly detection thresholds
+type AnomalyOptions struct {
+	// ScanWindow is the time window in which scan targets are counted
+	ScanWindow time.Duration
+	// HorizontalScanMinIPs is the number of distinct IPs on one port that makes a horizontal scan
+	HorizontalScanMinIPs int
+	// VerticalScanMinPorts is the number of distinct ports on one IP that makes a vertical scan
+	VerticalScanMinPorts int
+	// BurstWindow is the time window in which a process's burst rate is measured
+	BurstWindow time.Duration
+	// BurstMinEvents is the minimum number of connections in a burst window
+	BurstMinEvents int
+	// BurstFactor is how many times the process's own rate a burst must reach
+	BurstFactor float64
+	// StormWindow is the time window in which failed connection retries are counted
+	StormWindow time.Duration
+	// StormMinAttempts is the minimum number of attempts, most of them failing, that make a storm
+	StormMinAttempts int
+}
+
+// DefaultAnomalyOptions returns the thresholds used by detect_anomalies
+func DefaultAnomalyOptions() AnomalyOptions {
+	return AnomalyOptions{
+		ScanWindow:           time.Minute,
+		HorizontalScanMinIPs: 20,
+		VerticalScanMinPorts: 15,
+		BurstWindow:          10 * time.Second,
+		BurstMinEvents:       20,
+		BurstFactor:          5,
+		StormWindow:          10 * time.Second,
+		StormMinAttempts:     20,
+	}
+}
+
+// Evidence holds the measurements that support an anomaly
+type Evidence struct {
+	Events        int      `json:"events"`
+	DistinctIPs   int      `json:"distinct_ips,omitempty"`
+	DistinctPorts int      `json:"distinct_ports,omitempty"`
+	Failures      int      `json:"failures,omitempty"`
+	WindowSeconds float64  `json:"window_seconds"`
+	Rate          float64  `json:"rate_per_second,omitempty"`
+	BaselineRate  float64  `json:"baseline_rate_per_second,omitempty"`
+	ErrorCodes    []int32  `json:"error_codes,omitempty"`
+	Samples       []string `json:"samples,omitempty"`
+}
+
+// Anomaly is a behavioural finding about one process
+type Anomaly struct {
+	Type        string    `json:"type"`
+	Severity    Severity  `json:"severity"`
+	PID         uint32    `json:"pid"`
+	Command     string    `json:"command"`
+	Target      string    `json:"target"`
+	Description string    `json:"description"`
+	Evidence    Evidence  `json:"evidence"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+}
+
+// maxSamples bounds the example destinations kept as evidence
+const maxSamples = 5
+
+// DetectAnomalies looks for horizontal and vertical scans, connection bursts and retry storms,
+// most severe first
+func DetectAnomalies(events []netclient.ConnectionEvent, opts AnomalyOptions) []Anomaly {
+	type processKey struct {
+		pid     uint32
+		command string
+	}
+
+	byProcess := make(map[processKey][]netclient.ConnectionEvent)
+	for _, event := range events {
+		key := processKey{event.PID, event.Command}
+		byProcess[key] = append(byProcess[key], event)
+	}
+
+	var anomalies []Anomaly
+	for _, processEvents := range byProcess {
+		sort.Slice(processEvents, func(i, j int) bool {
+			return EventTime(processEvents[i]).Before(EventTime(processEvents[j]))
+		})
+		anomalies = append(anomalies, detectHorizontalScans(processEvents, opts)...)
+		anomalies = append(anomalies, detectVerticalScans(processEvents, opts)...)
+		if a, ok := detectBurst(processEvents, opts); ok {
+			anomalies = append(anomalies, a)
+		}
+		if a, ok := detectStorm(processEvents, opts); ok {
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].Severity != anomalies[j].Severity {
+			return severityRank[anomalies[i].Severity] > severityRank[anomalies[j].Severity]
+		}
+		if anomalies[i].Command != anomalies[j].Command {
+			return anomalies[i].Command < anomalies[j].Command
+		}
+		return anomalies[i].Type < anomalies[j].Type
+	})
+	return anomalies
+}
+
+// FilterAnomalies keeps anomalies at or above the given severity
+func FilterAnomalies(anomalies []Anomaly, min Severity) []Anomaly {
+	var filtered []Anomaly
+	for _, a := range anomalies {
+		if a.Severity.AtLeast(min) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// detectHorizontalScans finds one process reaching many IPs on the same port
+func detectHorizontalScans(events []netclient.ConnectionEvent, opts AnomalyOptions) []Anomaly {
+	byPort := make(map[uint16][]netclient.ConnectionEvent)
+	for _, event := range events {
+		if event.DestinationIP != "" {
+			byPort[event.DestinationPort] = append(byPort[event.DestinationPort], event)
+		}
+	}
+
+	var anomalies []Anomaly
+	for port, portEvents := range byPort {
+		distinct, from, to := maxDistinctInWindow(portEvents, opts.ScanWindow, func(e netclient.ConnectionEvent) string {
+			return e.DestinationIP
+		})
+		if distinct < opts.HorizontalScanMinIPs {
+			continue
+		}
+
+		window := portEvents[from:to]
+		severity := SeverityMedium
+		if distinct >= 5*opts.HorizontalScanMinIPs {
+			severity = SeverityHigh
+		}
+		anomalies = append(anomalies, Anomaly{
+			Type:        AnomalyHorizontalScan,
+			Severity:    severity,
+			PID:         window[0].PID,
+			Command:     window[0].Command,
+			Target:      fmt.Sprintf("port %d", port),
+			Description: fmt.Sprintf("connected to %d distinct IPs on port %d within %s", distinct, port, opts.ScanWindow),
+			Evidence: Evidence{
+				Events:        len(window),
+				DistinctIPs:   distinct,
+				Failures:      countFailures(window),
+				WindowSeconds: spanSeconds(window),
+				Samples:       sampleDestinations(window),
+			},
+			Start: EventTime(window[0]),
+			End:   EventTime(window[len(window)-1]),
+		})
+	}
+	return anomalies
+}
+
+// detectVerticalScans finds one process probing many ports on the same IP
+func detectVerticalScans(events []netclient.ConnectionEvent, opts AnomalyOptions) []Anomaly {
+	byIP := make(map[string][]netclient.ConnectionEvent)
+	for _, event := range events {
+		if event.DestinationIP != "" {
+			byIP[event.DestinationIP] = append(byIP[event.DestinationIP], event)
+		}
+	}
+
+	var anomalies []Anomaly
+	for ip, ipEvents := range byIP {
+		distinct, from, to := maxDistinctInWindow(ipEvents, opts.ScanWindow, func(e netclient.ConnectionEvent) string {
+			return fmt.Sprintf("%d", e.DestinationPort)
+		})
+		if distinct < opts.VerticalScanMinPorts {
+			continue
+		}
+
+		window := ipEvents[from:to]
+		severity := SeverityMedium
+		if distinct >= 100 {
+			severity = SeverityHigh
+		}
+		anomalies = append(anomalies, Anomaly{
+			Type:        AnomalyVerticalScan,
+			Severity:    severity,
+			PID:         window[0].PID,
+			Command:     window[0].Command,
+			Target:      ip,
+			Description: fmt.Sprintf("connected to %d distinct ports on %s within %s", distinct, ip, opts.ScanWindow),
+			Evidence: Evidence{
+				Events:        len(window),
+				DistinctPorts: distinct,
+				Failures:      countFailures(window),
+				WindowSeconds: spanSeconds(window),
+				Samples:       sampleDestinations(window),
+			},
+			Start: EventTime(window[0]),
+			End:   EventTime(window[len(window)-1]),
+		})
+	}
+	return anomalies
+}
+
+// detectBurst compares a process's busiest window with its rate over the rest of the timeline
+func detectBurst(events []netclient.ConnectionEvent, opts AnomalyOptions) (Anomaly, bool) {
+	total := len(events)
+	span := EventTime(events[total-1]).Sub(EventTime(events[0]))
+	// Without enough history outside the burst window there is no rate to compare against
+	if total < opts.BurstMinEvents || span < 3*opts.BurstWindow {
+		return Anomaly{}, false
+	}
+
+	count, from, to := maxCountInWindow(events, opts.BurstWindow, func(netclient.ConnectionEvent) bool { return true })
+	if count < opts.BurstMinEvents {
+		return Anomaly{}, false
+	}
+
+	rate := float64(count) / opts.BurstWindow.Seconds()
+	baseline := float64(total-count) / (span - opts.BurstWindow).Seconds()
+	if baseline > 0 && rate < opts.BurstFactor*baseline {
+		return Anomaly{}, false
+	}
+
+	severity := SeverityLow
+	switch {
+	case baseline == 0 || rate >= 4*opts.BurstFactor*baseline:
+		severity = SeverityHigh
+	case rate >= 2*opts.BurstFactor*baseline:
+		severity = SeverityMedium
+	}
+
+	window := events[from:to]
+	description := fmt.Sprintf("%d connections in %s (%.1f/s, %.0fx its usual %.2f/s)",
+		count, opts.BurstWindow, rate, rate/baseline, baseline)
+	if baseline == 0 {
+		description = fmt.Sprintf("%d connections in %s (%.1f/s) with no other activity", count, opts.BurstWindow, rate)
+	}
+	return Anomaly{
+		Type:        AnomalyBurst,
+		Severity:    severity,
+		PID:         window[0].PID,
+		Command:     window[0].Command,
+		Target:      "all destinations",
+		Description: description,
+		Evidence: Evidence{
+			Events:        count,
+			DistinctIPs:   distinctCount(window, func(e netclient.ConnectionEvent) string { return e.DestinationIP }),
+			Failures:      countFailures(window),
+			WindowSeconds: opts.BurstWindow.Seconds(),
+			Rate:          rate,
+			BaselineRate:  baseline,
+			Samples:       sampleDestinations(window),
+		},
+		Start: EventTime(window[0]),
+		End:   EventTime(window[len(window)-1]),
+	}, true
+}
+
+// detectStorm finds rapid retries after failed connections
+func detectStorm(events []netclient.ConnectionEvent, opts AnomalyOptions) (Anomaly, bool) {
+	failures, from, to := maxCountInWindow(events, opts.StormWindow, IsFailure)
+	window := events[from:to]
+	// A storm needs enough attempts in the window, most of them failing
+	if len(window) < opts.StormMinAttempts || failures*2 < len(window) {
+		return Anomaly{}, false
+	}
+
+	severity := SeverityMedium
+	if len(window) >= 5*opts.StormMinAttempts {
+		severity = SeverityHigh
+	}
+
+	codes := make(map[int32]bool)
+	var errorCodes []int32
+	for _, event := range window {
+		if IsFailure(event) && !codes[event.ReturnCode] {
+			codes[event.ReturnCode] = true
+			errorCodes = append(errorCodes, event.ReturnCode)
+		}
+	}
+	sort.Slice(errorCodes, func(i, j int) bool { return errorCodes[i] < errorCodes[j] })
+
+	span := spanSeconds(window)
+	return Anomaly{
+		Type:        AnomalyConnectionStorm,
+		Severity:    severity,
+		PID:         window[0].PID,
+		Command:     window[0].Command,
+		Target:      "all destinations",
+		Description: fmt.Sprintf("%d connection attempts in %.1fs, %d of them failed", len(window), span, failures),
+		Evidence: Evidence{
+			Events:        len(window),
+			DistinctIPs:   distinctCount(window, func(e netclient.ConnectionEvent) string { return e.DestinationIP }),
+			Failures:      failures,
+			WindowSeconds: span,
+			ErrorCodes:    errorCodes,
+			Samples:       sampleDestinations(window),
+		},
+		Start: EventTime(window[0]),
+		End:   EventTime(window[len(window)-1]),
+	}, true
+}
+
+// IsFailure reports whether a connect call failed
+func IsFailure(event netclient.ConnectionEvent) bool {
+	return event.ReturnCode < 0 && event.ReturnCode != errInProgress
+}
+
+// maxDistinctInWindow slides a time window over sorted events and returns the largest number of
+// distinct keys seen within it, with the window's index range
+func maxDistinctInWindow(events []netclient.ConnectionEvent, window time.Duration, key func(netclient.ConnectionEvent) string) (int, int, int) {
+	counts := make(map[string]int)
+	best, bestFrom, bestTo := 0, 0, 0
+	from := 0
+	for to, event := range events {
+		counts[key(event)]++
+		for EventTime(event).Sub(EventTime(events[from])) > window {
+			k := key(events[from])
+			counts[k]--
+			if counts[k] == 0 {
+				delete(counts, k)
+			}
+			from++
+		}
+		if len(counts) > best {
+			best, bestFrom, bestTo = len(counts), from, to+1
+		}
+	}
+	return best, bestFrom, bestTo
+}
+
+// maxCountInWindow slides a time window over sorted events and returns the largest number of
+// matching events within it, with the window's index range
+func maxCountInWindow(events []netclient.ConnectionEvent, window time.Duration, match func(netclient.ConnectionEvent) bool) (int, int, int) {
+	count, best, bestFrom, bestTo := 0, 0, 0, 0
+	from := 0
+	for to, event := range events {
+		if match(event) {
+			count++
+		}
+		for EventTime(event).Sub(EventTime(events[from])) > window {
+			if match(events[from]) {
+				count--
+			}
+			from++
+		}
+		if count > best {
+			best, bestFrom, bestTo = count, from, to+1
+		}
+	}
+	return best, bestFrom, bestTo
+}
+
+func distinctCount(events []netclient.ConnectionEvent, key func(netclient.ConnectionEvent) string) int {
+	seen := make(map[string]bool)
+	for _, event := range events {
+		seen[key(event)] = true
+	}
+	return len(seen)
+}
+
+func countFailures(events []netclient.ConnectionEvent) int {
+	failures := 0
+	for _, event := range events {
+		if IsFailure(event) {
+			failures++
+		}
+	}
+	return failures
+}
+
+func spanSeconds(events []netclient.ConnectionEvent) float64 {
+	if len(events) == 0 {
+		return 0
+	}
+	return EventTime(events[len(events)-1]).Sub(EventTime(events[0])).Seconds()
+}
+
+// sampleDestinations returns the first few distinct destinations of the events
+func sampleDestinations(events []netclient.ConnectionEvent) []string {
+	seen := make(map[string]bool)
+	var samples []string
+	for _, event := range events {
+		dest := fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)
+		if event.DestinationIP == "" || seen[dest] {
+			continue
+		}
+		seen[dest] = true
+		samples = append(samples, dest)
+		if len(samples) == maxSamples {
+			break
+		}
+	}
+	return samples
+}
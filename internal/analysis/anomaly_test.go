@@ -0,0 +1,151 @@
+package analysis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+func connectEvent(pid uint32, command, ip string, port uint16, offset time.Duration, returnCode int32) netclient.ConnectionEvent {
+	return netclient.ConnectionEvent{
+		PID:             pid,
+		Command:         command,
+		DestinationIP:   ip,
+		DestinationPort: port,
+		Protocol:        "TCP",
+		ReturnCode:      returnCode,
+		WallTime:        timelineStart.Add(offset),
+	}
+}
+
+func findAnomaly(anomalies []Anomaly, anomalyType string) (Anomaly, bool) {
+	for _, a := range anomalies {
+		if a.Type == anomalyType {
+			return a, true
+		}
+	}
+	return Anomaly{}, false
+}
+
+func TestDetectAnomalies_HorizontalScan(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	for i := 0; i < 30; i++ {
+		events = append(events, connectEvent(100, "scanner", fmt.Sprintf("10.0.0.%d", i+1), 22, time.Duration(i)*time.Second, -111))
+	}
+
+	anomalies := DetectAnomalies(events, DefaultAnomalyOptions())
+	a, ok := findAnomaly(anomalies, AnomalyHorizontalScan)
+	if !ok {
+		t.Fatalf("expected horizontal scan, got %+v", anomalies)
+	}
+	if a.Severity != SeverityMedium || a.Target != "port 22" || a.Evidence.DistinctIPs != 30 || a.Evidence.Failures != 30 {
+		t.Errorf("unexpected horizontal scan finding: %+v", a)
+	}
+	if len(a.Evidence.Samples) != maxSamples {
+		t.Errorf("expected %d sample destinations, got %v", maxSamples, a.Evidence.Samples)
+	}
+	if _, ok := findAnomaly(anomalies, AnomalyVerticalScan); ok {
+		t.Errorf("did not expect a vertical scan: %+v", anomalies)
+	}
+}
+
+func TestDetectAnomalies_ScanOutsideWindow(t *testing.T) {
+	// The same 30 IPs spread over half an hour is not a scan
+	var events []netclient.ConnectionEvent
+	for i := 0; i < 30; i++ {
+		events = append(events, connectEvent(100, "monitor", fmt.Sprintf("10.0.0.%d", i+1), 443, time.Duration(i)*time.Minute, 0))
+	}
+	if anomalies := DetectAnomalies(events, DefaultAnomalyOptions()); len(anomalies) != 0 {
+		t.Errorf("expected no anomalies, got %+v", anomalies)
+	}
+}
+
+func TestDetectAnomalies_VerticalScan(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	for port := 1; port <= 120; port++ {
+		events = append(events, connectEvent(200, "nmap", "192.168.1.10", uint16(port), time.Duration(port)*100*time.Millisecond, -111))
+	}
+
+	a, ok := findAnomaly(DetectAnomalies(events, DefaultAnomalyOptions()), AnomalyVerticalScan)
+	if !ok {
+		t.Fatal("expected vertical scan")
+	}
+	if a.Severity != SeverityHigh || a.Target != "192.168.1.10" || a.Evidence.DistinctPorts != 120 {
+		t.Errorf("unexpected vertical scan finding: %+v", a)
+	}
+}
+
+func TestDetectAnomalies_Burst(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	// One connection every 10 seconds for 10 minutes...
+	for i := 0; i < 60; i++ {
+		events = append(events, connectEvent(300, "app", "10.0.0.5", 5432, time.Duration(i)*10*time.Second, 0))
+	}
+	// ...then 50 connections in 5 seconds
+	for i := 0; i < 50; i++ {
+		events = append(events, connectEvent(300, "app", "10.0.0.5", 5432, 5*time.Minute+time.Duration(i)*100*time.Millisecond, 0))
+	}
+
+	a, ok := findAnomaly(DetectAnomalies(events, DefaultAnomalyOptions()), AnomalyBurst)
+	if !ok {
+		t.Fatal("expected burst")
+	}
+	if a.Severity != SeverityHigh || a.Evidence.Events < 50 || a.Evidence.BaselineRate <= 0 || a.Evidence.Rate <= a.Evidence.BaselineRate {
+		t.Errorf("unexpected burst finding: %+v", a)
+	}
+}
+
+func TestDetectAnomalies_SteadyRateIsNotBurst(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	for i := 0; i < 300; i++ {
+		events = append(events, connectEvent(300, "app", "10.0.0.5", 5432, time.Duration(i)*time.Second, 0))
+	}
+	if anomalies := DetectAnomalies(events, DefaultAnomalyOptions()); len(anomalies) != 0 {
+		t.Errorf("expected no anomalies for a steady rate, got %+v", anomalies)
+	}
+}
+
+func TestDetectAnomalies_ConnectionStorm(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	for i := 0; i < 40; i++ {
+		code := int32(-111)
+		if i%4 == 0 {
+			code = -110
+		}
+		events = append(events, connectEvent(400, "client", "10.0.0.7", 8080, time.Duration(i)*200*time.Millisecond, code))
+	}
+	// Non-blocking connects in progress are not failures
+	for i := 0; i < 40; i++ {
+		events = append(events, connectEvent(401, "proxy", "10.0.0.8", 8080, time.Duration(i)*200*time.Millisecond, errInProgress))
+	}
+
+	anomalies := DetectAnomalies(events, DefaultAnomalyOptions())
+	a, ok := findAnomaly(anomalies, AnomalyConnectionStorm)
+	if !ok {
+		t.Fatalf("expected connection storm, got %+v", anomalies)
+	}
+	if a.Command != "client" || a.Evidence.Failures != 40 || len(a.Evidence.ErrorCodes) != 2 || a.Evidence.ErrorCodes[0] != -111 {
+		t.Errorf("unexpected storm finding: %+v", a)
+	}
+	for _, a := range anomalies {
+		if a.Command == "proxy" && a.Type == AnomalyConnectionStorm {
+			t.Errorf("in-progress connects should not count as failures: %+v", a)
+		}
+	}
+}
+
+func TestFilterAnomalies(t *testing.T) {
+	anomalies := []Anomaly{
+		{Type: AnomalyBurst, Severity: SeverityLow},
+		{Type: AnomalyHorizontalScan, Severity: SeverityMedium},
+		{Type: AnomalyVerticalScan, Severity: SeverityHigh},
+	}
+	if got := FilterAnomalies(anomalies, SeverityMedium); len(got) != 2 {
+		t.Errorf("expected 2 anomalies at medium or above, got %+v", got)
+	}
+	if _, ok := ParseSeverity("critical"); ok {
+		t.Error("expected unknown severity to be rejected")
+	}
+}
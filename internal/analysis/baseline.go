@@ -0,0 +1,334 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// baselineVersion is the on-disk format version of the baseline store
+const baselineVersion = 1
+
+// maxBaselineEntries bounds the destinations and ports remembered per process
+const maxBaselineEntries = 1000
+
+// DefaultMinSnapshots is the number of learned windows before a baseline is trusted
+const DefaultMinSnapshots = 3
+
+// Window is an observation period of connections and packet drops
+type Window struct {
+	Start       time.Time
+	End         time.Time
+	Connections []netclient.ConnectionEvent
+	Drops       []netclient.PacketDropInfo
+}
+
+// Minutes returns the window length in minutes, at least one second
+func (w Window) Minutes() float64 {
+	return math.Max(w.End.Sub(w.Start).Minutes(), 1.0/60)
+}
+
+// ProcessBaseline is the learned normal behaviour of one process name
+type ProcessBaseline struct {
+	Command      string         `json:"command"`
+	Snapshots    int            `json:"snapshots"`
+	Destinations map[string]int `json:"destinations"`
+	Ports        map[string]int `json:"ports"`
+	FailureModes map[string]int `json:"failure_modes,omitempty"`
+	MinRate      float64        `json:"min_rate_per_minute"`
+	MaxRate      float64        `json:"max_rate_per_minute"`
+	MaxDropRate  float64        `json:"max_drop_rate_per_minute"`
+	FirstSeen    time.Time      `json:"first_seen"`
+	LastSeen     time.Time      `json:"last_seen"`
+}
+
+// Baseline holds learned behaviour for every observed process, keyed by process name
+type Baseline struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// LearnedUntil is the end of the recorded history already folded into the baseline
+	LearnedUntil time.Time                   `json:"learned_until,omitempty"`
+	Processes    map[string]*ProcessBaseline `json:"processes"`
+}
+
+// NewBaseline creates an empty baseline
+func NewBaseline() *Baseline {
+	return &Baseline{
+		Version:   baselineVersion,
+		Processes: make(map[string]*ProcessBaseline),
+	}
+}
+
+// LoadBaseline reads a baseline file. A missing file yields an empty baseline.
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewBaseline(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %v", err)
+	}
+
+	baseline := NewBaseline()
+	if err := json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %v", path, err)
+	}
+	if baseline.Version != baselineVersion {
+		return nil, fmt.Errorf("unsupported baseline version %d in %s", baseline.Version, path)
+	}
+	if baseline.Processes == nil {
+		baseline.Processes = make(map[string]*ProcessBaseline)
+	}
+	return baseline, nil
+}
+
+// Save writes the baseline atomically, creating the parent directory if needed
+func (b *Baseline) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create baseline directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode baseline: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write baseline: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write baseline: %v", err)
+	}
+	return nil
+}
+
+// processActivity is the behaviour of one process within a window
+type processActivity struct {
+	connections  int
+	drops        int
+	destinations map[string]int
+	ports        map[string]int
+	failureModes map[string]int
+}
+
+// summarizeWindow groups a window's activity by process name
+func summarizeWindow(window Window) map[string]*processActivity {
+	activity := make(map[string]*processActivity)
+	get := func(command string) *processActivity {
+		a, exists := activity[command]
+		if !exists {
+			a = &processActivity{
+				destinations: make(map[string]int),
+				ports:        make(map[string]int),
+				failureModes: make(map[string]int),
+			}
+			activity[command] = a
+		}
+		return a
+	}
+
+	for _, event := range window.Connections {
+		a := get(event.Command)
+		a.connections++
+		if event.DestinationIP != "" {
+			a.destinations[fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)]++
+			a.ports[fmt.Sprintf("%d/%s", event.DestinationPort, strings.ToLower(event.Protocol))]++
+		}
+		if IsFailure(event) {
+			a.failureModes[fmt.Sprintf("connect error %d", event.ReturnCode)]++
+		}
+	}
+	for _, drop := range window.Drops {
+		a := get(drop.Command)
+		a.drops++
+		a.failureModes["packet drop: "+drop.Reason]++
+	}
+	return activity
+}
+
+// Learn folds a window into the baseline
+func (b *Baseline) Learn(window Window) {
+	minutes := window.Minutes()
+	for command, a := range summarizeWindow(window) {
+		p, exists := b.Processes[command]
+		if !exists {
+			p = &ProcessBaseline{
+				Command:      command,
+				Destinations: make(map[string]int),
+				Ports:        make(map[string]int),
+				FailureModes: make(map[string]int),
+				FirstSeen:    window.Start,
+			}
+			b.Processes[command] = p
+		}
+		if p.FailureModes == nil {
+			p.FailureModes = make(map[string]int)
+		}
+
+		rate := float64(a.connections) / minutes
+		if p.Snapshots == 0 || rate < p.MinRate {
+			p.MinRate = rate
+		}
+		p.MaxRate = math.Max(p.MaxRate, rate)
+		p.MaxDropRate = math.Max(p.MaxDropRate, float64(a.drops)/minutes)
+
+		mergeCounts(p.Destinations, a.destinations)
+		mergeCounts(p.Ports, a.ports)
+		mergeCounts(p.FailureModes, a.failureModes)
+		p.Snapshots++
+		p.LastSeen = window.End
+	}
+	b.UpdatedAt = window.End
+}
+
+// mergeCounts adds counts to a bounded map; new keys are dropped once the map is full
+func mergeCounts(dst, src map[string]int) {
+	for key, count := range src {
+		if _, exists := dst[key]; !exists && len(dst) >= maxBaselineEntries {
+			continue
+		}
+		dst[key] += count
+	}
+}
+
+// Baseline comparison statuses
+const (
+	StatusNewProcess = "new_process"
+	StatusLearning   = "learning"
+	StatusNormal     = "normal"
+	StatusDeviating  = "deviating"
+)
+
+// deviationThreshold is the score from which a process is reported as deviating
+const deviationThreshold = 25
+
+// rateSpikeFactor is how far above its highest learned rate a process must go to spike
+const rateSpikeFactor = 1.5
+
+// Deviation scores one process's window against its baseline
+type Deviation struct {
+	Command         string   `json:"command"`
+	Status          string   `json:"status"`
+	Score           int      `json:"score"`
+	Snapshots       int      `json:"snapshots"`
+	Connections     int      `json:"connections"`
+	Rate            float64  `json:"rate_per_minute"`
+	BaselineMinRate float64  `json:"baseline_min_rate_per_minute"`
+	BaselineMaxRate float64  `json:"baseline_max_rate_per_minute"`
+	RateSpike       bool     `json:"rate_spike"`
+	DropRate        float64  `json:"drop_rate_per_minute"`
+	DropSpike       bool     `json:"drop_spike"`
+	NewDestinations []string `json:"new_destinations,omitempty"`
+	NewPorts        []string `json:"new_ports,omitempty"`
+	NewFailureModes []string `json:"new_failure_modes,omitempty"`
+}
+
+// Compare scores each process active in the window against the baseline, highest score first.
+// Scores run from 0 (as usual) to 100 and weigh the share of traffic to new destinations, new
+// ports, rate and drop spikes, and failure modes never seen before.
+func (b *Baseline) Compare(window Window, minSnapshots int) []Deviation {
+	if minSnapshots <= 0 {
+		minSnapshots = DefaultMinSnapshots
+	}
+	minutes := window.Minutes()
+
+	var deviations []Deviation
+	for command, a := range summarizeWindow(window) {
+		d := Deviation{
+			Command:     command,
+			Connections: a.connections,
+			Rate:        round2(float64(a.connections) / minutes),
+			DropRate:    round2(float64(a.drops) / minutes),
+		}
+
+		p, known := b.Processes[command]
+		if !known {
+			d.Status = StatusNewProcess
+			// A process never seen is only notable once the baseline has learned anything
+			if len(b.Processes) > 0 {
+				d.Score = 50
+			}
+			deviations = append(deviations, d)
+			continue
+		}
+
+		d.Snapshots = p.Snapshots
+		d.BaselineMinRate = round2(p.MinRate)
+		d.BaselineMaxRate = round2(p.MaxRate)
+		d.NewDestinations = newKeys(a.destinations, p.Destinations)
+		d.NewPorts = newKeys(a.ports, p.Ports)
+		d.NewFailureModes = newKeys(a.failureModes, p.FailureModes)
+		d.RateSpike = d.Rate > p.MaxRate*rateSpikeFactor && d.Rate-p.MaxRate >= 1
+		d.DropSpike = d.DropRate > p.MaxDropRate*rateSpikeFactor && d.DropRate-p.MaxDropRate >= 1
+
+		var score float64
+		if len(d.NewDestinations) > 0 {
+			newConnections := 0
+			for _, dest := range d.NewDestinations {
+				newConnections += a.destinations[dest]
+			}
+			score += math.Max(10, 40*float64(newConnections)/float64(a.connections))
+		}
+		if len(d.NewPorts) > 0 {
+			score += 15
+		}
+		if d.RateSpike {
+			score += 20
+		}
+		if len(d.NewFailureModes) > 0 {
+			score += 15
+		}
+		if d.DropSpike {
+			score += 10
+		}
+		d.Score = int(math.Min(100, math.Round(score)))
+
+		switch {
+		case p.Snapshots < minSnapshots:
+			d.Status = StatusLearning
+		case d.Score >= deviationThreshold:
+			d.Status = StatusDeviating
+		default:
+			d.Status = StatusNormal
+		}
+		deviations = append(deviations, d)
+	}
+
+	sort.Slice(deviations, func(i, j int) bool {
+		if deviations[i].Score != deviations[j].Score {
+			return deviations[i].Score > deviations[j].Score
+		}
+		return deviations[i].Command < deviations[j].Command
+	})
+	return deviations
+}
+
+// newKeys returns the keys of current missing from known, most frequent first
+func newKeys(current, known map[string]int) []string {
+	var keys []string
+	for key := range current {
+		if _, exists := known[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if current[keys[i]] != current[keys[j]] {
+			return current[keys[i]] > current[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
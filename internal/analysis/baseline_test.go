@@ -0,0 +1,160 @@
+package analysis
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// nginxWindow builds a one-minute window of nginx proxying to its upstream
+func nginxWindow(start time.Time, connections int) Window {
+	window := Window{Start: start, End: start.Add(time.Minute)}
+	for i := 0; i < connections; i++ {
+		window.Connections = append(window.Connections, netclient.ConnectionEvent{
+			PID:             80,
+			Command:         "nginx",
+			DestinationIP:   "10.0.0.5",
+			DestinationPort: 8080,
+			Protocol:        "TCP",
+			WallTime:        start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	return window
+}
+
+func learnedBaseline(snapshots int) *Baseline {
+	baseline := NewBaseline()
+	for i := 0; i < snapshots; i++ {
+		baseline.Learn(nginxWindow(timelineStart.Add(time.Duration(i)*time.Minute), 10+i*5))
+	}
+	return baseline
+}
+
+func TestBaselineLearn(t *testing.T) {
+	baseline := learnedBaseline(3)
+	p := baseline.Processes["nginx"]
+	if p == nil || p.Snapshots != 3 {
+		t.Fatalf("expected 3 nginx snapshots, got %+v", p)
+	}
+	if p.MinRate != 10 || p.MaxRate != 20 {
+		t.Errorf("expected rate range 10-20/min, got %.1f-%.1f", p.MinRate, p.MaxRate)
+	}
+	if p.Destinations["10.0.0.5:8080"] != 45 || p.Ports["8080/tcp"] != 45 {
+		t.Errorf("unexpected learned destinations %v / ports %v", p.Destinations, p.Ports)
+	}
+}
+
+func TestBaselineCompare_Normal(t *testing.T) {
+	baseline := learnedBaseline(3)
+	deviations := baseline.Compare(nginxWindow(timelineStart.Add(time.Hour), 15), 0)
+	if len(deviations) != 1 {
+		t.Fatalf("expected one process, got %+v", deviations)
+	}
+	d := deviations[0]
+	if d.Status != StatusNormal || d.Score != 0 || d.RateSpike || len(d.NewDestinations) != 0 {
+		t.Errorf("expected normal behaviour, got %+v", d)
+	}
+}
+
+func TestBaselineCompare_Deviating(t *testing.T) {
+	baseline := learnedBaseline(3)
+
+	window := nginxWindow(timelineStart.Add(time.Hour), 50)
+	for i := 0; i < 10; i++ {
+		window.Connections = append(window.Connections, netclient.ConnectionEvent{
+			PID:             80,
+			Command:         "nginx",
+			DestinationIP:   "203.0.113.9",
+			DestinationPort: 6667,
+			Protocol:        "TCP",
+			ReturnCode:      -111,
+		})
+	}
+	window.Drops = append(window.Drops, netclient.PacketDropInfo{PID: 80, Command: "nginx", Reason: "NO_SOCKET"})
+
+	deviations := baseline.Compare(window, 0)
+	if len(deviations) != 1 {
+		t.Fatalf("expected one process, got %+v", deviations)
+	}
+	d := deviations[0]
+	if d.Status != StatusDeviating || !d.RateSpike || d.Rate != 60 {
+		t.Errorf("expected a deviating rate spike, got %+v", d)
+	}
+	if len(d.NewDestinations) != 1 || d.NewDestinations[0] != "203.0.113.9:6667" {
+		t.Errorf("expected new destination, got %v", d.NewDestinations)
+	}
+	if len(d.NewPorts) != 1 || d.NewPorts[0] != "6667/tcp" {
+		t.Errorf("expected new port, got %v", d.NewPorts)
+	}
+	if len(d.NewFailureModes) != 2 {
+		t.Errorf("expected connect error and drop failure modes, got %v", d.NewFailureModes)
+	}
+	if d.Score < 50 {
+		t.Errorf("expected a high score, got %d", d.Score)
+	}
+}
+
+func TestBaselineCompare_NewAndLearning(t *testing.T) {
+	baseline := learnedBaseline(1)
+
+	window := nginxWindow(timelineStart.Add(time.Hour), 10)
+	window.Connections = append(window.Connections, netclient.ConnectionEvent{
+		PID: 99, Command: "xmrig", DestinationIP: "198.51.100.1", DestinationPort: 3333, Protocol: "TCP",
+	})
+
+	statuses := make(map[string]Deviation)
+	for _, d := range baseline.Compare(window, 3) {
+		statuses[d.Command] = d
+	}
+	if statuses["xmrig"].Status != StatusNewProcess || statuses["xmrig"].Score != 50 {
+		t.Errorf("expected xmrig to be a new process, got %+v", statuses["xmrig"])
+	}
+	if statuses["nginx"].Status != StatusLearning {
+		t.Errorf("expected nginx baseline to still be learning, got %+v", statuses["nginx"])
+	}
+
+	// Nothing is unusual against an empty baseline
+	if d := NewBaseline().Compare(window, 3); d[0].Score != 0 || d[1].Score != 0 {
+		t.Errorf("expected zero scores against an empty baseline, got %+v", d)
+	}
+}
+
+func TestBaselineSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "baselines.json")
+
+	empty, err := LoadBaseline(path)
+	if err != nil || len(empty.Processes) != 0 {
+		t.Fatalf("expected empty baseline for a missing file, got %+v, %v", empty, err)
+	}
+
+	baseline := learnedBaseline(2)
+	baseline.LearnedUntil = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := baseline.Save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	loaded, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	p := loaded.Processes["nginx"]
+	if p == nil || p.Snapshots != 2 || p.MaxRate != 15 || p.Destinations["10.0.0.5:8080"] != 25 {
+		t.Errorf("unexpected loaded baseline: %+v", p)
+	}
+	if !loaded.LearnedUntil.Equal(baseline.LearnedUntil) {
+		t.Errorf("expected the learned history position to be kept, got %v", loaded.LearnedUntil)
+	}
+}
+
+func TestMergeCountsBounded(t *testing.T) {
+	dst := make(map[string]int)
+	src := make(map[string]int)
+	for i := 0; i < maxBaselineEntries+10; i++ {
+		src[time.Duration(i).String()] = 1
+	}
+	mergeCounts(dst, src)
+	if len(dst) != maxBaselineEntries {
+		t.Errorf("expected %d entries, got %d", maxBaselineEntries, len(dst))
+	}
+}
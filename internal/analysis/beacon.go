@@ -0,0 +1,178 @@
+// Package analysis implements behavioural analysis over connection event streams
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// BeaconOptions tunes periodic connection detection
+type BeaconOptions struct {
+	// MinEvents is the minimum number of connections to a destination before it is analyzed
+	MinEvents int
+	// MinPeriod ignores faster repetition, which is treated as a burst rather than a beacon
+	MinPeriod time.Duration
+	// ScoreThreshold is the periodicity score from which a pair is reported as a likely beacon
+	ScoreThreshold float64
+}
+
+// DefaultBeaconOptions returns the options used by analyze_patterns
+func DefaultBeaconOptions() BeaconOptions {
+	return BeaconOptions{
+		MinEvents:      6,
+		MinPeriod:      time.Second,
+		ScoreThreshold: 0.8,
+	}
+}
+
+// Beacon describes the timing of repeated connections from one process to one destination
+type Beacon struct {
+	PID              uint32    `json:"pid"`
+	Command          string    `json:"command"`
+	Destination      string    `json:"destination"`
+	Hostname         string    `json:"hostname,omitempty"`
+	Count            int       `json:"count"`
+	PeriodSeconds    float64   `json:"period_seconds"`
+	MeanInterval     float64   `json:"mean_interval_seconds"`
+	JitterSeconds    float64   `json:"jitter_seconds"`
+	PeriodicityScore float64   `json:"periodicity_score"`
+	FirstSeen        time.Time `json:"first_seen"`
+	LastSeen         time.Time `json:"last_seen"`
+}
+
+// EventTime returns when an event happened, preferring wall-clock time over the raw timestamp
+func EventTime(event netclient.ConnectionEvent) time.Time {
+	if !event.WallTime.IsZero() {
+		return event.WallTime
+	}
+	return time.Unix(0, int64(event.TimestampNS))
+}
+
+// AnalyzeIntervals computes inter-arrival statistics for every process and destination pair
+// with enough connections, regardless of how periodic they are
+func AnalyzeIntervals(events []netclient.ConnectionEvent, opts BeaconOptions) []Beacon {
+	type pairKey struct {
+		pid         uint32
+		command     string
+		destination string
+	}
+
+	pairs := make(map[pairKey][]netclient.ConnectionEvent)
+	for _, event := range events {
+		if event.DestinationIP == "" {
+			continue
+		}
+		key := pairKey{event.PID, event.Command, fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)}
+		pairs[key] = append(pairs[key], event)
+	}
+
+	var results []Beacon
+	for key, pairEvents := range pairs {
+		if len(pairEvents) < opts.MinEvents {
+			continue
+		}
+
+		times := make([]time.Time, len(pairEvents))
+		for i, event := range pairEvents {
+			times[i] = EventTime(event)
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+		intervals := make([]float64, len(times)-1)
+		for i := 1; i < len(times); i++ {
+			intervals[i-1] = times[i].Sub(times[i-1]).Seconds()
+		}
+
+		period := median(intervals)
+		if period < opts.MinPeriod.Seconds() {
+			continue
+		}
+		mean, stddev := meanStddev(intervals)
+
+		results = append(results, Beacon{
+			PID:              key.pid,
+			Command:          key.command,
+			Destination:      key.destination,
+			Hostname:         pairEvents[0].Hostname,
+			Count:            len(pairEvents),
+			PeriodSeconds:    period,
+			MeanInterval:     mean,
+			JitterSeconds:    stddev,
+			PeriodicityScore: periodicityScore(intervals, period),
+			FirstSeen:        times[0],
+			LastSeen:         times[len(times)-1],
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].PeriodicityScore != results[j].PeriodicityScore {
+			return results[i].PeriodicityScore > results[j].PeriodicityScore
+		}
+		return results[i].Destination < results[j].Destination
+	})
+	return results
+}
+
+// DetectBeacons returns the process and destination pairs whose connections are regular enough
+// to be likely beacons, most periodic first
+func DetectBeacons(events []netclient.ConnectionEvent, opts BeaconOptions) []Beacon {
+	var beacons []Beacon
+	for _, b := range AnalyzeIntervals(events, opts) {
+		if b.PeriodicityScore >= opts.ScoreThreshold {
+			beacons = append(beacons, b)
+		}
+	}
+	return beacons
+}
+
+// periodicityScore rates how well intervals fit multiples of the period, from 0 (random) to 1
+// (perfectly regular). Matching multiples keeps a beacon's score high when single beats are missed.
+func periodicityScore(intervals []float64, period float64) float64 {
+	if len(intervals) == 0 || period <= 0 {
+		return 0
+	}
+
+	var total float64
+	for _, interval := range intervals {
+		multiple := math.Max(1, math.Round(interval/period))
+		total += math.Min(0.5, math.Abs(interval-multiple*period)/period)
+	}
+
+	// Residuals are capped at half a period, so random timing averages about 0.25
+	score := 1 - 2*total/float64(len(intervals))
+	return math.Round(score*100) / 100
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
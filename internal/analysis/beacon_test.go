@@ -0,0 +1,141 @@
+package analysis
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+var timelineStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// timeline builds connection events from one process to one destination at the given offsets
+func timeline(pid uint32, command, ip string, port uint16, offsets []time.Duration) []netclient.ConnectionEvent {
+	events := make([]netclient.ConnectionEvent, len(offsets))
+	for i, offset := range offsets {
+		events[i] = netclient.ConnectionEvent{
+			PID:             pid,
+			Command:         command,
+			DestinationIP:   ip,
+			DestinationPort: port,
+			Protocol:        "TCP",
+			WallTime:        timelineStart.Add(offset),
+		}
+	}
+	return events
+}
+
+func periodicOffsets(count int, period, jitter time.Duration, rng *rand.Rand) []time.Duration {
+	offsets := make([]time.Duration, count)
+	for i := range offsets {
+		offsets[i] = time.Duration(i) * period
+		if jitter > 0 {
+			offsets[i] += time.Duration(rng.Int63n(int64(2*jitter))) - jitter
+		}
+	}
+	return offsets
+}
+
+func TestDetectBeacons_Regular(t *testing.T) {
+	events := timeline(42, "implant", "203.0.113.10", 443, periodicOffsets(20, time.Minute, 0, nil))
+
+	beacons := DetectBeacons(events, DefaultBeaconOptions())
+	if len(beacons) != 1 {
+		t.Fatalf("expected 1 beacon, got %+v", beacons)
+	}
+	b := beacons[0]
+	if b.PeriodSeconds != 60 || b.MeanInterval != 60 || b.JitterSeconds != 0 || b.PeriodicityScore != 1 {
+		t.Errorf("unexpected stats for a perfect beacon: %+v", b)
+	}
+	if b.Count != 20 || b.Destination != "203.0.113.10:443" || b.Command != "implant" {
+		t.Errorf("unexpected beacon identity: %+v", b)
+	}
+}
+
+func TestDetectBeacons_Jitter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	events := timeline(42, "implant", "203.0.113.10", 443, periodicOffsets(30, time.Minute, 5*time.Second, rng))
+
+	beacons := DetectBeacons(events, DefaultBeaconOptions())
+	if len(beacons) != 1 {
+		t.Fatalf("expected jittered beacon to be detected, got %+v", beacons)
+	}
+	b := beacons[0]
+	if math.Abs(b.PeriodSeconds-60) > 5 {
+		t.Errorf("expected period near 60s, got %.1f", b.PeriodSeconds)
+	}
+	if b.JitterSeconds == 0 || b.PeriodicityScore >= 1 {
+		t.Errorf("expected non-zero jitter and imperfect score, got %+v", b)
+	}
+}
+
+func TestDetectBeacons_MissedBeats(t *testing.T) {
+	offsets := periodicOffsets(20, 30*time.Second, 0, nil)
+	// Drop a few check-ins; the remaining connections still land on multiples of the period
+	offsets = append(offsets[:5], offsets[7:]...)
+	offsets = append(offsets[:12], offsets[13:]...)
+	events := timeline(7, "healthcheck", "10.0.0.8", 8080, offsets)
+
+	beacons := DetectBeacons(events, DefaultBeaconOptions())
+	if len(beacons) != 1 || beacons[0].PeriodSeconds != 30 || beacons[0].PeriodicityScore != 1 {
+		t.Errorf("expected 30s beacon despite missed beats, got %+v", beacons)
+	}
+}
+
+func TestDetectBeacons_Random(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	var offsets []time.Duration
+	var at time.Duration
+	for i := 0; i < 50; i++ {
+		at += time.Duration(rng.ExpFloat64() * float64(time.Minute))
+		offsets = append(offsets, at)
+	}
+	events := timeline(9, "browser", "198.51.100.4", 443, offsets)
+
+	if beacons := DetectBeacons(events, DefaultBeaconOptions()); len(beacons) != 0 {
+		t.Errorf("expected no beacon for random traffic, got %+v", beacons)
+	}
+
+	stats := AnalyzeIntervals(events, DefaultBeaconOptions())
+	if len(stats) != 1 || stats[0].PeriodicityScore > 0.7 {
+		t.Errorf("expected a low periodicity score for random traffic, got %+v", stats)
+	}
+}
+
+func TestDetectBeacons_Thresholds(t *testing.T) {
+	// Too few connections to judge
+	few := timeline(1, "cron", "203.0.113.1", 443, periodicOffsets(4, time.Minute, 0, nil))
+	// Sub-second repetition is a burst, not a beacon
+	burst := timeline(2, "client", "203.0.113.2", 443, periodicOffsets(20, 100*time.Millisecond, 0, nil))
+	// Unix sockets have no destination address
+	local := timeline(3, "agent", "", 0, periodicOffsets(20, time.Minute, 0, nil))
+
+	var events []netclient.ConnectionEvent
+	events = append(events, few...)
+	events = append(events, burst...)
+	events = append(events, local...)
+	if beacons := DetectBeacons(events, DefaultBeaconOptions()); len(beacons) != 0 {
+		t.Errorf("expected no beacons, got %+v", beacons)
+	}
+}
+
+func TestDetectBeacons_SeparatesPairs(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	events = append(events, timeline(42, "implant", "203.0.113.10", 443, periodicOffsets(10, time.Minute, 0, nil))...)
+	events = append(events, timeline(43, "implant", "203.0.113.10", 443, periodicOffsets(10, 5*time.Minute, 0, nil))...)
+	events = append(events, timeline(42, "implant", "203.0.113.11", 443, periodicOffsets(10, 10*time.Second, 0, nil))...)
+
+	beacons := DetectBeacons(events, DefaultBeaconOptions())
+	if len(beacons) != 3 {
+		t.Fatalf("expected 3 beacons, got %+v", beacons)
+	}
+	periods := make(map[float64]bool)
+	for _, b := range beacons {
+		periods[b.PeriodSeconds] = true
+	}
+	if !periods[60] || !periods[300] || !periods[10] {
+		t.Errorf("expected 10s, 60s and 300s periods, got %+v", beacons)
+	}
+}
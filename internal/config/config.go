@@ -0,0 +1,336 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/srodi/netspy/internal/enrich"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/store"
+)
+
+// Config holds optional netspy settings loaded from a JSON config file
+type Config struct {
+	Kubernetes KubernetesConfig `json:"kubernetes"`
+	DNS        DNSConfig        `json:"dns"`
+	GeoIP      GeoIPConfig      `json:"geoip"`
+	Services   ServicesConfig   `json:"services"`
+	Baseline   BaselineConfig   `json:"baseline"`
+	History    HistoryConfig    `json:"history"`
+	Cassette   CassetteConfig   `json:"cassette"`
+	LLM        LLMConfig        `json:"llm"`
+	Sessions   SessionsConfig   `json:"sessions"`
+
+	// DataDir holds locally persisted state such as baselines (default: ~/.netspy)
+	DataDir string `json:"data_dir,omitempty"`
+}
+
+// KubernetesConfig configures container and pod attribution for network events
+type KubernetesConfig struct {
+	// MetadataFile is a kubelet pods JSON dump or a static container mapping file
+	MetadataFile string `json:"metadata_file,omitempty"`
+	// ProcRoot is the procfs mount used to read process cgroups (default: /proc)
+	ProcRoot string `json:"proc_root,omitempty"`
+}
+
+// DNSConfig configures destination hostname enrichment
+type DNSConfig struct {
+	// DisableReverseLookups limits hostname enrichment to the hosts file
+	DisableReverseLookups bool `json:"disable_reverse_lookups,omitempty"`
+	// TimeoutMS bounds each reverse lookup (default: 500)
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// MaxLookups bounds uncached lookups per tool call (default: 64)
+	MaxLookups int `json:"max_lookups,omitempty"`
+	// HostsFile overrides the static hosts file (default: /etc/hosts)
+	HostsFile string `json:"hosts_file,omitempty"`
+}
+
+// HostnameOptions converts the DNS settings to enricher options, keeping defaults for unset values
+func (c DNSConfig) HostnameOptions() enrich.HostnameOptions {
+	opts := enrich.DefaultHostnameOptions()
+	if c.TimeoutMS > 0 {
+		opts.Timeout = time.Duration(c.TimeoutMS) * time.Millisecond
+	}
+	if c.MaxLookups > 0 {
+		opts.MaxLookups = c.MaxLookups
+	}
+	if c.HostsFile != "" {
+		opts.HostsFile = c.HostsFile
+	}
+	return opts
+}
+
+// GeoIPConfig configures offline country and ASN enrichment
+type GeoIPConfig struct {
+	// Databases lists MaxMind DB (.mmdb) or CSV IP-range files, consulted in order
+	Databases []string `json:"databases,omitempty"`
+}
+
+// ServicesConfig configures port to service name mapping
+type ServicesConfig struct {
+	// File is an /etc/services style database (default: /etc/services)
+	File string `json:"file,omitempty"`
+	// Overrides maps "port" or "port/proto" to a service name, taking precedence over all other sources
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// ServicesFile returns the services database path, defaulting to /etc/services
+func (c ServicesConfig) ServicesFile() string {
+	if c.File != "" {
+		return c.File
+	}
+	return "/etc/services"
+}
+
+// BaselineConfig configures per-process behavioural baselines
+type BaselineConfig struct {
+	// File stores learned baselines (default: baselines.json in the data directory)
+	File string `json:"file,omitempty"`
+	// MinSnapshots is the number of learned windows before a baseline is trusted (default: 3)
+	MinSnapshots int `json:"min_snapshots,omitempty"`
+}
+
+// SessionsConfig configures where chat sessions are saved
+type SessionsConfig struct {
+	// Dir holds one JSON file per session (default: sessions in the data directory)
+	Dir string `json:"dir,omitempty"`
+}
+
+// HistoryConfig configures the local telemetry history store
+type HistoryConfig struct {
+	// Dir holds history segments (default: history in the data directory)
+	Dir string `json:"dir,omitempty"`
+	// Disabled stops tools from reading recorded history
+	Disabled bool `json:"disabled,omitempty"`
+	// RetentionHours is how long recorded events are kept (default: 168)
+	RetentionHours int `json:"retention_hours,omitempty"`
+	// MaxSizeMB bounds the size of recorded history (default: 256)
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+}
+
+// Options converts the history settings to store options, keeping defaults for unset values
+func (c HistoryConfig) Options() store.Options {
+	opts := store.DefaultOptions()
+	if c.RetentionHours > 0 {
+		opts.Retention = time.Duration(c.RetentionHours) * time.Hour
+	}
+	if c.MaxSizeMB > 0 {
+		opts.MaxBytes = int64(c.MaxSizeMB) << 20
+	}
+	return opts
+}
+
+// CassetteConfig configures recording and replaying eBPF server traffic
+type CassetteConfig struct {
+	// Record writes every eBPF server request and response to this cassette file
+	Record string `json:"record,omitempty"`
+	// Replay serves responses from this cassette file instead of calling the eBPF server
+	Replay string `json:"replay,omitempty"`
+	// Scrub redacts "ips", "processes" or "all" from recorded cassettes
+	Scrub string `json:"scrub,omitempty"`
+	// KeepTimes replays event times as recorded instead of shifting them to the present
+	KeepTimes bool `json:"keep_times,omitempty"`
+}
+
+// Replaying reports whether responses come from a cassette rather than the eBPF server
+func (c CassetteConfig) Replaying() bool {
+	return c.Replay != ""
+}
+
+// Transport returns the HTTP transport for eBPF server requests, or nil for the default transport
+func (c CassetteConfig) Transport() (http.RoundTripper, error) {
+	if c.Record != "" && c.Replay != "" {
+		return nil, fmt.Errorf("cannot record and replay a cassette at the same time")
+	}
+	if c.Replay != "" {
+		cassette, err := netclient.LoadCassette(c.Replay)
+		if err != nil {
+			return nil, err
+		}
+		return netclient.NewReplayTransport(cassette, netclient.ReplayOptions{KeepTimes: c.KeepTimes}), nil
+	}
+	if c.Record != "" {
+		scrub, err := netclient.ParseScrubOptions(c.Scrub)
+		if err != nil {
+			return nil, err
+		}
+		return netclient.NewRecordingTransport(nil, c.Record, scrub), nil
+	}
+	return nil, nil
+}
+
+// LLMConfig selects the language model backend used by AI tools. Unset values fall back to the
+// NETSPY_LLM_PROVIDER, NETSPY_LLM_MODEL and NETSPY_LLM_BASE_URL environment variables.
+type LLMConfig struct {
+	// Provider is openai, azure, ollama, anthropic or offline (default: openai)
+	Provider string `json:"provider,omitempty"`
+	// Model overrides the provider's default model
+	Model string `json:"model,omitempty"`
+	// BaseURL points the provider at another endpoint, e.g. a vLLM or LM Studio server
+	BaseURL string `json:"base_url,omitempty"`
+	// APIKeyEnv names the environment variable holding the API key (default: per provider, e.g. OPENAI_API_KEY)
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+	// APIVersion is the Azure OpenAI api-version (default: 2024-06-01)
+	APIVersion string `json:"api_version,omitempty"`
+	// MaxTokens limits response length where the API requires it (default: 4096)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// DisableStreaming waits for complete responses instead of printing answers as they are generated
+	DisableStreaming bool `json:"disable_streaming,omitempty"`
+
+	// Limits on the function calling loop of each query; negative values disable a limit
+	// MaxToolRounds bounds model responses that request tools (default: 8)
+	MaxToolRounds int `json:"max_tool_rounds,omitempty"`
+	// MaxToolCalls bounds tool executions (default: 24)
+	MaxToolCalls int `json:"max_tool_calls,omitempty"`
+	// TimeoutSeconds bounds wall-clock time before a final answer is forced (default: 120)
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// TokenBudget bounds the total tokens reported by the provider (default: 150000)
+	TokenBudget int `json:"token_budget,omitempty"`
+
+	// ToolWorkers is the number of tool calls from one model turn run concurrently (default: 4)
+	ToolWorkers int `json:"tool_workers,omitempty"`
+	// ToolTimeoutSeconds bounds each tool call; negative disables the timeout (default: 30)
+	ToolTimeoutSeconds int `json:"tool_timeout_seconds,omitempty"`
+
+	// MaxToolResultTokens cuts larger tool outputs to their head, tail and statistics; negative keeps them whole (default: 4000)
+	MaxToolResultTokens int `json:"max_tool_result_tokens,omitempty"`
+	// MaxContextTokens summarizes older turns once the history grows past it; negative never summarizes (default: 32000)
+	MaxContextTokens int `json:"max_context_tokens,omitempty"`
+
+	// Tools maps a consumer such as "contextual_analysis", or "*" for every consumer, to the
+	// tools it may call. Unset deny lists exclude contextual_analysis and ai_insights.
+	Tools map[string]openai.ToolFilter `json:"tools,omitempty"`
+
+	// TraceDir, when set, receives a JSON trace of every contextual analysis for auditing
+	TraceDir string `json:"trace_dir,omitempty"`
+}
+
+// ToolFilter returns the tool filter for a function calling consumer
+func (c LLMConfig) ToolFilter(consumer string) openai.ToolFilter {
+	if filter, ok := c.Tools[consumer]; ok {
+		return filter
+	}
+	return c.Tools["*"]
+}
+
+// Limits converts the loop limits to agent limits, keeping defaults for unset values
+func (c LLMConfig) Limits() openai.Limits {
+	limits := openai.DefaultLimits()
+	limits.MaxToolRounds = limitValue(c.MaxToolRounds, limits.MaxToolRounds)
+	limits.MaxToolCalls = limitValue(c.MaxToolCalls, limits.MaxToolCalls)
+	limits.Timeout = time.Duration(limitValue(c.TimeoutSeconds, int(limits.Timeout/time.Second))) * time.Second
+	limits.TokenBudget = limitValue(c.TokenBudget, limits.TokenBudget)
+	return limits
+}
+
+// ExecutionOptions converts the tool execution settings, keeping defaults for unset values
+func (c LLMConfig) ExecutionOptions() openai.ExecutionOptions {
+	opts := openai.DefaultExecutionOptions()
+	if c.ToolWorkers > 0 {
+		opts.Workers = c.ToolWorkers
+	}
+	opts.CallTimeout = time.Duration(limitValue(c.ToolTimeoutSeconds, int(opts.CallTimeout/time.Second))) * time.Second
+	return opts
+}
+
+// ContextOptions converts the context window settings, keeping defaults for unset values
+func (c LLMConfig) ContextOptions() openai.ContextOptions {
+	opts := openai.DefaultContextOptions()
+	opts.MaxToolResultTokens = limitValue(c.MaxToolResultTokens, opts.MaxToolResultTokens)
+	opts.MaxContextTokens = limitValue(c.MaxContextTokens, opts.MaxContextTokens)
+	return opts
+}
+
+// limitValue returns the default for zero and zero (unlimited) for negative values
+func limitValue(value, def int) int {
+	switch {
+	case value < 0:
+		return 0
+	case value == 0:
+		return def
+	}
+	return value
+}
+
+// ProviderConfig converts the LLM settings to provider options
+func (c LLMConfig) ProviderConfig() openai.ProviderConfig {
+	cfg := openai.ProviderConfig{
+		Provider:   c.Provider,
+		Model:      c.Model,
+		BaseURL:    c.BaseURL,
+		APIVersion: c.APIVersion,
+		MaxTokens:  c.MaxTokens,
+
+		DisableStreaming: c.DisableStreaming,
+	}
+	if c.APIKeyEnv != "" {
+		cfg.APIKey = os.Getenv(c.APIKeyEnv)
+	}
+	return cfg
+}
+
+// DataPath returns the path of a file in the data directory, defaulting to ~/.netspy
+func (c *Config) DataPath(name string) string {
+	dir := c.DataDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		dir = filepath.Join(home, ".netspy")
+	}
+	return filepath.Join(dir, name)
+}
+
+// BaselineFile returns the baseline store path
+func (c *Config) BaselineFile() string {
+	if c.Baseline.File != "" {
+		return c.Baseline.File
+	}
+	return c.DataPath("baselines.json")
+}
+
+// HistoryDir returns the history store directory
+func (c *Config) HistoryDir() string {
+	if c.History.Dir != "" {
+		return c.History.Dir
+	}
+	return c.DataPath("history")
+}
+
+// SessionsDir returns the directory of saved chat sessions
+func (c *Config) SessionsDir() string {
+	if c.Sessions.Dir != "" {
+		return c.Sessions.Dir
+	}
+	return c.DataPath("sessions")
+}
+
+// Default returns a configuration with all optional features disabled
+func Default() *Config {
+	return &Config{}
+}
+
+// Load reads a JSON configuration file. An empty path returns the default configuration.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,42 @@
+package enrich
+
+import (
+	"strings"
+)
+
+// multiLabelSuffixes lists common public suffixes that span two labels. Registered domains
+// under these suffixes keep three labels (example.co.uk rather than co.uk).
+var multiLabelSuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true,
+	"com.au": true, "net.au": true, "org.au": true,
+	"co.jp": true, "ne.jp": true, "or.jp": true,
+	"co.nz": true, "co.za": true, "co.in": true, "co.kr": true,
+	"com.br": true, "com.cn": true, "com.mx": true, "com.sg": true, "com.tr": true,
+	// Cloud provider suffixes where each customer owns a subdomain
+	"amazonaws.com": true, "cloudfront.net": true, "azurewebsites.net": true,
+	"cloudapp.net": true, "appspot.com": true, "herokuapp.com": true,
+	"github.io": true, "googleusercontent.com": true,
+}
+
+// RegisteredDomain returns the registrable domain of a hostname, e.g. "api.github.com" -> "github.com".
+// It uses a small built-in suffix list rather than the full public suffix list.
+func RegisteredDomain(hostname string) string {
+	host := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if host == "" {
+		return ""
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 {
+		return host
+	}
+
+	keep := 2
+	if len(labels) >= 3 && multiLabelSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+		keep = 3
+	}
+	if len(labels) <= keep {
+		return host
+	}
+	return strings.Join(labels[len(labels)-keep:], ".")
+}
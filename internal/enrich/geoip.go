@@ -0,0 +1,229 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// GeoInfo is the country and autonomous system an IP address belongs to
+type GeoInfo struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN     uint32 `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+// GeoDatabase looks up offline geolocation data for an IP address
+type GeoDatabase interface {
+	Lookup(ip net.IP) (GeoInfo, bool)
+}
+
+// OpenGeoDatabase opens a MaxMind DB (.mmdb) or CSV IP-range database based on the file extension
+func OpenGeoDatabase(path string) (GeoDatabase, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".mmdb") {
+		return OpenMMDB(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geo database: %v", err)
+	}
+	defer f.Close()
+	return ParseCSVRanges(f)
+}
+
+// ipRange is one row of a CSV range database, with addresses in 16-byte form
+type ipRange struct {
+	start net.IP
+	end   net.IP
+	info  GeoInfo
+}
+
+// CSVRangeDatabase is a sorted list of IP ranges loaded from CSV
+type CSVRangeDatabase struct {
+	ranges []ipRange
+}
+
+// ParseCSVRanges reads rows of "start_ip,end_ip,country,asn,org". The start column may
+// instead hold a CIDR network with an empty end column. A header row and lines starting
+// with '#' are ignored; the ASN may be written as "15169" or "AS15169".
+func ParseCSVRanges(r io.Reader) (*CSVRangeDatabase, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	db := &CSVRangeDatabase{}
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse geo CSV: %v", err)
+		}
+		line++
+		if len(record) < 3 {
+			return nil, fmt.Errorf("geo CSV line %d: expected at least 3 columns", line)
+		}
+
+		start, end, err := parseRange(record[0], record[1])
+		if err != nil {
+			if line == 1 {
+				continue // header row
+			}
+			return nil, fmt.Errorf("geo CSV line %d: %v", line, err)
+		}
+
+		entry := ipRange{start: start, end: end, info: GeoInfo{Country: strings.ToUpper(strings.TrimSpace(record[2]))}}
+		if len(record) > 3 {
+			asn := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(record[3])), "AS")
+			if asn != "" {
+				value, err := strconv.ParseUint(asn, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("geo CSV line %d: invalid ASN %q", line, record[3])
+				}
+				entry.info.ASN = uint32(value)
+			}
+		}
+		if len(record) > 4 {
+			entry.info.Org = strings.TrimSpace(record[4])
+		}
+		db.ranges = append(db.ranges, entry)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return db, nil
+}
+
+// parseRange parses a start/end pair or a CIDR network into an inclusive 16-byte range
+func parseRange(startStr, endStr string) (net.IP, net.IP, error) {
+	startStr = strings.TrimSpace(startStr)
+	endStr = strings.TrimSpace(endStr)
+
+	if strings.Contains(startStr, "/") {
+		_, network, err := net.ParseCIDR(startStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid network %q", startStr)
+		}
+		start := network.IP.To16()
+		end := make(net.IP, len(start))
+		mask := network.Mask
+		if len(mask) == net.IPv4len {
+			// Align a 4-byte mask with the IPv4-mapped 16-byte address
+			mask = append(net.CIDRMask(96, 128)[:12], mask...)
+		}
+		for i := range start {
+			end[i] = start[i] | ^mask[i]
+		}
+		return start, end, nil
+	}
+
+	start := net.ParseIP(startStr)
+	end := net.ParseIP(endStr)
+	if start == nil || end == nil {
+		return nil, nil, fmt.Errorf("invalid range %q-%q", startStr, endStr)
+	}
+	return start.To16(), end.To16(), nil
+}
+
+// Lookup implements GeoDatabase with a binary search over the sorted ranges
+func (db *CSVRangeDatabase) Lookup(ip net.IP) (GeoInfo, bool) {
+	addr := ip.To16()
+	if addr == nil {
+		return GeoInfo{}, false
+	}
+
+	// Find the last range starting at or before the address
+	idx := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, addr) > 0
+	}) - 1
+	if idx < 0 || bytes.Compare(addr, db.ranges[idx].end) > 0 {
+		return GeoInfo{}, false
+	}
+	return db.ranges[idx].info, true
+}
+
+// GeoEnricher annotates destinations using one or more offline databases. Databases are
+// consulted in order and their results merged, so a country database and an ASN database
+// can be combined.
+type GeoEnricher struct {
+	databases []GeoDatabase
+}
+
+// NewGeoEnricher creates an enricher over the given databases
+func NewGeoEnricher(databases ...GeoDatabase) *GeoEnricher {
+	return &GeoEnricher{databases: databases}
+}
+
+// Lookup returns the merged geo information for an IP address
+func (g *GeoEnricher) Lookup(ip net.IP) (GeoInfo, bool) {
+	var merged GeoInfo
+	found := false
+	for _, db := range g.databases {
+		info, ok := db.Lookup(ip)
+		if !ok {
+			continue
+		}
+		found = true
+		if merged.Country == "" {
+			merged.Country = info.Country
+		}
+		if merged.ASN == 0 {
+			merged.ASN = info.ASN
+		}
+		if merged.Org == "" {
+			merged.Org = info.Org
+		}
+	}
+	return merged, found
+}
+
+// Annotate fills Country, ASN and ASOrg on connection events in place
+func (g *GeoEnricher) Annotate(events []netclient.ConnectionEvent) {
+	if len(g.databases) == 0 {
+		return
+	}
+
+	cache := make(map[string]GeoInfo)
+	for i := range events {
+		ipStr := events[i].DestinationIP
+		if ipStr == "" {
+			continue
+		}
+
+		info, seen := cache[ipStr]
+		if !seen {
+			if ip := net.ParseIP(strings.Trim(ipStr, "[]")); ip != nil {
+				info, _ = g.Lookup(ip)
+			}
+			cache[ipStr] = info
+		}
+
+		events[i].Country = info.Country
+		events[i].ASN = info.ASN
+		events[i].ASOrg = info.Org
+	}
+}
+
+// FormatASN renders an autonomous system as "AS15169 Google LLC"
+func FormatASN(asn uint32, org string) string {
+	if asn == 0 {
+		return org
+	}
+	if org == "" {
+		return fmt.Sprintf("AS%d", asn)
+	}
+	return fmt.Sprintf("AS%d %s", asn, org)
+}
@@ -0,0 +1,238 @@
+package enrich
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// Resolver performs reverse DNS lookups. *net.Resolver satisfies this interface.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// HostnameOptions controls the cost of reverse lookups
+type HostnameOptions struct {
+	Timeout     time.Duration // Per-lookup timeout
+	Concurrency int           // Maximum lookups in flight
+	MaxLookups  int           // Maximum uncached lookups per Annotate call
+	CacheSize   int           // Maximum cached addresses
+	CacheTTL    time.Duration // Lifetime of successful lookups
+	NegativeTTL time.Duration // Lifetime of failed lookups
+	HostsFile   string        // Static hosts file consulted before DNS ("" disables)
+}
+
+// DefaultHostnameOptions returns conservative defaults suitable for interactive use
+func DefaultHostnameOptions() HostnameOptions {
+	return HostnameOptions{
+		Timeout:     500 * time.Millisecond,
+		Concurrency: 8,
+		MaxLookups:  64,
+		CacheSize:   4096,
+		CacheTTL:    10 * time.Minute,
+		NegativeTTL: time.Minute,
+		HostsFile:   "/etc/hosts",
+	}
+}
+
+type hostnameEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// HostnameEnricher resolves destination IPs to hostnames using /etc/hosts and cached reverse DNS
+type HostnameEnricher struct {
+	resolver Resolver
+	opts     HostnameOptions
+	hosts    map[string]string
+
+	mu    sync.Mutex
+	cache map[string]hostnameEntry
+}
+
+// NewHostnameEnricher creates an enricher. A nil resolver limits lookups to the hosts file.
+func NewHostnameEnricher(resolver Resolver, opts HostnameOptions) *HostnameEnricher {
+	defaults := DefaultHostnameOptions()
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaults.Concurrency
+	}
+	if opts.MaxLookups <= 0 {
+		opts.MaxLookups = defaults.MaxLookups
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = defaults.CacheSize
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaults.CacheTTL
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = defaults.NegativeTTL
+	}
+
+	e := &HostnameEnricher{
+		resolver: resolver,
+		opts:     opts,
+		hosts:    make(map[string]string),
+		cache:    make(map[string]hostnameEntry),
+	}
+	if opts.HostsFile != "" {
+		if f, err := os.Open(opts.HostsFile); err == nil {
+			e.hosts = ParseHosts(bufio.NewScanner(f))
+			f.Close()
+		}
+	}
+	return e
+}
+
+// ParseHosts reads hosts file lines into an IP to canonical hostname map
+func ParseHosts(scanner *bufio.Scanner) map[string]string {
+	hosts := make(map[string]string)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		// The first name listed for an address is its canonical hostname
+		if _, exists := hosts[ip.String()]; !exists {
+			hosts[ip.String()] = fields[1]
+		}
+	}
+	return hosts
+}
+
+// Lookup returns the hostname for an IP address, or "" if none is known
+func (e *HostnameEnricher) Lookup(ctx context.Context, ip string) string {
+	if hostname, ok := e.cached(ip); ok {
+		return hostname
+	}
+	return e.resolve(ctx, ip)
+}
+
+// cached returns a hosts file or unexpired cache entry
+func (e *HostnameEnricher) cached(ip string) (string, bool) {
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return "", true
+	}
+	if hostname, ok := e.hosts[parsed.String()]; ok {
+		return hostname, true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if entry, ok := e.cache[parsed.String()]; ok && time.Now().Before(entry.expires) {
+		return entry.hostname, true
+	}
+	return "", false
+}
+
+// resolve performs a timeout-limited reverse lookup and caches the outcome
+func (e *HostnameEnricher) resolve(ctx context.Context, ip string) string {
+	addr := net.ParseIP(strings.Trim(ip, "[]")).String()
+	if e.resolver == nil {
+		return ""
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, e.opts.Timeout)
+	defer cancel()
+
+	var hostname string
+	ttl := e.opts.NegativeTTL
+	if names, err := e.resolver.LookupAddr(lookupCtx, addr); err == nil && len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+		ttl = e.opts.CacheTTL
+	}
+
+	e.mu.Lock()
+	if len(e.cache) >= e.opts.CacheSize {
+		e.evictLocked()
+	}
+	e.cache[addr] = hostnameEntry{hostname: hostname, expires: time.Now().Add(ttl)}
+	e.mu.Unlock()
+
+	return hostname
+}
+
+// evictLocked drops expired entries, or the entry closest to expiry if none have expired
+func (e *HostnameEnricher) evictLocked() {
+	now := time.Now()
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range e.cache {
+		if now.After(entry.expires) {
+			delete(e.cache, key)
+			continue
+		}
+		if oldestKey == "" || entry.expires.Before(oldest) {
+			oldestKey, oldest = key, entry.expires
+		}
+	}
+	if len(e.cache) >= e.opts.CacheSize && oldestKey != "" {
+		delete(e.cache, oldestKey)
+	}
+}
+
+// Annotate fills Hostname and Domain on connection events in place. Uncached addresses are
+// resolved concurrently, up to MaxLookups per call; the rest are left for later calls.
+func (e *HostnameEnricher) Annotate(ctx context.Context, events []netclient.ConnectionEvent) {
+	resolved := make(map[string]string)
+	var pending []string
+	for _, event := range events {
+		ip := event.DestinationIP
+		if ip == "" {
+			continue
+		}
+		if _, seen := resolved[ip]; seen {
+			continue
+		}
+		if hostname, ok := e.cached(ip); ok {
+			resolved[ip] = hostname
+			continue
+		}
+		resolved[ip] = ""
+		if len(pending) < e.opts.MaxLookups {
+			pending = append(pending, ip)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, e.opts.Concurrency)
+	for _, ip := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			hostname := e.resolve(ctx, ip)
+			mu.Lock()
+			resolved[ip] = hostname
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+
+	for i := range events {
+		if hostname := resolved[events[i].DestinationIP]; hostname != "" {
+			events[i].Hostname = hostname
+			events[i].Domain = RegisteredDomain(hostname)
+		}
+	}
+}
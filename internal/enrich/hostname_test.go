@@ -0,0 +1,155 @@
+package enrich
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// stubResolver answers reverse lookups from a fixed table and counts calls
+type stubResolver struct {
+	mu    sync.Mutex
+	names map[string]string
+	delay time.Duration
+	calls int
+}
+
+func (r *stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if name, ok := r.names[addr]; ok {
+		return []string{name + "."}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func testOptions() HostnameOptions {
+	opts := DefaultHostnameOptions()
+	opts.HostsFile = ""
+	return opts
+}
+
+func TestHostnameEnricher_Annotate(t *testing.T) {
+	resolver := &stubResolver{names: map[string]string{
+		"140.82.112.3": "lb-140-82-112-3-iad.github.com",
+		"140.82.112.4": "lb-140-82-112-4-iad.github.com",
+	}}
+	enricher := NewHostnameEnricher(resolver, testOptions())
+
+	events := []netclient.ConnectionEvent{
+		{DestinationIP: "140.82.112.3", DestinationPort: 443},
+		{DestinationIP: "140.82.112.4", DestinationPort: 443},
+		{DestinationIP: "140.82.112.3", DestinationPort: 443},
+		{DestinationIP: "10.0.0.9", DestinationPort: 5432},
+		{DestinationIP: ""},
+	}
+	enricher.Annotate(context.Background(), events)
+
+	if events[0].Hostname != "lb-140-82-112-3-iad.github.com" || events[0].Domain != "github.com" {
+		t.Errorf("unexpected enrichment: %+v", events[0])
+	}
+	if events[1].Domain != "github.com" {
+		t.Errorf("expected both IPs to share a domain, got %q", events[1].Domain)
+	}
+	if events[3].Hostname != "" {
+		t.Errorf("expected unresolved IP to stay empty, got %q", events[3].Hostname)
+	}
+	if resolver.calls != 3 {
+		t.Errorf("expected one lookup per unique IP, got %d", resolver.calls)
+	}
+
+	// Positive and negative results are cached
+	enricher.Annotate(context.Background(), events)
+	if resolver.calls != 3 {
+		t.Errorf("expected cached results on second call, got %d lookups", resolver.calls)
+	}
+}
+
+func TestHostnameEnricher_Timeout(t *testing.T) {
+	resolver := &stubResolver{names: map[string]string{"1.2.3.4": "slow.example.com"}, delay: time.Second}
+	opts := testOptions()
+	opts.Timeout = 20 * time.Millisecond
+	enricher := NewHostnameEnricher(resolver, opts)
+
+	start := time.Now()
+	if hostname := enricher.Lookup(context.Background(), "1.2.3.4"); hostname != "" {
+		t.Errorf("expected timeout to yield no hostname, got %q", hostname)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected lookup to be bounded by timeout, took %v", elapsed)
+	}
+}
+
+func TestHostnameEnricher_MaxLookups(t *testing.T) {
+	resolver := &stubResolver{names: map[string]string{}}
+	opts := testOptions()
+	opts.MaxLookups = 2
+	enricher := NewHostnameEnricher(resolver, opts)
+
+	events := []netclient.ConnectionEvent{
+		{DestinationIP: "1.1.1.1"}, {DestinationIP: "2.2.2.2"}, {DestinationIP: "3.3.3.3"}, {DestinationIP: "4.4.4.4"},
+	}
+	enricher.Annotate(context.Background(), events)
+	if resolver.calls != 2 {
+		t.Errorf("expected lookups to be capped at 2, got %d", resolver.calls)
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts := ParseHosts(bufio.NewScanner(strings.NewReader(`
+# comment
+127.0.0.1   localhost
+10.0.0.5    db.internal db   # primary database
+::1         localhost ip6-localhost
+bogus       entry
+`)))
+
+	if hosts["10.0.0.5"] != "db.internal" {
+		t.Errorf("expected canonical hostname, got %q", hosts["10.0.0.5"])
+	}
+	if hosts["::1"] != "localhost" {
+		t.Errorf("expected IPv6 entry, got %q", hosts["::1"])
+	}
+	if _, ok := hosts["bogus"]; ok {
+		t.Error("expected invalid addresses to be skipped")
+	}
+
+	// Hosts entries take precedence and need no resolver
+	enricher := NewHostnameEnricher(nil, testOptions())
+	enricher.hosts = hosts
+	if hostname := enricher.Lookup(context.Background(), "10.0.0.5"); hostname != "db.internal" {
+		t.Errorf("expected hosts file lookup, got %q", hostname)
+	}
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := map[string]string{
+		"api.github.com":                      "github.com",
+		"github.com.":                         "github.com",
+		"www.bbc.co.uk":                       "bbc.co.uk",
+		"s3.eu-west-1.amazonaws.com":          "eu-west-1.amazonaws.com",
+		"ec2-1-2-3-4.compute-1.amazonaws.com": "compute-1.amazonaws.com",
+		"localhost":                           "localhost",
+		"":                                    "",
+	}
+	for input, expected := range tests {
+		if got := RegisteredDomain(input); got != expected {
+			t.Errorf("RegisteredDomain(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata map at the end of a MaxMind DB file
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDataSectionSeparator is the size of the zero padding between the search tree and the data section
+const mmdbDataSectionSeparator = 16
+
+// MMDBReader reads MaxMind DB format files (GeoLite2/GeoIP2 Country, City and ASN databases)
+// without external dependencies. Only the fields netspy needs are extracted.
+type MMDBReader struct {
+	buffer       []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	treeSize     uint
+	ipv4Start    uint
+}
+
+// OpenMMDB loads a MaxMind DB file into memory
+func OpenMMDB(path string) (*MMDBReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mmdb file: %v", err)
+	}
+	return NewMMDBReader(data)
+}
+
+// NewMMDBReader parses a MaxMind DB held in memory
+func NewMMDBReader(data []byte) (*MMDBReader, error) {
+	markerIdx := bytes.LastIndex(data, mmdbMetadataMarker)
+	if markerIdx == -1 {
+		return nil, fmt.Errorf("invalid mmdb file: metadata marker not found")
+	}
+
+	metaStart := uint(markerIdx + len(mmdbMetadataMarker))
+	metaDecoder := mmdbDecoder{buffer: data[metaStart:]}
+	raw, _, err := metaDecoder.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mmdb metadata: %v", err)
+	}
+	metadata, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid mmdb metadata: expected map")
+	}
+
+	r := &MMDBReader{
+		buffer:     data,
+		nodeCount:  uint(mmdbUint(metadata["node_count"])),
+		recordSize: uint(mmdbUint(metadata["record_size"])),
+		ipVersion:  uint(mmdbUint(metadata["ip_version"])),
+	}
+	r.databaseType, _ = metadata["database_type"].(string)
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported mmdb record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported mmdb ip version %d", r.ipVersion)
+	}
+
+	r.treeSize = r.nodeCount * r.recordSize / 4
+	if r.treeSize+mmdbDataSectionSeparator > uint(markerIdx) {
+		return nil, fmt.Errorf("invalid mmdb file: search tree exceeds file size")
+	}
+
+	// IPv4 lookups in an IPv6 tree start below ::/96
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// DatabaseType returns the database_type metadata value, e.g. "GeoLite2-ASN"
+func (r *MMDBReader) DatabaseType() string {
+	return r.databaseType
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of a search tree node
+func (r *MMDBReader) readNode(node uint, bit uint) uint {
+	b := r.buffer
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return (uint(b[off+3])&0xF0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return (uint(b[off+3])&0x0F)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// LookupRaw returns the decoded record for an IP address, or nil if the address is not in the database
+func (r *MMDBReader) LookupRaw(ip net.IP) (any, error) {
+	var addr []byte
+	node := uint(0)
+
+	if ip4 := ip.To4(); ip4 != nil {
+		addr = ip4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		addr = ip.To16()
+		if addr == nil {
+			return nil, fmt.Errorf("invalid IP address")
+		}
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, fmt.Errorf("invalid mmdb search tree")
+	}
+
+	offset := node - r.nodeCount - mmdbDataSectionSeparator
+	decoder := mmdbDecoder{buffer: r.buffer[r.treeSize+mmdbDataSectionSeparator:]}
+	value, _, err := decoder.decode(offset)
+	return value, err
+}
+
+// Lookup implements GeoDatabase using the GeoIP2 Country/City and ASN record layouts
+func (r *MMDBReader) Lookup(ip net.IP) (GeoInfo, bool) {
+	raw, err := r.LookupRaw(ip)
+	if err != nil || raw == nil {
+		return GeoInfo{}, false
+	}
+	record, ok := raw.(map[string]any)
+	if !ok {
+		return GeoInfo{}, false
+	}
+
+	var info GeoInfo
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]any); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				info.Country = code
+				break
+			}
+		}
+	}
+	info.ASN = uint32(mmdbUint(record["autonomous_system_number"]))
+	info.Org, _ = record["autonomous_system_organization"].(string)
+
+	return info, info.Country != "" || info.ASN != 0 || info.Org != ""
+}
+
+// mmdbDecoder decodes the MaxMind DB data section format
+type mmdbDecoder struct {
+	buffer []byte
+}
+
+const (
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEndMarker = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+)
+
+// decode decodes the value at offset and returns it with the offset just past it
+func (d *mmdbDecoder) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+	}
+
+	ctrl := d.buffer[offset]
+	offset++
+	typeNum := uint(ctrl >> 5)
+
+	if typeNum == mmdbPointer {
+		pointer, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	if typeNum == 0 {
+		if offset >= uint(len(d.buffer)) {
+			return nil, 0, fmt.Errorf("unexpected end of data reading extended type")
+		}
+		typeNum = 7 + uint(d.buffer[offset])
+		offset++
+	}
+
+	size, offset, err := d.decodeSize(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return d.decodeValue(typeNum, size, offset)
+}
+
+// decodePointer resolves a pointer control byte to an absolute data section offset
+func (d *mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	pointerSize := uint((ctrl>>3)&0x3) + 1
+	if offset+pointerSize > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("unexpected end of data reading pointer")
+	}
+
+	buf := d.buffer[offset : offset+pointerSize]
+	var prefix uint
+	if pointerSize != 4 {
+		prefix = uint(ctrl & 0x7)
+	}
+	value := prefix
+	for _, b := range buf {
+		value = value<<8 | uint(b)
+	}
+
+	switch pointerSize {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+	return value, offset + pointerSize, nil
+}
+
+// decodeSize reads the payload size encoded in the control byte and any extension bytes
+func (d *mmdbDecoder) decodeSize(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+
+	extra := size - 28
+	if offset+extra > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("unexpected end of data reading size")
+	}
+	var value uint
+	for _, b := range d.buffer[offset : offset+extra] {
+		value = value<<8 | uint(b)
+	}
+
+	switch size {
+	case 29:
+		size = 29 + value
+	case 30:
+		size = 285 + value
+	default:
+		size = 65821 + value
+	}
+	return size, offset + extra, nil
+}
+
+// decodeValue decodes a payload of the given type and size
+func (d *mmdbDecoder) decodeValue(typeNum, size, offset uint) (any, uint, error) {
+	switch typeNum {
+	case mmdbMap:
+		result := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[keyStr] = value
+			offset = next
+		}
+		return result, offset, nil
+
+	case mmdbArray:
+		result := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+
+	case mmdbBool:
+		return size != 0, offset, nil
+
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("unexpected end of data decoding type %d", typeNum)
+	}
+	payload := d.buffer[offset : offset+size]
+	next := offset + size
+
+	switch typeNum {
+	case mmdbString:
+		return string(payload), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), payload...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var value uint64
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		return value, next, nil
+	case mmdbInt32:
+		var value uint32
+		for _, b := range payload {
+			value = value<<8 | uint32(b)
+		}
+		return int64(int32(value)), next, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(payload), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown mmdb data type %d", typeNum)
+	}
+}
+
+// mmdbUint converts a decoded unsigned integer to uint64, returning 0 for other types
+func mmdbUint(value any) uint64 {
+	if v, ok := value.(uint64); ok {
+		return v
+	}
+	return 0
+}
@@ -0,0 +1,269 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+var updateFixtures = flag.Bool("update", false, "regenerate testdata fixtures")
+
+const mmdbFixture = "testdata/geo-test.mmdb"
+
+// fixtureNetworks is the content of testdata/geo-test.mmdb
+var fixtureNetworks = []struct {
+	cidr   string
+	record map[string]any
+}{
+	{"8.8.8.0/24", map[string]any{
+		"country":                        map[string]any{"iso_code": "US"},
+		"autonomous_system_number":       uint32(15169),
+		"autonomous_system_organization": "Google LLC",
+	}},
+	{"1.1.1.0/24", map[string]any{
+		"registered_country":             map[string]any{"iso_code": "AU"},
+		"autonomous_system_number":       uint32(13335),
+		"autonomous_system_organization": "Cloudflare, Inc.",
+	}},
+	{"2a00:1450::/32", map[string]any{
+		"country":                        map[string]any{"iso_code": "IE"},
+		"autonomous_system_number":       uint32(15169),
+		"autonomous_system_organization": "Google LLC",
+	}},
+}
+
+func TestMMDBReader_Fixture(t *testing.T) {
+	if *updateFixtures {
+		if err := os.WriteFile(mmdbFixture, buildTestMMDB(t), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := OpenGeoDatabase(mmdbFixture)
+	if err != nil {
+		t.Fatalf("failed to open fixture: %v", err)
+	}
+	if reader := db.(*MMDBReader); reader.DatabaseType() != "netspy-test" {
+		t.Errorf("unexpected database type %q", reader.DatabaseType())
+	}
+
+	tests := []struct {
+		ip    string
+		found bool
+		info  GeoInfo
+	}{
+		{"8.8.8.8", true, GeoInfo{Country: "US", ASN: 15169, Org: "Google LLC"}},
+		{"1.1.1.1", true, GeoInfo{Country: "AU", ASN: 13335, Org: "Cloudflare, Inc."}},
+		{"2a00:1450:4001:82b::200e", true, GeoInfo{Country: "IE", ASN: 15169, Org: "Google LLC"}},
+		{"9.9.9.9", false, GeoInfo{}},
+		{"2001:db8::1", false, GeoInfo{}},
+	}
+	for _, tt := range tests {
+		info, found := db.Lookup(net.ParseIP(tt.ip))
+		if found != tt.found || info != tt.info {
+			t.Errorf("Lookup(%s) = %+v, %v; expected %+v, %v", tt.ip, info, found, tt.info, tt.found)
+		}
+	}
+}
+
+func TestMMDBReader_Invalid(t *testing.T) {
+	if _, err := NewMMDBReader([]byte("not a database")); err == nil {
+		t.Error("expected error for missing metadata")
+	}
+}
+
+func TestCSVRangeDatabase(t *testing.T) {
+	db, err := OpenGeoDatabase(filepath.Join("testdata", "geo-test.csv"))
+	if err != nil {
+		t.Fatalf("failed to open CSV fixture: %v", err)
+	}
+
+	tests := []struct {
+		ip    string
+		found bool
+		info  GeoInfo
+	}{
+		{"10.1.2.3", true, GeoInfo{Country: "ZZ", Org: "Internal"}},
+		{"52.94.0.10", true, GeoInfo{Country: "US", ASN: 16509, Org: "Amazon.com, Inc."}},
+		{"185.199.108.153", true, GeoInfo{Country: "NL", ASN: 54113, Org: "Fastly"}},
+		{"185.199.112.1", false, GeoInfo{}},
+		{"2606:4700::1111", true, GeoInfo{Country: "US", ASN: 13335, Org: "Cloudflare, Inc."}},
+		{"192.0.2.1", false, GeoInfo{}},
+	}
+	for _, tt := range tests {
+		info, found := db.Lookup(net.ParseIP(tt.ip))
+		if found != tt.found || info != tt.info {
+			t.Errorf("Lookup(%s) = %+v, %v; expected %+v, %v", tt.ip, info, found, tt.info, tt.found)
+		}
+	}
+}
+
+func TestGeoEnricher_Annotate(t *testing.T) {
+	countries, err := ParseCSVRanges(bytes.NewBufferString("8.8.8.0/24,,US\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asns, err := ParseCSVRanges(bytes.NewBufferString("8.8.0.0,8.8.255.255,,AS15169,Google LLC\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enricher := NewGeoEnricher(countries, asns)
+
+	events := []netclient.ConnectionEvent{
+		{DestinationIP: "8.8.8.8"},
+		{DestinationIP: "127.0.0.1"},
+		{DestinationIP: ""},
+	}
+	enricher.Annotate(events)
+
+	if events[0].Country != "US" || events[0].ASN != 15169 || events[0].ASOrg != "Google LLC" {
+		t.Errorf("expected merged geo info, got %+v", events[0])
+	}
+	if events[1].Country != "" || events[1].ASN != 0 {
+		t.Errorf("expected no geo info for loopback, got %+v", events[1])
+	}
+}
+
+// buildTestMMDB writes a minimal IPv6 MaxMind DB with 24-bit records containing fixtureNetworks
+func buildTestMMDB(t *testing.T) []byte {
+	t.Helper()
+
+	const empty = -1
+	type node struct{ children [2]int } // >= 0 node index, empty, or < -1 data reference
+	nodes := []node{{[2]int{empty, empty}}}
+	var records [][]byte
+
+	for _, network := range fixtureNetworks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		addr := ipNet.IP.To16()
+		if ipNet.IP.To4() != nil {
+			addr = append(make(net.IP, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+
+		records = append(records, encodeMMDBValue(network.record))
+		dataRef := -2 - (len(records) - 1)
+
+		current := 0
+		for i := 0; i < ones; i++ {
+			bit := int(addr[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[current].children[bit] = dataRef
+				break
+			}
+			if nodes[current].children[bit] == empty {
+				nodes = append(nodes, node{[2]int{empty, empty}})
+				nodes[current].children[bit] = len(nodes) - 1
+			}
+			current = nodes[current].children[bit]
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := make([]int, len(records))
+	for i, record := range records {
+		offsets[i] = data.Len()
+		data.Write(record)
+	}
+
+	nodeCount := len(nodes)
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for _, child := range n.children {
+			var value int
+			switch {
+			case child == empty:
+				value = nodeCount
+			case child < empty:
+				value = nodeCount + mmdbDataSectionSeparator + offsets[-child-2]
+			default:
+				value = child
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, mmdbDataSectionSeparator))
+	out.Write(data.Bytes())
+	out.Write(mmdbMetadataMarker)
+	out.Write(encodeMMDBValue(map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(6),
+		"database_type":               "netspy-test",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	}))
+	return out.Bytes()
+}
+
+// encodeMMDBValue encodes strings, unsigned integers and maps in the MaxMind DB data format
+func encodeMMDBValue(value any) []byte {
+	var buf bytes.Buffer
+	writeHeader := func(typeNum int, size int) {
+		// Sizes of 29 and above are stored in a following byte (fixtures stay below 285)
+		sizeBits, extra := size, []byte(nil)
+		if size >= 29 {
+			sizeBits, extra = 29, []byte{byte(size - 29)}
+		}
+		if typeNum <= 7 {
+			buf.WriteByte(byte(typeNum<<5 | sizeBits))
+		} else {
+			buf.WriteByte(byte(sizeBits))
+			buf.WriteByte(byte(typeNum - 7))
+		}
+		buf.Write(extra)
+	}
+
+	switch v := value.(type) {
+	case string:
+		writeHeader(mmdbString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeHeader(mmdbUint16, 2)
+		binary.Write(&buf, binary.BigEndian, v)
+	case uint32:
+		writeHeader(mmdbUint32, 4)
+		binary.Write(&buf, binary.BigEndian, v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeHeader(mmdbMap, len(v))
+		for _, key := range keys {
+			buf.Write(encodeMMDBValue(key))
+			buf.Write(encodeMMDBValue(v[key]))
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestMMDBDecoder_Pointers(t *testing.T) {
+	data := make([]byte, 2100)
+	copy(data, []byte{0x42, 'a', 'b'}) // string "ab" at offset 0
+	copy(data[3:], []byte{0x20, 0x00}) // 1-byte pointer to offset 0
+	copy(data[2060:], []byte{0x43, 'x', 'y', 'z'})
+	copy(data[5:], []byte{0x28, 0x00, 0x0C}) // 2-byte pointer: 0x000C + 2048 = 2060
+
+	decoder := mmdbDecoder{buffer: data}
+	value, next, err := decoder.decode(3)
+	if err != nil || value != "ab" || next != 5 {
+		t.Errorf("expected pointer to resolve to \"ab\" ending at 5, got %v, %d, %v", value, next, err)
+	}
+	value, next, err = decoder.decode(5)
+	if err != nil || value != "xyz" || next != 8 {
+		t.Errorf("expected 2-byte pointer to resolve to \"xyz\" ending at 8, got %v, %d, %v", value, next, err)
+	}
+}
@@ -0,0 +1,122 @@
+package enrich
+
+import (
+	"net"
+	"strings"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// Scope classifies where a destination lives relative to the host
+type Scope string
+
+const (
+	ScopeLoopback      Scope = "loopback"
+	ScopePrivate       Scope = "private"
+	ScopeLinkLocal     Scope = "link-local"
+	ScopeCGNAT         Scope = "cgnat"
+	ScopeMulticast     Scope = "multicast"
+	ScopeCloudMetadata Scope = "cloud-metadata"
+	ScopePublic        Scope = "public"
+	ScopeUnix          Scope = "unix"
+	ScopeUnspecified   Scope = "unspecified"
+)
+
+// AllScopes lists every scope in reporting order
+var AllScopes = []Scope{
+	ScopeCloudMetadata, ScopePublic, ScopePrivate, ScopeCGNAT, ScopeLinkLocal,
+	ScopeLoopback, ScopeMulticast, ScopeUnspecified, ScopeUnix,
+}
+
+// cloudMetadataIPs are the instance metadata endpoints of the major cloud providers
+var cloudMetadataIPs = map[string]bool{
+	"169.254.169.254": true, // AWS, GCP, Azure, OpenStack
+	"fd00:ec2::254":   true, // AWS IMDS over IPv6
+}
+
+var (
+	privateNets = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+	cgnatNet    = mustParseCIDRs("100.64.0.0/10")[0]
+)
+
+// ClassifyIP returns the scope of a destination IP. An empty address is a local (unix) socket.
+func ClassifyIP(ipStr string) Scope {
+	ipStr = strings.Trim(ipStr, "[]")
+	if ipStr == "" {
+		return ScopeUnix
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return ScopeUnspecified
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	switch {
+	case cloudMetadataIPs[ip.String()]:
+		return ScopeCloudMetadata
+	case ip.IsUnspecified():
+		return ScopeUnspecified
+	case ip.IsLoopback():
+		return ScopeLoopback
+	case ip.IsMulticast():
+		return ScopeMulticast
+	case ip.IsLinkLocalUnicast():
+		return ScopeLinkLocal
+	case cgnatNet.Contains(ip):
+		return ScopeCGNAT
+	}
+
+	for _, network := range privateNets {
+		if network.Contains(ip) {
+			return ScopePrivate
+		}
+	}
+	return ScopePublic
+}
+
+// ParseScopes parses a comma-separated scope list, returning the unknown entries separately
+func ParseScopes(list string) ([]Scope, []string) {
+	var scopes []Scope
+	var unknown []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		found := false
+		for _, scope := range AllScopes {
+			if string(scope) == item {
+				scopes = append(scopes, scope)
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, item)
+		}
+	}
+	return scopes, unknown
+}
+
+// ClassifyEvents fills the Scope field of connection events in place
+func ClassifyEvents(events []netclient.ConnectionEvent) {
+	for i := range events {
+		events[i].Scope = string(ClassifyIP(events[i].DestinationIP))
+	}
+}
+
+// mustParseCIDRs parses constant network lists
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,46 @@
+package enrich
+
+import "testing"
+
+func TestClassifyIP(t *testing.T) {
+	tests := map[string]Scope{
+		"":                ScopeUnix,
+		"127.0.0.1":       ScopeLoopback,
+		"[::1]":           ScopeLoopback,
+		"10.1.2.3":        ScopePrivate,
+		"172.20.0.5":      ScopePrivate,
+		"192.168.1.10":    ScopePrivate,
+		"fd12:3456::1":    ScopePrivate,
+		"169.254.10.1":    ScopeLinkLocal,
+		"fe80::1":         ScopeLinkLocal,
+		"100.64.0.1":      ScopeCGNAT,
+		"224.0.0.251":     ScopeMulticast,
+		"ff02::fb":        ScopeMulticast,
+		"169.254.169.254": ScopeCloudMetadata,
+		"fd00:ec2::254":   ScopeCloudMetadata,
+		"8.8.8.8":         ScopePublic,
+		"2606:4700::1111": ScopePublic,
+		"::ffff:10.0.0.1": ScopePrivate,
+		"0.0.0.0":         ScopeUnspecified,
+		"not-an-ip":       ScopeUnspecified,
+	}
+	for ip, expected := range tests {
+		if got := ClassifyIP(ip); got != expected {
+			t.Errorf("ClassifyIP(%q) = %s, expected %s", ip, got, expected)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, unknown := ParseScopes("public, Cloud-Metadata,bogus")
+	if len(scopes) != 2 || scopes[0] != ScopePublic || scopes[1] != ScopeCloudMetadata {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+	if len(unknown) != 1 || unknown[0] != "bogus" {
+		t.Errorf("expected bogus to be reported, got %v", unknown)
+	}
+
+	if scopes, unknown := ParseScopes(""); scopes != nil || unknown != nil {
+		t.Errorf("expected empty list to parse to nothing, got %v %v", scopes, unknown)
+	}
+}
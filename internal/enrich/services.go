@@ -0,0 +1,120 @@
+package enrich
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// builtinServices is a subset of the IANA service registry with display names, keyed by "port/proto"
+var builtinServices = map[string]string{
+	"20/tcp": "FTP-Data", "21/tcp": "FTP", "22/tcp": "SSH", "23/tcp": "Telnet",
+	"25/tcp": "SMTP", "53/tcp": "DNS", "53/udp": "DNS", "67/udp": "DHCP", "68/udp": "DHCP",
+	"69/udp": "TFTP", "80/tcp": "HTTP", "88/tcp": "Kerberos", "88/udp": "Kerberos",
+	"110/tcp": "POP3", "123/udp": "NTP", "135/tcp": "MS-RPC", "137/udp": "NetBIOS",
+	"139/tcp": "NetBIOS", "143/tcp": "IMAP", "161/udp": "SNMP", "162/udp": "SNMP-Trap",
+	"179/tcp": "BGP", "389/tcp": "LDAP", "443/tcp": "HTTPS", "443/udp": "QUIC",
+	"445/tcp": "SMB", "465/tcp": "SMTPS", "514/udp": "Syslog", "587/tcp": "SMTP-Submission",
+	"636/tcp": "LDAPS", "853/tcp": "DNS-over-TLS", "873/tcp": "rsync", "993/tcp": "IMAPS",
+	"995/tcp": "POP3S", "1433/tcp": "MSSQL", "1521/tcp": "Oracle", "1883/tcp": "MQTT",
+	"2049/tcp": "NFS", "2375/tcp": "Docker", "2376/tcp": "Docker-TLS", "2379/tcp": "etcd",
+	"2380/tcp": "etcd-peer", "3000/tcp": "Grafana", "3306/tcp": "MySQL", "3389/tcp": "RDP",
+	"4222/tcp": "NATS", "4317/tcp": "OTLP-gRPC", "4318/tcp": "OTLP-HTTP", "5044/tcp": "Beats",
+	"5353/udp": "mDNS", "5432/tcp": "PostgreSQL", "5601/tcp": "Kibana", "5672/tcp": "AMQP",
+	"5900/tcp": "VNC", "6379/tcp": "Redis", "6443/tcp": "Kubernetes-API", "6667/tcp": "IRC",
+	"8086/tcp": "InfluxDB", "8125/udp": "StatsD", "8200/tcp": "Vault", "8300/tcp": "Consul",
+	"8443/tcp": "HTTPS-Alt", "8500/tcp": "Consul-HTTP", "8883/tcp": "MQTTS", "9000/tcp": "MinIO",
+	"9042/tcp": "Cassandra", "9090/tcp": "Prometheus", "9092/tcp": "Kafka", "9093/tcp": "Alertmanager",
+	"9100/tcp": "Node-Exporter", "9200/tcp": "Elasticsearch", "9300/tcp": "Elasticsearch-Transport",
+	"10250/tcp": "Kubelet", "11211/tcp": "Memcached", "27017/tcp": "MongoDB",
+}
+
+// EphemeralPortStart is the start of the IANA dynamic/private port range
+const EphemeralPortStart = 49152
+
+// serviceKey builds the lookup key for a port and protocol name such as "TCP"
+func serviceKey(port uint16, protocol string) string {
+	return fmt.Sprintf("%d/%s", port, strings.ToLower(protocol))
+}
+
+// BuiltinServiceName returns the built-in name of a port, or "" if it is not in the registry subset
+func BuiltinServiceName(port uint16, protocol string) string {
+	return builtinServices[serviceKey(port, protocol)]
+}
+
+// ParseServices reads an /etc/services style file into a "port/proto" to name map
+func ParseServices(r io.Reader) map[string]string {
+	services := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		portProto := strings.ToLower(fields[1])
+		if _, exists := services[portProto]; !exists {
+			services[portProto] = fields[0]
+		}
+	}
+	return services
+}
+
+// ServiceMapper maps destination ports to service names. User overrides take precedence over
+// the built-in registry subset, which takes precedence over the system services file.
+type ServiceMapper struct {
+	overrides map[string]string
+	system    map[string]string
+}
+
+// NewServiceMapper creates a mapper. Override keys are "port" (any protocol) or "port/proto".
+// An empty servicesFile skips the system services database.
+func NewServiceMapper(servicesFile string, overrides map[string]string) *ServiceMapper {
+	m := &ServiceMapper{
+		overrides: make(map[string]string),
+		system:    make(map[string]string),
+	}
+	for key, name := range overrides {
+		m.overrides[strings.ToLower(key)] = name
+	}
+	if servicesFile != "" {
+		if f, err := os.Open(servicesFile); err == nil {
+			m.system = ParseServices(f)
+			f.Close()
+		}
+	}
+	return m
+}
+
+// Name returns the service name for a destination port, or "" if unknown
+func (m *ServiceMapper) Name(port uint16, protocol string) string {
+	if port == 0 {
+		return ""
+	}
+	key := serviceKey(port, protocol)
+	if name, ok := m.overrides[key]; ok {
+		return name
+	}
+	if name, ok := m.overrides[strconv.Itoa(int(port))]; ok {
+		return name
+	}
+	if name, ok := builtinServices[key]; ok {
+		return name
+	}
+	return m.system[key]
+}
+
+// Annotate fills the Service field of connection events in place
+func (m *ServiceMapper) Annotate(events []netclient.ConnectionEvent) {
+	for i := range events {
+		events[i].Service = m.Name(events[i].DestinationPort, events[i].Protocol)
+	}
+}
@@ -0,0 +1,75 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseServices(t *testing.T) {
+	data := `# Network services
+ssh		22/tcp				# SSH Remote Login Protocol
+domain		53/tcp
+domain		53/udp
+http		80/tcp		www		# WorldWideWeb HTTP
+webcache	8080/tcp	http-alt
+http-alt	8080/tcp
+`
+	services := ParseServices(strings.NewReader(data))
+	tests := map[string]string{
+		"22/tcp":   "ssh",
+		"53/udp":   "domain",
+		"80/tcp":   "http",
+		"8080/tcp": "webcache",
+	}
+	for key, expected := range tests {
+		if got := services[key]; got != expected {
+			t.Errorf("services[%q] = %q, expected %q", key, got, expected)
+		}
+	}
+	if len(services) != 5 {
+		t.Errorf("expected 5 entries, got %d: %v", len(services), services)
+	}
+}
+
+func TestServiceMapperPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services")
+	data := "https 443/tcp\nwebcache 8080/tcp\nsunproxyadmin 8081/tcp\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := NewServiceMapper(path, map[string]string{
+		"8081":     "internal-api",
+		"5432/TCP": "orders-db",
+		"9999/udp": "telemetry",
+	})
+
+	tests := []struct {
+		port     uint16
+		protocol string
+		expected string
+	}{
+		{443, "TCP", "HTTPS"},         // built-in beats system file
+		{8080, "TCP", "webcache"},     // system file fills gaps
+		{8081, "TCP", "internal-api"}, // port override beats system file
+		{5432, "TCP", "orders-db"},    // port/proto override beats built-in
+		{9999, "UDP", "telemetry"},    // protocol-specific override
+		{9999, "TCP", ""},             // ...does not apply to other protocols
+		{0, "TCP", ""},                // local sockets have no service
+		{60000, "TCP", ""},
+	}
+	for _, tt := range tests {
+		if got := mapper.Name(tt.port, tt.protocol); got != tt.expected {
+			t.Errorf("Name(%d, %s) = %q, expected %q", tt.port, tt.protocol, got, tt.expected)
+		}
+	}
+}
+
+func TestServiceMapperMissingFile(t *testing.T) {
+	mapper := NewServiceMapper(filepath.Join(t.TempDir(), "missing"), nil)
+	if got := mapper.Name(22, "TCP"); got != "SSH" {
+		t.Errorf("expected built-in names without a services file, got %q", got)
+	}
+}
@@ -0,0 +1,316 @@
+// Package fakeopenai implements a scripted stand-in for an OpenAI-compatible chat completions API.
+// Each request is answered with the next canned assistant turn, and requests are recorded so tests
+// can check what the client sent. Requests with "stream": true are answered with server-sent
+// events that split the content into words and the tool call arguments into fragments.
+package fakeopenai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Turn is one canned response from the fake server
+type Turn struct {
+	// Content is the assistant message text
+	Content string
+	// ToolCalls are function calls requested by the assistant
+	ToolCalls []ToolCall
+	// NilContent sends a null content field even when Content is empty and there are no tool calls
+	NilContent bool
+	// FinishReason defaults to "tool_calls" when ToolCalls is set and "stop" otherwise
+	FinishReason string
+	// PromptTokens and CompletionTokens are reported as usage when either is set
+	PromptTokens     int
+	CompletionTokens int
+
+	// Error returns an API error object with this message instead of a choice
+	Error string
+	// NoChoices returns an empty choices list
+	NoChoices bool
+	// Status is the HTTP status code (default: 200)
+	Status int
+	// RawBody is sent verbatim instead of a generated response
+	RawBody string
+}
+
+// ToolCall is a function call requested by a canned assistant turn
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // JSON-encoded arguments (default: "{}")
+}
+
+// Message is a chat message as received by the fake server
+type Message struct {
+	Role       string            `json:"role"`
+	Content    *string           `json:"content"`
+	ToolCalls  []json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+}
+
+// Text returns the message content, or an empty string for null content
+func (m Message) Text() string {
+	if m.Content == nil {
+		return ""
+	}
+	return *m.Content
+}
+
+// Request is a chat completion request received by the fake server
+type Request struct {
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Tools          []RequestTool   `json:"tools,omitempty"`
+	ToolChoice     json.RawMessage `json:"tool_choice,omitempty"`
+	ResponseFormat json.RawMessage `json:"response_format,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	Authorization  string          `json:"-"`
+}
+
+// StreamOptions are the stream settings of a request
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// RequestTool is a tool definition offered to the model in a request
+type RequestTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+// ToolNames returns the names of the tools offered in the request
+func (r Request) ToolNames() []string {
+	names := make([]string, 0, len(r.Tools))
+	for _, tool := range r.Tools {
+		names = append(names, tool.Function.Name)
+	}
+	return names
+}
+
+// Server replays scripted turns in order on POST /chat/completions
+type Server struct {
+	mu       sync.Mutex
+	turns    []Turn
+	next     int
+	requests []Request
+}
+
+// New creates a server that answers requests with the given turns in order
+func New(turns ...Turn) *Server {
+	return &Server{turns: turns}
+}
+
+// Enqueue appends turns to the script
+func (s *Server) Enqueue(turns ...Turn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns = append(s.turns, turns...)
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Remaining returns the number of scripted turns not yet served
+func (s *Server) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.turns) - s.next
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	req.Authorization = r.Header.Get("Authorization")
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	if s.next >= len(s.turns) {
+		s.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("script exhausted after %d turns", len(s.turns)))
+		return
+	}
+	turn := s.turns[s.next]
+	s.next++
+	index := s.next
+	s.mu.Unlock()
+
+	status := turn.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if req.Stream && status == http.StatusOK && turn.RawBody == "" {
+		includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		turn.stream(w, turn.response(req.Model, index), includeUsage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if turn.RawBody != "" {
+		_, _ = io.WriteString(w, turn.RawBody)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(turn.response(req.Model, index))
+}
+
+// response builds the chat completion body for a turn
+func (t Turn) response(model string, index int) map[string]any {
+	resp := map[string]any{
+		"id":      fmt.Sprintf("chatcmpl-fake-%d", index),
+		"object":  "chat.completion",
+		"model":   model,
+		"choices": []any{},
+	}
+	if t.Error != "" {
+		resp["error"] = map[string]any{"message": t.Error, "type": "invalid_request_error"}
+		delete(resp, "choices")
+		return resp
+	}
+	if t.NoChoices {
+		return resp
+	}
+
+	message := map[string]any{"role": "assistant", "content": nil}
+	if t.Content != "" || (len(t.ToolCalls) == 0 && !t.NilContent) {
+		message["content"] = t.Content
+	}
+	if len(t.ToolCalls) > 0 {
+		calls := make([]map[string]any, 0, len(t.ToolCalls))
+		for i, call := range t.ToolCalls {
+			id := call.ID
+			if id == "" {
+				id = fmt.Sprintf("call_%d_%d", index, i+1)
+			}
+			arguments := call.Arguments
+			if arguments == "" {
+				arguments = "{}"
+			}
+			calls = append(calls, map[string]any{
+				"id":       id,
+				"type":     "function",
+				"function": map[string]any{"name": call.Name, "arguments": arguments},
+			})
+		}
+		message["tool_calls"] = calls
+	}
+
+	finishReason := t.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+		if len(t.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+	}
+	resp["choices"] = []any{map[string]any{"index": 0, "message": message, "finish_reason": finishReason}}
+	if t.PromptTokens > 0 || t.CompletionTokens > 0 {
+		resp["usage"] = map[string]any{
+			"prompt_tokens":     t.PromptTokens,
+			"completion_tokens": t.CompletionTokens,
+			"total_tokens":      t.PromptTokens + t.CompletionTokens,
+		}
+	}
+	return resp
+}
+
+// stream sends a generated response as chat completion chunks
+func (t Turn) stream(w http.ResponseWriter, resp map[string]any, includeUsage bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	send := func(data any) {
+		encoded, _ := json.Marshal(data)
+		fmt.Fprintf(w, "data: %s\n\n", encoded)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	chunk := func(delta map[string]any, finishReason any) map[string]any {
+		return map[string]any{
+			"id":      resp["id"],
+			"object":  "chat.completion.chunk",
+			"model":   resp["model"],
+			"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		}
+	}
+
+	if t.Error != "" {
+		send(map[string]any{"error": resp["error"]})
+		return
+	}
+	if !t.NoChoices {
+		choice := resp["choices"].([]any)[0].(map[string]any)
+		message := choice["message"].(map[string]any)
+		send(chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+		for _, word := range splitWords(t.Content) {
+			send(chunk(map[string]any{"content": word}, nil))
+		}
+		calls, _ := message["tool_calls"].([]map[string]any)
+		for i, call := range calls {
+			function := call["function"].(map[string]any)
+			arguments := function["arguments"].(string)
+			half := len(arguments) / 2
+			send(chunk(map[string]any{"tool_calls": []any{map[string]any{
+				"index": i, "id": call["id"], "type": "function",
+				"function": map[string]any{"name": function["name"], "arguments": ""},
+			}}}, nil))
+			for _, fragment := range []string{arguments[:half], arguments[half:]} {
+				send(chunk(map[string]any{"tool_calls": []any{map[string]any{
+					"index": i, "function": map[string]any{"arguments": fragment},
+				}}}, nil))
+			}
+		}
+		send(chunk(map[string]any{}, choice["finish_reason"]))
+	}
+	if usage, ok := resp["usage"]; ok && includeUsage {
+		send(map[string]any{"id": resp["id"], "object": "chat.completion.chunk", "choices": []any{}, "usage": usage})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// splitWords splits text after each space, so the pieces join back to the original
+func splitWords(text string) []string {
+	var words []string
+	for text != "" {
+		i := strings.IndexByte(text, ' ')
+		if i < 0 {
+			words = append(words, text)
+			break
+		}
+		words = append(words, text[:i+1])
+		text = text[i+1:]
+	}
+	return words
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": message, "type": "fake_server_error"},
+	})
+}
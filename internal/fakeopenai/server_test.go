@@ -0,0 +1,58 @@
+package fakeopenai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, server *Server, path, body string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	var decoded map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid response body %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, decoded
+}
+
+func TestServer_ToolCallDefaults(t *testing.T) {
+	server := New(Turn{ToolCalls: []ToolCall{{Name: "list_connections"}}})
+
+	status, resp := post(t, server, "/v1/chat/completions", `{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	choice := resp["choices"].([]any)[0].(map[string]any)
+	if choice["finish_reason"] != "tool_calls" {
+		t.Errorf("expected tool_calls finish reason, got %v", choice["finish_reason"])
+	}
+	message := choice["message"].(map[string]any)
+	if message["content"] != nil {
+		t.Errorf("expected null content alongside tool calls, got %v", message["content"])
+	}
+	call := message["tool_calls"].([]any)[0].(map[string]any)
+	function := call["function"].(map[string]any)
+	if call["id"] != "call_1_1" || function["arguments"] != "{}" {
+		t.Errorf("unexpected generated tool call %v", call)
+	}
+	if server.Remaining() != 0 || len(server.Requests()) != 1 {
+		t.Errorf("expected the turn to be consumed and the request recorded")
+	}
+}
+
+func TestServer_ScriptExhaustedAndUnknownEndpoint(t *testing.T) {
+	server := New()
+	if status, resp := post(t, server, "/chat/completions", `{}`); status != http.StatusInternalServerError || resp["error"] == nil {
+		t.Errorf("expected error when script is exhausted, got %d %v", status, resp)
+	}
+	if status, _ := post(t, server, "/embeddings", `{}`); status != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown endpoint, got %d", status)
+	}
+	if status, _ := post(t, server, "/chat/completions", `not json`); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid body, got %d", status)
+	}
+}
@@ -0,0 +1,185 @@
+// Package fakeserver implements an in-process stand-in for the eBPF server HTTP API, driven by
+// scenario timelines, for tests, demos and development
+package fakeserver
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Scenario is a timeline of connection and packet drop events, plus faults to inject
+type Scenario struct {
+	Name        string           `json:"name,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Connections []ConnectionSpec `json:"connections,omitempty"`
+	Drops       []DropSpec       `json:"drops,omitempty"`
+	Faults      []Fault          `json:"faults,omitempty"`
+}
+
+// Timing places an event on the timeline. Offset is relative to server start: negative offsets are
+// history that exists at start, positive ones appear while the server runs. Repeat and Every turn
+// one entry into a periodic series.
+type Timing struct {
+	Offset Duration `json:"offset,omitempty"`
+	Repeat int      `json:"repeat,omitempty"` // Number of events (default: 1)
+	Every  Duration `json:"every,omitempty"`  // Interval between repeated events
+}
+
+// ConnectionSpec describes one or more connection events
+type ConnectionSpec struct {
+	Timing
+	PID         uint32 `json:"pid"`
+	Command     string `json:"command"`
+	Destination string `json:"destination"`           // "ip:port" or "[ipv6]:port"
+	Protocol    string `json:"protocol,omitempty"`    // Default: TCP
+	ReturnCode  int32  `json:"return_code,omitempty"` // e.g. -111 for connection refused
+}
+
+// DropSpec describes one or more packet drop events
+type DropSpec struct {
+	Timing
+	PID     uint32 `json:"pid"`
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+}
+
+// Fault makes matching requests fail or slow down
+type Fault struct {
+	// Endpoint is the request path to affect, e.g. /api/list-connections (default: all except /health)
+	Endpoint string `json:"endpoint,omitempty"`
+	// Status is returned instead of the normal response when non-zero, e.g. 500
+	Status int `json:"status,omitempty"`
+	// Latency delays the response
+	Latency Duration `json:"latency,omitempty"`
+	// Malformed returns a truncated JSON body with status 200
+	Malformed bool `json:"malformed,omitempty"`
+	// Count limits the fault to the first N matching requests (default: every request)
+	Count int `json:"count,omitempty"`
+	// Rate applies the fault to a random fraction of matching requests, between 0 and 1 (default: 1)
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// Duration is a time.Duration that reads and writes JSON strings such as "30s" or "-5m"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %v", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+//go:embed demo.json
+var demoScenario []byte
+
+// DemoScenario returns the built-in scenario used when no scenario file is given
+func DemoScenario() *Scenario {
+	scenario, err := ParseScenario(bytes.NewReader(demoScenario))
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in scenario: %v", err))
+	}
+	return scenario
+}
+
+// LoadScenario reads a JSON scenario file
+func LoadScenario(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scenario: %v", err)
+	}
+	defer f.Close()
+
+	scenario, err := ParseScenario(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %v", path, err)
+	}
+	return scenario, nil
+}
+
+// ParseScenario decodes and validates a JSON scenario
+func ParseScenario(r io.Reader) (*Scenario, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	var scenario Scenario
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, err
+	}
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+// Validate checks the scenario for entries that cannot be served
+func (s *Scenario) Validate() error {
+	for i, spec := range s.Connections {
+		if spec.Destination == "" {
+			return fmt.Errorf("connection %d: destination is required", i)
+		}
+		if err := spec.Timing.validate(); err != nil {
+			return fmt.Errorf("connection %d: %v", i, err)
+		}
+	}
+	for i, spec := range s.Drops {
+		if spec.Reason == "" {
+			return fmt.Errorf("drop %d: reason is required", i)
+		}
+		if err := spec.Timing.validate(); err != nil {
+			return fmt.Errorf("drop %d: %v", i, err)
+		}
+	}
+	for i, fault := range s.Faults {
+		if fault.Rate < 0 || fault.Rate > 1 {
+			return fmt.Errorf("fault %d: rate must be between 0 and 1", i)
+		}
+		if fault.Status == 0 && fault.Latency == 0 && !fault.Malformed {
+			return fmt.Errorf("fault %d: set status, latency or malformed", i)
+		}
+	}
+	return nil
+}
+
+func (t Timing) validate() error {
+	if t.Repeat < 0 {
+		return fmt.Errorf("repeat must not be negative")
+	}
+	if t.Repeat > 1 && t.Every <= 0 {
+		return fmt.Errorf("every must be positive when repeat is set")
+	}
+	return nil
+}
+
+// offsets returns the timeline offsets of each event described by the timing
+func (t Timing) offsets() []time.Duration {
+	repeat := t.Repeat
+	if repeat == 0 {
+		repeat = 1
+	}
+	offsets := make([]time.Duration, repeat)
+	for i := range offsets {
+		offsets[i] = time.Duration(t.Offset) + time.Duration(i)*time.Duration(t.Every)
+	}
+	return offsets
+}
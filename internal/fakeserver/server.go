@@ -0,0 +1,407 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// connection is a materialized connection event on the timeline
+type connection struct {
+	at   time.Time
+	info netclient.ConnectionInfo
+}
+
+// drop is a materialized packet drop event on the timeline
+type drop struct {
+	at   time.Time
+	info netclient.PacketDropInfo
+}
+
+// Server serves the eBPF server HTTP API from a scenario. Events become visible once the clock
+// passes their timeline position.
+type Server struct {
+	scenario    *Scenario
+	now         func() time.Time
+	connections []connection
+	drops       []drop
+	verbose     bool
+
+	mu       sync.Mutex
+	faults   []Fault
+	hits     []int // Requests each fault has been applied to
+	requests map[string]int
+	random   *rand.Rand
+}
+
+// New creates a server for a scenario, with the timeline starting now
+func New(scenario *Scenario) *Server {
+	return NewWithClock(scenario, time.Now)
+}
+
+// NewWithClock creates a server reading time from the given clock. The timeline starts at the
+// clock's current time.
+func NewWithClock(scenario *Scenario, now func() time.Time) *Server {
+	if scenario == nil {
+		scenario = &Scenario{}
+	}
+	s := &Server{
+		scenario: scenario,
+		now:      now,
+		requests: make(map[string]int),
+		random:   rand.New(rand.NewSource(1)),
+	}
+
+	start := now()
+	for _, spec := range scenario.Connections {
+		for _, offset := range spec.offsets() {
+			s.connections = append(s.connections, connection{at: start.Add(offset), info: connectionInfo(spec, start.Add(offset))})
+		}
+	}
+	sort.SliceStable(s.connections, func(i, j int) bool { return s.connections[i].at.Before(s.connections[j].at) })
+	for i := range s.connections {
+		s.connections[i].info.ID = strconv.Itoa(i + 1)
+	}
+
+	for _, spec := range scenario.Drops {
+		for _, offset := range spec.offsets() {
+			at := start.Add(offset)
+			s.drops = append(s.drops, drop{at: at, info: netclient.PacketDropInfo{
+				PID:       spec.PID,
+				Command:   spec.Command,
+				Reason:    spec.Reason,
+				Timestamp: float64(at.UnixNano()),
+			}})
+		}
+	}
+	sort.SliceStable(s.drops, func(i, j int) bool { return s.drops[i].at.Before(s.drops[j].at) })
+
+	for _, fault := range scenario.Faults {
+		s.AddFault(fault)
+	}
+	return s
+}
+
+// SetVerbose enables logging of every request
+func (s *Server) SetVerbose(verbose bool) {
+	s.verbose = verbose
+}
+
+// AddFault injects a fault in addition to those of the scenario
+func (s *Server) AddFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault)
+	s.hits = append(s.hits, 0)
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+	s.hits = nil
+}
+
+// Requests returns the number of requests received for a path
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// ServeHTTP implements the eBPF server API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.verbose {
+		log.Printf("Fake server: %s %s", r.Method, r.URL.String())
+	}
+
+	fault, faulted := s.matchFault(r.URL.Path)
+	if faulted {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(time.Duration(fault.Latency)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			writeError(w, fault.Status, "injected_fault", fmt.Sprintf("injected %d for %s", fault.Status, r.URL.Path))
+			return
+		}
+		if fault.Malformed {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"total_events": 3, "events_by_pid": {"1": [{"pid": 1,`))
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/health":
+		s.handleHealth(w, r)
+	case "/api/connection-summary":
+		s.handleConnectionSummary(w, r)
+	case "/api/list-connections":
+		s.handleListConnections(w, r)
+	case "/api/packet-drop-summary":
+		s.handlePacketDropSummary(w, r)
+	case "/api/list-packet-drops":
+		s.handleListPacketDrops(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("unknown endpoint %s", r.URL.Path))
+	}
+}
+
+// matchFault counts the request and returns the fault to apply to it, if any
+func (s *Server) matchFault(path string) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[path]++
+	for i, fault := range s.faults {
+		if fault.Endpoint == "" && path == "/health" {
+			continue
+		}
+		if fault.Endpoint != "" && fault.Endpoint != path {
+			continue
+		}
+		if fault.Count > 0 && s.hits[i] >= fault.Count {
+			continue
+		}
+		if fault.Rate > 0 && s.random.Float64() >= fault.Rate {
+			continue
+		}
+		s.hits[i]++
+		return fault, true
+	}
+	return Fault{}, false
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET")
+		return
+	}
+	writeJSON(w, map[string]string{"status": "healthy", "scenario": s.scenario.Name})
+}
+
+// summaryRequest is the body of both summary endpoints
+type summaryRequest struct {
+	PID             int    `json:"pid"`
+	Command         string `json:"command"`
+	ProcessName     string `json:"process_name"`
+	DurationSeconds int    `json:"duration_seconds"`
+}
+
+func (s *Server) decodeSummaryRequest(w http.ResponseWriter, r *http.Request) (summaryRequest, bool) {
+	var req summaryRequest
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use POST")
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return req, false
+	}
+	if req.Command == "" {
+		req.Command = req.ProcessName
+	}
+	if req.DurationSeconds <= 0 {
+		req.DurationSeconds = 60
+	}
+	return req, true
+}
+
+func (s *Server) handleConnectionSummary(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeSummaryRequest(w, r)
+	if !ok {
+		return
+	}
+
+	now := s.now()
+	since := now.Add(-time.Duration(req.DurationSeconds) * time.Second)
+	count := 0
+	for _, c := range s.visibleConnections(now) {
+		if !c.at.Before(since) && matchesProcess(c.info.PID, c.info.Command, req.PID, req.Command) {
+			count++
+		}
+	}
+
+	writeJSON(w, netclient.ConnectionSummaryOutput{
+		Count:           count,
+		PID:             req.PID,
+		Command:         req.Command,
+		DurationSeconds: req.DurationSeconds,
+		QueryTime:       now.UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *Server) handleListConnections(w http.ResponseWriter, r *http.Request) {
+	var pid, limit int
+	switch r.Method {
+	case http.MethodGet:
+		pid, _ = strconv.Atoi(r.URL.Query().Get("pid"))
+		limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	case http.MethodPost:
+		var req netclient.ListConnectionsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		if req.PID != nil {
+			pid = *req.PID
+		}
+		if req.Limit != nil {
+			limit = *req.Limit
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST")
+		return
+	}
+
+	now := s.now()
+	var matching []connection
+	for _, c := range s.visibleConnections(now) {
+		if matchesProcess(c.info.PID, c.info.Command, pid, "") {
+			matching = append(matching, c)
+		}
+	}
+	// The limit keeps the most recent events
+	if limit > 0 && len(matching) > limit {
+		matching = matching[len(matching)-limit:]
+	}
+
+	output := netclient.ListConnectionsOutput{
+		TotalEvents: len(matching),
+		EventsByPID: make(map[string][]netclient.ConnectionInfo),
+		QueryTime:   now.UTC().Format(time.RFC3339),
+	}
+	for _, c := range matching {
+		key := strconv.FormatUint(uint64(c.info.PID), 10)
+		output.EventsByPID[key] = append(output.EventsByPID[key], c.info)
+	}
+	output.TotalPIDs = len(output.EventsByPID)
+	writeJSON(w, output)
+}
+
+func (s *Server) handlePacketDropSummary(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeSummaryRequest(w, r)
+	if !ok {
+		return
+	}
+
+	now := s.now()
+	since := now.Add(-time.Duration(req.DurationSeconds) * time.Second)
+	count := 0
+	for _, d := range s.visibleDrops(now) {
+		if !d.at.Before(since) && matchesProcess(d.info.PID, d.info.Command, req.PID, req.Command) {
+			count++
+		}
+	}
+
+	writeJSON(w, netclient.PacketDropSummaryOutput{
+		Count:           count,
+		PID:             req.PID,
+		Command:         req.Command,
+		DurationSeconds: req.DurationSeconds,
+		QueryTime:       now.UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *Server) handleListPacketDrops(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET")
+		return
+	}
+
+	now := s.now()
+	drops := s.visibleDrops(now)
+	output := netclient.PacketDropListOutput{
+		TotalEvents: len(drops),
+		EventsByPID: make(map[string][]netclient.PacketDropInfo),
+		QueryTime:   now.UTC().Format(time.RFC3339),
+	}
+	for _, d := range drops {
+		key := strconv.FormatUint(uint64(d.info.PID), 10)
+		output.EventsByPID[key] = append(output.EventsByPID[key], d.info)
+	}
+	output.TotalPIDs = len(output.EventsByPID)
+	if len(drops) == 0 {
+		output.Message = "No packet drops recorded"
+	}
+	writeJSON(w, output)
+}
+
+// visibleConnections returns the connections that have happened by now, oldest first
+func (s *Server) visibleConnections(now time.Time) []connection {
+	n := sort.Search(len(s.connections), func(i int) bool { return s.connections[i].at.After(now) })
+	return s.connections[:n]
+}
+
+// visibleDrops returns the packet drops that have happened by now, oldest first
+func (s *Server) visibleDrops(now time.Time) []drop {
+	n := sort.Search(len(s.drops), func(i int) bool { return s.drops[i].at.After(now) })
+	return s.drops[:n]
+}
+
+func matchesProcess(pid uint32, command string, wantPID int, wantCommand string) bool {
+	return (wantPID <= 0 || pid == uint32(wantPID)) && (wantCommand == "" || command == wantCommand)
+}
+
+// connectionInfo builds the server representation of a connection event
+func connectionInfo(spec ConnectionSpec, at time.Time) netclient.ConnectionInfo {
+	protocol := strings.ToUpper(spec.Protocol)
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	socketType := "SOCK_STREAM"
+	if protocol == "UDP" {
+		socketType = "SOCK_DGRAM"
+	}
+
+	info := netclient.ConnectionInfo{
+		PID:         spec.PID,
+		Command:     spec.Command,
+		Destination: spec.Destination,
+		Protocol:    protocol,
+		SocketType:  socketType,
+		ReturnCode:  spec.ReturnCode,
+		Time:        at.UTC().Format(time.RFC3339Nano),
+		Timestamp:   float64(at.UnixNano()),
+		Type:        "connection",
+	}
+	if host, port, err := net.SplitHostPort(spec.Destination); err == nil {
+		info.DestinationIP = host
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			info.DestinationPort = uint16(p)
+		}
+		info.AddressFamily = 2 // AF_INET
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			info.AddressFamily = 10 // AF_INET6
+		}
+	}
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Fake server: failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
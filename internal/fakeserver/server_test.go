@@ -0,0 +1,134 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+const testScenario = `{
+  "name": "test",
+  "connections": [
+    {"offset": "-50s", "repeat": 5, "every": "10s", "pid": 10, "command": "curl", "destination": "1.1.1.1:443"},
+    {"offset": "-5m", "pid": 20, "command": "dig", "destination": "[2001:db8::1]:53", "protocol": "udp", "return_code": -111},
+    {"offset": "1m", "pid": 10, "command": "curl", "destination": "1.1.1.1:443"}
+  ],
+  "drops": [
+    {"offset": "-10s", "pid": 10, "command": "curl", "reason": "TCP_RESET"}
+  ]
+}`
+
+// testClock is a manually advanced clock
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func newTestServer(t *testing.T) (*Server, *testClock) {
+	t.Helper()
+	scenario, err := ParseScenario(strings.NewReader(testScenario))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	clock := &testClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	return NewWithClock(scenario, clock.Now), clock
+}
+
+func get(t *testing.T, s *Server, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+func TestTimeline(t *testing.T) {
+	s, clock := newTestServer(t)
+
+	var output netclient.ListConnectionsOutput
+	if err := json.Unmarshal(get(t, s, http.MethodGet, "/api/list-connections", "").Body.Bytes(), &output); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if output.TotalEvents != 6 || output.TotalPIDs != 2 {
+		t.Errorf("expected 6 events from 2 PIDs at start, got %d from %d", output.TotalEvents, output.TotalPIDs)
+	}
+	dig := output.EventsByPID["20"][0]
+	if dig.DestinationIP != "2001:db8::1" || dig.DestinationPort != 53 || dig.AddressFamily != 10 || dig.SocketType != "SOCK_DGRAM" || dig.ID != "1" {
+		t.Errorf("unexpected event: %+v", dig)
+	}
+
+	// Future events appear as the clock advances
+	clock.now = clock.now.Add(time.Minute)
+	output = netclient.ListConnectionsOutput{}
+	json.Unmarshal(get(t, s, http.MethodPost, "/api/list-connections", `{"pid": 10, "limit": 2}`).Body.Bytes(), &output)
+	if output.TotalEvents != 2 || output.EventsByPID["10"][1].ID != "7" {
+		t.Errorf("expected the 2 most recent curl events, got %+v", output)
+	}
+}
+
+func TestSummaries(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	var summary netclient.ConnectionSummaryOutput
+	json.Unmarshal(get(t, s, http.MethodPost, "/api/connection-summary", `{"process_name": "curl", "duration_seconds": 30}`).Body.Bytes(), &summary)
+	if summary.Count != 3 || summary.Command != "curl" {
+		t.Errorf("unexpected connection summary: %+v", summary)
+	}
+
+	var drops netclient.PacketDropSummaryOutput
+	json.Unmarshal(get(t, s, http.MethodPost, "/api/packet-drop-summary", `{"pid": 10, "duration_seconds": 60}`).Body.Bytes(), &drops)
+	if drops.Count != 1 {
+		t.Errorf("unexpected drop summary: %+v", drops)
+	}
+
+	if code := get(t, s, http.MethodGet, "/api/connection-summary", "").Code; code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", code)
+	}
+	if code := get(t, s, http.MethodGet, "/api/unknown", "").Code; code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", code)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.AddFault(Fault{Endpoint: "/api/list-packet-drops", Status: http.StatusInternalServerError, Count: 1})
+	s.AddFault(Fault{Malformed: true, Count: 1})
+
+	if code := get(t, s, http.MethodGet, "/api/list-packet-drops", "").Code; code != http.StatusInternalServerError {
+		t.Errorf("expected injected 500, got %d", code)
+	}
+	if code := get(t, s, http.MethodGet, "/health", "").Code; code != http.StatusOK {
+		t.Errorf("expected health to be unaffected, got %d", code)
+	}
+	body := get(t, s, http.MethodGet, "/api/list-connections", "").Body.Bytes()
+	if json.Valid(body) {
+		t.Errorf("expected malformed JSON, got %s", body)
+	}
+	if code := get(t, s, http.MethodGet, "/api/list-packet-drops", "").Code; code != http.StatusOK {
+		t.Errorf("expected fault to expire after one request, got %d", code)
+	}
+	if s.Requests("/api/list-packet-drops") != 2 {
+		t.Errorf("expected 2 recorded requests, got %d", s.Requests("/api/list-packet-drops"))
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	for _, scenario := range []string{
+		`{"connections": [{"pid": 1}]}`,
+		`{"connections": [{"destination": "1.1.1.1:80", "repeat": 3}]}`,
+		`{"faults": [{"endpoint": "/health"}]}`,
+		`{"faults": [{"status": 500, "rate": 2}]}`,
+		`{"unknown": true}`,
+	} {
+		if _, err := ParseScenario(strings.NewReader(scenario)); err == nil {
+			t.Errorf("expected an error for %s", scenario)
+		}
+	}
+
+	if demo := DemoScenario(); len(demo.Connections) == 0 {
+		t.Error("expected the demo scenario to have connections")
+	}
+}
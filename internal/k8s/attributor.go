@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// cgroupCacheTTL bounds how long a PID's cgroup is trusted before re-reading it, since PIDs are reused
+const cgroupCacheTTL = 30 * time.Second
+
+// Attributor maps process IDs to containers and Kubernetes pods
+type Attributor struct {
+	procRoot string
+	source   MetadataSource
+
+	mu        sync.Mutex
+	cache     map[uint32]cachedCgroup
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type cachedCgroup struct {
+	info    CgroupInfo
+	fetched time.Time
+}
+
+// NewAttributor creates an attributor reading cgroups from /proc. The metadata source may be nil,
+// in which case only container IDs are resolved.
+func NewAttributor(source MetadataSource) *Attributor {
+	return NewAttributorWithProcRoot("/proc", source)
+}
+
+// NewAttributorWithProcRoot creates an attributor reading cgroups from an alternate procfs mount
+func NewAttributorWithProcRoot(procRoot string, source MetadataSource) *Attributor {
+	if procRoot == "" {
+		procRoot = "/proc"
+	}
+	return &Attributor{
+		procRoot: procRoot,
+		source:   source,
+		cache:    make(map[uint32]cachedCgroup),
+		now:      time.Now,
+	}
+}
+
+// Attribute resolves the container and pod of a process
+func (a *Attributor) Attribute(pid uint32) (CgroupInfo, PodInfo, bool) {
+	info := a.cgroupInfo(pid)
+	if info.ContainerID == "" && info.PodUID == "" {
+		return info, PodInfo{}, false
+	}
+	if a.source == nil {
+		return info, PodInfo{}, false
+	}
+
+	pod, ok := a.source.Lookup(info)
+	return info, pod, ok
+}
+
+// cgroupInfo reads and caches the cgroup of a process. Missing processes yield an empty result.
+func (a *Attributor) cgroupInfo(pid uint32) CgroupInfo {
+	a.mu.Lock()
+	cached, ok := a.cache[pid]
+	a.mu.Unlock()
+	if ok && a.now().Sub(cached.fetched) < cgroupCacheTTL {
+		return cached.info
+	}
+
+	var info CgroupInfo
+	data, err := os.ReadFile(filepath.Join(a.procRoot, fmt.Sprintf("%d", pid), "cgroup"))
+	if err == nil {
+		info = ParseCgroup(string(data))
+	}
+
+	now := a.now()
+	a.mu.Lock()
+	a.cache[pid] = cachedCgroup{info: info, fetched: now}
+	a.pruneCache(now)
+	a.mu.Unlock()
+
+	return info
+}
+
+// pruneCache removes expired entries at most once per TTL, so long-running recorders do not
+// keep an entry for every PID they have ever seen. The caller holds the lock.
+func (a *Attributor) pruneCache(now time.Time) {
+	if now.Sub(a.lastPrune) < cgroupCacheTTL {
+		return
+	}
+	for pid, cached := range a.cache {
+		if now.Sub(cached.fetched) >= cgroupCacheTTL {
+			delete(a.cache, pid)
+		}
+	}
+	a.lastPrune = now
+}
+
+// Annotate fills the container and pod fields of connection events in place
+func (a *Attributor) Annotate(events []netclient.ConnectionEvent) {
+	for i := range events {
+		info, pod, ok := a.Attribute(events[i].PID)
+		// Keep attribution recorded while the process was alive
+		if info.ContainerID != "" {
+			events[i].ContainerID = info.ContainerID
+		}
+		if ok {
+			events[i].Namespace = pod.Namespace
+			events[i].Pod = pod.Pod
+			events[i].Container = pod.Container
+		}
+	}
+}
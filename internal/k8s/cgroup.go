@@ -0,0 +1,159 @@
+package k8s
+
+import (
+	"strings"
+)
+
+// CgroupInfo holds container identity derived from a process cgroup file
+type CgroupInfo struct {
+	ContainerID string `json:"container_id,omitempty"`
+	PodUID      string `json:"pod_uid,omitempty"`
+	Runtime     string `json:"runtime,omitempty"`
+}
+
+// runtimePrefixes maps cgroup path prefixes to container runtimes.
+// Longer prefixes must come first so "cri-containerd-" wins over "containerd-".
+var runtimePrefixes = []struct {
+	prefix  string
+	runtime string
+}{
+	{"cri-containerd-", "containerd"},
+	{"containerd-", "containerd"},
+	{"crio-conmon-", "cri-o"},
+	{"crio-", "cri-o"},
+	{"docker-", "docker"},
+	{"libpod-", "podman"},
+}
+
+// ParseCgroup extracts the container ID and pod UID from the contents of /proc/<pid>/cgroup.
+// It understands cgroupfs paths (/docker/<id>, /kubepods/<qos>/pod<uid>/<id>) as well as
+// systemd slice paths (kubepods-burstable-pod<uid>.slice/cri-containerd-<id>.scope).
+func ParseCgroup(content string) CgroupInfo {
+	var info CgroupInfo
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Format is hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		lineInfo := parseCgroupPath(parts[2])
+		if info.PodUID == "" {
+			info.PodUID = lineInfo.PodUID
+		}
+		if lineInfo.ContainerID != "" {
+			info.ContainerID = lineInfo.ContainerID
+			info.Runtime = lineInfo.Runtime
+			if lineInfo.PodUID != "" {
+				info.PodUID = lineInfo.PodUID
+			}
+			break
+		}
+	}
+
+	return info
+}
+
+// parseCgroupPath walks a single cgroup path from the leaf upwards
+func parseCgroupPath(path string) CgroupInfo {
+	var info CgroupInfo
+	segments := strings.Split(path, "/")
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		if segment == "" {
+			continue
+		}
+
+		if info.ContainerID == "" {
+			if id, runtime := containerIDFromSegment(segment, segments, i); id != "" {
+				info.ContainerID = id
+				info.Runtime = runtime
+				continue
+			}
+		}
+
+		if info.PodUID == "" {
+			info.PodUID = podUIDFromSegment(segment)
+		}
+	}
+
+	return info
+}
+
+// containerIDFromSegment returns the container ID embedded in a cgroup path segment, if any
+func containerIDFromSegment(segment string, segments []string, index int) (string, string) {
+	name := strings.TrimSuffix(segment, ".scope")
+
+	for _, rp := range runtimePrefixes {
+		if strings.HasPrefix(name, rp.prefix) {
+			id := strings.TrimPrefix(name, rp.prefix)
+			if isContainerID(id) {
+				return id, rp.runtime
+			}
+		}
+	}
+
+	// cgroupfs layouts use the bare ID as the leaf, e.g. /docker/<id> or /kubepods/besteffort/pod<uid>/<id>
+	if isContainerID(name) {
+		runtime := ""
+		if index > 0 {
+			switch parent := segments[index-1]; {
+			case parent == "docker":
+				runtime = "docker"
+			case strings.HasPrefix(parent, "pod"):
+				runtime = "containerd"
+			}
+		}
+		return name, runtime
+	}
+
+	return "", ""
+}
+
+// podUIDFromSegment returns the pod UID from a "pod<uid>" or "kubepods-<qos>-pod<uid>.slice" segment
+func podUIDFromSegment(segment string) string {
+	name := strings.TrimSuffix(segment, ".slice")
+	idx := strings.LastIndex(name, "pod")
+	if idx == -1 {
+		return ""
+	}
+	if idx > 0 && name[idx-1] != '-' {
+		return ""
+	}
+
+	uid := name[idx+3:]
+	// systemd escapes dashes in unit names as underscores
+	uid = strings.ReplaceAll(uid, "_", "-")
+	if len(uid) != 36 || strings.Count(uid, "-") != 4 {
+		return ""
+	}
+	return uid
+}
+
+// isContainerID reports whether s looks like a 64 character hex container ID
+func isContainerID(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !((r >= '0' && r <= '9') || (r >= 'a' && r <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeContainerID strips runtime URI prefixes such as "containerd://" from a container ID
+func NormalizeContainerID(id string) string {
+	if idx := strings.Index(id, "://"); idx != -1 {
+		return id[idx+3:]
+	}
+	return id
+}
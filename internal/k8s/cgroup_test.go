@@ -0,0 +1,81 @@
+package k8s
+
+import "testing"
+
+const testContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseCgroup(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		containerID string
+		podUID      string
+		runtime     string
+	}{
+		{
+			name:        "docker cgroupfs",
+			content:     "12:memory:/docker/" + testContainerID + "\n",
+			containerID: testContainerID,
+			runtime:     "docker",
+		},
+		{
+			name:        "docker systemd scope",
+			content:     "0::/system.slice/docker-" + testContainerID + ".scope\n",
+			containerID: testContainerID,
+			runtime:     "docker",
+		},
+		{
+			name:        "containerd cgroupfs in pod",
+			content:     "11:cpu:/kubepods/besteffort/pod5a1c2b3d-1111-2222-3333-444455556666/" + testContainerID + "\n",
+			containerID: testContainerID,
+			podUID:      "5a1c2b3d-1111-2222-3333-444455556666",
+			runtime:     "containerd",
+		},
+		{
+			name:        "containerd systemd slice",
+			content:     "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod5a1c2b3d_1111_2222_3333_444455556666.slice/cri-containerd-" + testContainerID + ".scope\n",
+			containerID: testContainerID,
+			podUID:      "5a1c2b3d-1111-2222-3333-444455556666",
+			runtime:     "containerd",
+		},
+		{
+			name:        "cri-o systemd slice",
+			content:     "0::/kubepods.slice/kubepods-pod5a1c2b3d_1111_2222_3333_444455556666.slice/crio-" + testContainerID + ".scope\n",
+			containerID: testContainerID,
+			podUID:      "5a1c2b3d-1111-2222-3333-444455556666",
+			runtime:     "cri-o",
+		},
+		{
+			name:    "host process",
+			content: "0::/user.slice/user-1000.slice/session-2.scope\n",
+		},
+		{
+			name:    "empty",
+			content: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := ParseCgroup(tt.content)
+			if info.ContainerID != tt.containerID {
+				t.Errorf("expected container ID %q, got %q", tt.containerID, info.ContainerID)
+			}
+			if info.PodUID != tt.podUID {
+				t.Errorf("expected pod UID %q, got %q", tt.podUID, info.PodUID)
+			}
+			if info.Runtime != tt.runtime {
+				t.Errorf("expected runtime %q, got %q", tt.runtime, info.Runtime)
+			}
+		})
+	}
+}
+
+func TestNormalizeContainerID(t *testing.T) {
+	if got := NormalizeContainerID("containerd://" + testContainerID); got != testContainerID {
+		t.Errorf("expected prefix to be stripped, got %q", got)
+	}
+	if got := NormalizeContainerID(testContainerID); got != testContainerID {
+		t.Errorf("expected bare ID unchanged, got %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// PodInfo describes the Kubernetes workload a container belongs to
+type PodInfo struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	PodUID    string `json:"pod_uid,omitempty"`
+}
+
+// MetadataSource resolves container identity to pod metadata
+type MetadataSource interface {
+	Lookup(info CgroupInfo) (PodInfo, bool)
+}
+
+// StaticSource is an in-memory MetadataSource keyed by container ID and pod UID
+type StaticSource struct {
+	byContainer map[string]PodInfo
+	byPodUID    map[string]PodInfo
+}
+
+// NewStaticSource creates a metadata source from a container ID to pod mapping
+func NewStaticSource(containers map[string]PodInfo) *StaticSource {
+	s := &StaticSource{
+		byContainer: make(map[string]PodInfo),
+		byPodUID:    make(map[string]PodInfo),
+	}
+	for id, pod := range containers {
+		s.add(id, pod)
+	}
+	return s
+}
+
+// add registers a container and, when known, its pod UID
+func (s *StaticSource) add(containerID string, pod PodInfo) {
+	if containerID != "" {
+		s.byContainer[NormalizeContainerID(containerID)] = pod
+	}
+	if pod.PodUID != "" {
+		if _, exists := s.byPodUID[pod.PodUID]; !exists {
+			// The pod-level entry is only a fallback, so it carries no container name
+			podOnly := pod
+			podOnly.Container = ""
+			s.byPodUID[pod.PodUID] = podOnly
+		}
+	}
+}
+
+// Lookup implements MetadataSource, preferring an exact container match over the pod UID
+func (s *StaticSource) Lookup(info CgroupInfo) (PodInfo, bool) {
+	if info.ContainerID != "" {
+		if pod, ok := s.byContainer[info.ContainerID]; ok {
+			return pod, true
+		}
+	}
+	if info.PodUID != "" {
+		if pod, ok := s.byPodUID[info.PodUID]; ok {
+			return pod, true
+		}
+	}
+	return PodInfo{}, false
+}
+
+// staticEntry is one record of a static metadata file
+type staticEntry struct {
+	ContainerID string `json:"container_id"`
+	PodInfo
+}
+
+// kubeletPodList is the subset of the kubelet /pods response used for attribution
+type kubeletPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+			UID       string `json:"uid"`
+		} `json:"metadata"`
+		Status struct {
+			ContainerStatuses     []kubeletContainerStatus `json:"containerStatuses"`
+			InitContainerStatuses []kubeletContainerStatus `json:"initContainerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type kubeletContainerStatus struct {
+	Name        string `json:"name"`
+	ContainerID string `json:"containerID"`
+}
+
+// ParseMetadata builds a StaticSource from either a kubelet pods JSON document
+// ({"items": [...]}) or a static list of {container_id, namespace, pod, container} records
+func ParseMetadata(data []byte) (*StaticSource, error) {
+	source := NewStaticSource(nil)
+
+	var probe json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %v", err)
+	}
+
+	// A static mapping is a JSON array; anything else is treated as a kubelet pod list
+	if len(probe) > 0 && probe[0] == '[' {
+		var entries []staticEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse static metadata: %v", err)
+		}
+		for _, entry := range entries {
+			source.add(entry.ContainerID, entry.PodInfo)
+		}
+		return source, nil
+	}
+
+	var pods kubeletPodList
+	if err := json.Unmarshal(data, &pods); err != nil {
+		return nil, fmt.Errorf("failed to parse kubelet pod list: %v", err)
+	}
+	for _, item := range pods.Items {
+		base := PodInfo{
+			Namespace: item.Metadata.Namespace,
+			Pod:       item.Metadata.Name,
+			PodUID:    item.Metadata.UID,
+		}
+		statuses := append(item.Status.ContainerStatuses, item.Status.InitContainerStatuses...)
+		for _, status := range statuses {
+			pod := base
+			pod.Container = status.Name
+			source.add(status.ContainerID, pod)
+		}
+		// Pods without running containers are still resolvable by UID
+		if len(statuses) == 0 {
+			source.add("", base)
+		}
+	}
+
+	return source, nil
+}
+
+// FileSource is a MetadataSource backed by a file that is reloaded when it changes
+type FileSource struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	source  *StaticSource
+}
+
+// NewFileSource creates a metadata source reading the given kubelet pods JSON or static file
+func NewFileSource(path string) (*FileSource, error) {
+	fs := &FileSource{path: path}
+	if err := fs.reload(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// reload re-reads the metadata file if its modification time changed
+func (fs *FileSource) reload() error {
+	stat, err := os.Stat(fs.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat metadata file: %v", err)
+	}
+	if fs.source != nil && stat.ModTime().Equal(fs.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(fs.path)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata file: %v", err)
+	}
+	source, err := ParseMetadata(data)
+	if err != nil {
+		return err
+	}
+
+	fs.source = source
+	fs.modTime = stat.ModTime()
+	return nil
+}
+
+// Lookup implements MetadataSource. Reload errors keep the last good snapshot.
+func (fs *FileSource) Lookup(info CgroupInfo) (PodInfo, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	_ = fs.reload()
+	if fs.source == nil {
+		return PodInfo{}, false
+	}
+	return fs.source.Lookup(info)
+}
@@ -0,0 +1,119 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+const kubeletPods = `{
+  "kind": "PodList",
+  "items": [
+    {
+      "metadata": {"name": "api-7d9f", "namespace": "payments", "uid": "5a1c2b3d-1111-2222-3333-444455556666"},
+      "status": {
+        "containerStatuses": [
+          {"name": "api", "containerID": "containerd://` + testContainerID + `"}
+        ]
+      }
+    }
+  ]
+}`
+
+func TestParseMetadata_KubeletPods(t *testing.T) {
+	source, err := ParseMetadata([]byte(kubeletPods))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pod, ok := source.Lookup(CgroupInfo{ContainerID: testContainerID})
+	if !ok {
+		t.Fatal("expected container to resolve")
+	}
+	if pod.Namespace != "payments" || pod.Pod != "api-7d9f" || pod.Container != "api" {
+		t.Errorf("unexpected pod info: %+v", pod)
+	}
+
+	// Unknown container IDs fall back to the pod UID
+	pod, ok = source.Lookup(CgroupInfo{ContainerID: "unknown", PodUID: "5a1c2b3d-1111-2222-3333-444455556666"})
+	if !ok || pod.Pod != "api-7d9f" || pod.Container != "" {
+		t.Errorf("expected pod-level fallback, got %+v (ok=%v)", pod, ok)
+	}
+}
+
+func TestParseMetadata_Static(t *testing.T) {
+	data := `[{"container_id": "docker://` + testContainerID + `", "namespace": "web", "pod": "nginx-0", "container": "nginx"}]`
+	source, err := ParseMetadata([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pod, ok := source.Lookup(CgroupInfo{ContainerID: testContainerID})
+	if !ok || pod.Pod != "nginx-0" {
+		t.Errorf("expected static entry to resolve, got %+v (ok=%v)", pod, ok)
+	}
+
+	if _, err := ParseMetadata([]byte("not json")); err == nil {
+		t.Error("expected error for invalid metadata")
+	}
+}
+
+func TestAttributor_Annotate(t *testing.T) {
+	procRoot := t.TempDir()
+	writeCgroup := func(pid, content string) {
+		dir := filepath.Join(procRoot, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCgroup("100", "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+	writeCgroup("200", "0::/user.slice/session-1.scope\n")
+
+	source := NewStaticSource(map[string]PodInfo{
+		testContainerID: {Namespace: "payments", Pod: "api-7d9f", Container: "api"},
+	})
+	attributor := NewAttributorWithProcRoot(procRoot, source)
+
+	events := []netclient.ConnectionEvent{
+		{PID: 100, Command: "api"},
+		{PID: 200, Command: "sshd"},
+		{PID: 300, Command: "gone"},
+	}
+	attributor.Annotate(events)
+
+	if events[0].Pod != "api-7d9f" || events[0].Namespace != "payments" || events[0].ContainerID != testContainerID {
+		t.Errorf("expected containerized event to be attributed, got %+v", events[0])
+	}
+	if events[1].Pod != "" || events[1].ContainerID != "" {
+		t.Errorf("expected host process to stay unattributed, got %+v", events[1])
+	}
+	if events[2].Pod != "" {
+		t.Errorf("expected missing process to stay unattributed, got %+v", events[2])
+	}
+}
+
+func TestAttributor_PrunesStalePIDs(t *testing.T) {
+	attributor := NewAttributorWithProcRoot(t.TempDir(), nil)
+	now := time.Unix(1700000000, 0)
+	attributor.now = func() time.Time { return now }
+
+	for pid := uint32(1); pid <= 100; pid++ {
+		attributor.cgroupInfo(pid)
+	}
+	if len(attributor.cache) != 100 {
+		t.Fatalf("expected 100 cached PIDs, got %d", len(attributor.cache))
+	}
+
+	// Once the TTL has passed, the next lookup drops every expired PID
+	now = now.Add(cgroupCacheTTL)
+	attributor.cgroupInfo(500)
+	if _, ok := attributor.cache[500]; !ok || len(attributor.cache) != 1 {
+		t.Errorf("expected only the fresh PID to stay cached, got %d entries", len(attributor.cache))
+	}
+}
@@ -0,0 +1,57 @@
+package mcp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// stringArgument returns a string tool argument, or "" when missing or not a string
+func stringArgument(arguments map[string]any, key string) string {
+	if val, exists := arguments[key]; exists {
+		if str, ok := val.(string); ok {
+			return str
+		}
+	}
+	return ""
+}
+
+// intArgument returns an integer tool argument, accepting JSON numbers and Go ints
+func intArgument(arguments map[string]any, key string, defaultValue int) int {
+	if val, exists := arguments[key]; exists {
+		if f, ok := val.(float64); ok {
+			return int(f)
+		} else if i, ok := val.(int); ok {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// boolArgument returns a boolean tool argument, accepting JSON booleans and "true"/"false" strings
+func boolArgument(arguments map[string]any, key string) bool {
+	if val, exists := arguments[key]; exists {
+		if b, ok := val.(bool); ok {
+			return b
+		} else if str, ok := val.(string); ok {
+			return str == "true"
+		}
+	}
+	return false
+}
+
+// timeArgument parses a time tool argument given as an RFC 3339 timestamp or as a duration before
+// now (e.g. "30m"). ok is false when the argument is missing or empty.
+func timeArgument(arguments map[string]any, key string, now time.Time) (at time.Time, ok bool, err error) {
+	value := stringArgument(arguments, key)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, true, nil
+	}
+	if ago, err := time.ParseDuration(strings.TrimPrefix(value, "-")); err == nil {
+		return now.Add(-ago), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid %s %q: use an RFC 3339 time or a duration such as 30m", key, value)
+}
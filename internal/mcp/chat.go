@@ -0,0 +1,185 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/session"
+)
+
+// chatSession keeps one contextual analyst across REPL turns so follow-up questions see the
+// earlier questions, tool results and answers. The conversation is saved after every answer.
+type chatSession struct {
+	client  *MCPClient
+	analyst *openai.ContextualNetworkAnalyst
+	session *session.Session
+	out     *terminalStream
+}
+
+// CheckChatProvider reports why chat mode cannot run with provider. Unlike single analyses,
+// a conversation has no rule-based fallback.
+func CheckChatProvider(provider openai.LLMProvider) error {
+	err := openai.CheckProvider(provider)
+	if errors.Is(err, openai.ErrOffline) {
+		return fmt.Errorf("chat mode needs a language model, but the offline provider is selected; choose one with --llm-provider or the llm section of the config file")
+	}
+	if err != nil {
+		return fmt.Errorf("chat mode needs a language model, but %s is unavailable: %v", provider.Name(), err)
+	}
+	return nil
+}
+
+// StartChat starts the MCP server and runs chat mode on standard input, continuing the saved
+// session resume when it is not nil
+func (c *MCPClient) StartChat(ctx context.Context, resume *session.Session, first string) error {
+	if err := CheckChatProvider(c.server.llm); err != nil {
+		return err
+	}
+	if err := c.server.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start MCP server: %v", err)
+	}
+	return c.runChat(ctx, bufio.NewScanner(os.Stdin), resume, first)
+}
+
+// runChat reads chat turns until /exit or end of input. A non-empty first message is sent
+// before reading.
+func (c *MCPClient) runChat(ctx context.Context, scanner *bufio.Scanner, resume *session.Session, first string) error {
+	chat := &chatSession{
+		client:  c,
+		analyst: c.server.newContextualAnalyst(),
+		out:     newTerminalStream(),
+	}
+	chat.analyst.SetStreamHandler(chat.out.handle)
+
+	provider := chat.analyst.Provider()
+	fmt.Printf("💬 Chat mode with %s (%s). Follow-up questions keep the earlier context.\n", provider.Name(), provider.Model())
+	if resume != nil {
+		chat.session = resume
+		chat.analyst.RestoreConversation(resume.Messages)
+		fmt.Printf("   Resumed session %s: %d questions, last updated %s\n",
+			resume.ID, resume.Questions(), resume.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		if last := lastAnswer(resume.Messages); last != "" {
+			fmt.Printf("   Last answer: %s\n", preview(last, 200))
+		}
+	} else {
+		chat.newSession()
+	}
+	fmt.Printf("   Saving to session %s (resume with: netspy chat --resume %s)\n", chat.session.ID, chat.session.ID)
+	fmt.Println("   Commands: /reset, /history, /trace, /help, /exit")
+	fmt.Println()
+
+	if first != "" {
+		chat.send(ctx, first)
+	}
+	for {
+		fmt.Print("chat> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			continue
+		}
+		if !strings.HasPrefix(input, "/") {
+			chat.send(ctx, input)
+			continue
+		}
+
+		switch strings.Fields(input)[0] {
+		case "/exit", "/quit":
+			fmt.Println("Leaving chat mode")
+			return nil
+		case "/reset":
+			chat.analyst.StartNewConversation()
+			chat.newSession()
+			fmt.Printf("Conversation cleared; saving to new session %s\n", chat.session.ID)
+		case "/history":
+			chat.printHistory()
+		case "/trace":
+			if run := chat.analyst.LastRun(); run != nil {
+				fmt.Print(openai.FormatTrace(run))
+			} else {
+				fmt.Println("No answer to trace yet")
+			}
+		case "/help":
+			fmt.Println("/reset    Start a new conversation")
+			fmt.Println("/history  Show the messages sent to the model with estimated sizes")
+			fmt.Println("/trace    Show the LLM requests and tool calls behind the last answer")
+			fmt.Println("/exit     Return to the netspy-mcp prompt")
+		default:
+			fmt.Printf("Unknown chat command %s (type /help)\n", input)
+		}
+		fmt.Println()
+	}
+}
+
+// send answers one chat message, streaming the reply and tool activity
+func (chat *chatSession) send(ctx context.Context, message string) {
+	_, err := chat.analyst.ContinueConversation(ctx, message)
+	chat.out.reset()
+
+	run := chat.analyst.LastRun()
+	chat.client.server.saveTrace(run)
+	provider := chat.analyst.Provider()
+	chat.session.Provider, chat.session.Model, chat.session.LLM = provider.Name(), provider.Model(), chat.client.llm
+	chat.session.Record(chat.analyst.GetConversationHistory(), run)
+	if saveErr := chat.client.sessions.Save(chat.session); saveErr != nil {
+		fmt.Printf("Warning: %v\n", saveErr)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	} else if run != nil && run.Limited() {
+		fmt.Printf("⚠️ Answer stopped early: %s after %d tool calls in %d rounds.\n", run.Describe(), run.ToolCalls, run.ToolRounds)
+	}
+	fmt.Println()
+}
+
+// newSession starts saving to a fresh session
+func (chat *chatSession) newSession() {
+	provider := chat.analyst.Provider()
+	chat.session = session.New(provider.Name(), provider.Model(), chat.client.llm)
+}
+
+// lastAnswer returns the text of the last assistant message
+func lastAnswer(messages []openai.ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "assistant" && messages[i].Content != nil && *messages[i].Content != "" {
+			return *messages[i].Content
+		}
+	}
+	return ""
+}
+
+// preview collapses whitespace and shortens text to max bytes
+func preview(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > max {
+		text = text[:max] + "…"
+	}
+	return text
+}
+
+// maxHistoryPreview bounds the text shown per message by /history
+const maxHistoryPreview = 100
+
+// printHistory lists the conversation with the estimated tokens of each message
+func (chat *chatSession) printHistory() {
+	history := chat.analyst.GetConversationHistory()
+	for i, message := range history {
+		text := ""
+		if message.Content != nil {
+			text = *message.Content
+		}
+		for _, call := range message.ToolCalls {
+			text += " → " + call.Function.Name
+		}
+		fmt.Printf("%3d. %-9s ~%5d tokens  %s\n", i+1, message.Role, message.EstimatedTokens(), preview(text, maxHistoryPreview))
+	}
+	fmt.Printf("%d messages, ~%d tokens\n", len(history), chat.analyst.EstimatedTokens())
+}
@@ -0,0 +1,26 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+func TestCheckChatProvider(t *testing.T) {
+	if err := CheckChatProvider(openai.NewOllamaProvider("", "")); err != nil {
+		t.Errorf("expected Ollama to be usable, got %v", err)
+	}
+
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.History.Disabled = true
+	cfg.LLM.Provider = openai.ProviderOffline
+	client := NewMCPClientWithConfig("http://netspy.test", false, cfg)
+	err := client.StartChat(context.Background(), nil, "")
+	if err == nil || !strings.Contains(err.Error(), "offline provider is selected") {
+		t.Errorf("expected chat to be refused offline, got %v", err)
+	}
+}
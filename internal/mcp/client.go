@@ -9,19 +9,41 @@ import (
 	"strings"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/session"
 )
 
 // MCPClient provides an interactive interface to the MCP server
 type MCPClient struct {
-	server  *NetworkMCPServer
-	verbose bool
+	server   *NetworkMCPServer
+	llm      config.LLMConfig // Saved with chat sessions
+	sessions *session.Store
+	out      *terminalStream
+	stream   bool // Print contextual analyses as they are generated
+	verbose  bool
 }
 
 // NewMCPClient creates a new MCP client
 func NewMCPClient(ebpfServerURL string, verbose bool) *MCPClient {
 	return &MCPClient{
-		server:  NewNetworkMCPServer(ebpfServerURL, verbose),
-		verbose: verbose,
+		server:   NewNetworkMCPServer(ebpfServerURL, verbose),
+		sessions: session.NewStore(config.Default().SessionsDir()),
+		out:      newTerminalStream(),
+		stream:   true,
+		verbose:  verbose,
+	}
+}
+
+// NewMCPClientWithConfig creates a new MCP client whose embedded server uses the given configuration
+func NewMCPClientWithConfig(ebpfServerURL string, verbose bool, cfg *config.Config) *MCPClient {
+	return &MCPClient{
+		server:   NewNetworkMCPServerWithConfig(ebpfServerURL, verbose, cfg),
+		llm:      cfg.LLM,
+		sessions: session.NewStore(cfg.SessionsDir()),
+		out:      newTerminalStream(),
+		stream:   !cfg.LLM.DisableStreaming,
+		verbose:  verbose,
 	}
 }
 
@@ -42,8 +64,12 @@ func (c *MCPClient) StartInteractiveMode(ctx context.Context) error {
 	fmt.Println("  dropsummary  - Get packet drop summary")
 	fmt.Println("  droplist     - List recent packet drops")
 	fmt.Println("  analyze      - Analyze connection patterns")
+	fmt.Println("  anomalies    - Detect scans, bursts and connection storms")
+	fmt.Println("  baseline     - Compare recent behaviour with learned baselines")
+	fmt.Println("  diff         - Compare two time windows or recorded cassettes")
 	fmt.Println("  insights     - Get AI insights about network behavior")
 	fmt.Println("  contextual   - Get contextual AI analysis with automatic tool usage")
+	fmt.Println("  chat         - Multi-turn AI conversation that keeps context between questions")
 	fmt.Println("  tools        - Show available MCP tools")
 	fmt.Println("  help         - Show this help message")
 	fmt.Println("  quit/exit    - Exit interactive mode")
@@ -67,6 +93,18 @@ func (c *MCPClient) StartInteractiveMode(ctx context.Context) error {
 			break
 		}
 
+		// Chat mode reads its own turns until the user leaves it
+		if input == "chat" || strings.HasPrefix(input, "chat ") {
+			if err := CheckChatProvider(c.server.llm); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			if err := c.runChat(ctx, scanner, nil, strings.TrimSpace(strings.TrimPrefix(input, "chat"))); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if err := c.handleCommand(ctx, input); err != nil {
 			fmt.Printf("Error: %v\n", err)
 		}
@@ -109,6 +147,15 @@ func (c *MCPClient) handleCommand(ctx context.Context, input string) error {
 	case "analyze":
 		return c.handleAnalyzeCommand(ctx, parts[1:])
 
+	case "anomalies":
+		return c.handleAnomaliesCommand(ctx, parts[1:])
+
+	case "baseline":
+		return c.handleBaselineCommand(ctx, parts[1:])
+
+	case "diff":
+		return c.handleDiffCommand(ctx, parts[1:])
+
 	case "insights":
 		return c.handleInsightsCommand(ctx, parts[1:])
 
@@ -124,17 +171,19 @@ func (c *MCPClient) handleCommand(ctx context.Context, input string) error {
 func (c *MCPClient) showHelp() {
 	fmt.Println("Network Telemetry MCP Commands:")
 	fmt.Println()
-	fmt.Println("summary [--pid <pid>] [--process <n>] [--duration <seconds>]")
+	fmt.Println("summary [--pid <pid>] [--process <n>] [--duration <seconds>] [--namespace <ns>] [--pod <pod>] [--group-by <key>]")
 	fmt.Println("  Get a summary of network connections")
 	fmt.Println("  Examples:")
 	fmt.Println("    summary --pid 1234")
 	fmt.Println("    summary --process curl --duration 120")
+	fmt.Println("    summary --namespace payments --group-by pod")
 	fmt.Println()
-	fmt.Println("list [--pid <pid>] [--process <n>] [--max-events <count>]")
+	fmt.Println("list [--pid <pid>] [--process <n>] [--max-events <count>] [--scope <list>]")
 	fmt.Println("  List recent network connection events")
 	fmt.Println("  Examples:")
 	fmt.Println("    list")
 	fmt.Println("    list --process nginx --max-events 20")
+	fmt.Println("    list --scope public,cloud-metadata")
 	fmt.Println()
 	fmt.Println("dropsummary [--pid <pid>] [--process <n>] [--duration <seconds>]")
 	fmt.Println("  Get a summary of packet drop events")
@@ -148,22 +197,53 @@ func (c *MCPClient) showHelp() {
 	fmt.Println("    droplist")
 	fmt.Println("    droplist --process nginx --max-events 15")
 	fmt.Println()
-	fmt.Println("analyze [--pid <pid>] [--process <n>]")
+	fmt.Println("analyze [--pid <pid>] [--process <n>] [--namespace <ns>] [--pod <pod>] [--group-by <key>]")
 	fmt.Println("  Analyze network connection patterns")
 	fmt.Println("  Examples:")
 	fmt.Println("    analyze --process ssh")
+	fmt.Println("    analyze --namespace kube-system --group-by container")
+	fmt.Println()
+	fmt.Println("anomalies [--pid <pid>] [--process <n>] [--duration <seconds>] [--namespace <ns>] [--pod <pod>] [--min-severity <level>]")
+	fmt.Println("  Detect horizontal/vertical scans, connection bursts and retry storms")
+	fmt.Println("  Examples:")
+	fmt.Println("    anomalies")
+	fmt.Println("    anomalies --process python3 --min-severity medium")
+	fmt.Println()
+	fmt.Println("baseline [--pid <pid>] [--process <n>] [--duration <seconds>] [--learn]")
+	fmt.Println("  Compare recent behaviour with learned per-process baselines")
+	fmt.Println("  Examples:")
+	fmt.Println("    baseline --learn")
+	fmt.Println("    baseline --process nginx --duration 300")
+	fmt.Println()
+	fmt.Println("diff [--pid <pid>] [--process <n>] [--duration <seconds>] [--split <time>] [--before-cassette <file>] [--after-cassette <file>]")
+	fmt.Println("  Compare two windows: new and vanished destinations, rate changes, new drop reasons and failures")
+	fmt.Println("  Examples:")
+	fmt.Println("    diff --duration 600")
+	fmt.Println("    diff --split 2025-01-01T12:00:00Z --process api")
+	fmt.Println("    diff --split 30m")
 	fmt.Println()
 	fmt.Println("insights <summary_text>")
 	fmt.Println("  Get AI-powered insights about network behavior")
 	fmt.Println("  Examples:")
 	fmt.Println("    insights \"curl made 5 connections in 60 seconds\"")
 	fmt.Println()
-	fmt.Println("contextual <query>")
+	fmt.Println("contextual [--trace] [--json] <query>")
 	fmt.Println("  Get contextual AI analysis with automatic tool usage and comprehensive insights")
+	fmt.Println("  --trace prints the LLM requests and tool calls behind the answer")
+	fmt.Println("  --json prints a findings report with severity, evidence and recommendations as JSON")
 	fmt.Println("  Examples:")
 	fmt.Println("    contextual \"Analyze the network behavior of process nginx\"")
 	fmt.Println("    contextual \"What's happening with my network connections?\"")
-	fmt.Println("    contextual \"Are there any packet drops or connection issues?\"")
+	fmt.Println("    contextual --trace \"Are there any packet drops or connection issues?\"")
+	fmt.Println("    contextual --json \"Which processes have failing connections?\"")
+	fmt.Println()
+	fmt.Println("chat [question]")
+	fmt.Println("  Start a multi-turn AI conversation; follow-up questions keep the earlier context")
+	fmt.Println("  Inside chat: /reset clears the conversation, /history lists messages with estimated")
+	fmt.Println("  tokens, /trace shows the requests and tool calls behind the last answer, /exit leaves")
+	fmt.Println("  Examples:")
+	fmt.Println("    chat Which processes had packet drops in the last 10 minutes?")
+	fmt.Println("    chat> And which destinations did the worst one talk to?")
 }
 
 // showTools displays available MCP tools
@@ -191,7 +271,7 @@ func (c *MCPClient) handleSummaryCommand(ctx context.Context, args []string) err
 		return err
 	}
 
-	c.printResult(result)
+	c.PrintResult(result)
 	return nil
 }
 
@@ -208,7 +288,7 @@ func (c *MCPClient) handleListCommand(ctx context.Context, args []string) error
 		return err
 	}
 
-	c.printResult(result)
+	c.PrintResult(result)
 	return nil
 }
 
@@ -225,7 +305,58 @@ func (c *MCPClient) handleAnalyzeCommand(ctx context.Context, args []string) err
 		return err
 	}
 
-	c.printResult(result)
+	c.PrintResult(result)
+	return nil
+}
+
+// handleAnomaliesCommand processes the anomalies command
+func (c *MCPClient) handleAnomaliesCommand(ctx context.Context, args []string) error {
+	arguments := c.parseArguments(args)
+
+	params := &mcp.CallToolParamsFor[map[string]any]{
+		Arguments: arguments,
+	}
+
+	result, err := c.server.handleDetectAnomalies(ctx, nil, params)
+	if err != nil {
+		return err
+	}
+
+	c.PrintResult(result)
+	return nil
+}
+
+// handleBaselineCommand processes the baseline command
+func (c *MCPClient) handleBaselineCommand(ctx context.Context, args []string) error {
+	arguments := c.parseArguments(args)
+
+	params := &mcp.CallToolParamsFor[map[string]any]{
+		Arguments: arguments,
+	}
+
+	result, err := c.server.handleCompareToBaseline(ctx, nil, params)
+	if err != nil {
+		return err
+	}
+
+	c.PrintResult(result)
+	return nil
+}
+
+// handleDiffCommand processes the diff command
+func (c *MCPClient) handleDiffCommand(ctx context.Context, args []string) error {
+	arguments := c.parseArguments(args)
+
+	params := &mcp.CallToolParamsFor[map[string]any]{
+		Arguments: arguments,
+	}
+
+	result, err := c.server.handleCompareWindows(ctx, nil, params)
+	if err != nil {
+		return err
+	}
+
+	c.PrintResult(result)
 	return nil
 }
 
@@ -254,36 +385,51 @@ func (c *MCPClient) handleInsightsCommand(ctx context.Context, args []string) er
 		return err
 	}
 
-	c.printResult(result)
+	c.PrintResult(result)
 	return nil
 }
 
 // handleContextualCommand processes the contextual analysis command
 func (c *MCPClient) handleContextualCommand(ctx context.Context, args []string) error {
-	if len(args) == 0 {
+	trace := false
+	output := "text"
+	words := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "--trace":
+			trace = true
+		case "--json":
+			output = "json"
+		default:
+			words = append(words, arg)
+		}
+	}
+	if len(words) == 0 {
 		return fmt.Errorf("contextual command requires a query as argument")
 	}
 
-	query := strings.Join(args, " ")
+	query := strings.Join(words, " ")
 	// Remove quotes if present
 	if strings.HasPrefix(query, "\"") && strings.HasSuffix(query, "\"") {
 		query = strings.Trim(query, "\"")
 	}
 
 	arguments := map[string]any{
-		"query": query,
+		"query":  query,
+		"trace":  trace,
+		"output": output,
 	}
 
 	params := &mcp.CallToolParamsFor[map[string]any]{
 		Arguments: arguments,
 	}
 
-	result, err := c.server.handleContextualAnalysis(ctx, nil, params)
+	result, err := c.server.handleContextualAnalysis(c.streamContext(ctx, arguments), nil, params)
 	if err != nil {
 		return err
 	}
 
-	c.printResult(result)
+	c.PrintResult(result)
 	return nil
 }
 
@@ -301,6 +447,7 @@ func (c *MCPClient) parseArguments(args []string) map[string]any {
 			if key == "process" {
 				key = "process_name"
 			}
+			key = strings.ReplaceAll(key, "-", "_")
 
 			// Check if there's a value following this flag
 			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
@@ -323,15 +470,38 @@ func (c *MCPClient) parseArguments(args []string) map[string]any {
 	return arguments
 }
 
-// printResult prints the result from an MCP tool call
-func (c *MCPClient) printResult(result *mcp.CallToolResult) {
-	for _, content := range result.Content {
+// PrintResult prints the result from an MCP tool call. An analysis that was already printed
+// as it streamed is not repeated; only the notes and trace that follow it are printed.
+func (c *MCPClient) PrintResult(result *mcp.CallToolResult) {
+	contents := result.Content
+	if c.out.printedText {
+		c.out.reset()
+		if run, ok := result.StructuredContent.(*openai.RunResult); ok && run.Content != "" && len(contents) > 0 {
+			if run.Limited() {
+				fmt.Println()
+				fmt.Println(limitNote(run))
+			}
+			contents = contents[1:]
+		}
+	}
+	for _, content := range contents {
 		if textContent, ok := content.(*mcp.TextContent); ok {
 			fmt.Println(textContent.Text)
 		}
 	}
 }
 
+// streamContext prepares the terminal for a contextual analysis and, unless streaming is
+// disabled, returns a context that prints the answer and tool calls as they happen. JSON
+// reports are not streamed so the output stays parseable.
+func (c *MCPClient) streamContext(ctx context.Context, arguments map[string]any) context.Context {
+	c.out.reset()
+	if !c.stream || arguments["output"] == "json" {
+		return ctx
+	}
+	return withStream(ctx, c.out.handle)
+}
+
 // RunSingleCommand executes a single MCP command and returns the result
 func (c *MCPClient) RunSingleCommand(ctx context.Context, toolName string, arguments map[string]any) (*mcp.CallToolResult, error) {
 	// Start the MCP server
@@ -354,10 +524,16 @@ func (c *MCPClient) RunSingleCommand(ctx context.Context, toolName string, argum
 		return c.server.handleListPacketDrops(ctx, nil, params)
 	case "analyze_patterns":
 		return c.server.handleAnalyzePatterns(ctx, nil, params)
+	case "detect_anomalies":
+		return c.server.handleDetectAnomalies(ctx, nil, params)
+	case "compare_to_baseline":
+		return c.server.handleCompareToBaseline(ctx, nil, params)
+	case "compare_windows":
+		return c.server.handleCompareWindows(ctx, nil, params)
 	case "ai_insights":
 		return c.server.handleAIInsights(ctx, nil, params)
 	case "contextual_analysis":
-		return c.server.handleContextualAnalysis(ctx, nil, params)
+		return c.server.handleContextualAnalysis(c.streamContext(ctx, arguments), nil, params)
 	default:
 		return nil, fmt.Errorf("unknown tool: %s", toolName)
 	}
@@ -2,13 +2,28 @@ package mcp
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/jsonschema"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/enrich"
+	"github.com/srodi/netspy/internal/k8s"
 	"github.com/srodi/netspy/internal/netclient"
 	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/store"
 	"github.com/srodi/netspy/internal/utils"
 )
 
@@ -19,10 +34,29 @@ type NetworkMCPServer struct {
 	ebpfServerURL   string
 	verbose         bool
 	registeredTools map[string]*mcp.Tool // Store registered tools for discovery
+	attributor      *k8s.Attributor      // Maps PIDs to containers and pods
+	hostnames       *enrich.HostnameEnricher
+	geo             *enrich.GeoEnricher
+	services        *enrich.ServiceMapper
+	baselineFile    string       // Local store of learned per-process baselines
+	minSnapshots    int          // Learned windows before a baseline is trusted
+	baselineMu      sync.Mutex   // Serializes baseline load, learn and save
+	history         *store.Store // Recorded telemetry, nil when unavailable
+	llm             openai.LLMProvider
+	llmLimits       openai.Limits     // Bounds on the contextual analysis tool loop
+	analystTools    openai.ToolFilter // Tools the contextual analyst may call
+	toolExecution   openai.ExecutionOptions
+	llmContext      openai.ContextOptions
+	traceDir        string // Directory receiving JSON traces of contextual analyses (optional)
 }
 
 // NewNetworkMCPServer creates a new MCP server for network telemetry using the official SDK
 func NewNetworkMCPServer(ebpfServerURL string, verbose bool) *NetworkMCPServer {
+	return NewNetworkMCPServerWithConfig(ebpfServerURL, verbose, config.Default())
+}
+
+// NewNetworkMCPServerWithConfig creates a new MCP server with optional features from the given configuration
+func NewNetworkMCPServerWithConfig(ebpfServerURL string, verbose bool, cfg *config.Config) *NetworkMCPServer {
 	s := &NetworkMCPServer{
 		httpClient:      netclient.NewClientWithVerbose(ebpfServerURL, verbose),
 		ebpfServerURL:   ebpfServerURL,
@@ -30,6 +64,77 @@ func NewNetworkMCPServer(ebpfServerURL string, verbose bool) *NetworkMCPServer {
 		registeredTools: make(map[string]*mcp.Tool),
 	}
 
+	// Record or replay eBPF server traffic
+	transport, err := cfg.Cassette.Transport()
+	if err != nil {
+		log.Printf("Warning: cassette unavailable: %v", err)
+	} else if transport != nil {
+		s.httpClient = netclient.NewClientWithTransport(ebpfServerURL, verbose, transport)
+	}
+
+	// Set up container and pod attribution
+	var metadataSource k8s.MetadataSource
+	if cfg.Kubernetes.MetadataFile != "" {
+		fileSource, err := k8s.NewFileSource(cfg.Kubernetes.MetadataFile)
+		if err != nil {
+			log.Printf("Warning: pod metadata unavailable: %v", err)
+		} else {
+			metadataSource = fileSource
+		}
+	}
+	s.attributor = k8s.NewAttributorWithProcRoot(cfg.Kubernetes.ProcRoot, metadataSource)
+
+	// Set up destination hostname enrichment
+	var resolver enrich.Resolver = net.DefaultResolver
+	if cfg.DNS.DisableReverseLookups {
+		resolver = nil
+	}
+	s.hostnames = enrich.NewHostnameEnricher(resolver, cfg.DNS.HostnameOptions())
+
+	// Set up offline GeoIP/ASN enrichment
+	var geoDatabases []enrich.GeoDatabase
+	for _, path := range cfg.GeoIP.Databases {
+		db, err := enrich.OpenGeoDatabase(path)
+		if err != nil {
+			log.Printf("Warning: GeoIP database %s unavailable: %v", path, err)
+			continue
+		}
+		geoDatabases = append(geoDatabases, db)
+	}
+	s.geo = enrich.NewGeoEnricher(geoDatabases...)
+
+	// Set up port to service name mapping
+	s.services = enrich.NewServiceMapper(cfg.Services.ServicesFile(), cfg.Services.Overrides)
+
+	// Per-process baselines are loaded on each comparison so other writers are picked up
+	s.baselineFile = cfg.BaselineFile()
+	s.minSnapshots = cfg.Baseline.MinSnapshots
+
+	// Use recorded history for windows older than the live backend keeps, when a store exists.
+	// A replayed cassette is self-contained, so local history is not mixed in.
+	if !cfg.History.Disabled && !cfg.Cassette.Replaying() {
+		if _, err := os.Stat(cfg.HistoryDir()); err == nil {
+			history, err := store.Open(cfg.HistoryDir(), cfg.History.Options())
+			if err != nil {
+				log.Printf("Warning: history store unavailable: %v", err)
+			} else {
+				s.history = history
+			}
+		}
+	}
+
+	// AI tools use the configured LLM backend; a bad selection fails those tools rather than
+	// falling back to another provider
+	s.llm = openai.ConfiguredProvider(cfg.LLM.ProviderConfig())
+	s.llmLimits = cfg.LLM.Limits()
+	s.analystTools = cfg.LLM.ToolFilter(openai.ConsumerContextualAnalysis)
+	s.toolExecution = cfg.LLM.ExecutionOptions()
+	s.llmContext = cfg.LLM.ContextOptions()
+	s.traceDir = cfg.LLM.TraceDir
+	if verbose {
+		log.Printf("MCP Server: AI tools use %s (model %s)", s.llm.Name(), s.llm.Model())
+	}
+
 	// Create the implementation info
 	impl := &mcp.Implementation{
 		Name:    "network-telemetry",
@@ -73,6 +178,19 @@ func (s *NetworkMCPServer) registerTools() {
 					Description: "Duration in seconds to analyze (default: 60)",
 					Default:     []byte("60"),
 				},
+				"namespace": {
+					Type:        "string",
+					Description: "Filter by Kubernetes namespace (optional)",
+				},
+				"pod": {
+					Type:        "string",
+					Description: "Filter by Kubernetes pod name (optional)",
+				},
+				"group_by": {
+					Type:        "string",
+					Description: "Break down connections by namespace, pod, container, process, hostname, domain, asn, country or service (optional)",
+					Enum:        []any{"namespace", "pod", "container", "process", "hostname", "domain", "asn", "country", "service"},
+				},
 			},
 		},
 	}
@@ -99,6 +217,10 @@ func (s *NetworkMCPServer) registerTools() {
 					Description: "Maximum number of events to return (default: 10)",
 					Default:     []byte("10"),
 				},
+				"scope": {
+					Type:        "string",
+					Description: "Filter by destination class, comma-separated: public, private, loopback, link-local, cgnat, multicast, cloud-metadata, unspecified, unix (optional)",
+				},
 			},
 		},
 	}
@@ -125,16 +247,153 @@ func (s *NetworkMCPServer) registerTools() {
 					Description: "Duration in seconds to analyze (default: 60)",
 					Default:     []byte("60"),
 				},
+				"namespace": {
+					Type:        "string",
+					Description: "Filter by Kubernetes namespace (optional)",
+				},
+				"pod": {
+					Type:        "string",
+					Description: "Filter by Kubernetes pod name (optional)",
+				},
+				"group_by": {
+					Type:        "string",
+					Description: "Break down connections by namespace, pod, container, process, hostname, domain, asn, country or service (optional)",
+					Enum:        []any{"namespace", "pod", "container", "process", "hostname", "domain", "asn", "country", "service"},
+				},
 			},
 		},
 	}
 	mcp.AddTool(s.server, analyzePatternsTool, s.handleAnalyzePatterns)
 	s.registeredTools["analyze_patterns"] = analyzePatternsTool
 
+	// Register detect_anomalies tool
+	detectAnomaliesTool := &mcp.Tool{
+		Name:        "detect_anomalies",
+		Description: "Detect behavioural anomalies: horizontal scans (many IPs, one port), vertical scans (one IP, many ports), connection bursts and retry storms after failures",
+		InputSchema: &jsonschema.Schema{
+			Type: "object",
+			Properties: map[string]*jsonschema.Schema{
+				"pid": {
+					Type:        "integer",
+					Description: "Process ID to analyze (optional, default: all processes)",
+				},
+				"process_name": {
+					Type:        "string",
+					Description: "Process name to analyze (optional, default: all processes)",
+				},
+				"duration": {
+					Type:        "integer",
+					Description: "Only consider the last N seconds (optional, default: all available events)",
+				},
+				"namespace": {
+					Type:        "string",
+					Description: "Filter by Kubernetes namespace (optional)",
+				},
+				"pod": {
+					Type:        "string",
+					Description: "Filter by Kubernetes pod name (optional)",
+				},
+				"min_severity": {
+					Type:        "string",
+					Description: "Only report findings at or above this severity (default: low)",
+					Enum:        []any{"low", "medium", "high"},
+					Default:     []byte(`"low"`),
+				},
+			},
+		},
+	}
+	mcp.AddTool(s.server, detectAnomaliesTool, s.handleDetectAnomalies)
+	s.registeredTools["detect_anomalies"] = detectAnomaliesTool
+
+	// Register compare_to_baseline tool
+	compareToBaselineTool := &mcp.Tool{
+		Name:        "compare_to_baseline",
+		Description: "Score recent per-process behaviour against baselines learned from recorded history and past windows, listing new destinations, new ports, rate spikes and new failure modes",
+		InputSchema: &jsonschema.Schema{
+			Type: "object",
+			Properties: map[string]*jsonschema.Schema{
+				"pid": {
+					Type:        "integer",
+					Description: "Process ID to compare (optional, default: all processes)",
+				},
+				"process_name": {
+					Type:        "string",
+					Description: "Process name to compare (optional, default: all processes)",
+				},
+				"duration": {
+					Type:        "integer",
+					Description: "Window in seconds to compare (default: 60)",
+					Default:     []byte("60"),
+				},
+				"learn": {
+					Type:        "boolean",
+					Description: "Add this window to the baseline after comparing (default: false)",
+					Default:     []byte("false"),
+				},
+			},
+		},
+	}
+	mcp.AddTool(s.server, compareToBaselineTool, s.handleCompareToBaseline)
+	s.registeredTools["compare_to_baseline"] = compareToBaselineTool
+
+	// Register compare_windows tool
+	compareWindowsTool := &mcp.Tool{
+		Name:        "compare_windows",
+		Description: "Compare two time windows or two recorded cassettes, e.g. before and after a deploy: new and vanished destinations, per-process rate changes, new drop reasons and new failing destinations",
+		InputSchema: &jsonschema.Schema{
+			Type: "object",
+			Properties: map[string]*jsonschema.Schema{
+				"pid": {
+					Type:        "integer",
+					Description: "Process ID to compare (optional, default: all processes)",
+				},
+				"process_name": {
+					Type:        "string",
+					Description: "Process name to compare (optional, default: all processes)",
+				},
+				"duration": {
+					Type:        "integer",
+					Description: "Length of each window in seconds (default: 300)",
+					Default:     []byte("300"),
+				},
+				"split": {
+					Type:        "string",
+					Description: "Boundary between the windows, e.g. the deploy time, as RFC 3339 or a duration ago such as 30m (default: duration ago)",
+				},
+				"before_start": {
+					Type:        "string",
+					Description: "Explicit start of the before window (RFC 3339 or duration ago, optional)",
+				},
+				"before_end": {
+					Type:        "string",
+					Description: "Explicit end of the before window (optional)",
+				},
+				"after_start": {
+					Type:        "string",
+					Description: "Explicit start of the after window (optional)",
+				},
+				"after_end": {
+					Type:        "string",
+					Description: "Explicit end of the after window (optional)",
+				},
+				"before_cassette": {
+					Type:        "string",
+					Description: "Cassette file to use as the before window instead of a time range (optional)",
+				},
+				"after_cassette": {
+					Type:        "string",
+					Description: "Cassette file to use as the after window instead of a time range (optional)",
+				},
+			},
+		},
+	}
+	mcp.AddTool(s.server, compareWindowsTool, s.handleCompareWindows)
+	s.registeredTools["compare_windows"] = compareWindowsTool
+
 	// Register contextual_analysis tool - this uses OpenAI with function calling
 	contextualAnalysisTool := &mcp.Tool{
 		Name:        "contextual_analysis",
-		Description: "Get AI-powered network analysis with automatic tool usage and comprehensive insights",
+		Description: "Get AI-powered network analysis with automatic tool usage and comprehensive insights. Without a usable LLM provider, rule-based checks answer with a health report instead.",
 		InputSchema: &jsonschema.Schema{
 			Type: "object",
 			Properties: map[string]*jsonschema.Schema{
@@ -155,6 +414,16 @@ func (s *NetworkMCPServer) registerTools() {
 					Description: "Duration in seconds for analysis (default: 60)",
 					Default:     []byte("60"),
 				},
+				"trace": {
+					Type:        "boolean",
+					Description: "Append a trace of the LLM requests and tool calls behind the answer (default: true, false for json output)",
+				},
+				"output": {
+					Type:        "string",
+					Description: "text for a Markdown answer, or json for a schema-validated findings report with severity, affected process and destination, evidence and recommendations (default: text)",
+					Enum:        []any{"text", "json"},
+					Default:     []byte(`"text"`),
+				},
 			},
 			Required: []string{"query"},
 		},
@@ -165,7 +434,7 @@ func (s *NetworkMCPServer) registerTools() {
 	// Register ai_insights tool
 	aiInsightsTool := &mcp.Tool{
 		Name:        "ai_insights",
-		Description: "Get AI-powered insights about network behavior using OpenAI GPT-3.5-turbo",
+		Description: "Get AI-powered insights about network behavior from the configured LLM provider. Without one, the text is not interpreted and rule-based checks run on the process and window the summary names (default: the last minute)",
 		InputSchema: &jsonschema.Schema{
 			Type: "object",
 			Properties: map[string]*jsonschema.Schema{
@@ -226,6 +495,10 @@ func (s *NetworkMCPServer) registerTools() {
 					Description: "Maximum number of events to return (default: 10)",
 					Default:     []byte("10"),
 				},
+				"duration": {
+					Type:        "integer",
+					Description: "Only list drops from the last duration seconds, reading recorded history when the window reaches back that far (optional)",
+				},
 			},
 		},
 	}
@@ -267,10 +540,24 @@ func (s *NetworkMCPServer) handleGetNetworkSummary(ctx context.Context, session
 		}
 	}
 
+	namespace := stringArgument(arguments, "namespace")
+	podName := stringArgument(arguments, "pod")
+	groupBy := stringArgument(arguments, "group_by")
+
 	if s.verbose {
 		log.Printf("MCP Server: get_network_summary called with pid=%d, processName='%s', duration=%d", pid, processName, duration)
 	}
 
+	var pidFilter *int
+	if pid > 0 {
+		pidFilter = &pid
+	}
+
+	// Workload filters, grouping and recorded history need per-event data rather than the server-side count
+	if namespace != "" || podName != "" || groupBy != "" || s.historyCovers(ctx, pidFilter, time.Now().Add(-time.Duration(duration)*time.Second)) {
+		return s.workloadNetworkSummary(ctx, pid, processName, duration, namespace, podName, groupBy)
+	}
+
 	// Connect to eBPF server
 	if err := s.httpClient.Connect(ctx); err != nil {
 		return &mcp.CallToolResult{
@@ -296,6 +583,17 @@ func (s *NetworkMCPServer) handleGetNetworkSummary(ctx context.Context, session
 
 	// Format the response
 	formattedSummary := utils.FormatConnectionSummary(pid, processName, duration, summary)
+	structured := utils.NetworkSummary{
+		Target:          summaryTarget(pid, processName, "", ""),
+		DurationSeconds: duration,
+		Count:           summary.Count,
+	}
+
+	// Break the window down by destination class; the count above stays authoritative. An
+	// empty window has nothing to break down, so it skips listing the events.
+	if summary.Count > 0 {
+		formattedSummary += s.scopeBreakdown(ctx, pid, processName, duration, &structured)
+	}
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
@@ -303,9 +601,63 @@ func (s *NetworkMCPServer) handleGetNetworkSummary(ctx context.Context, session
 				Text: formattedSummary,
 			},
 		},
+		StructuredContent: structured,
 	}, nil
 }
 
+// scopeBreakdown lists the window's connections to count them by destination class, filling
+// the scope fields of structured. It returns the text to append, empty when unavailable.
+func (s *NetworkMCPServer) scopeBreakdown(ctx context.Context, pid int, processName string, duration int, structured *utils.NetworkSummary) string {
+	var pidFilter *int
+	if pid > 0 {
+		pidFilter = &pid
+	}
+	events, err := s.fetchConnectionEvents(ctx, pidFilter)
+	if err != nil {
+		if s.verbose {
+			log.Printf("MCP Server: scope breakdown unavailable: %v", err)
+		}
+		return ""
+	}
+
+	var windowEvents []netclient.ConnectionEvent
+	for _, event := range events {
+		if (pid <= 0 || event.PID == uint32(pid)) &&
+			(processName == "" || event.Command == processName) {
+			windowEvents = append(windowEvents, event)
+		}
+	}
+	windowEvents = utils.FilterSince(windowEvents, time.Now().Add(-time.Duration(duration)*time.Second))
+	if len(windowEvents) == 0 {
+		return ""
+	}
+	report := utils.BuildPatternReport(windowEvents)
+	structured.Scopes = report.Scopes
+	structured.MetadataAccess = report.MetadataAccess
+	return "\n" + utils.FormatScopeBreakdown(report.Scopes, report.MetadataAccess)
+}
+
+// summaryTarget describes the filters of a summary, e.g. "process 'curl', namespace 'web'"
+func summaryTarget(pid int, processName, namespace, podName string) string {
+	var targets []string
+	if pid > 0 {
+		targets = append(targets, fmt.Sprintf("PID %d", pid))
+	}
+	if processName != "" {
+		targets = append(targets, fmt.Sprintf("process '%s'", processName))
+	}
+	if namespace != "" {
+		targets = append(targets, fmt.Sprintf("namespace '%s'", namespace))
+	}
+	if podName != "" {
+		targets = append(targets, fmt.Sprintf("pod '%s'", podName))
+	}
+	if len(targets) == 0 {
+		return "all processes"
+	}
+	return strings.Join(targets, ", ")
+}
+
 // handleListConnections handles the list_connections tool call
 func (s *NetworkMCPServer) handleListConnections(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
 	if s.verbose {
@@ -349,6 +701,17 @@ func (s *NetworkMCPServer) handleListConnections(ctx context.Context, session *m
 		log.Printf("MCP Server: list_connections called with pid=%s, processName='%s', maxEvents=%d", pidStr, processName, maxEvents)
 	}
 
+	scopes, unknownScopes := enrich.ParseScopes(stringArgument(arguments, "scope"))
+	if len(unknownScopes) > 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Error: unknown scope '%s' (expected one of: %s)", strings.Join(unknownScopes, ", "), scopeNames()),
+				},
+			},
+		}, nil
+	}
+
 	// Connect to eBPF server
 	if err := s.httpClient.Connect(ctx); err != nil {
 		return &mcp.CallToolResult{
@@ -361,7 +724,7 @@ func (s *NetworkMCPServer) handleListConnections(ctx context.Context, session *m
 	}
 
 	// Get connections from eBPF server
-	output, err := s.httpClient.ListConnections(ctx, pid, nil)
+	events, err := s.fetchConnectionEvents(ctx, pid)
 	if err != nil {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
@@ -372,17 +735,14 @@ func (s *NetworkMCPServer) handleListConnections(ctx context.Context, session *m
 		}, nil
 	}
 
-	// Convert to connection events and filter
+	// Filter by process name if specified
 	var allEvents []netclient.ConnectionEvent
-	for _, connections := range output.EventsByPID {
-		for _, conn := range connections {
-			event := conn.ToConnectionEvent()
-			// Filter by process name if specified
-			if processName == "" || event.Command == processName {
-				allEvents = append(allEvents, event)
-			}
+	for _, event := range events {
+		if processName == "" || event.Command == processName {
+			allEvents = append(allEvents, event)
 		}
 	}
+	allEvents = utils.FilterByScope(allEvents, scopes)
 
 	// Format the response
 	formattedList := utils.FormatConnectionEvents(allEvents, maxEvents)
@@ -422,6 +782,16 @@ func (s *NetworkMCPServer) handleAnalyzePatterns(ctx context.Context, session *m
 		}
 	}
 
+	namespace := stringArgument(arguments, "namespace")
+	podName := stringArgument(arguments, "pod")
+	groupBy := stringArgument(arguments, "group_by")
+
+	// An explicit duration extends the analysis into recorded history
+	var since time.Time
+	if _, exists := arguments["duration"]; exists {
+		since = time.Now().Add(-time.Duration(intArgument(arguments, "duration", 60)) * time.Second)
+	}
+
 	// Connect to eBPF server
 	if err := s.httpClient.Connect(ctx); err != nil {
 		return &mcp.CallToolResult{
@@ -434,7 +804,7 @@ func (s *NetworkMCPServer) handleAnalyzePatterns(ctx context.Context, session *m
 	}
 
 	// Get connections from eBPF server
-	output, err := s.httpClient.ListConnections(ctx, pid, nil)
+	allEvents, err := s.fetchConnectionEventsSince(ctx, pid, since)
 	if err != nil {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
@@ -445,17 +815,18 @@ func (s *NetworkMCPServer) handleAnalyzePatterns(ctx context.Context, session *m
 		}, nil
 	}
 
-	// Convert to connection events and filter
+	// Filter by process and workload
 	var filteredEvents []netclient.ConnectionEvent
-	for _, connections := range output.EventsByPID {
-		for _, conn := range connections {
-			event := conn.ToConnectionEvent()
-			if (pid == nil || event.PID == uint32(*pid)) &&
-				(processName == "" || event.Command == processName) {
-				filteredEvents = append(filteredEvents, event)
-			}
+	for _, event := range allEvents {
+		if (pid == nil || event.PID == uint32(*pid)) &&
+			(processName == "" || event.Command == processName) {
+			filteredEvents = append(filteredEvents, event)
 		}
 	}
+	filteredEvents = utils.FilterByWorkload(filteredEvents, namespace, podName)
+	if !since.IsZero() {
+		filteredEvents = utils.FilterSince(filteredEvents, since)
+	}
 
 	if len(filteredEvents) == 0 {
 		return &mcp.CallToolResult{
@@ -468,132 +839,681 @@ func (s *NetworkMCPServer) handleAnalyzePatterns(ctx context.Context, session *m
 	}
 
 	// Analyze patterns
-	analysis := utils.AnalyzeConnectionPatterns(filteredEvents)
+	report := utils.BuildPatternReport(filteredEvents)
+
+	if groupBy != "" {
+		groups, err := utils.GroupConnectionEvents(filteredEvents, groupBy)
+		if err != nil {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Error: %v", err),
+					},
+				},
+			}, nil
+		}
+		report.GroupBy = groupBy
+		report.Groups = groups
+	}
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
 			&mcp.TextContent{
-				Text: analysis,
+				Text: utils.FormatPatternReport(report),
 			},
 		},
+		StructuredContent: report,
 	}, nil
 }
 
-// handleAIInsights handles the ai_insights tool call
-func (s *NetworkMCPServer) handleAIInsights(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
+// handleDetectAnomalies handles the detect_anomalies tool call
+func (s *NetworkMCPServer) handleDetectAnomalies(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
 	if s.verbose {
-		log.Printf("MCP Server: Handling ai_insights request")
+		log.Printf("MCP Server: Handling detect_anomalies request")
 	}
 
 	// Parse arguments
 	arguments := params.Arguments
-	summaryText, exists := arguments["summary_text"]
-	if !exists {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				&mcp.TextContent{
-					Text: "Missing required parameter: summary_text",
+	var pid *int
+	if _, exists := arguments["pid"]; exists {
+		pidInt := intArgument(arguments, "pid", 0)
+		pid = &pidInt
+	}
+	processName := stringArgument(arguments, "process_name")
+	duration := intArgument(arguments, "duration", 0)
+	namespace := stringArgument(arguments, "namespace")
+	podName := stringArgument(arguments, "pod")
+
+	minSeverity := analysis.SeverityLow
+	if name := stringArgument(arguments, "min_severity"); name != "" {
+		severity, ok := analysis.ParseSeverity(name)
+		if !ok {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Error: invalid min_severity '%s' (expected one of: low, medium, high)", name),
+					},
 				},
-			},
-		}, nil
+			}, nil
+		}
+		minSeverity = severity
 	}
 
-	summaryStr, ok := summaryText.(string)
-	if !ok {
+	// Connect to eBPF server
+	if err := s.httpClient.Connect(ctx); err != nil {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{
-					Text: "Invalid summary_text parameter: must be a string",
+					Text: fmt.Sprintf("Failed to connect to eBPF server: %v", err),
 				},
 			},
 		}, nil
 	}
 
-	// Get AI insights using OpenAI
-	insights, err := openai.AskLLM(summaryStr)
+	var since time.Time
+	if duration > 0 {
+		since = time.Now().Add(-time.Duration(duration) * time.Second)
+	}
+	events, err := s.fetchConnectionEventsSince(ctx, pid, since)
 	if err != nil {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{
-					Text: fmt.Sprintf("Failed to get AI insights: %v\n(Ensure OPENAI_API_KEY environment variable is set)", err),
+					Text: fmt.Sprintf("Failed to list connections: %v", err),
 				},
 			},
 		}, nil
 	}
 
+	// Filter by process, workload and time window
+	var filteredEvents []netclient.ConnectionEvent
+	for _, event := range events {
+		if (pid == nil || event.PID == uint32(*pid)) &&
+			(processName == "" || event.Command == processName) {
+			filteredEvents = append(filteredEvents, event)
+		}
+	}
+	filteredEvents = utils.FilterByWorkload(filteredEvents, namespace, podName)
+	if duration > 0 {
+		filteredEvents = utils.FilterSince(filteredEvents, since)
+	}
+
+	anomalies := analysis.DetectAnomalies(filteredEvents, analysis.DefaultAnomalyOptions())
+	report := utils.AnomalyReport{
+		TotalEvents: len(filteredEvents),
+		MinSeverity: string(minSeverity),
+		Anomalies:   analysis.FilterAnomalies(anomalies, minSeverity),
+	}
+
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
 			&mcp.TextContent{
-				Text: insights,
+				Text: utils.FormatAnomalyReport(report),
 			},
 		},
+		StructuredContent: report,
 	}, nil
 }
 
-// Start starts the MCP server
-func (s *NetworkMCPServer) Start(ctx context.Context) error {
-	if s.verbose {
-		log.Printf("Starting Network Telemetry MCP Server")
-	}
-
-	// Test connection to eBPF server
-	if err := s.httpClient.Connect(ctx); err != nil {
-		log.Printf("Warning: Could not connect to eBPF server at %s: %v", s.ebpfServerURL, err)
-		log.Printf("Make sure the eBPF server is running with: sudo ./bin/ebpf-server --http --port 8080")
-	} else {
-		log.Printf("Successfully connected to eBPF server at %s", s.ebpfServerURL)
-	}
-
-	return nil
-}
-
-// handleGetPacketDropSummary handles the get_packet_drop_summary tool call
-func (s *NetworkMCPServer) handleGetPacketDropSummary(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
+// handleCompareToBaseline handles the compare_to_baseline tool call
+func (s *NetworkMCPServer) handleCompareToBaseline(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
 	if s.verbose {
-		log.Printf("MCP Server: Handling get_packet_drop_summary request")
+		log.Printf("MCP Server: Handling compare_to_baseline request")
 	}
 
 	// Parse arguments
 	arguments := params.Arguments
-	var pid int
-	var processName string
-	duration := 60
-
-	if pidVal, exists := arguments["pid"]; exists {
-		if pidFloat, ok := pidVal.(float64); ok {
-			pid = int(pidFloat)
-		} else if pidInt, ok := pidVal.(int); ok {
-			pid = pidInt
-		}
+	var pid *int
+	if _, exists := arguments["pid"]; exists {
+		pidInt := intArgument(arguments, "pid", 0)
+		pid = &pidInt
 	}
+	processName := stringArgument(arguments, "process_name")
+	duration := intArgument(arguments, "duration", 60)
+	learn := boolArgument(arguments, "learn")
 
-	if procVal, exists := arguments["process_name"]; exists {
-		if procStr, ok := procVal.(string); ok {
-			processName = procStr
-		}
-	}
+	// Concurrent calls would otherwise overwrite each other's learned windows
+	s.baselineMu.Lock()
+	defer s.baselineMu.Unlock()
 
-	if durVal, exists := arguments["duration"]; exists {
-		if durFloat, ok := durVal.(float64); ok {
-			duration = int(durFloat)
-		} else if durInt, ok := durVal.(int); ok {
-			duration = durInt
-		}
+	baseline, err := analysis.LoadBaseline(s.baselineFile)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to load baseline: %v", err),
+				},
+			},
+		}, nil
 	}
 
-	// Get packet drop summary from eBPF server
-	summary, err := s.httpClient.GetPacketDropSummary(ctx, pid, processName, duration)
-	if err != nil {
+	// Connect to eBPF server
+	if err := s.httpClient.Connect(ctx); err != nil {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{
-					Text: fmt.Sprintf("Failed to get packet drop summary: %v", err),
+					Text: fmt.Sprintf("Failed to connect to eBPF server: %v", err),
 				},
 			},
 		}, nil
 	}
 
-	// Format the response
+	end := time.Now()
+	window := analysis.Window{Start: end.Add(-time.Duration(duration) * time.Second), End: end}
+
+	events, err := s.fetchConnectionEventsSince(ctx, pid, window.Start)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to list connections: %v", err),
+				},
+			},
+		}, nil
+	}
+
+	// Packet drops are best effort; a baseline can still be compared without them
+	drops, err := s.fetchPacketDropsSince(ctx, window.Start)
+	if err != nil && s.verbose {
+		log.Printf("MCP Server: packet drops unavailable for baseline comparison: %v", err)
+	}
+
+	// Build the comparison window for the selected processes
+	for _, event := range utils.FilterSince(events, window.Start) {
+		if (pid == nil || event.PID == uint32(*pid)) &&
+			(processName == "" || event.Command == processName) {
+			window.Connections = append(window.Connections, event)
+		}
+	}
+	for _, drop := range utils.FilterDropsSince(drops, window.Start) {
+		if (pid == nil || drop.PID == uint32(*pid)) &&
+			(processName == "" || drop.Command == processName) {
+			window.Drops = append(window.Drops, drop)
+		}
+	}
+
+	// Catch up on recorded history before the compared window, so the baseline reflects the past
+	historyWindows := s.learnBaselineHistory(baseline, window.Start, time.Duration(duration)*time.Second)
+
+	report := utils.BaselineReport{
+		DurationSeconds: duration,
+		HistoryWindows:  historyWindows,
+		Processes:       baseline.Compare(window, s.minSnapshots),
+	}
+
+	if learn {
+		baseline.Learn(window)
+		// History up to the end of this window must not be learned a second time
+		if baseline.LearnedUntil.Before(window.End) {
+			baseline.LearnedUntil = window.End
+		}
+	}
+	if learn || historyWindows > 0 {
+		if err := baseline.Save(s.baselineFile); err != nil {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Failed to save baseline: %v", err),
+					},
+				},
+			}, nil
+		}
+		report.Learned = learn
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{
+				Text: utils.FormatBaselineReport(report),
+			},
+		},
+		StructuredContent: report,
+	}, nil
+}
+
+// handleCompareWindows handles the compare_windows tool call
+func (s *NetworkMCPServer) handleCompareWindows(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
+	if s.verbose {
+		log.Printf("MCP Server: Handling compare_windows request")
+	}
+
+	// Parse arguments
+	arguments := params.Arguments
+	var pid *int
+	if _, exists := arguments["pid"]; exists {
+		pidInt := intArgument(arguments, "pid", 0)
+		pid = &pidInt
+	}
+	processName := stringArgument(arguments, "process_name")
+	beforeCassette := stringArgument(arguments, "before_cassette")
+	afterCassette := stringArgument(arguments, "after_cassette")
+
+	before, after, err := s.comparisonWindowRanges(arguments, time.Now())
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: err.Error(),
+				},
+			},
+		}, nil
+	}
+
+	// Fetch live and recorded events once for whichever sides are time ranges
+	var events []netclient.ConnectionEvent
+	var drops []netclient.PacketDropInfo
+	if beforeCassette == "" || afterCassette == "" {
+		if err := s.httpClient.Connect(ctx); err != nil {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Failed to connect to eBPF server: %v", err),
+					},
+				},
+			}, nil
+		}
+
+		since := after.Start
+		if beforeCassette == "" && before.Start.Before(since) {
+			since = before.Start
+		}
+		events, err = s.fetchConnectionEventsSince(ctx, pid, since)
+		if err != nil {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Failed to list connections: %v", err),
+					},
+				},
+			}, nil
+		}
+
+		// Packet drops are best effort; destinations and rates can still be compared without them
+		drops, err = s.fetchPacketDropsSince(ctx, since)
+		if err != nil && s.verbose {
+			log.Printf("MCP Server: packet drops unavailable for window comparison: %v", err)
+		}
+	}
+
+	sides := []struct {
+		label    string
+		cassette string
+		window   *analysis.Window
+	}{
+		{"before", beforeCassette, &before},
+		{"after", afterCassette, &after},
+	}
+	for _, side := range sides {
+		sideEvents, sideDrops := events, drops
+		if side.cassette != "" {
+			*side.window, sideEvents, sideDrops, err = s.cassetteWindow(ctx, side.cassette, pid)
+			if err != nil {
+				return &mcp.CallToolResult{
+					Content: []mcp.Content{
+						&mcp.TextContent{
+							Text: fmt.Sprintf("Failed to read %s cassette: %v", side.label, err),
+						},
+					},
+				}, nil
+			}
+		}
+		fillWindow(side.window, sideEvents, sideDrops, pid, processName)
+	}
+
+	beforeLabel, afterLabel := "Before", "After"
+	if beforeCassette != "" {
+		beforeLabel = filepath.Base(beforeCassette)
+	}
+	if afterCassette != "" {
+		afterLabel = filepath.Base(afterCassette)
+	}
+	diff := utils.DiffWindows(beforeLabel, before, afterLabel, after)
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{
+				Text: utils.FormatWindowDiff(diff),
+			},
+		},
+		StructuredContent: diff,
+	}, nil
+}
+
+// comparisonWindowRanges resolves the before and after time ranges of a compare_windows call.
+// By default the after window is the last duration seconds and the before window precedes it.
+func (s *NetworkMCPServer) comparisonWindowRanges(arguments map[string]any, now time.Time) (analysis.Window, analysis.Window, error) {
+	length := time.Duration(intArgument(arguments, "duration", 300)) * time.Second
+	if length <= 0 {
+		return analysis.Window{}, analysis.Window{}, fmt.Errorf("duration must be positive")
+	}
+
+	split := now.Add(-length)
+	if at, ok, err := timeArgument(arguments, "split", now); err != nil {
+		return analysis.Window{}, analysis.Window{}, err
+	} else if ok {
+		split = at
+	}
+
+	before := analysis.Window{Start: split.Add(-length), End: split}
+	after := analysis.Window{Start: split, End: split.Add(length)}
+	if after.End.After(now) {
+		after.End = now
+	}
+
+	bounds := []struct {
+		key    string
+		target *time.Time
+	}{
+		{"before_start", &before.Start},
+		{"before_end", &before.End},
+		{"after_start", &after.Start},
+		{"after_end", &after.End},
+	}
+	for _, bound := range bounds {
+		at, ok, err := timeArgument(arguments, bound.key, now)
+		if err != nil {
+			return analysis.Window{}, analysis.Window{}, err
+		}
+		if ok {
+			*bound.target = at
+		}
+	}
+
+	if !before.Start.Before(before.End) || !after.Start.Before(after.End) {
+		return analysis.Window{}, analysis.Window{}, fmt.Errorf("invalid comparison windows: %s–%s and %s–%s",
+			before.Start.Format(time.RFC3339), before.End.Format(time.RFC3339), after.Start.Format(time.RFC3339), after.End.Format(time.RFC3339))
+	}
+	return before, after, nil
+}
+
+// cassetteWindow reads the events recorded in a cassette, with their original times. The window
+// spans the recorded events.
+func (s *NetworkMCPServer) cassetteWindow(ctx context.Context, path string, pid *int) (analysis.Window, []netclient.ConnectionEvent, []netclient.PacketDropInfo, error) {
+	cassette, err := netclient.LoadCassette(path)
+	if err != nil {
+		return analysis.Window{}, nil, nil, err
+	}
+	client := netclient.NewClientWithTransport(s.ebpfServerURL, s.verbose,
+		netclient.NewReplayTransport(cassette, netclient.ReplayOptions{KeepTimes: true}))
+
+	output, err := client.ListConnections(ctx, pid, nil)
+	if err != nil {
+		return analysis.Window{}, nil, nil, err
+	}
+	var events []netclient.ConnectionEvent
+	for _, connections := range output.EventsByPID {
+		for _, conn := range connections {
+			events = append(events, conn.ToConnectionEvent())
+		}
+	}
+	s.enrichEvents(ctx, events)
+
+	var drops []netclient.PacketDropInfo
+	if dropOutput, err := client.ListPacketDrops(ctx); err == nil {
+		for _, list := range dropOutput.EventsByPID {
+			drops = append(drops, list...)
+		}
+	}
+
+	window := analysis.Window{Start: cassette.RecordedAt, End: cassette.RecordedAt}
+	for _, event := range events {
+		at := analysis.EventTime(event)
+		if at.Before(window.Start) {
+			window.Start = at
+		}
+		if at.After(window.End) {
+			window.End = at
+		}
+	}
+	// Include the last event in the half-open window
+	window.End = window.End.Add(time.Nanosecond)
+	return window, events, drops, nil
+}
+
+// fillWindow adds the events and drops within the window's time range that match the process filters.
+// Drops without a wall-clock timestamp cannot be placed in a window and are left out.
+func fillWindow(window *analysis.Window, events []netclient.ConnectionEvent, drops []netclient.PacketDropInfo, pid *int, processName string) {
+	for _, event := range events {
+		at := analysis.EventTime(event)
+		if at.Before(window.Start) || !at.Before(window.End) {
+			continue
+		}
+		if (pid == nil || event.PID == uint32(*pid)) && (processName == "" || event.Command == processName) {
+			window.Connections = append(window.Connections, event)
+		}
+	}
+	for _, drop := range drops {
+		at := store.DropTime(drop, time.Time{})
+		if at.Before(window.Start) || !at.Before(window.End) {
+			continue
+		}
+		if (pid == nil || drop.PID == uint32(*pid)) && (processName == "" || drop.Command == processName) {
+			window.Drops = append(window.Drops, drop)
+		}
+	}
+}
+
+// handleAIInsights handles the ai_insights tool call
+func (s *NetworkMCPServer) handleAIInsights(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
+	if s.verbose {
+		log.Printf("MCP Server: Handling ai_insights request")
+	}
+
+	// Parse arguments
+	arguments := params.Arguments
+	summaryText, exists := arguments["summary_text"]
+	if !exists {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: "Missing required parameter: summary_text",
+				},
+			},
+		}, nil
+	}
+
+	summaryStr, ok := summaryText.(string)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: "Invalid summary_text parameter: must be a string",
+				},
+			},
+		}, nil
+	}
+
+	// Without a usable provider the text cannot be interpreted, so the rule-based checks run on
+	// the process and window the summary describes instead
+	if err := openai.CheckProvider(s.llm); err != nil {
+		scope := parseSummaryScope(summaryStr)
+		analyst := s.newRuleBasedAnalyst()
+		var health string
+		var healthErr error
+		if scope.processName != "" || scope.pid > 0 {
+			health, healthErr = analyst.AnalyzeProcess(ctx, scope.processName, scope.pid, scope.duration)
+		} else {
+			health, healthErr = analyst.GetNetworkHealth(ctx, scope.duration)
+		}
+		if healthErr != nil {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Failed to run the offline health check: %v", healthErr),
+					},
+				},
+			}, nil
+		}
+		result := &mcp.CallToolResult{
+			Content:           []mcp.Content{&mcp.TextContent{Text: scope.note()}, &mcp.TextContent{Text: health}},
+			StructuredContent: analyst.LastRun(),
+		}
+		if !errors.Is(err, openai.ErrOffline) {
+			result.Content = append(result.Content, &mcp.TextContent{Text: offlineNote(s.llm, err)})
+		}
+		return result, nil
+	}
+
+	// Get AI insights from the configured provider
+	insights, err := openai.AskLLMWithProvider(ctx, s.llm, summaryStr)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to get AI insights from %s: %v\n(Check the LLM provider settings and API key)", s.llm.Name(), err),
+				},
+			},
+		}, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{
+				Text: insights,
+			},
+		},
+	}, nil
+}
+
+// summaryScope is the process and window a summary_text describes, as written by the summary tools
+type summaryScope struct {
+	processName string
+	pid         int
+	duration    int
+	found       bool // Whether the window was read from the text rather than defaulted
+}
+
+var (
+	summaryWindowPattern = regexp.MustCompile(`last (\d+) ?s(?:econds)?\b`)
+	summaryTargetPattern = regexp.MustCompile(`^\s*(?:PID (\d+)|process '([^']+)')`)
+)
+
+// parseSummaryScope reads the scope of a summary such as "process 'curl' made 12 outbound
+// connection attempts over the last 300 seconds". The window defaults to the last minute.
+func parseSummaryScope(text string) summaryScope {
+	scope := summaryScope{duration: 60}
+	if m := summaryWindowPattern.FindStringSubmatch(text); m != nil {
+		if seconds, err := strconv.Atoi(m[1]); err == nil && seconds > 0 {
+			scope.duration = seconds
+			scope.found = true
+		}
+	}
+	if m := summaryTargetPattern.FindStringSubmatch(text); m != nil {
+		if m[1] != "" {
+			scope.pid, _ = strconv.Atoi(m[1])
+		} else {
+			scope.processName = m[2]
+		}
+	}
+	return scope
+}
+
+// note explains that the summary was not interpreted and what was checked instead
+func (scope summaryScope) note() string {
+	target := "all processes"
+	if scope.pid > 0 {
+		target = fmt.Sprintf("PID %d", scope.pid)
+	} else if scope.processName != "" {
+		target = fmt.Sprintf("process '%s'", scope.processName)
+	}
+	window := fmt.Sprintf("the last %d seconds, the window the summary describes", scope.duration)
+	if !scope.found {
+		window = fmt.Sprintf("the last %d seconds, since the summary names no window", scope.duration)
+	}
+	return fmt.Sprintf("ℹ️ Interpreting summary_text needs a language model, so the text was not analyzed. These are live rule-based checks of %s over %s.", target, window)
+}
+
+// Start starts the MCP server
+func (s *NetworkMCPServer) Start(ctx context.Context) error {
+	if s.verbose {
+		log.Printf("Starting Network Telemetry MCP Server")
+	}
+
+	// Test connection to eBPF server
+	if err := s.httpClient.Connect(ctx); err != nil {
+		log.Printf("Warning: Could not connect to eBPF server at %s: %v", s.ebpfServerURL, err)
+		log.Printf("Make sure the eBPF server is running with: sudo ./bin/ebpf-server --http --port 8080")
+	} else {
+		log.Printf("Successfully connected to eBPF server at %s", s.ebpfServerURL)
+	}
+
+	return nil
+}
+
+// handleGetPacketDropSummary handles the get_packet_drop_summary tool call
+func (s *NetworkMCPServer) handleGetPacketDropSummary(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) (*mcp.CallToolResult, error) {
+	if s.verbose {
+		log.Printf("MCP Server: Handling get_packet_drop_summary request")
+	}
+
+	// Parse arguments
+	arguments := params.Arguments
+	var pid int
+	var processName string
+	duration := 60
+
+	if pidVal, exists := arguments["pid"]; exists {
+		if pidFloat, ok := pidVal.(float64); ok {
+			pid = int(pidFloat)
+		} else if pidInt, ok := pidVal.(int); ok {
+			pid = pidInt
+		}
+	}
+
+	if procVal, exists := arguments["process_name"]; exists {
+		if procStr, ok := procVal.(string); ok {
+			processName = procStr
+		}
+	}
+
+	if durVal, exists := arguments["duration"]; exists {
+		if durFloat, ok := durVal.(float64); ok {
+			duration = int(durFloat)
+		} else if durInt, ok := durVal.(int); ok {
+			duration = durInt
+		}
+	}
+
+	var pidFilter *int
+	if pid > 0 {
+		pidFilter = &pid
+	}
+
+	// Count from the drop events when the window reaches back into recorded history; otherwise
+	// the eBPF server's summary, which also counts the drops it leaves out of lists, is authoritative
+	since := time.Now().Add(-time.Duration(duration) * time.Second)
+	drops, recorded, err := s.packetDropsSince(ctx, since)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to list packet drops: %v", err),
+				},
+			},
+		}, nil
+	}
+	var summary netclient.PacketDropSummaryOutput
+	if recorded > 0 {
+		summary = netclient.PacketDropSummaryOutput{
+			Count:           len(filterPacketDrops(drops, pidFilter, processName, since)),
+			PID:             pid,
+			Command:         processName,
+			DurationSeconds: duration,
+		}
+	} else {
+		summary, err = s.httpClient.GetPacketDropSummary(ctx, pid, processName, duration)
+	}
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to get packet drop summary: %v", err),
+				},
+			},
+		}, nil
+	}
+
+	// Format the response
 	var target string
 	if pid > 0 {
 		target = fmt.Sprintf("PID %d", pid)
@@ -620,6 +1540,7 @@ func (s *NetworkMCPServer) handleGetPacketDropSummary(ctx context.Context, sessi
 				Text: result,
 			},
 		},
+		StructuredContent: summary,
 	}, nil
 }
 
@@ -634,6 +1555,7 @@ func (s *NetworkMCPServer) handleListPacketDrops(ctx context.Context, session *m
 	var pid *int
 	var processName string
 	maxEvents := 10
+	duration := 0
 
 	if pidVal, exists := arguments["pid"]; exists {
 		if pidFloat, ok := pidVal.(float64); ok {
@@ -658,8 +1580,20 @@ func (s *NetworkMCPServer) handleListPacketDrops(ctx context.Context, session *m
 		}
 	}
 
-	// Get packet drops from eBPF server
-	output, err := s.httpClient.ListPacketDrops(ctx)
+	if durVal, exists := arguments["duration"]; exists {
+		if durFloat, ok := durVal.(float64); ok {
+			duration = int(durFloat)
+		} else if durInt, ok := durVal.(int); ok {
+			duration = durInt
+		}
+	}
+
+	// Get live packet drops, and recorded ones when the window reaches back before them
+	var since time.Time
+	if duration > 0 {
+		since = time.Now().Add(-time.Duration(duration) * time.Second)
+	}
+	drops, err := s.fetchPacketDropsSince(ctx, since)
 	if err != nil {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
@@ -670,31 +1604,15 @@ func (s *NetworkMCPServer) handleListPacketDrops(ctx context.Context, session *m
 		}, nil
 	}
 
-	// Convert and filter packet drops
+	// Filter packet drops
+	matched := filterPacketDrops(drops, pid, processName, since)
 	var filteredDrops []string
-	count := 0
-	for _, drops := range output.EventsByPID {
-		for _, drop := range drops {
-			// Filter by PID if specified
-			if pid != nil && drop.PID != uint32(*pid) {
-				continue
-			}
-			// Filter by process name if specified
-			if processName != "" && drop.Command != processName {
-				continue
-			}
-
-			if count >= maxEvents {
-				break
-			}
-
-			dropInfo := fmt.Sprintf("PID %d (%s): packet dropped - %s", drop.PID, drop.Command, drop.Reason)
-			filteredDrops = append(filteredDrops, dropInfo)
-			count++
-		}
-		if count >= maxEvents {
+	for _, drop := range matched {
+		if len(filteredDrops) >= maxEvents {
 			break
 		}
+		dropInfo := fmt.Sprintf("PID %d (%s): packet dropped - %s", drop.PID, drop.Command, drop.Reason)
+		filteredDrops = append(filteredDrops, dropInfo)
 	}
 
 	var result string
@@ -707,17 +1625,299 @@ func (s *NetworkMCPServer) handleListPacketDrops(ctx context.Context, session *m
 			result += fmt.Sprintf(" for process '%s'", processName)
 		}
 	} else {
-		result = fmt.Sprintf("Recent packet drop events (%d total):\n", output.TotalEvents)
+		result = fmt.Sprintf("Recent packet drop events (%d total):\n", len(matched))
 		for i, drop := range filteredDrops {
 			result += fmt.Sprintf("%d. %s\n", i+1, drop)
 		}
-		if output.TotalEvents > len(filteredDrops) {
-			result += fmt.Sprintf("... and %d more events", output.TotalEvents-len(filteredDrops))
+		if len(matched) > len(filteredDrops) {
+			result += fmt.Sprintf("... and %d more events", len(matched)-len(filteredDrops))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{
+				Text: result,
+			},
+		},
+	}, nil
+}
+
+// scopeNames lists the valid scope filter values
+func scopeNames() string {
+	names := make([]string, 0, len(enrich.AllScopes))
+	for _, scope := range enrich.AllScopes {
+		names = append(names, string(scope))
+	}
+	return strings.Join(names, ", ")
+}
+
+// fetchConnectionEvents lists connections from the eBPF server and converts them to
+// connection events annotated with container and pod attribution
+func (s *NetworkMCPServer) fetchConnectionEvents(ctx context.Context, pid *int) ([]netclient.ConnectionEvent, error) {
+	return s.fetchConnectionEventsSince(ctx, pid, time.Time{})
+}
+
+// fetchConnectionEventsSince lists live connections and, when the window starts before the oldest
+// live event, prepends recorded history for the rest of the window
+func (s *NetworkMCPServer) fetchConnectionEventsSince(ctx context.Context, pid *int, since time.Time) ([]netclient.ConnectionEvent, error) {
+	output, err := s.httpClient.ListConnections(ctx, pid, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []netclient.ConnectionEvent
+	for _, connections := range output.EventsByPID {
+		for _, conn := range connections {
+			live = append(live, conn.ToConnectionEvent())
+		}
+	}
+
+	var events []netclient.ConnectionEvent
+	if result, ok := s.queryHistory(since, liveStart(live, time.Now()), pid); ok {
+		events = append(events, result.Connections...)
+	}
+	events = append(events, live...)
+
+	s.enrichEvents(ctx, events)
+	return events, nil
+}
+
+// liveStart returns the time of the oldest live event, or now when there are none. Recorded
+// history fills the part of a window before it.
+func liveStart(events []netclient.ConnectionEvent, now time.Time) time.Time {
+	start := now
+	for _, event := range events {
+		if at := analysis.EventTime(event); at.Before(start) {
+			start = at
 		}
 	}
+	return start
+}
+
+// enrichEvents adds workload attribution, destination enrichment and scope classification
+func (s *NetworkMCPServer) enrichEvents(ctx context.Context, events []netclient.ConnectionEvent) {
+	s.attributor.Annotate(events)
+	s.hostnames.Annotate(ctx, events)
+	s.geo.Annotate(events)
+	s.services.Annotate(events)
+	enrich.ClassifyEvents(events)
+}
+
+// fetchPacketDropsSince lists live packet drops and recorded drops from before the live window
+func (s *NetworkMCPServer) fetchPacketDropsSince(ctx context.Context, since time.Time) ([]netclient.PacketDropInfo, error) {
+	drops, _, err := s.packetDropsSince(ctx, since)
+	return drops, err
+}
+
+// packetDropsSince is fetchPacketDropsSince that also returns how many of the drops were read
+// from recorded history
+func (s *NetworkMCPServer) packetDropsSince(ctx context.Context, since time.Time) ([]netclient.PacketDropInfo, int, error) {
+	output, err := s.httpClient.ListPacketDrops(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	if output.QueryTime != "" {
-		result += fmt.Sprintf("\nQuery time: %s", output.QueryTime)
+	now := time.Now()
+	liveStart := now
+	var live []netclient.PacketDropInfo
+	for _, drops := range output.EventsByPID {
+		for _, drop := range drops {
+			if at := store.DropTime(drop, now); at.Before(liveStart) {
+				liveStart = at
+			}
+			live = append(live, drop)
+		}
+	}
+
+	var drops []netclient.PacketDropInfo
+	if result, ok := s.queryHistory(since, liveStart, nil); ok {
+		drops = append(drops, result.Drops...)
+	}
+	return append(drops, live...), len(drops), nil
+}
+
+// filterPacketDrops keeps the drops of pid and processName, when set, that happened at or after since
+func filterPacketDrops(drops []netclient.PacketDropInfo, pid *int, processName string, since time.Time) []netclient.PacketDropInfo {
+	now := time.Now()
+	var filtered []netclient.PacketDropInfo
+	for _, drop := range drops {
+		if pid != nil && drop.PID != uint32(*pid) {
+			continue
+		}
+		if processName != "" && drop.Command != processName {
+			continue
+		}
+		if !since.IsZero() && store.DropTime(drop, now).Before(since) {
+			continue
+		}
+		filtered = append(filtered, drop)
+	}
+	return filtered
+}
+
+// maxBaselineHistoryWindows bounds the recorded windows learned by one compare_to_baseline call;
+// the rest are learned by later calls
+const maxBaselineHistoryWindows = 2000
+
+// minBaselineHistoryWindow is the shortest window recorded history is learned in, since the
+// rates of very short windows are mostly noise
+const minBaselineHistoryWindow = time.Minute
+
+// learnBaselineHistory folds whole windows of recorded history between what the baseline has
+// already learned and until into the baseline, returning how many were learned. The caller
+// holds baselineMu.
+func (s *NetworkMCPServer) learnBaselineHistory(baseline *analysis.Baseline, until time.Time, length time.Duration) int {
+	if s.history == nil {
+		return 0
+	}
+	if length < minBaselineHistoryWindow {
+		length = minBaselineHistoryWindow
+	}
+	start := baseline.LearnedUntil
+	if start.IsZero() {
+		if start = s.history.Oldest(); start.IsZero() {
+			return 0
+		}
+	}
+	count := int(until.Sub(start) / length)
+	if count <= 0 {
+		return 0
+	}
+	if count > maxBaselineHistoryWindows {
+		count = maxBaselineHistoryWindows
+	}
+	end := start.Add(time.Duration(count) * length)
+
+	result, ok := s.queryHistory(start, end, nil)
+	if !ok {
+		return 0
+	}
+	for _, window := range utils.HistoryWindows(result, start, end, length) {
+		baseline.Learn(window)
+	}
+	baseline.LearnedUntil = end
+	if s.verbose {
+		log.Printf("MCP Server: learned %d baseline windows from history (%s to %s)",
+			count, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	return count
+}
+
+// queryHistory reads recorded events in [since, until) when a history store is available
+func (s *NetworkMCPServer) queryHistory(since, until time.Time, pid *int) (store.Result, bool) {
+	if s.history == nil || since.IsZero() || !since.Before(until) {
+		return store.Result{}, false
+	}
+
+	result, err := s.history.Query(store.Query{Start: since, End: until, PID: pid})
+	if err != nil {
+		log.Printf("Warning: failed to read history: %v", err)
+		return store.Result{}, false
+	}
+	if s.verbose {
+		log.Printf("MCP Server: read %d connections and %d drops from history (%s to %s)",
+			len(result.Connections), len(result.Drops), since.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	return result, true
+}
+
+// historyCovers reports whether a window starting at since reaches back before the oldest live
+// event into recorded history, the same check fetchConnectionEventsSince makes
+func (s *NetworkMCPServer) historyCovers(ctx context.Context, pid *int, since time.Time) bool {
+	if s.history == nil {
+		return false
+	}
+	oldest := s.history.Oldest()
+	if oldest.IsZero() {
+		return false
+	}
+	output, err := s.httpClient.ListConnections(ctx, pid, nil)
+	if err != nil {
+		return false
+	}
+	var live []netclient.ConnectionEvent
+	for _, connections := range output.EventsByPID {
+		for _, conn := range connections {
+			live = append(live, conn.ToConnectionEvent())
+		}
+	}
+	start := liveStart(live, time.Now())
+	return since.Before(start) && oldest.Before(start)
+}
+
+// workloadNetworkSummary counts connections from event data so they can be filtered and grouped by Kubernetes workload
+func (s *NetworkMCPServer) workloadNetworkSummary(ctx context.Context, pid int, processName string, duration int, namespace, podName, groupBy string) (*mcp.CallToolResult, error) {
+	var pidFilter *int
+	if pid > 0 {
+		pidFilter = &pid
+	}
+
+	if err := s.httpClient.Connect(ctx); err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to connect to eBPF server: %v", err),
+				},
+			},
+		}, nil
+	}
+
+	events, err := s.fetchConnectionEventsSince(ctx, pidFilter, time.Now().Add(-time.Duration(duration)*time.Second))
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{
+					Text: fmt.Sprintf("Failed to list connections: %v", err),
+				},
+			},
+		}, nil
+	}
+
+	var filtered []netclient.ConnectionEvent
+	for _, event := range events {
+		if (pid <= 0 || event.PID == uint32(pid)) &&
+			(processName == "" || event.Command == processName) {
+			filtered = append(filtered, event)
+		}
+	}
+	filtered = utils.FilterByWorkload(filtered, namespace, podName)
+	filtered = utils.FilterSince(filtered, time.Now().Add(-time.Duration(duration)*time.Second))
+
+	target := summaryTarget(pid, processName, namespace, podName)
+	structured := utils.NetworkSummary{
+		Target:          target,
+		DurationSeconds: duration,
+		Count:           len(filtered),
+	}
+
+	var result string
+	if len(filtered) == 0 {
+		result = fmt.Sprintf("No network connections found for %s in the last %d seconds\n", target, duration)
+	} else {
+		result = fmt.Sprintf("%s made %d outbound connection attempts over the last %d seconds\n", target, len(filtered), duration)
+		report := utils.BuildPatternReport(filtered)
+		structured.Scopes = report.Scopes
+		structured.MetadataAccess = report.MetadataAccess
+		result += utils.FormatScopeBreakdown(report.Scopes, report.MetadataAccess)
+	}
+
+	if groupBy != "" {
+		groups, err := utils.GroupConnectionEvents(filtered, groupBy)
+		if err != nil {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{
+						Text: fmt.Sprintf("Error: %v", err),
+					},
+				},
+			}, nil
+		}
+		if len(groups) > 0 {
+			result += utils.FormatGroupCounts(groupBy, groups)
+		}
+		structured.GroupBy = groupBy
+		structured.Groups = groups
 	}
 
 	return &mcp.CallToolResult{
@@ -726,6 +1926,7 @@ func (s *NetworkMCPServer) handleListPacketDrops(ctx context.Context, session *m
 				Text: result,
 			},
 		},
+		StructuredContent: structured,
 	}, nil
 }
 
@@ -767,6 +1968,12 @@ func (s *NetworkMCPServer) RunSingleCommand(ctx context.Context, toolName string
 		return s.handleListPacketDrops(ctx, nil, params)
 	case "analyze_patterns":
 		return s.handleAnalyzePatterns(ctx, nil, params)
+	case "detect_anomalies":
+		return s.handleDetectAnomalies(ctx, nil, params)
+	case "compare_to_baseline":
+		return s.handleCompareToBaseline(ctx, nil, params)
+	case "compare_windows":
+		return s.handleCompareWindows(ctx, nil, params)
 	case "ai_insights":
 		return s.handleAIInsights(ctx, nil, params)
 	case "contextual_analysis":
@@ -837,48 +2044,175 @@ func (s *NetworkMCPServer) handleContextualAnalysis(ctx context.Context, session
 		}
 	}
 
-	// Create the intelligent network analyst
-	analyst := openai.NewContextualNetworkAnalyst(s, s.verbose)
-
-	// If specific process parameters are provided, do focused analysis
-	if processName != "" || pid > 0 {
-		analysis, err := analyst.AnalyzeProcess(ctx, processName, pid, duration)
-		if err != nil {
-			return &mcp.CallToolResult{
-				Content: []mcp.Content{
-					&mcp.TextContent{
-						Text: fmt.Sprintf("Error during intelligent analysis: %v", err),
-					},
-				},
-			}, nil
-		}
-
+	output := "text"
+	if outputVal, ok := arguments["output"].(string); ok && outputVal != "" {
+		output = outputVal
+	}
+	if output != "text" && output != "json" {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{
-					Text: analysis,
+					Text: fmt.Sprintf("Error: unknown output %q, use text or json", output),
 				},
 			},
 		}, nil
 	}
 
-	// Otherwise, process the general query
-	analysis, err := analyst.AnalyzeNetworkQuery(ctx, queryStr)
+	// The trace would get in the way of parsing a JSON report, so it is opt-in there
+	trace := output == "text"
+	if traceVal, ok := arguments["trace"].(bool); ok {
+		trace = traceVal
+	}
+
+	// Create the intelligent network analyst, or the rule-based one without a language model
+	analyst, fallback := s.newAnalyst()
+	if stream := s.analysisStream(ctx, session, params); stream != nil {
+		analyst.SetStreamHandler(stream)
+	}
+
+	// If specific process parameters are provided, do focused analysis
+	var analysis string
+	var err error
+	if processName != "" || pid > 0 {
+		analysis, err = analyst.AnalyzeProcess(ctx, processName, pid, duration)
+	} else if rules, ok := analyst.(*openai.RuleBasedAnalyst); ok {
+		// The rules cannot interpret the query, so it gets the health check of the requested window
+		analysis, err = rules.GetNetworkHealth(ctx, duration)
+	} else {
+		// Otherwise, process the general query
+		analysis, err = analyst.AnalyzeNetworkQuery(ctx, queryStr)
+	}
+	if err == nil && output == "json" {
+		var report *openai.Report
+		if report, err = analyst.ExtractReport(ctx); err == nil && fallback != nil {
+			report.Fallback = fmt.Sprintf("%s is unavailable: %v", s.llm.Name(), fallback)
+		}
+	}
+	run := analyst.LastRun()
+	s.saveTrace(run)
 	if err != nil {
-		return &mcp.CallToolResult{
+		result := &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{
 					Text: fmt.Sprintf("Error during intelligent analysis: %v", err),
 				},
 			},
-		}, nil
+		}
+		if run != nil {
+			// Keep the partial trace so failed analyses can be audited too
+			result.StructuredContent = run
+			if trace {
+				result.Content = append(result.Content, &mcp.TextContent{Text: openai.FormatTrace(run)})
+			}
+		}
+		return result, nil
+	}
+
+	var result *mcp.CallToolResult
+	if output == "json" {
+		result, err = reportResult(run)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		result = analysisResult(analysis, run)
+		if fallback != nil {
+			result.Content = append(result.Content, &mcp.TextContent{Text: offlineNote(s.llm, fallback)})
+		}
+	}
+	if trace && run != nil {
+		result.Content = append(result.Content, &mcp.TextContent{Text: openai.FormatTrace(run)})
+	}
+	return result, nil
+}
+
+// newContextualAnalyst creates an analyst with the configured provider, tools and limits
+func (s *NetworkMCPServer) newContextualAnalyst() *openai.ContextualNetworkAnalyst {
+	analyst := openai.NewContextualNetworkAnalystWithProvider(s, s.llm, s.verbose)
+	analyst.SetToolFilter(s.analystTools)
+	analyst.SetLimits(s.llmLimits)
+	analyst.SetExecutionOptions(s.toolExecution)
+	analyst.SetContextOptions(s.llmContext)
+	return analyst
+}
+
+// newAnalyst creates the analyst for contextual analyses: the contextual analyst when the
+// configured provider is usable, otherwise the offline rule-based analyst. The error explains
+// the fallback when a provider was configured but is unavailable.
+func (s *NetworkMCPServer) newAnalyst() (openai.NetworkAnalyst, error) {
+	err := openai.CheckProvider(s.llm)
+	if err == nil {
+		return s.newContextualAnalyst(), nil
+	}
+
+	analyst := s.newRuleBasedAnalyst()
+	if errors.Is(err, openai.ErrOffline) {
+		return analyst, nil
 	}
+	if s.verbose {
+		log.Printf("MCP Server: %s is unavailable, using the rule-based analyst: %v", s.llm.Name(), err)
+	}
+	return analyst, err
+}
+
+// newRuleBasedAnalyst creates an offline analyst with the configured tools
+func (s *NetworkMCPServer) newRuleBasedAnalyst() *openai.RuleBasedAnalyst {
+	analyst := openai.NewRuleBasedAnalyst(s, s.verbose)
+	analyst.SetToolFilter(s.analystTools)
+	analyst.SetExecutionOptions(s.toolExecution)
+	return analyst
+}
+
+// offlineNote tells users why an answer came from the rule-based analyst
+func offlineNote(provider openai.LLMProvider, err error) string {
+	return fmt.Sprintf("ℹ️ %s is unavailable (%v), so this report comes from the offline rule-based analyst. Configure the provider for model-based analysis, or use --llm-provider offline to hide this note.", provider.Name(), err)
+}
 
+// saveTrace writes the trace of an analysis to the configured trace directory, if any
+func (s *NetworkMCPServer) saveTrace(run *openai.RunResult) {
+	if s.traceDir == "" || run == nil {
+		return
+	}
+	path := filepath.Join(s.traceDir, openai.TraceFileName(run))
+	if err := openai.SaveTrace(path, run); err != nil {
+		log.Printf("MCP Server: Failed to save analysis trace: %v", err)
+		return
+	}
+	if s.verbose {
+		log.Printf("MCP Server: Saved analysis trace to %s", path)
+	}
+}
+
+// analysisResult returns an analysis with its run statistics, noting when a limit cut the
+// tool loop short
+func analysisResult(analysis string, run *openai.RunResult) *mcp.CallToolResult {
+	if run == nil {
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: analysis}}}
+	}
+	if run.Limited() {
+		analysis += "\n\n" + limitNote(run)
+	}
 	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			&mcp.TextContent{
-				Text: analysis,
-			},
-		},
+		Content:           []mcp.Content{&mcp.TextContent{Text: analysis}},
+		StructuredContent: run,
+	}
+}
+
+// reportResult returns the findings report of an analysis as JSON text, with the run and its
+// report as structured content
+func reportResult(run *openai.RunResult) (*mcp.CallToolResult, error) {
+	data, err := json.MarshalIndent(run.Report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode findings report: %v", err)
+	}
+	return &mcp.CallToolResult{
+		Content:           []mcp.Content{&mcp.TextContent{Text: string(data)}},
+		StructuredContent: run,
 	}, nil
 }
+
+// limitNote explains that a limit cut the analysis short
+func limitNote(run *openai.RunResult) string {
+	return fmt.Sprintf("⚠️ Analysis stopped early: %s after %d tool calls in %d rounds. Narrow the query or raise the limit for a fuller answer.",
+		run.Describe(), run.ToolCalls, run.ToolRounds)
+}
@@ -0,0 +1,299 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/store"
+)
+
+func connection(pid uint32, command string, at time.Time) netclient.ConnectionEvent {
+	return netclient.ConnectionEvent{
+		PID:             pid,
+		Command:         command,
+		DestinationIP:   "10.0.0.5",
+		DestinationPort: 5432,
+		Protocol:        "TCP",
+		TimestampNS:     uint64(at.UnixNano()),
+		WallTime:        at,
+	}
+}
+
+func drop(pid uint32, command string, at time.Time) netclient.PacketDropInfo {
+	return netclient.PacketDropInfo{PID: pid, Command: command, Reason: "NO_SOCKET", Timestamp: float64(at.UnixNano())}
+}
+
+// liveCassette serves the given connections and drops as the live eBPF server's lists
+func liveCassette(connections []netclient.ConnectionEvent, drops []netclient.PacketDropInfo) *netclient.Cassette {
+	var items []string
+	for _, c := range connections {
+		items = append(items, fmt.Sprintf(`{"pid":%d,"command":%q,"destination":"%s:%d","protocol":"TCP","time":%q}`,
+			c.PID, c.Command, c.DestinationIP, c.DestinationPort, c.WallTime.Format(time.RFC3339Nano)))
+	}
+	dropJSON, _ := json.Marshal(drops)
+	return &netclient.Cassette{
+		Version: 1,
+		Interactions: []netclient.Interaction{
+			{
+				Request: netclient.RecordedRequest{Method: "GET", Path: "/api/list-connections"},
+				Response: netclient.RecordedResponse{Status: 200, ContentType: "application/json", Body: json.RawMessage(
+					fmt.Sprintf(`{"total_events":%d,"events_by_pid":{"0":[%s]}}`, len(items), strings.Join(items, ",")))},
+			},
+			{
+				Request: netclient.RecordedRequest{Method: "GET", Path: "/api/list-packet-drops"},
+				Response: netclient.RecordedResponse{Status: 200, ContentType: "application/json", Body: json.RawMessage(
+					fmt.Sprintf(`{"total_events":%d,"events_by_pid":{"0":%s}}`, len(drops), dropJSON))},
+			},
+		},
+	}
+}
+
+// newHistoryServer returns a server answering from the cassette, with times kept, and reading
+// the given history store
+func newHistoryServer(t *testing.T, cassette *netclient.Cassette, history *store.Store) *NetworkMCPServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.History.Disabled = true
+	cfg.DNS.DisableReverseLookups = true
+	s := NewNetworkMCPServerWithConfig("http://netspy.test", false, cfg)
+	s.httpClient = netclient.NewClientWithTransport("http://netspy.test", false,
+		netclient.NewReplayTransport(cassette, netclient.ReplayOptions{KeepTimes: true}))
+	s.history = history
+	return s
+}
+
+func TestHistoryWindows(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	live := []netclient.ConnectionEvent{
+		connection(42, "curl", now.Add(-2*time.Minute)),
+		connection(42, "curl", now.Add(-time.Minute)),
+	}
+	liveDrops := []netclient.PacketDropInfo{drop(42, "curl", now.Add(-90*time.Second))}
+
+	history, err := store.Open(t.TempDir(), store.DefaultOptions())
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	err = history.Append(store.Snapshot{
+		Time: now.Add(-time.Minute),
+		Connections: []netclient.ConnectionEvent{
+			connection(42, "curl", now.Add(-10*time.Minute)),
+			connection(7, "dig", now.Add(-9*time.Minute)),
+			connection(42, "curl", now.Add(-8*time.Minute)),
+			// Also still live, so it must not be counted twice
+			connection(42, "curl", now.Add(-time.Minute)),
+		},
+		Drops: []netclient.PacketDropInfo{drop(7, "dig", now.Add(-9*time.Minute))},
+	})
+	if err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	s := newHistoryServer(t, liveCassette(live, liveDrops), history)
+
+	tests := []struct {
+		name         string
+		start, end   time.Time
+		pid          *int
+		covers       bool
+		events       int // Live and recorded events fetched for the window
+		drops        int
+		windowEvents int
+		windowDrops  int
+	}{
+		{"inside live range", now.Add(-2 * time.Minute), now, nil, false, 2, 1, 2, 1},
+		{"before live range", now.Add(-11 * time.Minute), now.Add(-5 * time.Minute), intPtr(42), true, 5, 2, 2, 0},
+		{"spanning history and live", now.Add(-9*time.Minute - 30*time.Second), now, nil, true, 4, 2, 4, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if got := s.historyCovers(ctx, nil, tt.start); got != tt.covers {
+				t.Errorf("historyCovers = %v, want %v", got, tt.covers)
+			}
+
+			events, err := s.fetchConnectionEventsSince(ctx, nil, tt.start)
+			if err != nil {
+				t.Fatalf("fetchConnectionEventsSince failed: %v", err)
+			}
+			if len(events) != tt.events {
+				t.Errorf("fetched %d events, want %d", len(events), tt.events)
+			}
+			drops, err := s.fetchPacketDropsSince(ctx, tt.start)
+			if err != nil {
+				t.Fatalf("fetchPacketDropsSince failed: %v", err)
+			}
+			if len(drops) != tt.drops {
+				t.Errorf("fetched %d drops, want %d", len(drops), tt.drops)
+			}
+
+			window := analysis.Window{Start: tt.start, End: tt.end}
+			fillWindow(&window, events, drops, tt.pid, "")
+			if len(window.Connections) != tt.windowEvents || len(window.Drops) != tt.windowDrops {
+				t.Errorf("window holds %d events and %d drops, want %d and %d",
+					len(window.Connections), len(window.Drops), tt.windowEvents, tt.windowDrops)
+			}
+		})
+	}
+
+	// Drop tools count recorded drops for windows reaching back before the live ones
+	params := &mcp.CallToolParamsFor[map[string]any]{Arguments: map[string]any{"duration": 600}}
+	result, _ := s.handleGetPacketDropSummary(context.Background(), nil, params)
+	if summary, ok := result.StructuredContent.(netclient.PacketDropSummaryOutput); !ok || summary.Count != 2 {
+		t.Errorf("expected 2 drops in the last 10 minutes, got %+v", result.StructuredContent)
+	}
+	params.Arguments["process_name"] = "dig"
+	result, _ = s.handleListPacketDrops(context.Background(), nil, params)
+	if text := result.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "(1 total)") || !strings.Contains(text, "PID 7 (dig)") {
+		t.Errorf("expected dig's recorded drop, got %q", text)
+	}
+
+	// Without a store only the live range is available
+	s.history = nil
+	if s.historyCovers(context.Background(), nil, now.Add(-time.Hour)) {
+		t.Error("expected no history coverage without a store")
+	}
+}
+
+func TestComparisonWindowRanges(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := &NetworkMCPServer{}
+
+	tests := []struct {
+		name          string
+		arguments     map[string]any
+		before, after analysis.Window
+		err           string
+	}{
+		{
+			name:      "default",
+			arguments: map[string]any{},
+			before:    analysis.Window{Start: now.Add(-10 * time.Minute), End: now.Add(-5 * time.Minute)},
+			after:     analysis.Window{Start: now.Add(-5 * time.Minute), End: now},
+		},
+		{
+			name:      "split in the past",
+			arguments: map[string]any{"duration": 600, "split": "1h"},
+			before:    analysis.Window{Start: now.Add(-70 * time.Minute), End: now.Add(-time.Hour)},
+			after:     analysis.Window{Start: now.Add(-time.Hour), End: now.Add(-50 * time.Minute)},
+		},
+		{
+			name:      "explicit bounds",
+			arguments: map[string]any{"before_start": "2024-05-01T08:00:00Z", "before_end": "2024-05-01T09:00:00Z", "after_start": "30m"},
+			before:    analysis.Window{Start: now.Add(-4 * time.Hour), End: now.Add(-3 * time.Hour)},
+			after:     analysis.Window{Start: now.Add(-30 * time.Minute), End: now},
+		},
+		{name: "zero duration", arguments: map[string]any{"duration": 0}, err: "duration must be positive"},
+		{name: "empty window", arguments: map[string]any{"before_start": "1h", "before_end": "2h"}, err: "invalid comparison windows"},
+		{name: "bad time", arguments: map[string]any{"split": "yesterday"}, err: "invalid split"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after, err := s.comparisonWindowRanges(tt.arguments, now)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("comparisonWindowRanges failed: %v", err)
+			}
+			if !before.Start.Equal(tt.before.Start) || !before.End.Equal(tt.before.End) ||
+				!after.Start.Equal(tt.after.Start) || !after.End.Equal(tt.after.End) {
+				t.Errorf("got %s–%s and %s–%s", before.Start, before.End, after.Start, after.End)
+			}
+		})
+	}
+}
+
+func TestCassetteWindow(t *testing.T) {
+	recorded := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	cassette := liveCassette([]netclient.ConnectionEvent{
+		connection(42, "curl", recorded.Add(time.Minute)),
+		connection(7, "dig", recorded.Add(5*time.Minute)),
+	}, []netclient.PacketDropInfo{drop(42, "curl", recorded.Add(2*time.Minute))})
+	cassette.RecordedAt = recorded
+	path := filepath.Join(t.TempDir(), "before.json")
+	if err := cassette.Save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	s := newHistoryServer(t, &netclient.Cassette{}, nil)
+
+	window, events, drops, err := s.cassetteWindow(context.Background(), path, nil)
+	if err != nil {
+		t.Fatalf("cassetteWindow failed: %v", err)
+	}
+	if !window.Start.Equal(recorded) || !window.End.Equal(recorded.Add(5*time.Minute+time.Nanosecond)) {
+		t.Errorf("expected the window to span the recording, got %s–%s", window.Start, window.End)
+	}
+	if len(events) != 2 || len(drops) != 1 {
+		t.Fatalf("expected 2 events and 1 drop, got %d and %d", len(events), len(drops))
+	}
+
+	// Recorded times are kept, so the events fall inside the window rather than now
+	fillWindow(&window, events, drops, intPtr(42), "")
+	if len(window.Connections) != 1 || len(window.Drops) != 1 {
+		t.Errorf("expected curl's event and drop in the window, got %d and %d", len(window.Connections), len(window.Drops))
+	}
+
+	if _, _, _, err := s.cassetteWindow(context.Background(), filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Error("expected an error for a missing cassette")
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestParseSummaryScope(t *testing.T) {
+	tests := []struct {
+		text string
+		want summaryScope
+	}{
+		{"process 'curl' made 12 outbound connection attempts over the last 300 seconds", summaryScope{processName: "curl", duration: 300, found: true}},
+		{"PID 42 had 3 packet drops over the last 120 seconds", summaryScope{pid: 42, duration: 120, found: true}},
+		{"Baseline Comparison (last 600 seconds):\n  nginx normal", summaryScope{duration: 600, found: true}},
+		{"all processes made 5 outbound connection attempts over the last 30s", summaryScope{duration: 30, found: true}},
+		{"curl keeps timing out against postgres", summaryScope{duration: 60}},
+	}
+	for _, tt := range tests {
+		if got := parseSummaryScope(tt.text); got != tt.want {
+			t.Errorf("parseSummaryScope(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+
+	note := parseSummaryScope("curl is slow").note()
+	if !strings.Contains(note, "not analyzed") || !strings.Contains(note, "all processes over the last 60 seconds, since the summary names no window") {
+		t.Errorf("unexpected note %q", note)
+	}
+}
+
+func TestContextualAnalysisJSONFallback(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	now := time.Now().UTC().Truncate(time.Second)
+	s := newHistoryServer(t, liveCassette([]netclient.ConnectionEvent{connection(42, "curl", now.Add(-time.Minute))}, nil), nil)
+
+	params := &mcp.CallToolParamsFor[map[string]any]{Arguments: map[string]any{"query": "health check", "output": "json"}}
+	result, err := s.handleContextualAnalysis(context.Background(), nil, params)
+	if err != nil {
+		t.Fatalf("handleContextualAnalysis failed: %v", err)
+	}
+	var report openai.Report
+	if err := json.Unmarshal([]byte(result.Content[0].(*mcp.TextContent).Text), &report); err != nil {
+		t.Fatalf("expected a JSON report, got %v", err)
+	}
+	if !strings.HasPrefix(report.Fallback, "OpenAI is unavailable") || !strings.Contains(report.Fallback, "OPENAI_API_KEY") {
+		t.Errorf("expected the fallback reason in the report, got %q", report.Fallback)
+	}
+}
@@ -0,0 +1,149 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// streamKey carries the stream handler of a local caller in a context
+type streamKey struct{}
+
+// withStream returns a context whose contextual analyses report their progress to handler.
+// Local callers use it because they call tool handlers without an MCP session.
+func withStream(ctx context.Context, handler openai.StreamHandler) context.Context {
+	return context.WithValue(ctx, streamKey{}, handler)
+}
+
+// analysisStream returns the handler receiving the progress of a contextual analysis: MCP
+// notifications for a client session, or the local caller's handler, if any
+func (s *NetworkMCPServer) analysisStream(ctx context.Context, session *mcp.ServerSession, params *mcp.CallToolParamsFor[map[string]any]) openai.StreamHandler {
+	if session != nil {
+		return s.notificationStream(ctx, session, params.GetProgressToken())
+	}
+	handler, _ := ctx.Value(streamKey{}).(openai.StreamHandler)
+	return handler
+}
+
+// notificationStream forwards analysis progress to an MCP client. Requests with a progress
+// token get progress notifications whose messages carry the answer text and tool activity;
+// otherwise the events are sent as log messages, which clients receive after setting a level.
+func (s *NetworkMCPServer) notificationStream(ctx context.Context, session *mcp.ServerSession, token any) openai.StreamHandler {
+	progress := 0
+	return func(event openai.StreamEvent) {
+		progress++
+		var err error
+		if token != nil {
+			err = session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+				ProgressToken: token,
+				Progress:      float64(progress),
+				Message:       progressMessage(event),
+			})
+		} else {
+			err = session.Log(ctx, &mcp.LoggingMessageParams{
+				Level:  "info",
+				Logger: "contextual_analysis",
+				Data:   progressData(event),
+			})
+		}
+		if err != nil && s.verbose {
+			log.Printf("MCP Server: Failed to send analysis progress: %v", err)
+		}
+	}
+}
+
+// progressMessage describes an event in a progress notification
+func progressMessage(event openai.StreamEvent) string {
+	switch event.Type {
+	case openai.StreamToolCall:
+		return strings.TrimSpace(fmt.Sprintf("Calling %s %s", event.Tool, toolArguments(event.Arguments)))
+	case openai.StreamToolResult:
+		return fmt.Sprintf("%s %s", event.Tool, toolOutcome(event.Execution))
+	default:
+		return event.Text
+	}
+}
+
+// progressData describes an event in a log message
+func progressData(event openai.StreamEvent) map[string]any {
+	data := map[string]any{"event": string(event.Type)}
+	switch event.Type {
+	case openai.StreamText:
+		data["text"] = event.Text
+	case openai.StreamToolCall:
+		data["tool"] = event.Tool
+		data["arguments"] = event.Arguments
+	case openai.StreamToolResult:
+		data["tool"] = event.Tool
+		data["result"] = toolOutcome(event.Execution)
+	}
+	return data
+}
+
+// toolArguments returns tool call arguments for display, empty when there are none
+func toolArguments(arguments string) string {
+	if arguments == "{}" {
+		return ""
+	}
+	return arguments
+}
+
+// toolOutcome summarizes how a tool call ended
+func toolOutcome(exec *openai.ToolExecution) string {
+	switch {
+	case exec.Skipped:
+		return "skipped"
+	case exec.TimedOut:
+		return fmt.Sprintf("timed out after %dms", exec.LatencyMS)
+	default:
+		return fmt.Sprintf("returned %d bytes in %dms", exec.ResultBytes, exec.LatencyMS)
+	}
+}
+
+// terminalStream prints assistant text as it arrives and a line per tool call
+type terminalStream struct {
+	atLineStart bool // Whether the output ended with a newline
+	printedText bool // Whether assistant text was printed since the last reset
+}
+
+func newTerminalStream() *terminalStream {
+	return &terminalStream{atLineStart: true}
+}
+
+// handle prints one stream event
+func (t *terminalStream) handle(event openai.StreamEvent) {
+	switch event.Type {
+	case openai.StreamText:
+		fmt.Print(event.Text)
+		t.atLineStart = strings.HasSuffix(event.Text, "\n")
+		t.printedText = true
+	case openai.StreamToolCall:
+		t.endLine()
+		if arguments := toolArguments(event.Arguments); arguments != "" {
+			fmt.Printf("🔧 %s %s\n", event.Tool, arguments)
+		} else {
+			fmt.Printf("🔧 %s\n", event.Tool)
+		}
+	case openai.StreamToolResult:
+		t.endLine()
+		fmt.Printf("   ↳ %s %s\n", event.Tool, toolOutcome(event.Execution))
+	}
+}
+
+// endLine finishes a partially printed line
+func (t *terminalStream) endLine() {
+	if !t.atLineStart {
+		fmt.Println()
+		t.atLineStart = true
+	}
+}
+
+// reset prepares for the next answer
+func (t *terminalStream) reset() {
+	t.endLine()
+	t.printedText = false
+}
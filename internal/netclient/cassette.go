@@ -0,0 +1,587 @@
+package netclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CassetteVersion is the cassette file format version
+const CassetteVersion = 1
+
+// Cassette is a recording of the requests made to the eBPF server and the responses it returned
+type Cassette struct {
+	Version      int           `json:"version"`
+	RecordedAt   time.Time     `json:"recorded_at"`
+	BaseURL      string        `json:"base_url,omitempty"`
+	Scrubbed     []string      `json:"scrubbed,omitempty"` // What was redacted: "ips", "processes"
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the part of a request used to match it on replay
+type RecordedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Query  string          `json:"query,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// RecordedResponse is a recorded server response
+type RecordedResponse struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Text        string          `json:"text,omitempty"` // Non-JSON response bodies
+}
+
+// ScrubOptions selects what is redacted from a cassette before it is written
+type ScrubOptions struct {
+	// IPs replaces addresses with stable placeholders from 198.18.0.0/15 and 2001:db8::/32.
+	// Loopback and unspecified addresses are kept.
+	IPs bool
+	// Processes replaces process names with stable placeholders (process-1, process-2, ...)
+	Processes bool
+}
+
+// ParseScrubOptions parses a comma-separated list of "ips", "processes" or "all"
+func ParseScrubOptions(value string) (ScrubOptions, error) {
+	var opts ScrubOptions
+	for _, part := range strings.Split(value, ",") {
+		switch strings.TrimSpace(strings.ToLower(part)) {
+		case "":
+		case "ips", "ip":
+			opts.IPs = true
+		case "processes", "process":
+			opts.Processes = true
+		case "all":
+			opts.IPs, opts.Processes = true, true
+		default:
+			return opts, fmt.Errorf("unknown scrub option %q (use ips, processes or all)", part)
+		}
+	}
+	return opts, nil
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %v", err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %v", path, err)
+	}
+	if cassette.Version > CassetteVersion {
+		return nil, fmt.Errorf("cassette %s has unsupported version %d", path, cassette.Version)
+	}
+	return &cassette, nil
+}
+
+// Save atomically writes the cassette
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %v", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to create cassette directory: %v", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write cassette: %v", err)
+	}
+	return nil
+}
+
+// Scrub returns a copy of the cassette with IPs and process names redacted. Replacements are
+// consistent across the cassette, so scrubbed requests still match their responses on replay.
+func (c *Cassette) Scrub(opts ScrubOptions) *Cassette {
+	scrubber := newScrubber(opts)
+	scrubbed := *c
+	scrubbed.BaseURL = ""
+	scrubbed.Interactions = make([]Interaction, len(c.Interactions))
+	for i, interaction := range c.Interactions {
+		interaction.Request.Query = scrubber.query(interaction.Request.Query)
+		interaction.Request.Body = scrubber.json(interaction.Request.Body)
+		interaction.Response.Body = scrubber.json(interaction.Response.Body)
+		if interaction.Response.Text != "" {
+			interaction.Response.Text = scrubber.text(interaction.Response.Text)
+		}
+		scrubbed.Interactions[i] = interaction
+	}
+	scrubbed.Scrubbed = appendUnique(scrubbed.Scrubbed, opts)
+	return &scrubbed
+}
+
+func appendUnique(scrubbed []string, opts ScrubOptions) []string {
+	result := append([]string(nil), scrubbed...)
+	add := func(name string) {
+		for _, existing := range result {
+			if existing == name {
+				return
+			}
+		}
+		result = append(result, name)
+	}
+	if opts.IPs {
+		add("ips")
+	}
+	if opts.Processes {
+		add("processes")
+	}
+	return result
+}
+
+// RecordingTransport passes requests through to the server and appends each exchange to a
+// cassette file. The file is rewritten after every interaction, so an interrupted session still
+// leaves a usable cassette.
+type RecordingTransport struct {
+	next  http.RoundTripper
+	path  string
+	scrub ScrubOptions
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingTransport creates a transport recording to path. A nil next uses http.DefaultTransport.
+func NewRecordingTransport(next http.RoundTripper, path string, scrub ScrubOptions) *RecordingTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RecordingTransport{
+		next:     next,
+		path:     path,
+		scrub:    scrub,
+		cassette: Cassette{Version: CassetteVersion, RecordedAt: time.Now().UTC()},
+	}
+}
+
+// RoundTrip performs the request and records it
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// Connection failures are not recorded; replay reports missing interactions instead
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	response := RecordedResponse{Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	if json.Valid(body) {
+		response.Body = json.RawMessage(body)
+	} else {
+		response.Text = string(body)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cassette.BaseURL = req.URL.Scheme + "://" + req.URL.Host
+	t.cassette.Interactions = append(t.cassette.Interactions, Interaction{Request: request, Response: response})
+	cassette := &t.cassette
+	if t.scrub.IPs || t.scrub.Processes {
+		cassette = cassette.Scrub(t.scrub)
+	}
+	if err := cassette.Save(t.path); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ReplayOptions configures cassette replay
+type ReplayOptions struct {
+	// KeepTimes serves event times as recorded. By default they are shifted so the newest
+	// recorded event happened now, which keeps duration-based tools working on old cassettes.
+	KeepTimes bool
+}
+
+// ReplayTransport serves recorded responses instead of calling the server.
+//
+// Requests are matched on method, path, query and body. Repeated identical requests are served
+// the recorded responses in order, repeating the last one. If nothing matches exactly, the first
+// response recorded for the same path is used, preferring the same method, so tools called with
+// different arguments still get data. Health checks always succeed.
+type ReplayTransport struct {
+	cassette *Cassette
+	shift    time.Duration
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+// NewReplayTransport creates a transport replaying a cassette
+func NewReplayTransport(cassette *Cassette, opts ReplayOptions) *ReplayTransport {
+	t := &ReplayTransport{cassette: cassette, served: make(map[string]int)}
+	if opts.KeepTimes {
+		return t
+	}
+	// RecordedAt is when recording started, so the newest recorded event marks its end
+	end := cassette.NewestEventTime()
+	if end.IsZero() {
+		end = cassette.RecordedAt
+	}
+	if !end.IsZero() {
+		t.shift = time.Since(end)
+	}
+	return t
+}
+
+// NewestEventTime returns the latest event time found in the recorded responses, or the zero
+// time when they hold none
+func (c *Cassette) NewestEventTime() time.Time {
+	var newest time.Time
+	for _, interaction := range c.Interactions {
+		if len(interaction.Response.Body) == 0 {
+			continue
+		}
+		value, err := decodeJSON(interaction.Response.Body)
+		if err != nil {
+			continue
+		}
+		walkJSON(value, func(key string, v any) any {
+			if at, ok := eventTime(key, v); ok && at.After(newest) {
+				newest = at
+			}
+			return v
+		})
+	}
+	return newest
+}
+
+// eventTime parses the RFC3339 "time" fields and wall-clock nanosecond "timestamp" fields that
+// replay shifts
+func eventTime(key string, v any) (time.Time, bool) {
+	switch key {
+	case "time":
+		if s, ok := v.(string); ok {
+			if at, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return at, true
+			}
+		}
+	case "timestamp":
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				if at := time.Unix(0, int64(f)); at.Year() >= 2000 {
+					return at, true
+				}
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// RoundTrip answers a request from the cassette
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	response, ok := t.match(request)
+	if !ok {
+		if request.Path == "/health" {
+			response = RecordedResponse{Status: http.StatusOK, ContentType: "application/json", Body: json.RawMessage(`{"status":"ok"}`)}
+		} else {
+			return nil, fmt.Errorf("no recorded response for %s %s in cassette", request.Method, request.Path)
+		}
+	}
+
+	var body []byte
+	if len(response.Body) > 0 {
+		body = shiftTimes(response.Body, t.shift)
+	} else {
+		body = []byte(response.Text)
+	}
+
+	header := make(http.Header)
+	if response.ContentType != "" {
+		header.Set("Content-Type", response.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// match finds the recorded response for a request
+func (t *ReplayTransport) match(request RecordedRequest) (RecordedResponse, bool) {
+	key := requestKey(request)
+
+	var exact []RecordedResponse
+	var fallback, pathFallback *RecordedResponse
+	for i := range t.cassette.Interactions {
+		interaction := &t.cassette.Interactions[i]
+		if interaction.Request.Path != request.Path {
+			continue
+		}
+		switch {
+		case requestKey(interaction.Request) == key:
+			exact = append(exact, interaction.Response)
+		case interaction.Request.Method == request.Method && fallback == nil:
+			fallback = &interaction.Response
+		case pathFallback == nil:
+			pathFallback = &interaction.Response
+		}
+	}
+	if fallback == nil {
+		fallback = pathFallback
+	}
+
+	if len(exact) > 0 {
+		t.mu.Lock()
+		n := t.served[key]
+		t.served[key] = n + 1
+		t.mu.Unlock()
+		if n >= len(exact) {
+			n = len(exact) - 1
+		}
+		return exact[n], true
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return RecordedResponse{}, false
+}
+
+// recordRequest captures a request without consuming its body
+func recordRequest(req *http.Request) (RecordedRequest, error) {
+	request := RecordedRequest{Method: req.Method, Path: req.URL.Path, Query: req.URL.RawQuery}
+	if req.Body == nil || req.Body == http.NoBody {
+		return request, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return request, fmt.Errorf("failed to read request body: %v", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) > 0 {
+		var compact bytes.Buffer
+		if json.Compact(&compact, body) == nil {
+			request.Body = json.RawMessage(compact.Bytes())
+		} else {
+			request.Body, _ = json.Marshal(string(body))
+		}
+	}
+	return request, nil
+}
+
+// requestKey identifies a request for replay matching
+func requestKey(request RecordedRequest) string {
+	return request.Method + " " + request.Path + "?" + request.Query + " " + string(request.Body)
+}
+
+// shiftTimes moves RFC3339 "time" fields and wall-clock nanosecond "timestamp" fields forward
+func shiftTimes(body json.RawMessage, shift time.Duration) []byte {
+	if shift == 0 {
+		return body
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return body
+	}
+	value = walkJSON(value, func(key string, v any) any {
+		at, ok := eventTime(key, v)
+		if !ok {
+			return v
+		}
+		if key == "time" {
+			return at.Add(shift).Format(time.RFC3339Nano)
+		}
+		f, _ := v.(json.Number).Float64()
+		return json.Number(fmt.Sprintf("%.0f", f+float64(shift.Nanoseconds())))
+	})
+	shifted, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return shifted
+}
+
+// processKeys are the JSON fields and query parameters holding process names
+var processKeys = map[string]bool{"command": true, "process_name": true, "comm": true}
+
+// rawIPKeys hold binary encodings of addresses, which are cleared rather than rewritten
+var rawIPKeys = map[string]bool{"raw_ipv4": true, "raw_ipv6": true}
+
+var (
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern = regexp.MustCompile(`(?i)\b[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}\b|::[0-9a-f]{1,4}(?::[0-9a-f]{1,4})*`)
+)
+
+// scrubber replaces sensitive values with stable placeholders
+type scrubber struct {
+	opts      ScrubOptions
+	ips       map[string]string
+	processes map[string]string
+}
+
+func newScrubber(opts ScrubOptions) *scrubber {
+	return &scrubber{opts: opts, ips: make(map[string]string), processes: make(map[string]string)}
+}
+
+func (s *scrubber) json(body json.RawMessage) json.RawMessage {
+	if len(body) == 0 {
+		return body
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return body
+	}
+	value = walkJSON(value, func(key string, v any) any {
+		if s.opts.IPs && rawIPKeys[key] {
+			return nil
+		}
+		str, ok := v.(string)
+		if !ok {
+			return v
+		}
+		if s.opts.Processes && processKeys[key] {
+			return s.process(str)
+		}
+		return s.text(str)
+	})
+	scrubbed, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return scrubbed
+}
+
+func (s *scrubber) query(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	for key, list := range values {
+		for i, v := range list {
+			if s.opts.Processes && processKeys[key] {
+				list[i] = s.process(v)
+			} else {
+				list[i] = s.text(v)
+			}
+		}
+	}
+	return values.Encode()
+}
+
+// text replaces addresses found in free text
+func (s *scrubber) text(value string) string {
+	if !s.opts.IPs {
+		return value
+	}
+	value = ipv4Pattern.ReplaceAllStringFunc(value, s.ip)
+	return ipv6Pattern.ReplaceAllStringFunc(value, s.ip)
+}
+
+func (s *scrubber) ip(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return value
+	}
+	if replacement, ok := s.ips[ip.String()]; ok {
+		return replacement
+	}
+
+	n := len(s.ips) + 1
+	var replacement string
+	if ip.To4() != nil {
+		replacement = fmt.Sprintf("198.%d.%d.%d", 18+(n>>16)&1, (n>>8)&0xff, n&0xff)
+	} else {
+		replacement = fmt.Sprintf("2001:db8::%x", n)
+	}
+	s.ips[ip.String()] = replacement
+	return replacement
+}
+
+func (s *scrubber) process(value string) string {
+	if value == "" {
+		return value
+	}
+	if replacement, ok := s.processes[value]; ok {
+		return replacement
+	}
+	replacement := fmt.Sprintf("process-%d", len(s.processes)+1)
+	s.processes[value] = replacement
+	return replacement
+}
+
+// decodeJSON decodes a JSON document keeping numbers exact
+func decodeJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// walkJSON applies fn to every object field and array element that is not itself a container.
+// Array elements are passed the key of the field holding the array.
+func walkJSON(value any, fn func(key string, v any) any) any {
+	var walk func(key string, v any) any
+	walk = func(key string, v any) any {
+		switch typed := v.(type) {
+		case map[string]any:
+			for k, child := range typed {
+				typed[k] = walk(k, child)
+			}
+			return typed
+		case []any:
+			if rawIPKeys[key] {
+				return fn(key, typed)
+			}
+			for i, child := range typed {
+				typed[i] = walk(key, child)
+			}
+			return typed
+		default:
+			return fn(key, v)
+		}
+	}
+	return walk("", value)
+}
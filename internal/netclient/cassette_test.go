@@ -0,0 +1,174 @@
+package netclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const cassetteConnections = `{"total_events":1,"total_pids":1,"events_by_pid":{"42":[{"id":"7","pid":42,"command":"curl","destination":"93.184.216.34:443","destination_ip":"93.184.216.34","destination_port":443,"protocol":"TCP","raw_ipv4":584628258,"time":"2024-05-01T10:00:00Z"}]}}`
+
+func newCassetteServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/api/list-connections":
+			w.Write([]byte(cassetteConnections))
+		case "/api/list-packet-drops":
+			w.Write([]byte(`{"total_events":0,"total_pids":0,"events_by_pid":{}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func recordCassette(t *testing.T, scrub ScrubOptions) string {
+	t.Helper()
+	server := newCassetteServer(t)
+	path := filepath.Join(t.TempDir(), "incident.json")
+
+	client := NewClientWithTransport(server.URL, false, NewRecordingTransport(nil, path, scrub))
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	if _, err := client.ListConnections(context.Background(), nil, nil); err != nil {
+		t.Fatalf("list connections failed: %v", err)
+	}
+	return path
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	path := recordCassette(t, ScrubOptions{})
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(cassette.Interactions) != 2 {
+		t.Fatalf("expected 2 interactions, got %d", len(cassette.Interactions))
+	}
+
+	// Replay against a server that does not exist
+	client := NewClientWithTransport("http://127.0.0.1:1", false, NewReplayTransport(cassette, ReplayOptions{KeepTimes: true}))
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("replayed connect failed: %v", err)
+	}
+	output, err := client.ListConnections(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatalf("replayed list failed: %v", err)
+	}
+	events := output.EventsByPID["42"]
+	if len(events) != 1 || events[0].Command != "curl" || events[0].Time != "2024-05-01T10:00:00Z" {
+		t.Errorf("unexpected replayed events: %+v", events)
+	}
+
+	// Different arguments fall back to the response recorded for the same endpoint
+	pid := 42
+	if _, err := client.ListConnections(context.Background(), &pid, nil); err != nil {
+		t.Errorf("expected fallback response, got %v", err)
+	}
+
+	if _, err := client.ListPacketDrops(context.Background()); err == nil {
+		t.Error("expected an error for an endpoint missing from the cassette")
+	}
+}
+
+func TestCassetteReplayShiftsTimes(t *testing.T) {
+	// Each call returns an event five minutes after the previous one, like a long session
+	recorded := time.Now().UTC().Truncate(time.Second)
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		at := recorded.Add(time.Duration(calls) * 5 * time.Minute).Format(time.RFC3339)
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"total_events":1,"total_pids":1,"events_by_pid":{"42":[{"pid":42,"command":"curl","time":%q}]}}`, at)
+	}))
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	recording := NewClientWithTransport(server.URL, false, NewRecordingTransport(nil, path, ScrubOptions{}))
+	for i := 0; i < 3; i++ {
+		if _, err := recording.ListConnections(context.Background(), nil, nil); err != nil {
+			t.Fatalf("list connections failed: %v", err)
+		}
+	}
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	// Replay an hour after the recording ended
+	cassette.RecordedAt = cassette.RecordedAt.Add(-time.Hour)
+	for i := range cassette.Interactions {
+		body := string(cassette.Interactions[i].Response.Body)
+		for k := 0; k < 3; k++ {
+			at := recorded.Add(time.Duration(k) * 5 * time.Minute)
+			body = strings.Replace(body, at.Format(time.RFC3339), at.Add(-time.Hour).Format(time.RFC3339), 1)
+		}
+		cassette.Interactions[i].Response.Body = json.RawMessage(body)
+	}
+
+	replay := NewClientWithTransport("http://127.0.0.1:1", false, NewReplayTransport(cassette, ReplayOptions{}))
+	var ages []time.Duration
+	for i := 0; i < 3; i++ {
+		output, err := replay.ListConnections(context.Background(), nil, nil)
+		if err != nil {
+			t.Fatalf("replayed list failed: %v", err)
+		}
+		ages = append(ages, time.Since(output.EventsByPID["42"][0].ToConnectionEvent().WallTime))
+	}
+	if ages[2] < -5*time.Second || ages[2] > 5*time.Second {
+		t.Errorf("expected the newest event to be replayed as happening now, got %s old", ages[2])
+	}
+	if ages[0] < 9*time.Minute || ages[0] > 11*time.Minute {
+		t.Errorf("expected the first event to keep its distance to the newest, got %s old", ages[0])
+	}
+}
+
+func TestCassetteScrub(t *testing.T) {
+	path := recordCassette(t, ScrubOptions{IPs: true, Processes: true})
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	body := string(cassette.Interactions[1].Response.Body)
+	for _, secret := range []string{"93.184.216.34", "curl", "584628258"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("scrubbed cassette still contains %q: %s", secret, body)
+		}
+	}
+	if cassette.BaseURL != "" {
+		t.Errorf("expected base URL to be removed, got %s", cassette.BaseURL)
+	}
+
+	client := NewClientWithTransport("http://127.0.0.1:1", false, NewReplayTransport(cassette, ReplayOptions{KeepTimes: true}))
+	output, err := client.ListConnections(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatalf("replayed list failed: %v", err)
+	}
+	event := output.EventsByPID["42"][0]
+	if event.Command != "process-1" || event.DestinationIP != "198.18.0.1" || event.Destination != "198.18.0.1:443" {
+		t.Errorf("unexpected scrubbed event: %+v", event)
+	}
+}
+
+func TestParseScrubOptions(t *testing.T) {
+	opts, err := ParseScrubOptions("ips, processes")
+	if err != nil || !opts.IPs || !opts.Processes {
+		t.Errorf("unexpected options %+v (%v)", opts, err)
+	}
+	if _, err := ParseScrubOptions("passwords"); err == nil {
+		t.Error("expected an error for an unknown option")
+	}
+}
@@ -36,6 +36,14 @@ func NewClientWithVerbose(baseURL string, verbose bool) *Client {
 	}
 }
 
+// NewClientWithTransport creates a new HTTP client sending requests through the given transport,
+// e.g. a cassette recording or replay transport
+func NewClientWithTransport(baseURL string, verbose bool, transport http.RoundTripper) *Client {
+	client := NewClientWithVerbose(baseURL, verbose)
+	client.httpClient.Transport = transport
+	return client
+}
+
 // Connect validates connection to the HTTP API server
 func (c *Client) Connect(ctx context.Context) error {
 	if c.verbose {
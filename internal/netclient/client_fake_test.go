@@ -0,0 +1,196 @@
+package netclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/fakeserver"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+const clientScenario = `{
+  "name": "client",
+  "connections": [
+    {"offset": "-40s", "repeat": 4, "every": "10s", "pid": 10, "command": "curl", "destination": "1.1.1.1:443"},
+    {"offset": "-20s", "pid": 20, "command": "nginx", "destination": "10.0.0.5:5432", "return_code": -111}
+  ],
+  "drops": [
+    {"offset": "-15s", "repeat": 2, "every": "5s", "pid": 20, "command": "nginx", "reason": "TCP_RESET"}
+  ]
+}`
+
+func newFakeServer(t *testing.T) (*fakeserver.Server, *netclient.Client) {
+	t.Helper()
+	scenario, err := fakeserver.ParseScenario(strings.NewReader(clientScenario))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	fake := fakeserver.New(scenario)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, netclient.NewClient(server.URL)
+}
+
+func TestFakeServer_ConnectAndHealthCheck(t *testing.T) {
+	fake, client := newFakeServer(t)
+	ctx := context.Background()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	if err := client.HealthCheck(ctx); err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+
+	fake.AddFault(fakeserver.Fault{Endpoint: "/health", Status: http.StatusServiceUnavailable})
+	if err := client.Connect(ctx); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected connect to report 503, got %v", err)
+	}
+	if err := client.HealthCheck(ctx); err == nil {
+		t.Error("expected health check to fail")
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+}
+
+func TestFakeServer_GetConnectionSummary(t *testing.T) {
+	_, client := newFakeServer(t)
+
+	summary, err := client.GetConnectionSummary(context.Background(), 0, "curl", 60)
+	if err != nil {
+		t.Fatalf("summary failed: %v", err)
+	}
+	if summary.Count != 4 || summary.Command != "curl" || summary.DurationSeconds != 60 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	summary, err = client.GetConnectionSummaryHTTP(20, "", 60)
+	if err != nil || summary.Count != 1 {
+		t.Errorf("unexpected summary by PID: %+v (%v)", summary, err)
+	}
+}
+
+func TestFakeServer_ListConnections(t *testing.T) {
+	_, client := newFakeServer(t)
+
+	// GET without filters
+	output, err := client.ListConnections(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if output.TotalEvents != 5 || output.TotalPIDs != 2 {
+		t.Errorf("expected 5 events from 2 PIDs, got %d from %d", output.TotalEvents, output.TotalPIDs)
+	}
+	event := output.EventsByPID["20"][0].ToConnectionEvent()
+	if event.DestinationIP != "10.0.0.5" || event.DestinationPort != 5432 || event.ReturnCode != -111 || event.WallTime.IsZero() {
+		t.Errorf("unexpected converted event: %+v", event)
+	}
+
+	// POST with filters
+	pid, limit := 10, 2
+	output, err = client.ListConnectionsHTTP(&pid, &limit)
+	if err != nil {
+		t.Fatalf("filtered list failed: %v", err)
+	}
+	if output.TotalEvents != 2 || len(output.EventsByPID["10"]) != 2 {
+		t.Errorf("expected 2 curl events, got %+v", output)
+	}
+}
+
+func TestFakeServer_PacketDrops(t *testing.T) {
+	_, client := newFakeServer(t)
+	ctx := context.Background()
+
+	summary, err := client.GetPacketDropSummary(ctx, 0, "nginx", 60)
+	if err != nil {
+		t.Fatalf("drop summary failed: %v", err)
+	}
+	if summary.Count != 2 {
+		t.Errorf("expected 2 drops, got %+v", summary)
+	}
+
+	output, err := client.ListPacketDrops(ctx)
+	if err != nil {
+		t.Fatalf("drop list failed: %v", err)
+	}
+	drops := output.EventsByPID["20"]
+	if output.TotalEvents != 2 || len(drops) != 2 || drops[0].Reason != "TCP_RESET" {
+		t.Errorf("unexpected drops: %+v", output)
+	}
+}
+
+func TestFakeServer_ServerErrors(t *testing.T) {
+	fake, client := newFakeServer(t)
+	fake.AddFault(fakeserver.Fault{Status: http.StatusInternalServerError})
+	ctx := context.Background()
+
+	calls := map[string]func() error{
+		"GetConnectionSummary": func() error { _, err := client.GetConnectionSummary(ctx, 0, "", 60); return err },
+		"ListConnections GET":  func() error { _, err := client.ListConnections(ctx, nil, nil); return err },
+		"ListConnections POST": func() error { pid := 10; _, err := client.ListConnections(ctx, &pid, nil); return err },
+		"GetPacketDropSummary": func() error { _, err := client.GetPacketDropSummary(ctx, 0, "", 60); return err },
+		"ListPacketDrops":      func() error { _, err := client.ListPacketDrops(ctx); return err },
+	}
+	for name, call := range calls {
+		err := call()
+		if err == nil || !strings.Contains(err.Error(), "server error (500): injected_fault") {
+			t.Errorf("%s: expected injected server error, got %v", name, err)
+		}
+	}
+}
+
+func TestFakeServer_MalformedJSON(t *testing.T) {
+	fake, client := newFakeServer(t)
+	fake.AddFault(fakeserver.Fault{Malformed: true})
+	ctx := context.Background()
+
+	calls := map[string]func() error{
+		"GetConnectionSummary": func() error { _, err := client.GetConnectionSummary(ctx, 0, "", 60); return err },
+		"ListConnections":      func() error { _, err := client.ListConnections(ctx, nil, nil); return err },
+		"GetPacketDropSummary": func() error { _, err := client.GetPacketDropSummary(ctx, 0, "", 60); return err },
+		"ListPacketDrops":      func() error { _, err := client.ListPacketDrops(ctx); return err },
+	}
+	for name, call := range calls {
+		if err := call(); err == nil || !strings.Contains(err.Error(), "failed to parse response") {
+			t.Errorf("%s: expected parse error, got %v", name, err)
+		}
+	}
+}
+
+func TestFakeServer_Latency(t *testing.T) {
+	fake, client := newFakeServer(t)
+	fake.AddFault(fakeserver.Fault{Endpoint: "/api/list-connections", Latency: fakeserver.Duration(time.Second)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.ListConnections(ctx, nil, nil); err == nil {
+		t.Error("expected the request to time out")
+	}
+
+	// Other endpoints are unaffected
+	if _, err := client.ListPacketDrops(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFakeServer_VerboseClient(t *testing.T) {
+	scenario, err := fakeserver.ParseScenario(strings.NewReader(clientScenario))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	server := httptest.NewServer(fakeserver.New(scenario))
+	defer server.Close()
+
+	client := netclient.NewClientWithVerbose(server.URL, true)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	if _, err := client.ListConnections(context.Background(), nil, nil); err != nil {
+		t.Errorf("list failed: %v", err)
+	}
+}
@@ -57,6 +57,21 @@ type ConnectionEvent struct {
 	Protocol        string    `json:"protocol"`
 	SocketType      string    `json:"socket_type"`
 	WallTime        time.Time `json:"wall_time"`
+
+	// Container attribution, filled in by the k8s attributor when available
+	ContainerID string `json:"container_id,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Pod         string `json:"pod,omitempty"`
+	Container   string `json:"container,omitempty"`
+
+	// Destination enrichment, filled in by the hostname and geo enrichers when available
+	Hostname string `json:"hostname,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Country  string `json:"country,omitempty"`
+	ASN      uint32 `json:"asn,omitempty"`
+	ASOrg    string `json:"as_org,omitempty"`
+	Scope    string `json:"scope,omitempty"` // loopback, private, public, cloud-metadata, ...
+	Service  string `json:"service,omitempty"`
 }
 
 // PacketDropInfo represents packet drop event information
@@ -6,6 +6,20 @@ import (
 	"strings"
 )
 
+// NetworkAnalyst answers network analysis requests by running MCP tools. It is implemented by
+// ContextualNetworkAnalyst with a language model and by RuleBasedAnalyst without one.
+type NetworkAnalyst interface {
+	AnalyzeNetworkQuery(ctx context.Context, query string) (string, error)
+	AnalyzeProcess(ctx context.Context, processName string, pid int, duration int) (string, error)
+	GetNetworkHealth(ctx context.Context, duration int) (string, error)
+	GetComprehensiveAnalysis(ctx context.Context, duration int) (string, error)
+	ExtractReport(ctx context.Context) (*Report, error)
+	LastRun() *RunResult
+	SetStreamHandler(handler StreamHandler)
+	SetToolFilter(filter ToolFilter)
+	SetExecutionOptions(opts ExecutionOptions)
+}
+
 // ContextualNetworkAnalyst provides AI-powered network analysis with tool integration
 type ContextualNetworkAnalyst struct {
 	conversationManager *ConversationManager
@@ -14,8 +28,13 @@ type ContextualNetworkAnalyst struct {
 
 // NewContextualNetworkAnalyst creates a new contextual network analyst
 func NewContextualNetworkAnalyst(mcpExecutor MCPToolExecutor, verbose bool) *ContextualNetworkAnalyst {
+	return NewContextualNetworkAnalystWithProvider(mcpExecutor, DefaultProvider(), verbose)
+}
+
+// NewContextualNetworkAnalystWithProvider creates a contextual network analyst backed by the given LLM provider
+func NewContextualNetworkAnalystWithProvider(mcpExecutor MCPToolExecutor, provider LLMProvider, verbose bool) *ContextualNetworkAnalyst {
 	analyst := &ContextualNetworkAnalyst{
-		conversationManager: NewConversationManager(mcpExecutor, verbose),
+		conversationManager: NewConversationManagerWithProvider(mcpExecutor, provider, verbose),
 		mcpExecutor:         mcpExecutor,
 	}
 
@@ -39,6 +58,8 @@ func (cna *ContextualNetworkAnalyst) setupSystemContext() {
 - **get_packet_drop_summary**: Analyze packet loss patterns
 - **list_packet_drops**: See specific drop events (if drops are found)
 - **analyze_patterns**: Get automated pattern analysis
+- **detect_anomalies**: Find port scans, connection bursts and retry storms with severity and evidence
+- **compare_windows**: Compare two time windows (e.g. before and after a deploy) for new/vanished destinations, rate changes, new drop reasons and new failures
 
 ## CRITICAL: Tool Usage Requirements:
 1. **ALWAYS start with get_network_summary** to get overall network health
@@ -97,6 +118,14 @@ func (cna *ContextualNetworkAnalyst) enhanceUserQuery(query string) string {
 		return query + "\n\nPlease check for packet drops and analyze any connectivity issues using get_packet_drop_summary and list_packet_drops tools."
 	}
 
+	if strings.Contains(queryLower, "deploy") || strings.Contains(queryLower, "what changed") || strings.Contains(queryLower, "changed since") {
+		return query + "\n\nPlease compare the windows before and after the change using compare_windows (set split to the change time if known), then use list_connections to inspect new destinations and failures."
+	}
+
+	if strings.Contains(queryLower, "anomal") || strings.Contains(queryLower, "scan") || strings.Contains(queryLower, "suspicious") {
+		return query + "\n\nPlease look for anomalies using detect_anomalies and analyze_patterns, and use list_connections to inspect the processes involved."
+	}
+
 	if strings.Contains(queryLower, "pattern") || strings.Contains(queryLower, "behavior") {
 		return query + "\n\nPlease analyze connection patterns using get_network_summary, list_connections, and analyze_patterns tools."
 	}
@@ -162,6 +191,56 @@ After gathering all data, provide:
 	return cna.AnalyzeNetworkQuery(ctx, query)
 }
 
+// SetToolFilter selects the MCP tools the analyst may call
+func (cna *ContextualNetworkAnalyst) SetToolFilter(filter ToolFilter) {
+	cna.conversationManager.SetToolFilter(filter)
+}
+
+// SetExecutionOptions sets how many tool calls run at once and how long each may take
+func (cna *ContextualNetworkAnalyst) SetExecutionOptions(opts ExecutionOptions) {
+	cna.conversationManager.SetExecutionOptions(opts)
+}
+
+// SetStreamHandler sets a handler that receives the answer and tool activity as they happen
+func (cna *ContextualNetworkAnalyst) SetStreamHandler(handler StreamHandler) {
+	cna.conversationManager.SetStreamHandler(handler)
+}
+
+// EstimatedTokens approximates the size of the conversation history
+func (cna *ContextualNetworkAnalyst) EstimatedTokens() int {
+	return cna.conversationManager.EstimatedTokens()
+}
+
+// Provider returns the LLM backend used by the analyst
+func (cna *ContextualNetworkAnalyst) Provider() LLMProvider {
+	return cna.conversationManager.Provider()
+}
+
+// SetContextOptions sets how large tool outputs and long conversations are shortened
+func (cna *ContextualNetworkAnalyst) SetContextOptions(opts ContextOptions) {
+	cna.conversationManager.SetContextOptions(opts)
+}
+
+// SetLimits bounds the tool rounds, tool calls, time and tokens spent on each query
+func (cna *ContextualNetworkAnalyst) SetLimits(limits Limits) {
+	cna.conversationManager.SetLimits(limits)
+}
+
+// LastRun returns how the most recent query was answered, including which limit stopped it
+func (cna *ContextualNetworkAnalyst) LastRun() *RunResult {
+	return cna.conversationManager.LastRun()
+}
+
+// ExtractReport restates the answer to the most recent query as a findings report whose
+// evidence cites the tool calls made while answering it
+func (cna *ContextualNetworkAnalyst) ExtractReport(ctx context.Context) (*Report, error) {
+	run := cna.conversationManager.LastRun()
+	if run == nil {
+		return nil, fmt.Errorf("no analysis to report on")
+	}
+	return cna.conversationManager.ExtractReport(ctx, run)
+}
+
 // StartNewConversation clears the conversation history and starts fresh
 func (cna *ContextualNetworkAnalyst) StartNewConversation() {
 	cna.conversationManager.ClearConversation()
@@ -173,6 +252,12 @@ func (cna *ContextualNetworkAnalyst) GetConversationHistory() []ChatMessage {
 	return cna.conversationManager.GetConversationHistory()
 }
 
+// RestoreConversation continues a saved conversation. The saved messages include the system
+// prompt they were answered with.
+func (cna *ContextualNetworkAnalyst) RestoreConversation(messages []ChatMessage) {
+	cna.conversationManager.SetConversationHistory(messages)
+}
+
 // ContinueConversation continues an existing conversation
 func (cna *ContextualNetworkAnalyst) ContinueConversation(ctx context.Context, message string) (string, error) {
 	return cna.conversationManager.ProcessMessage(ctx, message)
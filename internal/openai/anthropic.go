@@ -0,0 +1,400 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version sent with every request
+const anthropicVersion = "2023-06-01"
+
+// AnthropicProvider talks to the Anthropic Messages API with tool use
+type AnthropicProvider struct {
+	baseURL   string
+	apiKey    string
+	model     string
+	maxTokens int
+}
+
+// NewAnthropicProvider creates a provider for the Anthropic Messages API
+func NewAnthropicProvider(baseURL, apiKey, model string) *AnthropicProvider {
+	return &AnthropicProvider{
+		baseURL:   trimBaseURL(firstNonEmpty(baseURL, DefaultAnthropicBaseURL)),
+		apiKey:    apiKey,
+		model:     firstNonEmpty(model, DefaultAnthropicModel),
+		maxTokens: defaultMaxTokens,
+	}
+}
+
+type anthropicRequest struct {
+	Model      string               `json:"model"`
+	MaxTokens  int                  `json:"max_tokens"`
+	System     string               `json:"system,omitempty"`
+	Messages   []anthropicMessage   `json:"messages"`
+	Tools      []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream     bool                 `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a text, tool_use or tool_result content block
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *APIError `json:"error,omitempty"`
+}
+
+// anthropicStreamEvent is one server-sent event of a streamed Messages API reply
+type anthropicStreamEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message"`       // message_start
+	ContentBlock *anthropicBlock    `json:"content_block"` // content_block_start
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"` // message_delta
+	Error *APIError `json:"error"`
+}
+
+// Name returns the backend name
+func (p *AnthropicProvider) Name() string {
+	return "Anthropic"
+}
+
+// Model returns the default model
+func (p *AnthropicProvider) Model() string {
+	return p.model
+}
+
+// Chat translates the request to the Messages API: system messages become the system prompt,
+// tool calls become tool_use blocks and tool results become tool_result blocks in user turns
+func (p *AnthropicProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	body, headers, err := p.request(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result anthropicResponse
+	status, err := postJSON(ctx, p.baseURL+"/v1/messages", headers, body, &result)
+	return anthropicResult(&result, status, err, reportTool(req))
+}
+
+// ChatStream requests a streamed reply. Text and tool input arrive as deltas to content blocks,
+// which are assembled into the same response Chat returns.
+func (p *AnthropicProvider) ChatStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error) {
+	body, headers, err := p.request(req)
+	if err != nil {
+		return nil, err
+	}
+	body.Stream = true
+
+	resp, err := postRequest(ctx, p.baseURL+"/v1/messages", headers, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !isEventStream(resp) {
+		var result anthropicResponse
+		status, err := readJSON(resp, &result)
+		response, err := anthropicResult(&result, status, err, reportTool(req))
+		if err == nil {
+			deliverText(response, onText)
+		}
+		return response, err
+	}
+
+	var result anthropicResponse
+	var inputs []strings.Builder
+	err = readEvents(resp.Body, func(_ string, data string) error {
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("invalid Anthropic stream event: %v", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				result.Usage.InputTokens = event.Message.Usage.InputTokens
+			}
+		case "content_block_start":
+			for len(result.Content) <= event.Index {
+				result.Content = append(result.Content, anthropicBlock{})
+				inputs = append(inputs, strings.Builder{})
+			}
+			if event.ContentBlock != nil {
+				result.Content[event.Index] = *event.ContentBlock
+			}
+			// Chat joins text blocks with a newline
+			if result.Content[event.Index].Type == "text" && hasText(result.Content[:event.Index]) {
+				onText("\n")
+			}
+		case "content_block_delta":
+			if event.Index >= len(result.Content) {
+				return fmt.Errorf("Anthropic stream delta for unknown content block %d", event.Index)
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				result.Content[event.Index].Text += event.Delta.Text
+				onText(event.Delta.Text)
+			case "input_json_delta":
+				inputs[event.Index].WriteString(event.Delta.PartialJSON)
+			}
+		case "content_block_stop":
+			if event.Index < len(inputs) && inputs[event.Index].Len() > 0 {
+				result.Content[event.Index].Input = json.RawMessage(inputs[event.Index].String())
+			}
+		case "message_delta":
+			result.StopReason = event.Delta.StopReason
+			if event.Usage != nil {
+				result.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			result.Error = event.Error
+			return errStreamDone
+		}
+		return nil
+	})
+	return anthropicResult(&result, http.StatusOK, err, reportTool(req))
+}
+
+// request builds the Messages API request body and headers
+func (p *AnthropicProvider) request(req ChatRequest) (anthropicRequest, map[string]string, error) {
+	if err := p.check(); err != nil {
+		return anthropicRequest{}, nil, err
+	}
+
+	system, messages := toAnthropicMessages(req.Messages)
+	body := anthropicRequest{
+		Model:     firstNonEmpty(req.Model, p.model),
+		MaxTokens: p.maxTokens,
+		System:    system,
+		Messages:  messages,
+	}
+	for _, tool := range req.Tools {
+		body.Tools = append(body.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+	if len(body.Tools) > 0 {
+		body.ToolChoice = toAnthropicToolChoice(req.ToolChoice)
+	}
+	// The Messages API has no response_format; forcing a tool whose input schema is the
+	// requested one yields the same structured reply
+	if name := reportTool(req); name != "" {
+		body.Tools = append(body.Tools, anthropicTool{
+			Name:        name,
+			Description: req.ResponseFormat.JSONSchema.Description,
+			InputSchema: req.ResponseFormat.JSONSchema.Schema,
+		})
+		body.ToolChoice = &anthropicToolChoice{Type: "tool", Name: name}
+	}
+
+	headers := map[string]string{"anthropic-version": anthropicVersion}
+	if p.apiKey != "" {
+		headers["x-api-key"] = p.apiKey
+	}
+	return body, headers, nil
+}
+
+// check reports a missing API key for the hosted API
+func (p *AnthropicProvider) check() error {
+	if p.apiKey == "" && p.baseURL == DefaultAnthropicBaseURL {
+		return fmt.Errorf("ANTHROPIC_API_KEY not set")
+	}
+	return nil
+}
+
+// reportTool returns the name of the tool that carries a structured reply, or an empty string
+// when the request does not ask for one
+func reportTool(req ChatRequest) string {
+	if req.ResponseFormat == nil || req.ResponseFormat.JSONSchema == nil {
+		return ""
+	}
+	return req.ResponseFormat.JSONSchema.Name
+}
+
+// anthropicResult converts a Messages API reply to a chat response. The input of a call to
+// structuredTool is the structured reply and becomes the message content.
+func anthropicResult(result *anthropicResponse, status int, err error, structuredTool string) (*ChatResponse, error) {
+	if err != nil {
+		if status != http.StatusOK && status != 0 {
+			return nil, fmt.Errorf("Anthropic API returned HTTP %d", status)
+		}
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("Anthropic API error: %s", result.Error.Message)
+	}
+
+	message := ChatMessage{Role: "assistant"}
+	finishReason := anthropicFinishReason(result.StopReason)
+	var text []string
+	structured := ""
+	for _, block := range result.Content {
+		switch {
+		case block.Type == "text":
+			text = append(text, block.Text)
+		case block.Type == "tool_use" && structuredTool != "" && block.Name == structuredTool:
+			structured = string(block.Input)
+		case block.Type == "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: FunctionDetails{Name: block.Name, Arguments: arguments},
+			})
+		}
+	}
+	switch {
+	case structured != "":
+		// Any text next to the structured reply is commentary
+		message.Content = &structured
+		finishReason = "stop"
+	case len(text) > 0:
+		content := strings.Join(text, "\n")
+		message.Content = &content
+	}
+
+	return &ChatResponse{
+		Choices: []ChatChoice{{Message: message, FinishReason: finishReason}},
+		Usage: &Usage{
+			PromptTokens:     result.Usage.InputTokens,
+			CompletionTokens: result.Usage.OutputTokens,
+			TotalTokens:      result.Usage.InputTokens + result.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// hasText reports whether any of the blocks is a text block
+func hasText(blocks []anthropicBlock) bool {
+	for _, block := range blocks {
+		if block.Type == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+// toAnthropicMessages splits out the system prompt and merges consecutive messages of the same
+// role, since the Messages API requires user and assistant turns to alternate
+func toAnthropicMessages(messages []ChatMessage) (string, []anthropicMessage) {
+	var system []string
+	var converted []anthropicMessage
+	for _, msg := range messages {
+		content := ""
+		if msg.Content != nil {
+			content = *msg.Content
+		}
+
+		role := msg.Role
+		var blocks []anthropicBlock
+		switch msg.Role {
+		case "system":
+			system = append(system, content)
+			continue
+		case "tool":
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: content})
+		default:
+			if content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: content})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: jsonObject(call.Function.Arguments),
+				})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if n := len(converted); n > 0 && converted[n-1].Role == role {
+			converted[n-1].Content = append(converted[n-1].Content, blocks...)
+			continue
+		}
+		converted = append(converted, anthropicMessage{Role: role, Content: blocks})
+	}
+	return strings.Join(system, "\n\n"), converted
+}
+
+// toAnthropicToolChoice maps OpenAI tool_choice values: "auto", "none", "required" or
+// {"type": "function", "function": {"name": ...}}
+func toAnthropicToolChoice(choice interface{}) *anthropicToolChoice {
+	switch c := choice.(type) {
+	case string:
+		switch c {
+		case "none":
+			return &anthropicToolChoice{Type: "none"}
+		case "required":
+			return &anthropicToolChoice{Type: "any"}
+		}
+	case map[string]interface{}:
+		if function, ok := c["function"].(map[string]interface{}); ok {
+			if name, ok := function["name"].(string); ok {
+				return &anthropicToolChoice{Type: "tool", Name: name}
+			}
+		}
+	}
+	return &anthropicToolChoice{Type: "auto"}
+}
+
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "tool_use":
+		return "tool_calls"
+	case "max_tokens":
+		return "length"
+	case "end_turn", "stop_sequence":
+		return "stop"
+	}
+	return stopReason
+}
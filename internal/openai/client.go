@@ -1,12 +1,10 @@
 package openai
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
+	"time"
 )
 
 type ChatRequest struct {
@@ -16,6 +14,31 @@ type ChatRequest struct {
 	FunctionCall interface{}          `json:"function_call,omitempty"`
 	Tools        []Tool               `json:"tools,omitempty"`
 	ToolChoice   interface{}          `json:"tool_choice,omitempty"`
+	// ResponseFormat constrains the reply to JSON matching a schema
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// Stream and StreamOptions are set by providers when the reply is streamed
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// ResponseFormat requests structured output. Providers without response_format translate it
+// to their own mechanism: Ollama's format parameter or a forced Anthropic tool call.
+type ResponseFormat struct {
+	Type       string      `json:"type"` // "json_schema"
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema names the schema a structured reply must match
+type JSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Strict      bool            `json:"strict"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// StreamOptions asks a streaming chat completions API to report usage in its last chunk
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Tool struct {
@@ -35,67 +58,63 @@ type ChatMessage struct {
 }
 
 type ChatResponse struct {
-	Choices []struct {
-		Message      ChatMessage `json:"message"`
-		FinishReason string      `json:"finish_reason"`
-	} `json:"choices"`
-	Error *struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-	} `json:"error,omitempty"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   *Usage       `json:"usage,omitempty"`
+	Error   *APIError    `json:"error,omitempty"`
+}
+
+// ChatChoice is one candidate assistant message in a chat response
+type ChatChoice struct {
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// Usage reports the tokens consumed by a chat request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// APIError is an error object returned by the chat API
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
 }
 
 func AskLLM(summary string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY not set")
-	}
+	return AskLLMWithProvider(context.Background(), DefaultProvider(), summary)
+}
 
+// AskLLMWithProvider asks the given provider for insights about a summary
+func AskLLMWithProvider(ctx context.Context, provider LLMProvider, summary string) (string, error) {
 	prompt := CreateNetworkInsightsPrompt(summary)
 	systemContent := "You are a network connectivity analyst focused on providing actionable insights about connection patterns and application network behavior."
 
 	reqBody := ChatRequest{
-		Model: "gpt-3.5-turbo",
 		Messages: []ChatMessage{
 			{Role: "system", Content: &systemContent},
 			{Role: "user", Content: &prompt},
 		},
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewReader(data))
+	result, err := provider.Chat(ctx, reqBody)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var result ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	if result.Error != nil {
-		return "", fmt.Errorf("OpenAI API error: %s", result.Error.Message)
-	}
-
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+		return "", fmt.Errorf("no response from %s", provider.Name())
 	}
 
 	if result.Choices[0].Message.Content == nil {
-		return "", fmt.Errorf("empty response from OpenAI")
+		return "", fmt.Errorf("empty response from %s", provider.Name())
 	}
 
 	return *result.Choices[0].Message.Content, nil
@@ -105,23 +124,46 @@ func AskLLM(summary string) (string, error) {
 type ConversationManager struct {
 	functionManager *FunctionCallManager
 	messages        []ChatMessage
-	model           string
+	model           string // Empty uses the provider's model
+	provider        LLMProvider
+	limits          Limits
+	context         ContextOptions
+	stream          StreamHandler
+	lastRun         *RunResult
 }
 
-// NewConversationManager creates a new conversation manager with function calling
+// NewConversationManager creates a new conversation manager with function calling, using the
+// provider selected by environment variables
 func NewConversationManager(mcpExecutor MCPToolExecutor, verbose bool) *ConversationManager {
+	return NewConversationManagerWithProvider(mcpExecutor, DefaultProvider(), verbose)
+}
+
+// NewConversationManagerWithProvider creates a conversation manager that talks to the given provider
+func NewConversationManagerWithProvider(mcpExecutor MCPToolExecutor, provider LLMProvider, verbose bool) *ConversationManager {
 	return &ConversationManager{
 		functionManager: NewFunctionCallManager(mcpExecutor, verbose),
 		messages:        make([]ChatMessage, 0),
-		model:           "gpt-4o-mini", // Use a more capable model for function calling
+		provider:        provider,
+		limits:          DefaultLimits(),
+		context:         DefaultContextOptions(),
 	}
 }
 
-// SetModel sets the OpenAI model to use
+// SetModel overrides the provider's model
 func (cm *ConversationManager) SetModel(model string) {
 	cm.model = model
 }
 
+// SetProvider switches the conversation to another LLM backend, keeping the history
+func (cm *ConversationManager) SetProvider(provider LLMProvider) {
+	cm.provider = provider
+}
+
+// Provider returns the LLM backend used by the conversation
+func (cm *ConversationManager) Provider() LLMProvider {
+	return cm.provider
+}
+
 // AddSystemMessage adds a system message to the conversation
 func (cm *ConversationManager) AddSystemMessage(content string) {
 	cm.messages = append(cm.messages, ChatMessage{
@@ -140,44 +182,137 @@ func (cm *ConversationManager) AddUserMessage(content string) {
 
 // ProcessMessage processes a user message and handles any function calls
 func (cm *ConversationManager) ProcessMessage(ctx context.Context, userMessage string) (string, error) {
-	// Add user message
-	cm.AddUserMessage(userMessage)
-
-	// Make the initial request to OpenAI with function calling capabilities
-	response, err := cm.sendChatRequest(ctx)
+	run, err := cm.Run(ctx, userMessage)
 	if err != nil {
-		return "", fmt.Errorf("failed to send chat request: %v", err)
+		return "", err
 	}
+	return run.Content, nil
+}
 
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+// Run answers a user message, executing the tools the model requests until it answers or a
+// limit is reached. A limit withholds further tools with tool_choice "none", so the model still
+// answers from the data gathered so far, and the result records which limit stopped the loop.
+func (cm *ConversationManager) Run(ctx context.Context, userMessage string) (*RunResult, error) {
+	started := time.Now()
+	run := &RunResult{
+		Query:      userMessage,
+		Provider:   cm.provider.Name(),
+		Model:      firstNonEmpty(cm.model, cm.provider.Model()),
+		StartedAt:  started,
+		StopReason: StopComplete,
+		Limits:     cm.limits,
 	}
+	cm.lastRun = run
 
-	choice := response.Choices[0]
-
-	// Add assistant's response to conversation
-	cm.messages = append(cm.messages, choice.Message)
-
-	// If the assistant wants to call functions, execute them
-	if len(choice.Message.ToolCalls) > 0 {
-		return cm.handleFunctionCalls(ctx, choice.Message.ToolCalls)
+	cm.AddUserMessage(userMessage)
+	cm.compactHistory(ctx, run)
+
+	// Tools and the requests that may call them run against the deadline
+	toolCtx := ctx
+	if cm.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		toolCtx, cancel = context.WithDeadline(ctx, started.Add(cm.limits.Timeout))
+		defer cancel()
 	}
 
-	// If it's a direct response, return the content
-	if choice.Message.Content != nil {
-		return *choice.Message.Content, nil
+	toolChoice := "auto"
+	for requests := 1; ; requests++ {
+		// The forced final request gets a short grace period of its own, so it can still answer
+		// after the deadline
+		requestCtx, cancel := toolCtx, context.CancelFunc(func() {})
+		if toolChoice == "none" {
+			requestCtx, cancel = context.WithTimeout(ctx, finalRequestGrace)
+		}
+		response, err := cm.sendChatRequest(requestCtx, toolChoice, run)
+		cancel()
+		if err != nil && toolChoice != "none" && toolCtx.Err() != nil && ctx.Err() == nil {
+			// The deadline passed while waiting for the model; answer from the data gathered so far
+			run.StopReason = StopDeadline
+			toolChoice = "none"
+			continue
+		}
+		if err != nil {
+			run.DurationMS = time.Since(started).Milliseconds()
+			if requests == 1 {
+				return nil, fmt.Errorf("failed to send chat request: %v", err)
+			}
+			return nil, fmt.Errorf("failed to get final response: %v", err)
+		}
+		if response.Usage != nil {
+			run.Usage.add(*response.Usage)
+		}
+
+		if len(response.Choices) == 0 {
+			run.DurationMS = time.Since(started).Milliseconds()
+			if requests == 1 {
+				return nil, fmt.Errorf("no response from %s", cm.provider.Name())
+			}
+			return nil, fmt.Errorf("no final response from %s", cm.provider.Name())
+		}
+
+		message := response.Choices[0].Message
+		if toolChoice == "none" {
+			// Ignore calls a model makes despite tool_choice "none" so every call in the history has a result
+			message.ToolCalls = nil
+		}
+		cm.messages = append(cm.messages, message)
+		if message.Content != nil && *message.Content != "" && !run.LLMRequests[len(run.LLMRequests)-1].Streamed {
+			cm.emit(StreamEvent{Type: StreamText, Text: *message.Content})
+		}
+
+		if len(message.ToolCalls) == 0 {
+			if message.Content == nil {
+				run.DurationMS = time.Since(started).Milliseconds()
+				if requests == 1 {
+					return nil, fmt.Errorf("no content in response")
+				}
+				return nil, fmt.Errorf("no content in final response")
+			}
+			run.Content = *message.Content
+			run.DurationMS = time.Since(started).Milliseconds()
+			return run, nil
+		}
+
+		if reason := cm.limits.stopReason(run, started); reason != "" {
+			run.StopReason = reason
+			toolChoice = "none"
+			recorded := len(run.ToolExecutions)
+			cm.addSkippedResults(message.ToolCalls, len(run.LLMRequests), run.Describe(), run)
+			cm.emitToolResults(run, recorded)
+			continue
+		}
+
+		calls := message.ToolCalls
+		var skipped []ToolCall
+		if cm.limits.MaxToolCalls > 0 && run.ToolCalls+len(calls) > cm.limits.MaxToolCalls {
+			allowed := cm.limits.MaxToolCalls - run.ToolCalls
+			calls, skipped = calls[:allowed], calls[allowed:]
+		}
+		run.ToolRounds++
+		for _, call := range calls {
+			cm.emit(StreamEvent{Type: StreamToolCall, Tool: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+		recorded := len(run.ToolExecutions)
+		if err := cm.executeToolCalls(toolCtx, calls, len(run.LLMRequests), run); err != nil {
+			// Answer the calls anyway, since a history with unanswered calls is rejected by every provider
+			cm.addSkippedResults(message.ToolCalls, len(run.LLMRequests), err.Error(), run)
+			cm.emitToolResults(run, recorded)
+			run.DurationMS = time.Since(started).Milliseconds()
+			return nil, err
+		}
+		run.ToolCalls += len(calls)
+
+		if len(skipped) > 0 {
+			run.StopReason = StopMaxToolCalls
+			toolChoice = "none"
+			cm.addSkippedResults(skipped, len(run.LLMRequests), run.Describe(), run)
+		}
+		cm.emitToolResults(run, recorded)
 	}
-
-	return "", fmt.Errorf("no content in response")
 }
 
-// sendChatRequest sends a chat completion request to OpenAI
-func (cm *ConversationManager) sendChatRequest(ctx context.Context) (*ChatResponse, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY not set")
-	}
-
+// sendChatRequest sends the conversation to the provider
+func (cm *ConversationManager) sendChatRequest(ctx context.Context, toolChoice string, run *RunResult) (*ChatResponse, error) {
 	// Convert function definitions to tools format
 	tools := make([]Tool, 0, len(cm.functionManager.GetFunctions()))
 	for _, fn := range cm.functionManager.GetFunctions() {
@@ -191,83 +326,149 @@ func (cm *ConversationManager) sendChatRequest(ctx context.Context) (*ChatRespon
 		Model:      cm.model,
 		Messages:   cm.messages,
 		Tools:      tools,
-		ToolChoice: "auto", // Let the model decide when to call functions
+		ToolChoice: toolChoice, // "auto" lets the model decide; "none" forces an answer
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
-	}
+	return cm.recordRequest(ctx, reqBody, answerRequest, run)
+}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
+// requestKind distinguishes the LLM requests of a run
+type requestKind int
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
+const (
+	answerRequest  requestKind = iota // A turn of the tool loop
+	summaryRequest                    // Summarizes older conversation turns
+	reportRequest                     // Extracts a findings report
+)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+// recordRequest sends a chat request to the provider and records it in the run. Answers are
+// streamed to the stream handler when the provider supports it; summaries and reports never are.
+func (cm *ConversationManager) recordRequest(ctx context.Context, req ChatRequest, kind requestKind, run *RunResult) (*ChatResponse, error) {
+	record := LLMRequest{
+		Round:           len(run.LLMRequests) + 1,
+		Summary:         kind == summaryRequest,
+		Report:          kind == reportRequest,
+		Messages:        len(req.Messages),
+		EstimatedTokens: EstimateMessagesTokens(req.Messages),
 	}
-	defer resp.Body.Close()
-
-	var result ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if toolChoice, ok := req.ToolChoice.(string); ok {
+		record.ToolChoice = toolChoice
 	}
-
-	if result.Error != nil {
-		return nil, fmt.Errorf("OpenAI API error: %s", result.Error.Message)
+	sent := time.Now()
+	var response *ChatResponse
+	var err error
+	if streaming, ok := cm.provider.(StreamingProvider); ok && cm.stream != nil && kind == answerRequest {
+		record.Streamed = true
+		response, err = streaming.ChatStream(ctx, req, func(text string) {
+			cm.emit(StreamEvent{Type: StreamText, Text: text})
+		})
+	} else {
+		response, err = cm.provider.Chat(ctx, req)
+	}
+	record.LatencyMS = time.Since(sent).Milliseconds()
+	switch {
+	case err != nil:
+		record.Error = err.Error()
+	case len(response.Choices) == 0:
+		record.Usage = response.Usage
+		record.Error = "no choices in response"
+	default:
+		record.Usage = response.Usage
+		record.FinishReason = response.Choices[0].FinishReason
+		record.ToolCalls = len(response.Choices[0].Message.ToolCalls)
 	}
+	run.LLMRequests = append(run.LLMRequests, record)
 
-	return &result, nil
+	return response, err
 }
 
-// handleFunctionCalls executes function calls and continues the conversation
-func (cm *ConversationManager) handleFunctionCalls(ctx context.Context, toolCalls []ToolCall) (string, error) {
-	// Execute all function calls
+// executeToolCalls executes function calls, adds their results to the conversation and records
+// them in the run under the LLM request that asked for them
+func (cm *ConversationManager) executeToolCalls(ctx context.Context, toolCalls []ToolCall, round int, run *RunResult) error {
 	results, err := cm.functionManager.ExecuteFunctions(ctx, toolCalls)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute functions: %v", err)
+		return fmt.Errorf("failed to execute functions: %v", err)
 	}
 
-	// Add function results to conversation
-	for _, result := range results {
-		cm.messages = append(cm.messages, ChatMessage{
-			Role:       result.Role,
-			Content:    &result.Content,
-			ToolCallID: result.ToolCallID,
+	for i, result := range results {
+		content := TruncateToolOutput(result.Content, cm.context.MaxToolResultTokens)
+		cm.addToolResult(result.ToolCallID, content)
+		run.ToolExecutions = append(run.ToolExecutions, ToolExecution{
+			Round:       round,
+			ID:          result.ToolCallID,
+			Name:        toolCalls[i].Function.Name,
+			Arguments:   toolCalls[i].Function.Arguments,
+			LatencyMS:   result.Latency.Milliseconds(),
+			ResultBytes: len(result.Content),
+			Truncated:   len(content) < len(result.Content),
+			TimedOut:    result.TimedOut,
 		})
 	}
+	return nil
+}
 
-	// Send another request to get the final response
-	response, err := cm.sendChatRequest(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to get final response: %v", err)
+// addSkippedResults answers tool calls that a limit or an error prevented from running, keeping
+// every call in the history paired with a result
+func (cm *ConversationManager) addSkippedResults(toolCalls []ToolCall, round int, reason string, run *RunResult) {
+	for _, call := range toolCalls {
+		cm.addToolResult(call.ID, fmt.Sprintf("Not executed: %s. Answer with the data gathered so far.", reason))
+		run.ToolExecutions = append(run.ToolExecutions, ToolExecution{
+			Round:     round,
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+			Skipped:   true,
+		})
 	}
+}
 
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no final response from OpenAI")
-	}
+func (cm *ConversationManager) addToolResult(toolCallID, content string) {
+	cm.messages = append(cm.messages, ChatMessage{
+		Role:       "tool",
+		Content:    &content,
+		ToolCallID: toolCallID,
+	})
+}
 
-	choice := response.Choices[0]
+// SetToolFilter selects the MCP tools offered to the model
+func (cm *ConversationManager) SetToolFilter(filter ToolFilter) {
+	cm.functionManager.SetToolFilter(filter)
+}
 
-	// Add the final response to conversation
-	cm.messages = append(cm.messages, choice.Message)
+// SetExecutionOptions sets the worker limit and per-call timeout for tool calls
+func (cm *ConversationManager) SetExecutionOptions(opts ExecutionOptions) {
+	cm.functionManager.SetExecutionOptions(opts)
+}
 
-	// Check if there are more function calls (recursive case)
-	if len(choice.Message.ToolCalls) > 0 {
-		return cm.handleFunctionCalls(ctx, choice.Message.ToolCalls)
-	}
+// SetLimits sets the limits applied to each message
+func (cm *ConversationManager) SetLimits(limits Limits) {
+	cm.limits = limits
+}
 
-	// Return the final content
-	if choice.Message.Content != nil {
-		return *choice.Message.Content, nil
-	}
+// Limits returns the limits applied to each message
+func (cm *ConversationManager) Limits() Limits {
+	return cm.limits
+}
+
+// SetStreamHandler sets a handler that receives assistant text and tool activity as a message
+// is answered; nil disables streaming
+func (cm *ConversationManager) SetStreamHandler(handler StreamHandler) {
+	cm.stream = handler
+}
+
+// SetContextOptions sets how tool outputs and long histories are shortened
+func (cm *ConversationManager) SetContextOptions(opts ContextOptions) {
+	cm.context = opts
+}
 
-	return "", fmt.Errorf("no content in final response")
+// EstimatedTokens approximates the tokens the conversation history occupies
+func (cm *ConversationManager) EstimatedTokens() int {
+	return EstimateMessagesTokens(cm.messages)
+}
+
+// LastRun returns how the most recent message was answered, or nil before the first message
+func (cm *ConversationManager) LastRun() *RunResult {
+	return cm.lastRun
 }
 
 // GetConversationHistory returns the current conversation history
@@ -275,7 +476,14 @@ func (cm *ConversationManager) GetConversationHistory() []ChatMessage {
 	return cm.messages
 }
 
+// SetConversationHistory replaces the conversation, e.g. with one restored from disk
+func (cm *ConversationManager) SetConversationHistory(messages []ChatMessage) {
+	cm.messages = append([]ChatMessage(nil), messages...)
+	cm.lastRun = nil
+}
+
 // ClearConversation clears the conversation history
 func (cm *ConversationManager) ClearConversation() {
 	cm.messages = make([]ChatMessage, 0)
+	cm.lastRun = nil
 }
@@ -0,0 +1,456 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// fakeExecutor is an MCP tool executor that records calls and returns canned text
+type fakeExecutor struct {
+	tools      map[string]*mcp.Tool
+	outputs    map[string]string
+	structured map[string]any // Structured content returned with the text, if any
+	errors     map[string]error
+	delays     map[string]time.Duration // Sleeps ignoring cancellation, like a stuck backend
+
+	mu          sync.Mutex
+	calls       []executedCall
+	inFlight    int
+	maxInFlight int
+}
+
+type executedCall struct {
+	Tool      string
+	Arguments map[string]any
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{
+		tools: map[string]*mcp.Tool{
+			"list_connections": {
+				Name:        "list_connections",
+				Description: "List connection events",
+				InputSchema: &jsonschema.Schema{
+					Type: "object",
+					Properties: map[string]*jsonschema.Schema{
+						"pid":        {Type: "integer", Description: "Process ID"},
+						"max_events": {Type: "integer", Default: []byte("100")},
+					},
+				},
+			},
+			"list_packet_drops": {
+				Name:        "list_packet_drops",
+				Description: "List packet drops",
+				InputSchema: &jsonschema.Schema{
+					Type: "object",
+					Properties: map[string]*jsonschema.Schema{
+						"scope": {Type: "string", Enum: []any{"all", "external"}},
+					},
+					Required: []string{"scope"},
+				},
+			},
+		},
+		outputs: map[string]string{
+			"list_connections":  "3 connections from curl",
+			"list_packet_drops": "1 drop: TCP_RESET",
+		},
+		errors: map[string]error{},
+		delays: map[string]time.Duration{},
+	}
+}
+
+func (e *fakeExecutor) RunSingleCommand(ctx context.Context, toolName string, arguments map[string]any) (*mcp.CallToolResult, error) {
+	e.mu.Lock()
+	e.calls = append(e.calls, executedCall{Tool: toolName, Arguments: arguments})
+	e.inFlight++
+	if e.inFlight > e.maxInFlight {
+		e.maxInFlight = e.inFlight
+	}
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.inFlight--
+		e.mu.Unlock()
+	}()
+
+	time.Sleep(e.delays[toolName])
+	if err := e.errors[toolName]; err != nil {
+		return nil, err
+	}
+	return &mcp.CallToolResult{
+		Content:           []mcp.Content{&mcp.TextContent{Text: e.outputs[toolName]}},
+		StructuredContent: e.structured[toolName],
+	}, nil
+}
+
+func (e *fakeExecutor) GetRegisteredTools() map[string]*mcp.Tool {
+	return e.tools
+}
+
+func newFakeOpenAI(t *testing.T, turns ...fakeopenai.Turn) (*fakeopenai.Server, string) {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	fake := fakeopenai.New(turns...)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func newConversation(t *testing.T, executor *fakeExecutor, turns ...fakeopenai.Turn) (*openai.ConversationManager, *fakeopenai.Server) {
+	t.Helper()
+	fake, baseURL := newFakeOpenAI(t, turns...)
+	cm := openai.NewConversationManagerWithProvider(executor, openai.NewOpenAIProvider(baseURL, "test-key", ""), false)
+	return cm, fake
+}
+
+func TestAskLLM(t *testing.T) {
+	fake, baseURL := newFakeOpenAI(t, fakeopenai.Turn{Content: "curl is healthy"})
+
+	answer, err := openai.AskLLMWithProvider(context.Background(), openai.NewOpenAIProvider(baseURL, "test-key", ""), "3 connections from curl")
+	if err != nil {
+		t.Fatalf("AskLLM failed: %v", err)
+	}
+	if answer != "curl is healthy" {
+		t.Errorf("unexpected answer %q", answer)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	req := requests[0]
+	if req.Authorization != "Bearer test-key" {
+		t.Errorf("unexpected authorization header %q", req.Authorization)
+	}
+	if req.Model != openai.DefaultOpenAIModel {
+		t.Errorf("expected default model, got %q", req.Model)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[1].Role != "user" {
+		t.Fatalf("expected system and user messages, got %+v", req.Messages)
+	}
+	if !strings.Contains(req.Messages[1].Text(), "3 connections from curl") {
+		t.Errorf("expected summary in prompt, got %q", req.Messages[1].Text())
+	}
+	if len(req.Tools) != 0 {
+		t.Errorf("expected no tools, got %v", req.ToolNames())
+	}
+}
+
+func TestAskLLM_UsesBaseURLFromEnvironment(t *testing.T) {
+	fake, baseURL := newFakeOpenAI(t, fakeopenai.Turn{Content: "ok"})
+	t.Setenv("OPENAI_BASE_URL", baseURL)
+
+	if _, err := openai.AskLLM("summary"); err != nil {
+		t.Fatalf("AskLLM failed: %v", err)
+	}
+	if len(fake.Requests()) != 1 {
+		t.Errorf("expected request to reach the fake server")
+	}
+}
+
+func TestAskLLM_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		turn fakeopenai.Turn
+		want string
+	}{
+		{"api error", fakeopenai.Turn{Error: "model overloaded"}, "OpenAI API error: model overloaded"},
+		{"api error with status", fakeopenai.Turn{Status: http.StatusTooManyRequests, Error: "rate limited"}, "OpenAI API error: rate limited"},
+		{"empty choices", fakeopenai.Turn{NoChoices: true}, "no response from OpenAI"},
+		{"nil content", fakeopenai.Turn{NilContent: true}, "empty response from OpenAI"},
+		{"non-json error", fakeopenai.Turn{Status: http.StatusBadGateway, RawBody: "<html>bad gateway</html>"}, "HTTP 502"},
+		{"malformed body", fakeopenai.Turn{RawBody: `{"choices": [`}, "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, baseURL := newFakeOpenAI(t, tt.turn)
+			_, err := openai.AskLLMWithProvider(context.Background(), openai.NewOpenAIProvider(baseURL, "test-key", ""), "summary")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAskLLM_MissingKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_BASE_URL", "")
+	t.Setenv("NETSPY_LLM_PROVIDER", "")
+	t.Setenv("NETSPY_LLM_BASE_URL", "")
+
+	_, err := openai.AskLLM("summary")
+	if err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY not set") {
+		t.Errorf("expected missing key error, got %v", err)
+	}
+}
+
+func TestAskLLM_LocalServerWithoutKey(t *testing.T) {
+	fake, baseURL := newFakeOpenAI(t, fakeopenai.Turn{Content: "ok"})
+
+	if _, err := openai.AskLLMWithProvider(context.Background(), openai.NewOpenAIProvider(baseURL, "", "local-model"), "summary"); err != nil {
+		t.Fatalf("AskLLM failed: %v", err)
+	}
+	req := fake.Requests()[0]
+	if req.Authorization != "" {
+		t.Errorf("expected no authorization header, got %q", req.Authorization)
+	}
+	if req.Model != "local-model" {
+		t.Errorf("expected local-model, got %q", req.Model)
+	}
+}
+
+func TestConversation_DirectAnswer(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor, fakeopenai.Turn{Content: "No tools needed"})
+	cm.SetModel("test-model")
+	cm.AddSystemMessage("You are a network analyst")
+
+	answer, err := cm.ProcessMessage(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	if answer != "No tools needed" {
+		t.Errorf("unexpected answer %q", answer)
+	}
+	if len(executor.calls) != 0 {
+		t.Errorf("expected no tool calls, got %v", executor.calls)
+	}
+
+	req := fake.Requests()[0]
+	if req.Model != "test-model" {
+		t.Errorf("expected model test-model, got %q", req.Model)
+	}
+	if string(req.ToolChoice) != `"auto"` {
+		t.Errorf("expected tool_choice auto, got %s", req.ToolChoice)
+	}
+	names := strings.Join(req.ToolNames(), ",")
+	if !strings.Contains(names, "list_connections") || !strings.Contains(names, "list_packet_drops") {
+		t.Errorf("expected discovered tools to be offered, got %s", names)
+	}
+
+	history := cm.GetConversationHistory()
+	if len(history) != 3 || history[2].Role != "assistant" {
+		t.Errorf("expected system, user and assistant messages, got %+v", history)
+	}
+}
+
+func TestConversation_MultiStepToolCalls(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{
+			{ID: "call_a", Name: "list_connections", Arguments: `{"pid": 42}`},
+			{ID: "call_b", Name: "list_packet_drops", Arguments: `{"scope": "external"}`},
+		}},
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{
+			{ID: "call_c", Name: "list_connections", Arguments: `{"pid": 7}`},
+		}},
+		fakeopenai.Turn{Content: "curl looks fine; one reset drop"},
+	)
+
+	answer, err := cm.ProcessMessage(context.Background(), "what is curl doing?")
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	if answer != "curl looks fine; one reset drop" {
+		t.Errorf("unexpected answer %q", answer)
+	}
+	if fake.Remaining() != 0 {
+		t.Errorf("expected every scripted turn to be used, %d left", fake.Remaining())
+	}
+
+	// Tools run in the order the model requested them, with decoded arguments
+	var tools []string
+	for _, call := range executor.calls {
+		tools = append(tools, fmt.Sprintf("%s(%v)", call.Tool, call.Arguments))
+	}
+	want := "list_connections(map[pid:42]) list_packet_drops(map[scope:external]) list_connections(map[pid:7])"
+	if got := strings.Join(tools, " "); got != want {
+		t.Errorf("expected tool calls %q, got %q", want, got)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+
+	// The second request carries the assistant tool calls followed by one result per call
+	second := requests[1].Messages
+	if len(second) != 4 {
+		t.Fatalf("expected user, assistant and two tool messages, got %d", len(second))
+	}
+	if second[1].Role != "assistant" || len(second[1].ToolCalls) != 2 {
+		t.Errorf("expected assistant message with 2 tool calls, got %+v", second[1])
+	}
+	assertToolResult(t, second[2], "call_a", "3 connections from curl")
+	assertToolResult(t, second[3], "call_b", "1 drop: TCP_RESET")
+
+	third := requests[2].Messages
+	if len(third) != 6 {
+		t.Fatalf("expected 6 messages in final request, got %d", len(third))
+	}
+	assertToolResult(t, third[5], "call_c", "3 connections from curl")
+
+	history := cm.GetConversationHistory()
+	if len(history) != 7 || *history[6].Content != answer {
+		t.Errorf("expected final answer at the end of a 7 message history, got %d messages", len(history))
+	}
+
+	cm.ClearConversation()
+	if len(cm.GetConversationHistory()) != 0 {
+		t.Error("expected empty history after clear")
+	}
+}
+
+func TestConversation_ToolErrorsAreThreaded(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.errors["list_connections"] = fmt.Errorf("backend unavailable")
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{
+			{ID: "call_exec", Name: "list_connections"},
+			{ID: "call_unknown", Name: "delete_everything"},
+			{ID: "call_args", Name: "list_packet_drops", Arguments: `{not json`},
+		}},
+		fakeopenai.Turn{Content: "The backend is down"},
+	)
+
+	answer, err := cm.ProcessMessage(context.Background(), "status?")
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	if answer != "The backend is down" {
+		t.Errorf("unexpected answer %q", answer)
+	}
+	if len(executor.calls) != 1 {
+		t.Errorf("expected only the known tool with valid arguments to run, got %v", executor.calls)
+	}
+
+	messages := fake.Requests()[1].Messages
+	if len(messages) != 5 {
+		t.Fatalf("expected 3 tool results, got %d messages", len(messages))
+	}
+	assertToolResult(t, messages[2], "call_exec", "Error executing list_connections: backend unavailable")
+	assertToolResult(t, messages[3], "call_unknown", "Function execution failed: unknown function: delete_everything")
+	assertToolResult(t, messages[4], "call_args", "Function execution failed: failed to parse function arguments")
+}
+
+func TestConversation_Errors(t *testing.T) {
+	toolTurn := fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}}
+	tests := []struct {
+		name  string
+		turns []fakeopenai.Turn
+		want  string
+	}{
+		{"api error", []fakeopenai.Turn{{Error: "invalid model"}}, "failed to send chat request: OpenAI API error: invalid model"},
+		{"empty choices", []fakeopenai.Turn{{NoChoices: true}}, "no response from OpenAI"},
+		{"nil content", []fakeopenai.Turn{{NilContent: true}}, "no content in response"},
+		{"follow-up api error", []fakeopenai.Turn{toolTurn, {Error: "context length exceeded"}}, "failed to get final response: OpenAI API error: context length exceeded"},
+		{"follow-up empty choices", []fakeopenai.Turn{toolTurn, {NoChoices: true}}, "no final response from OpenAI"},
+		{"follow-up nil content", []fakeopenai.Turn{toolTurn, {NilContent: true}}, "no content in final response"},
+		{"follow-up http error", []fakeopenai.Turn{toolTurn, {Status: http.StatusServiceUnavailable, RawBody: "unavailable"}}, "HTTP 503"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm, _ := newConversation(t, newFakeExecutor(), tt.turns...)
+			_, err := cm.ProcessMessage(context.Background(), "question")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestConversation_ContinuesAcrossMessages(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "call_1", Name: "list_connections"}}},
+		fakeopenai.Turn{Content: "first answer"},
+		fakeopenai.Turn{Content: "second answer"},
+	)
+
+	if _, err := cm.ProcessMessage(context.Background(), "first"); err != nil {
+		t.Fatalf("first message failed: %v", err)
+	}
+	answer, err := cm.ProcessMessage(context.Background(), "second")
+	if err != nil {
+		t.Fatalf("second message failed: %v", err)
+	}
+	if answer != "second answer" {
+		t.Errorf("unexpected answer %q", answer)
+	}
+
+	last := fake.Requests()[2].Messages
+	if len(last) != 5 || last[4].Role != "user" || last[4].Text() != "second" {
+		t.Errorf("expected follow-up to carry the earlier turns, got %+v", last)
+	}
+}
+
+func assertToolResult(t *testing.T, message fakeopenai.Message, callID, content string) {
+	t.Helper()
+	if message.Role != "tool" {
+		t.Errorf("expected tool message, got role %q", message.Role)
+	}
+	if message.ToolCallID != callID {
+		t.Errorf("expected tool_call_id %q, got %q", callID, message.ToolCallID)
+	}
+	if !strings.Contains(message.Text(), content) {
+		t.Errorf("expected tool result for %s to contain %q, got %q", callID, content, message.Text())
+	}
+}
+
+func TestFunctionCallManager_ConvertsSchemas(t *testing.T) {
+	fm := openai.NewFunctionCallManager(newFakeExecutor(), false)
+
+	functions := make(map[string]openai.FunctionDefinition)
+	for _, fn := range fm.GetFunctions() {
+		functions[fn.Name] = fn
+	}
+	if len(functions) != 2 {
+		t.Fatalf("expected 2 functions, got %d", len(functions))
+	}
+
+	data, err := json.Marshal(functions["list_connections"].Parameters)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"max_events":{"default":100,"type":"integer"}`) {
+		t.Errorf("expected decoded default, got %s", data)
+	}
+
+	data, _ = json.Marshal(functions["list_packet_drops"].Parameters)
+	if !strings.Contains(string(data), `"enum":["all","external"]`) || !strings.Contains(string(data), `"required":["scope"]`) {
+		t.Errorf("expected enum and required fields, got %s", data)
+	}
+}
+
+func TestFunctionCallManager_ExecuteFunction(t *testing.T) {
+	executor := newFakeExecutor()
+	fm := openai.NewFunctionCallManager(executor, false)
+	ctx := context.Background()
+
+	result, err := fm.ExecuteFunction(ctx, openai.ToolCall{ID: "call_1", Function: openai.FunctionDetails{Name: "list_connections", Arguments: `{"pid": 1}`}})
+	if err != nil {
+		t.Fatalf("ExecuteFunction failed: %v", err)
+	}
+	if result.ToolCallID != "call_1" || result.Role != "tool" || result.Content != "3 connections from curl\n" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if _, err := fm.ExecuteFunction(ctx, openai.ToolCall{Function: openai.FunctionDetails{Name: "missing", Arguments: `{}`}}); err == nil {
+		t.Error("expected error for unknown function")
+	}
+	if _, err := fm.ExecuteFunction(ctx, openai.ToolCall{Function: openai.FunctionDetails{Name: "list_connections", Arguments: `[`}}); err == nil {
+		t.Error("expected error for invalid arguments")
+	}
+}
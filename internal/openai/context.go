@@ -0,0 +1,274 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ContextOptions keep a conversation within the model's context window. Zero values are unlimited.
+type ContextOptions struct {
+	MaxToolResultTokens int // Tool outputs above this estimate are cut to their head, tail and statistics
+	MaxContextTokens    int // Older turns are summarized when the history estimate exceeds this
+}
+
+// DefaultContextOptions returns options that fit the smaller context windows of local models
+func DefaultContextOptions() ContextOptions {
+	return ContextOptions{
+		MaxToolResultTokens: 4000,
+		MaxContextTokens:    32000,
+	}
+}
+
+const (
+	// bytesPerToken approximates tokenizers for English text and telemetry output
+	bytesPerToken = 4
+	// messageOverheadTokens covers the role and framing of each message
+	messageOverheadTokens = 4
+	// summaryPrefix marks the system message that replaces summarized turns
+	summaryPrefix = "Summary of the earlier conversation:\n"
+	// summaryInputTokens bounds each old message in the summarization request
+	summaryInputTokens = 500
+)
+
+// EstimateTokens approximates the number of tokens in text without a model-specific tokenizer
+func EstimateTokens(text string) int {
+	return (len(text) + bytesPerToken - 1) / bytesPerToken
+}
+
+// EstimatedTokens approximates the tokens a message occupies in a request
+func (m ChatMessage) EstimatedTokens() int {
+	tokens := messageOverheadTokens
+	if m.Content != nil {
+		tokens += EstimateTokens(*m.Content)
+	}
+	for _, call := range m.ToolCalls {
+		tokens += EstimateTokens(call.ID) + EstimateTokens(call.Function.Name) + EstimateTokens(call.Function.Arguments)
+	}
+	return tokens
+}
+
+// EstimateMessagesTokens approximates the tokens of a message history
+func EstimateMessagesTokens(messages []ChatMessage) int {
+	total := 0
+	for _, message := range messages {
+		total += message.EstimatedTokens()
+	}
+	return total
+}
+
+// TruncateToolOutput shortens content to about maxTokens, keeping the first and last lines and
+// replacing the rest with statistics about what was left out. Content within the limit, or any
+// content when maxTokens is not positive, is returned unchanged.
+func TruncateToolOutput(content string, maxTokens int) string {
+	if maxTokens <= 0 || EstimateTokens(content) <= maxTokens {
+		return content
+	}
+	budget := maxTokens * bytesPerToken
+	headBudget, tailBudget := budget*6/10, budget*3/10
+
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if len(lines) < 3 {
+		// A single long line, e.g. compact JSON, is cut by bytes
+		return fmt.Sprintf("%s\n... [truncated: %d of %d bytes omitted to fit the context window] ...\n%s",
+			content[:headBudget], len(content)-headBudget-tailBudget, len(content), content[len(content)-tailBudget:])
+	}
+
+	head, size := 0, 0
+	for head < len(lines)-1 && (head == 0 || size+len(lines[head])+1 <= headBudget) {
+		size += len(lines[head]) + 1
+		head++
+	}
+	tail, size := len(lines), 0
+	for tail-1 > head && (tail == len(lines) || size+len(lines[tail-1])+1 <= tailBudget) {
+		size += len(lines[tail-1]) + 1
+		tail--
+	}
+
+	var b strings.Builder
+	for _, line := range lines[:head] {
+		b.WriteString(truncateLine(line, headBudget))
+		b.WriteByte('\n')
+	}
+	omitted := lines[head:tail]
+	omittedBytes := 0
+	for _, line := range omitted {
+		omittedBytes += len(line) + 1
+	}
+	fmt.Fprintf(&b, "... [truncated: %d of %d lines (%d bytes) omitted to fit the context window] ...\n",
+		len(omitted), len(lines), omittedBytes)
+	if stats := columnStatistics(omitted); stats != "" {
+		b.WriteString(stats)
+	}
+	for _, line := range lines[tail:] {
+		b.WriteString(truncateLine(line, tailBudget))
+		b.WriteByte('\n')
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func truncateLine(line string, max int) string {
+	if len(line) <= max {
+		return line
+	}
+	return line[:max] + "…"
+}
+
+// maxColumnValues bounds the most frequent values listed per column of omitted lines
+const maxColumnValues = 5
+
+// columnStatistics summarizes omitted " | " separated rows, such as connection and drop events,
+// by the most frequent values of each column. Columns where most values are unique, like
+// timestamps, are left out.
+func columnStatistics(lines []string) string {
+	var rows [][]string
+	for _, line := range lines {
+		if fields := strings.Split(strings.TrimSpace(line), " | "); len(fields) > 1 {
+			rows = append(rows, fields)
+		}
+	}
+	if len(rows) < 2 {
+		return ""
+	}
+
+	var b strings.Builder
+	for column := 0; ; column++ {
+		counts := make(map[string]int)
+		present := 0
+		for _, row := range rows {
+			if column < len(row) {
+				counts[strings.TrimSpace(row[column])]++
+				present++
+			}
+		}
+		if present == 0 {
+			break
+		}
+		if len(counts) > present/2 {
+			continue
+		}
+
+		values := make([]string, 0, len(counts))
+		for value := range counts {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if counts[values[i]] != counts[values[j]] {
+				return counts[values[i]] > counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		if len(values) > maxColumnValues {
+			values = values[:maxColumnValues]
+		}
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = fmt.Sprintf("%s ×%d", value, counts[value])
+		}
+		if len(counts) > len(values) {
+			parts = append(parts, fmt.Sprintf("%d other values", len(counts)-len(values)))
+		}
+		fmt.Fprintf(&b, "    column %d of omitted rows: %s\n", column+1, strings.Join(parts, ", "))
+	}
+	return b.String()
+}
+
+// compactHistory replaces the oldest turns with a summary when the history exceeds
+// MaxContextTokens. System prompts and the current turn are kept; older turns are dropped until
+// the history fits in half the limit, so compaction does not repeat on every message.
+func (cm *ConversationManager) compactHistory(ctx context.Context, run *RunResult) {
+	limit := cm.context.MaxContextTokens
+	if limit <= 0 || EstimateMessagesTokens(cm.messages) <= limit {
+		return
+	}
+
+	// Leading system prompts are kept; an earlier summary is folded into the new one
+	prefix := 0
+	for prefix < len(cm.messages) && cm.messages[prefix].Role == "system" && !cm.messages[prefix].IsSummary() {
+		prefix++
+	}
+
+	// Turns start at user messages; the last one is the message being answered
+	var turns []int
+	for i := prefix; i < len(cm.messages); i++ {
+		if cm.messages[i].Role == "user" {
+			turns = append(turns, i)
+		}
+	}
+	if len(turns) == 0 {
+		return
+	}
+	target := limit/2 - EstimateMessagesTokens(cm.messages[:prefix])
+	cut := turns[len(turns)-1]
+	for _, start := range turns {
+		if EstimateMessagesTokens(cm.messages[start:]) <= target {
+			cut = start
+			break
+		}
+	}
+	if cut <= prefix || (cut == prefix+1 && cm.messages[prefix].IsSummary()) {
+		return
+	}
+
+	old := cm.messages[prefix:cut]
+	summary := cm.summarize(ctx, old, run)
+	messages := make([]ChatMessage, 0, prefix+1+len(cm.messages)-cut)
+	messages = append(messages, cm.messages[:prefix]...)
+	content := summaryPrefix + summary
+	messages = append(messages, ChatMessage{Role: "system", Content: &content})
+	messages = append(messages, cm.messages[cut:]...)
+	cm.messages = messages
+	run.SummarizedMessages += len(old)
+}
+
+// IsSummary reports whether the message is a summary that replaced older turns
+func (m ChatMessage) IsSummary() bool {
+	return m.Role == "system" && m.Content != nil && strings.HasPrefix(*m.Content, summaryPrefix)
+}
+
+// summarize asks the model to summarize old turns, falling back to an excerpt of the user
+// questions and answers when the request fails
+func (cm *ConversationManager) summarize(ctx context.Context, old []ChatMessage, run *RunResult) string {
+	var transcript strings.Builder
+	for _, message := range old {
+		text := ""
+		if message.Content != nil {
+			text = TruncateToolOutput(*message.Content, summaryInputTokens)
+		}
+		if message.IsSummary() {
+			text = strings.TrimPrefix(text, summaryPrefix)
+		}
+		for _, call := range message.ToolCalls {
+			text += fmt.Sprintf("\n[called %s(%s)]", call.Function.Name, call.Function.Arguments)
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", message.Role, strings.TrimSpace(text))
+	}
+
+	system := "You summarize network troubleshooting conversations. Keep the questions asked, the findings with their processes, destinations, counts and drop reasons, and any open issues. Be concise."
+	prompt := "Summarize this conversation so it can replace the original messages:\n\n" + transcript.String()
+	req := ChatRequest{
+		Model: cm.model,
+		Messages: []ChatMessage{
+			{Role: "system", Content: &system},
+			{Role: "user", Content: &prompt},
+		},
+	}
+	response, err := cm.recordRequest(ctx, req, summaryRequest, run)
+	if err == nil && response.Usage != nil {
+		run.Usage.add(*response.Usage)
+	}
+	if err == nil && len(response.Choices) > 0 && response.Choices[0].Message.Content != nil {
+		return *response.Choices[0].Message.Content
+	}
+
+	var b strings.Builder
+	for _, message := range old {
+		if message.Content == nil || message.Role == "tool" {
+			continue
+		}
+		text := strings.TrimPrefix(*message.Content, summaryPrefix)
+		fmt.Fprintf(&b, "- %s: %s\n", message.Role, truncateLine(strings.Join(strings.Fields(text), " "), 300))
+	}
+	return b.String()
+}
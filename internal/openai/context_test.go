@@ -0,0 +1,185 @@
+package openai_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// connectionLines returns n rows formatted like list_connections output
+func connectionLines(n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Recent connection events (%d total):\n", n)
+	for i := 0; i < n; i++ {
+		protocol := "TCP"
+		if i%4 == 0 {
+			protocol = "UDP"
+		}
+		fmt.Fprintf(&b, "  12:00:%02d | curl (PID %d) | 10.0.0.%d:443 | %s\n", i%60, 1000+i, i%250, protocol)
+	}
+	return b.String()
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if got := openai.EstimateTokens(""); got != 0 {
+		t.Errorf("expected 0 tokens for empty text, got %d", got)
+	}
+	if got := openai.EstimateTokens(strings.Repeat("a", 400)); got != 100 {
+		t.Errorf("expected 100 tokens, got %d", got)
+	}
+	content := strings.Repeat("x", 40)
+	message := openai.ChatMessage{Role: "user", Content: &content}
+	if got := message.EstimatedTokens(); got != 14 {
+		t.Errorf("expected 10 content tokens plus overhead, got %d", got)
+	}
+}
+
+func TestTruncateToolOutput(t *testing.T) {
+	output := connectionLines(2000)
+	if got := openai.TruncateToolOutput(output, -1); got != output {
+		t.Error("expected output to be kept whole without a limit")
+	}
+	if got := openai.TruncateToolOutput("short", 100); got != "short" {
+		t.Errorf("expected short output unchanged, got %q", got)
+	}
+
+	got := openai.TruncateToolOutput(output, 1000)
+	if tokens := openai.EstimateTokens(got); tokens > 1100 {
+		t.Errorf("expected about 1000 tokens, got %d", tokens)
+	}
+	if !strings.HasPrefix(got, "Recent connection events (2000 total):\n  12:00:00 | curl (PID 1000)") {
+		t.Errorf("expected the head to be kept, got %q", got[:80])
+	}
+	if !strings.HasSuffix(got, "curl (PID 2999) | 10.0.0.249:443 | TCP") {
+		t.Errorf("expected the tail to be kept, got %q", got[len(got)-80:])
+	}
+	for _, want := range []string{
+		"lines (",
+		"omitted to fit the context window",
+		"column 4 of omitted rows: TCP ×",
+		"UDP ×",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in truncated output", want)
+		}
+	}
+	// Columns that are mostly unique, such as PIDs, are not summarized
+	if strings.Contains(got, "column 2 of omitted rows") {
+		t.Error("expected no statistics for the unique PID column")
+	}
+
+	long := strings.Repeat("{\"k\":1}", 1000)
+	if got := openai.TruncateToolOutput(long, 100); !strings.Contains(got, "bytes omitted") || len(got) > 500 {
+		t.Errorf("expected a single long line to be cut by bytes, got %d bytes", len(got))
+	}
+}
+
+func TestRun_TruncatesLargeToolOutput(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.outputs["list_connections"] = connectionLines(2000)
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "big", Name: "list_connections", Arguments: `{"max_events": 2000}`}}},
+		fakeopenai.Turn{Content: "lots of curl"},
+	)
+	cm.SetContextOptions(openai.ContextOptions{MaxToolResultTokens: 500})
+
+	run, err := cm.Run(context.Background(), "what is curl doing?")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	messages := fake.Requests()[1].Messages
+	result := messages[len(messages)-1].Text()
+	if openai.EstimateTokens(result) > 550 || !strings.Contains(result, "omitted to fit the context window") {
+		t.Errorf("expected a truncated tool result, got %d tokens", openai.EstimateTokens(result))
+	}
+	exec := run.ToolExecutions[0]
+	if !exec.Truncated || exec.ResultBytes <= len(result) {
+		t.Errorf("expected the trace to record the full size and truncation, got %+v", exec)
+	}
+}
+
+func TestRun_SummarizesOlderTurns(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.outputs["list_connections"] = connectionLines(300)
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}},
+		fakeopenai.Turn{Content: "curl talks to 10.0.0.0/24 over TCP"},
+		fakeopenai.Turn{Content: "curl checked 10.0.0.0/24; no drops"},
+		fakeopenai.Turn{Content: "nothing new"},
+	)
+	cm.SetContextOptions(openai.ContextOptions{MaxContextTokens: 2000})
+	cm.AddSystemMessage("You are a network analyst.")
+
+	if _, err := cm.Run(context.Background(), "what is curl doing?"); err != nil {
+		t.Fatalf("first Run failed: %v", err)
+	}
+	run, err := cm.Run(context.Background(), "anything new?")
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if run.Content != "nothing new" || run.SummarizedMessages != 4 {
+		t.Errorf("expected 4 summarized messages, got %+v", run)
+	}
+	if len(run.LLMRequests) != 2 || !run.LLMRequests[0].Summary || run.LLMRequests[1].Summary {
+		t.Errorf("expected a summary request before the answer, got %+v", run.LLMRequests)
+	}
+
+	requests := fake.Requests()
+	summaryPrompt := requests[2].Messages[1].Text()
+	if !strings.Contains(summaryPrompt, "what is curl doing?") || !strings.Contains(summaryPrompt, "[called list_connections({})]") {
+		t.Errorf("expected the old turn in the summary request, got %q", summaryPrompt)
+	}
+	if len(requests[2].Tools) != 0 {
+		t.Error("expected no tools in the summary request")
+	}
+
+	messages := requests[3].Messages
+	if len(messages) != 3 {
+		t.Fatalf("expected system prompt, summary and question, got %d messages", len(messages))
+	}
+	if messages[0].Text() != "You are a network analyst." {
+		t.Errorf("expected the system prompt to be kept, got %q", messages[0].Text())
+	}
+	if messages[1].Role != "system" || !strings.HasSuffix(messages[1].Text(), "curl checked 10.0.0.0/24; no drops") {
+		t.Errorf("expected the summary as the second message, got %+v", messages[1])
+	}
+	if messages[2].Text() != "anything new?" {
+		t.Errorf("expected the current question last, got %q", messages[2].Text())
+	}
+	if len(cm.GetConversationHistory()) != 4 {
+		t.Errorf("expected the compacted history to be kept, got %d messages", len(cm.GetConversationHistory()))
+	}
+}
+
+func TestRun_SummaryFallsBackToExcerpt(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.outputs["list_connections"] = connectionLines(300)
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}},
+		fakeopenai.Turn{Content: "curl talks to 10.0.0.0/24"},
+		fakeopenai.Turn{Error: "summaries unavailable"},
+		fakeopenai.Turn{Content: "still fine"},
+	)
+	cm.SetContextOptions(openai.ContextOptions{MaxContextTokens: 2000})
+
+	if _, err := cm.Run(context.Background(), "what is curl doing?"); err != nil {
+		t.Fatalf("first Run failed: %v", err)
+	}
+	run, err := cm.Run(context.Background(), "anything new?")
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if run.Content != "still fine" || run.LLMRequests[0].Error == "" {
+		t.Errorf("expected the answer despite a failed summary, got %+v", run)
+	}
+	summary := fake.Requests()[3].Messages[0].Text()
+	for _, want := range []string{"- user: what is curl doing?", "- assistant: curl talks to 10.0.0.0/24"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected %q in the fallback summary, got %q", want, summary)
+		}
+	}
+}
@@ -3,7 +3,11 @@ package openai
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/jsonschema"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
@@ -31,9 +35,25 @@ type FunctionDetails struct {
 
 // ToolCallResult represents the result of a tool execution
 type ToolCallResult struct {
-	ToolCallID string `json:"tool_call_id"`
-	Role       string `json:"role"`
-	Content    string `json:"content"`
+	ToolCallID string        `json:"tool_call_id"`
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Latency    time.Duration `json:"-"` // Time spent executing the tool
+	TimedOut   bool          `json:"-"` // The per-call timeout expired before the tool returned
+}
+
+// ExecutionOptions control how the tool calls of one model turn are executed
+type ExecutionOptions struct {
+	Workers     int           // Tool calls run concurrently (default: 1, one at a time)
+	CallTimeout time.Duration // Time allowed for each tool call (0: no limit)
+}
+
+// DefaultExecutionOptions runs a few calls at once so multi-tool turns take one round trip
+func DefaultExecutionOptions() ExecutionOptions {
+	return ExecutionOptions{
+		Workers:     4,
+		CallTimeout: 30 * time.Second,
+	}
 }
 
 // MCPToolExecutor interface for executing MCP tools
@@ -50,14 +70,24 @@ type MCPToolDiscovery interface {
 type FunctionCallManager struct {
 	mcpExecutor MCPToolExecutor
 	functions   []FunctionDefinition
+	filter      ToolFilter
+	execution   ExecutionOptions
 	verbose     bool
 }
 
-// NewFunctionCallManager creates a new function call manager with automatic tool discovery
+// NewFunctionCallManager creates a new function call manager with automatic tool discovery,
+// excluding the default denied tools
 func NewFunctionCallManager(mcpExecutor MCPToolExecutor, verbose bool) *FunctionCallManager {
+	return NewFunctionCallManagerWithFilter(mcpExecutor, ToolFilter{}, verbose)
+}
+
+// NewFunctionCallManagerWithFilter creates a function call manager exposing only the tools the filter allows
+func NewFunctionCallManagerWithFilter(mcpExecutor MCPToolExecutor, filter ToolFilter, verbose bool) *FunctionCallManager {
 	fm := &FunctionCallManager{
 		mcpExecutor: mcpExecutor,
 		functions:   make([]FunctionDefinition, 0),
+		filter:      filter,
+		execution:   DefaultExecutionOptions(),
 		verbose:     verbose,
 	}
 
@@ -76,7 +106,22 @@ func NewFunctionCallManager(mcpExecutor MCPToolExecutor, verbose bool) *Function
 func (fm *FunctionCallManager) discoverMCPTools(discovery MCPToolDiscovery) {
 	tools := discovery.GetRegisteredTools()
 
-	for toolName, tool := range tools {
+	// Register in name order so requests are stable across runs
+	names := make([]string, 0, len(tools))
+	for toolName := range tools {
+		names = append(names, toolName)
+	}
+	sort.Strings(names)
+
+	for _, toolName := range names {
+		tool := tools[toolName]
+		if !fm.filter.Allows(toolName) {
+			if fm.verbose {
+				fmt.Printf("  - %s: excluded from function calling\n", toolName)
+			}
+			continue
+		}
+
 		// Convert MCP tool to OpenAI function definition
 		functionDef := fm.convertMCPToolToFunction(toolName, tool)
 		fm.functions = append(fm.functions, functionDef)
@@ -136,6 +181,10 @@ func (fm *FunctionCallManager) convertPropertySchema(schema *jsonschema.Schema)
 		property["description"] = schema.Description
 	}
 
+	if len(schema.Enum) > 0 {
+		property["enum"] = schema.Enum
+	}
+
 	if schema.Default != nil {
 		// Parse the default value if it's JSON bytes
 		var defaultValue interface{}
@@ -154,11 +203,25 @@ func (fm *FunctionCallManager) registerKnownMCPTools() {
 	fmt.Println("Warning: Using fallback tool registration. MCP tool auto-discovery not available.")
 }
 
+// SetToolFilter replaces the tool filter and rediscovers the exposed functions
+func (fm *FunctionCallManager) SetToolFilter(filter ToolFilter) {
+	fm.filter = filter
+	fm.functions = make([]FunctionDefinition, 0)
+	if toolDiscovery, ok := fm.mcpExecutor.(MCPToolDiscovery); ok {
+		fm.discoverMCPTools(toolDiscovery)
+	}
+}
+
 // GetFunctions returns all registered function definitions
 func (fm *FunctionCallManager) GetFunctions() []FunctionDefinition {
 	return fm.functions
 }
 
+// SetExecutionOptions sets the worker limit and per-call timeout for tool execution
+func (fm *FunctionCallManager) SetExecutionOptions(opts ExecutionOptions) {
+	fm.execution = opts
+}
+
 // ExecuteFunction executes a function call and returns the result
 func (fm *FunctionCallManager) ExecuteFunction(ctx context.Context, functionCall ToolCall) (*ToolCallResult, error) {
 	// Parse function arguments
@@ -180,12 +243,21 @@ func (fm *FunctionCallManager) ExecuteFunction(ctx context.Context, functionCall
 	}
 
 	// Execute the MCP tool
-	result, err := fm.mcpExecutor.RunSingleCommand(ctx, functionCall.Function.Name, arguments)
+	started := time.Now()
+	result, err := fm.runTool(ctx, functionCall.Function.Name, arguments)
+	latency := time.Since(started)
 	if err != nil {
+		timedOut := fm.execution.CallTimeout > 0 && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+		content := fmt.Sprintf("Error executing %s: %v", functionCall.Function.Name, err)
+		if timedOut {
+			content = fmt.Sprintf("Error executing %s: timed out after %s", functionCall.Function.Name, fm.execution.CallTimeout)
+		}
 		return &ToolCallResult{
 			ToolCallID: functionCall.ID,
 			Role:       "tool",
-			Content:    fmt.Sprintf("Error executing %s: %v", functionCall.Function.Name, err),
+			Content:    content,
+			Latency:    latency,
+			TimedOut:   timedOut,
 		}, nil
 	}
 
@@ -201,26 +273,84 @@ func (fm *FunctionCallManager) ExecuteFunction(ctx context.Context, functionCall
 		ToolCallID: functionCall.ID,
 		Role:       "tool",
 		Content:    content,
+		Latency:    latency,
 	}, nil
 }
 
-// ExecuteFunctions executes multiple function calls and returns their results
-func (fm *FunctionCallManager) ExecuteFunctions(ctx context.Context, functionCalls []ToolCall) ([]ToolCallResult, error) {
-	results := make([]ToolCallResult, 0, len(functionCalls))
-
-	for _, call := range functionCalls {
-		result, err := fm.ExecuteFunction(ctx, call)
-		if err != nil {
-			// Return error result for this function call
-			results = append(results, ToolCallResult{
-				ToolCallID: call.ID,
-				Role:       "tool",
-				Content:    fmt.Sprintf("Function execution failed: %v", err),
-			})
-		} else {
-			results = append(results, *result)
+// runTool runs an MCP tool within the per-call timeout. A tool that ignores cancellation is
+// abandoned when the timeout expires rather than holding up the conversation.
+func (fm *FunctionCallManager) runTool(ctx context.Context, name string, arguments map[string]any) (*mcp.CallToolResult, error) {
+	if fm.execution.CallTimeout <= 0 {
+		return fm.mcpExecutor.RunSingleCommand(ctx, name, arguments)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, fm.execution.CallTimeout)
+	defer cancel()
+
+	type outcome struct {
+		result *mcp.CallToolResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := fm.mcpExecutor.RunSingleCommand(callCtx, name, arguments)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case out := <-done:
+		if out.err == nil && out.result == nil {
+			return nil, fmt.Errorf("no result")
 		}
+		return out.result, out.err
+	case <-callCtx.Done():
+		return nil, callCtx.Err()
+	}
+}
+
+// ExecuteFunctions executes multiple function calls concurrently, up to the worker limit, and
+// returns their results in the order of the calls
+func (fm *FunctionCallManager) ExecuteFunctions(ctx context.Context, functionCalls []ToolCall) ([]ToolCallResult, error) {
+	results := make([]ToolCallResult, len(functionCalls))
+
+	workers := fm.execution.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(functionCalls) {
+		workers = len(functionCalls)
 	}
 
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = fm.executeOne(ctx, functionCalls[i])
+			}
+		}()
+	}
+	for i := range functionCalls {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
 	return results, nil
 }
+
+// executeOne executes a function call, turning failures into an error result for the model
+func (fm *FunctionCallManager) executeOne(ctx context.Context, call ToolCall) ToolCallResult {
+	result, err := fm.ExecuteFunction(ctx, call)
+	if err != nil {
+		// Return error result for this function call
+		return ToolCallResult{
+			ToolCallID: call.ID,
+			Role:       "tool",
+			Content:    fmt.Sprintf("Function execution failed: %v", err),
+		}
+	}
+	return *result
+}
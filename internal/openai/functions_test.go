@@ -0,0 +1,131 @@
+package openai_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// newSlowExecutor registers tools slow_0 ... slow_n-1, where earlier tools take longer
+func newSlowExecutor(n int, step time.Duration) *fakeExecutor {
+	executor := newFakeExecutor()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("slow_%d", i)
+		executor.tools[name] = &mcp.Tool{Name: name}
+		executor.outputs[name] = "result " + name
+		executor.delays[name] = time.Duration(n-i) * step
+	}
+	return executor
+}
+
+func slowCalls(n int) []openai.ToolCall {
+	calls := make([]openai.ToolCall, n)
+	for i := range calls {
+		calls[i] = openai.ToolCall{ID: fmt.Sprintf("call_%d", i), Function: openai.FunctionDetails{Name: fmt.Sprintf("slow_%d", i), Arguments: "{}"}}
+	}
+	return calls
+}
+
+func TestExecuteFunctions_ConcurrentStableOrder(t *testing.T) {
+	executor := newSlowExecutor(6, 10*time.Millisecond)
+	fm := openai.NewFunctionCallManager(executor, false)
+	fm.SetExecutionOptions(openai.ExecutionOptions{Workers: 3})
+
+	started := time.Now()
+	results, err := fm.ExecuteFunctions(context.Background(), slowCalls(6))
+	if err != nil {
+		t.Fatalf("ExecuteFunctions failed: %v", err)
+	}
+	elapsed := time.Since(started)
+
+	for i, result := range results {
+		if result.ToolCallID != fmt.Sprintf("call_%d", i) || !strings.Contains(result.Content, fmt.Sprintf("result slow_%d", i)) {
+			t.Errorf("result %d out of order: %+v", i, result)
+		}
+		if want := time.Duration(6-i) * 10 * time.Millisecond; result.Latency < want {
+			t.Errorf("result %d: latency %s shorter than tool delay %s", i, result.Latency, want)
+		}
+	}
+	if executor.maxInFlight != 3 {
+		t.Errorf("expected 3 calls in flight at most, got %d", executor.maxInFlight)
+	}
+	// Sequential execution would take 210ms
+	if elapsed >= 200*time.Millisecond {
+		t.Errorf("expected concurrent execution, took %s", elapsed)
+	}
+}
+
+func TestExecuteFunctions_SingleWorkerIsSequential(t *testing.T) {
+	executor := newSlowExecutor(3, time.Millisecond)
+	fm := openai.NewFunctionCallManager(executor, false)
+	fm.SetExecutionOptions(openai.ExecutionOptions{Workers: 1})
+
+	if _, err := fm.ExecuteFunctions(context.Background(), slowCalls(3)); err != nil {
+		t.Fatalf("ExecuteFunctions failed: %v", err)
+	}
+	if executor.maxInFlight != 1 {
+		t.Errorf("expected one call at a time, got %d", executor.maxInFlight)
+	}
+	for i, call := range executor.calls {
+		if call.Tool != fmt.Sprintf("slow_%d", i) {
+			t.Errorf("expected calls in request order, got %v", executor.calls)
+			break
+		}
+	}
+}
+
+func TestExecuteFunctions_PerCallTimeout(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.delays["list_packet_drops"] = 500 * time.Millisecond
+	fm := openai.NewFunctionCallManager(executor, false)
+	fm.SetExecutionOptions(openai.ExecutionOptions{Workers: 2, CallTimeout: 20 * time.Millisecond})
+
+	started := time.Now()
+	results, err := fm.ExecuteFunctions(context.Background(), []openai.ToolCall{
+		{ID: "fast", Function: openai.FunctionDetails{Name: "list_connections", Arguments: "{}"}},
+		{ID: "stuck", Function: openai.FunctionDetails{Name: "list_packet_drops", Arguments: "{}"}},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteFunctions failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed >= 400*time.Millisecond {
+		t.Errorf("expected the stuck call to be abandoned, took %s", elapsed)
+	}
+	if results[0].TimedOut || !strings.Contains(results[0].Content, "3 connections") {
+		t.Errorf("unexpected fast result %+v", results[0])
+	}
+	if !results[1].TimedOut || results[1].Content != "Error executing list_packet_drops: timed out after 20ms" {
+		t.Errorf("unexpected stuck result %+v", results[1])
+	}
+}
+
+func TestRun_RecordsToolLatency(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.delays["list_connections"] = 15 * time.Millisecond
+	cm, _ := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "a", Name: "list_connections"}, {ID: "b", Name: "list_packet_drops"}}},
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "c", Name: "list_packet_drops"}}},
+		fakeopenai.Turn{Content: "done"},
+	)
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(run.ToolExecutions) != 3 {
+		t.Fatalf("expected 3 tool executions, got %+v", run.ToolExecutions)
+	}
+	first := run.ToolExecutions[0]
+	if first.ID != "a" || first.Name != "list_connections" || first.Round != 1 || first.LatencyMS < 15 {
+		t.Errorf("unexpected first execution %+v", first)
+	}
+	if last := run.ToolExecutions[2]; last.ID != "c" || last.Round != 2 {
+		t.Errorf("unexpected last execution %+v", last)
+	}
+}
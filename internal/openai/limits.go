@@ -0,0 +1,131 @@
+package openai
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits bound the function calling loop of a single message. Zero values are unlimited.
+type Limits struct {
+	MaxToolRounds int           // Model responses that request tools
+	MaxToolCalls  int           // Tool executions across all rounds
+	Timeout       time.Duration // Wall-clock time before the final answer is forced
+	TokenBudget   int           // Total tokens reported by the provider across all requests
+}
+
+// DefaultLimits returns limits that leave room for a full analysis but stop a looping model
+func DefaultLimits() Limits {
+	return Limits{
+		MaxToolRounds: 8,
+		MaxToolCalls:  24,
+		Timeout:       2 * time.Minute,
+		TokenBudget:   150000,
+	}
+}
+
+// finalRequestGrace bounds the forced final request, which runs after Limits.Timeout elapsed
+const finalRequestGrace = 30 * time.Second
+
+// StopReason records why the function calling loop ended
+type StopReason string
+
+const (
+	StopComplete      StopReason = "complete"        // The model answered without further tool calls
+	StopMaxToolRounds StopReason = "max_tool_rounds" // Limits.MaxToolRounds was reached
+	StopMaxToolCalls  StopReason = "max_tool_calls"  // Limits.MaxToolCalls was reached
+	StopDeadline      StopReason = "deadline"        // Limits.Timeout elapsed
+	StopTokenBudget   StopReason = "token_budget"    // Limits.TokenBudget was used up
+)
+
+// RunResult describes how a message was answered. Together with the LLM requests and tool
+// executions it forms the trace of the answer; see FormatTrace and SaveTrace.
+type RunResult struct {
+	Query      string     `json:"query"`
+	Provider   string     `json:"provider"`
+	Model      string     `json:"model,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	Content    string     `json:"content"`
+	StopReason StopReason `json:"stop_reason"`
+	ToolRounds int        `json:"tool_rounds"`
+	ToolCalls  int        `json:"tool_calls"`
+	Usage      Usage      `json:"usage"`
+	DurationMS int64      `json:"duration_ms"`
+	Limits     Limits     `json:"-"`
+
+	// LLMRequests lists every chat request sent to the provider in order
+	LLMRequests []LLMRequest `json:"llm_requests,omitempty"`
+	// SummarizedMessages counts older messages replaced by a summary before answering
+	SummarizedMessages int `json:"summarized_messages,omitempty"`
+	// ToolExecutions lists every tool call the model requested, in request order
+	ToolExecutions []ToolExecution `json:"tool_executions,omitempty"`
+	// Report is the structured findings report, when one was requested
+	Report *Report `json:"report,omitempty"`
+}
+
+// LLMRequest records one chat request and the provider's reply. Summary requests condense
+// older turns and report requests extract findings; neither is part of the tool loop.
+type LLMRequest struct {
+	Round           int    `json:"round"`
+	Summary         bool   `json:"summary,omitempty"`
+	Report          bool   `json:"report,omitempty"`
+	Streamed        bool   `json:"streamed,omitempty"`
+	ToolChoice      string `json:"tool_choice,omitempty"`
+	Messages        int    `json:"messages"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+	LatencyMS       int64  `json:"latency_ms"`
+	FinishReason    string `json:"finish_reason,omitempty"`
+	Usage           *Usage `json:"usage,omitempty"`
+	ToolCalls       int    `json:"tool_calls"`
+	Error           string `json:"error,omitempty"`
+	// Invalid lists why a report reply was rejected
+	Invalid string `json:"invalid,omitempty"`
+}
+
+// ToolExecution records one tool call requested by the model. Round is the LLM request that
+// asked for it; skipped calls were stopped by a limit and never ran.
+type ToolExecution struct {
+	Round       int    `json:"round"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Arguments   string `json:"arguments"`
+	LatencyMS   int64  `json:"latency_ms"`
+	ResultBytes int    `json:"result_bytes"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	TimedOut    bool   `json:"timed_out,omitempty"`
+	Skipped     bool   `json:"skipped,omitempty"`
+}
+
+// Limited reports whether a limit forced the final answer
+func (r *RunResult) Limited() bool {
+	return r.StopReason != StopComplete
+}
+
+// Describe explains which limit stopped the loop, or returns an empty string when none did
+func (r *RunResult) Describe() string {
+	switch r.StopReason {
+	case StopMaxToolRounds:
+		return fmt.Sprintf("tool round limit reached (%d rounds)", r.Limits.MaxToolRounds)
+	case StopMaxToolCalls:
+		return fmt.Sprintf("tool call limit reached (%d calls)", r.Limits.MaxToolCalls)
+	case StopDeadline:
+		return fmt.Sprintf("time limit reached (%s)", r.Limits.Timeout)
+	case StopTokenBudget:
+		return fmt.Sprintf("token budget used up (%d of %d tokens)", r.Usage.TotalTokens, r.Limits.TokenBudget)
+	}
+	return ""
+}
+
+// stopReason returns the limit that prevents another tool round, if any
+func (l Limits) stopReason(run *RunResult, started time.Time) StopReason {
+	switch {
+	case l.Timeout > 0 && time.Since(started) >= l.Timeout:
+		return StopDeadline
+	case l.TokenBudget > 0 && run.Usage.TotalTokens >= l.TokenBudget:
+		return StopTokenBudget
+	case l.MaxToolRounds > 0 && run.ToolRounds >= l.MaxToolRounds:
+		return StopMaxToolRounds
+	case l.MaxToolCalls > 0 && run.ToolCalls >= l.MaxToolCalls:
+		return StopMaxToolCalls
+	}
+	return ""
+}
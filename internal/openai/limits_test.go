@@ -0,0 +1,208 @@
+package openai_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// loopingTurns returns n turns that each request one list_connections call
+func loopingTurns(n int) []fakeopenai.Turn {
+	turns := make([]fakeopenai.Turn, n)
+	for i := range turns {
+		turns[i] = fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}, PromptTokens: 100, CompletionTokens: 10}
+	}
+	return turns
+}
+
+func TestRun_CompletesWithinLimits(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(), append(loopingTurns(2), fakeopenai.Turn{Content: "done", PromptTokens: 50, CompletionTokens: 5})...)
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if run.Content != "done" || run.StopReason != openai.StopComplete || run.Limited() {
+		t.Errorf("expected complete run, got %+v", run)
+	}
+	if run.ToolRounds != 2 || run.ToolCalls != 2 {
+		t.Errorf("expected 2 rounds and 2 calls, got %d and %d", run.ToolRounds, run.ToolCalls)
+	}
+	if run.Usage.TotalTokens != 275 {
+		t.Errorf("expected 275 tokens, got %d", run.Usage.TotalTokens)
+	}
+	if cm.LastRun() != run {
+		t.Error("expected LastRun to return the run")
+	}
+}
+
+func TestRun_MaxToolRounds(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor, append(loopingTurns(3), fakeopenai.Turn{Content: "forced answer"})...)
+	cm.SetLimits(openai.Limits{MaxToolRounds: 2})
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if run.StopReason != openai.StopMaxToolRounds || run.Content != "forced answer" {
+		t.Errorf("expected forced answer after round limit, got %+v", run)
+	}
+	if len(executor.calls) != 2 {
+		t.Errorf("expected 2 executed calls, got %d", len(executor.calls))
+	}
+	if !strings.Contains(run.Describe(), "2 rounds") {
+		t.Errorf("unexpected description %q", run.Describe())
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(requests))
+	}
+	for i, req := range requests[:3] {
+		if string(req.ToolChoice) != `"auto"` {
+			t.Errorf("request %d: expected tool_choice auto, got %s", i, req.ToolChoice)
+		}
+	}
+	final := requests[3]
+	if string(final.ToolChoice) != `"none"` {
+		t.Errorf("expected final tool_choice none, got %s", final.ToolChoice)
+	}
+	// The unexecuted call from the third round is answered so the history stays valid
+	last := final.Messages[len(final.Messages)-1]
+	if last.Role != "tool" || !strings.Contains(last.Text(), "Not executed: tool round limit reached") {
+		t.Errorf("expected skipped tool result, got %+v", last)
+	}
+}
+
+func TestRun_MaxToolCallsExecutesRemainder(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{
+			{ID: "a", Name: "list_connections"}, {ID: "b", Name: "list_packet_drops"}, {ID: "c", Name: "list_connections"},
+		}},
+		fakeopenai.Turn{Content: "partial answer"},
+	)
+	cm.SetLimits(openai.Limits{MaxToolCalls: 2})
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if run.StopReason != openai.StopMaxToolCalls || run.ToolCalls != 2 || len(executor.calls) != 2 {
+		t.Errorf("expected 2 calls then a forced answer, got %+v with %d executions", run, len(executor.calls))
+	}
+
+	final := fake.Requests()[1]
+	if string(final.ToolChoice) != `"none"` {
+		t.Errorf("expected tool_choice none, got %s", final.ToolChoice)
+	}
+	messages := final.Messages
+	assertToolResult(t, messages[len(messages)-3], "a", "3 connections")
+	assertToolResult(t, messages[len(messages)-2], "b", "TCP_RESET")
+	assertToolResult(t, messages[len(messages)-1], "c", "Not executed: tool call limit reached (2 calls)")
+}
+
+func TestRun_TokenBudget(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, _ := newConversation(t, executor, append(loopingTurns(2), fakeopenai.Turn{Content: "over budget"})...)
+	cm.SetLimits(openai.Limits{TokenBudget: 200})
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// The first round (110 tokens) runs; the second pushes usage to 220 and is not executed
+	if run.StopReason != openai.StopTokenBudget || len(executor.calls) != 1 {
+		t.Errorf("expected token budget stop after one execution, got %+v with %d executions", run, len(executor.calls))
+	}
+	if !strings.Contains(run.Describe(), "220 of 200 tokens") {
+		t.Errorf("unexpected description %q", run.Describe())
+	}
+}
+
+func TestRun_Deadline(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor, fakeopenai.Turn{Content: "out of time"})
+	cm.SetLimits(openai.Limits{Timeout: time.Nanosecond})
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if run.StopReason != openai.StopDeadline || run.Content != "out of time" || len(executor.calls) != 0 {
+		t.Errorf("expected deadline stop without executions, got %+v with %d executions", run, len(executor.calls))
+	}
+	// The request sent after the deadline is cut off and only the forced one reaches the model
+	requests := fake.Requests()
+	if len(requests) != 1 || string(requests[0].ToolChoice) != `"none"` {
+		t.Errorf("expected only the forced request, got %+v", requests)
+	}
+	if len(run.LLMRequests) != 2 || run.LLMRequests[0].Error == "" {
+		t.Errorf("expected the cut-off request in the trace, got %+v", run.LLMRequests)
+	}
+}
+
+// hangingProvider blocks requests that may call tools until their context ends and answers
+// forced ones
+type hangingProvider struct {
+	deadlines []bool // Whether each request had a deadline
+}
+
+func (p *hangingProvider) Name() string  { return "Hanging" }
+func (p *hangingProvider) Model() string { return "slow" }
+
+func (p *hangingProvider) Chat(ctx context.Context, req openai.ChatRequest) (*openai.ChatResponse, error) {
+	_, ok := ctx.Deadline()
+	p.deadlines = append(p.deadlines, ok)
+	if req.ToolChoice != "none" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	answer := "partial answer"
+	return &openai.ChatResponse{Choices: []openai.ChatChoice{{Message: openai.ChatMessage{Role: "assistant", Content: &answer}}}}, nil
+}
+
+func TestRun_DeadlineCutsOffHangingModel(t *testing.T) {
+	provider := &hangingProvider{}
+	cm := openai.NewConversationManagerWithProvider(newFakeExecutor(), provider, false)
+	cm.SetLimits(openai.Limits{Timeout: 50 * time.Millisecond})
+
+	started := time.Now()
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the deadline to cut off the model request, took %s", elapsed)
+	}
+	if run.StopReason != openai.StopDeadline || run.Content != "partial answer" {
+		t.Errorf("expected a forced answer after the deadline, got %+v", run)
+	}
+	if len(provider.deadlines) != 2 || !provider.deadlines[0] || !provider.deadlines[1] {
+		t.Errorf("expected both requests to be bounded, got %v", provider.deadlines)
+	}
+}
+
+func TestRun_IgnoresToolCallsWhenForced(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{Content: "still calling", ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}},
+	)
+	cm.SetLimits(openai.Limits{Timeout: time.Nanosecond})
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if run.Content != "still calling" {
+		t.Errorf("expected the content of the forced turn, got %q", run.Content)
+	}
+	history := cm.GetConversationHistory()
+	if last := history[len(history)-1]; len(last.ToolCalls) != 0 {
+		t.Errorf("expected tool calls to be dropped from the forced answer, got %v", last.ToolCalls)
+	}
+}
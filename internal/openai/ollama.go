@@ -0,0 +1,210 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// OllamaProvider talks to the Ollama native chat API (/api/chat)
+type OllamaProvider struct {
+	baseURL string
+	model   string
+}
+
+// NewOllamaProvider creates a provider for the Ollama server at baseURL
+func NewOllamaProvider(baseURL, model string) *OllamaProvider {
+	return &OllamaProvider{
+		baseURL: ollamaBaseURL(firstNonEmpty(baseURL, DefaultOllamaBaseURL)),
+		model:   firstNonEmpty(model, DefaultOllamaModel),
+	}
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	// Format is a JSON schema the reply must match
+	Format json.RawMessage `json:"format,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// Name returns the backend name
+func (p *OllamaProvider) Name() string {
+	return "Ollama"
+}
+
+// Model returns the default model
+func (p *OllamaProvider) Model() string {
+	return p.model
+}
+
+// Chat translates the request to the Ollama chat API. Ollama tool calls carry no IDs, so IDs are
+// generated for the response and tool results are matched back to their function names.
+func (p *OllamaProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	var result ollamaResponse
+	status, err := postJSON(ctx, p.baseURL+"/api/chat", nil, p.request(req, false), &result)
+	return ollamaResult(&result, status, err, len(req.Messages))
+}
+
+// ChatStream requests a streamed reply, which Ollama sends as one JSON object per line. The
+// last line carries the token counts.
+func (p *OllamaProvider) ChatStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error) {
+	resp, err := postRequest(ctx, p.baseURL+"/api/chat", nil, p.request(req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result ollamaResponse
+		status, err := readJSON(resp, &result)
+		response, err := ollamaResult(&result, status, err, len(req.Messages))
+		if err == nil {
+			deliverText(response, onText)
+		}
+		return response, err
+	}
+
+	var result ollamaResponse
+	err = readLines(resp.Body, func(line []byte) error {
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("invalid Ollama stream chunk: %v", err)
+		}
+		if chunk.Error != "" {
+			result.Error = chunk.Error
+			return errStreamDone
+		}
+		if chunk.Message.Content != "" {
+			result.Message.Content += chunk.Message.Content
+			onText(chunk.Message.Content)
+		}
+		result.Message.ToolCalls = append(result.Message.ToolCalls, chunk.Message.ToolCalls...)
+		if chunk.Done {
+			result.DoneReason = chunk.DoneReason
+			result.PromptEvalCount = chunk.PromptEvalCount
+			result.EvalCount = chunk.EvalCount
+			return errStreamDone
+		}
+		return nil
+	})
+	return ollamaResult(&result, http.StatusOK, err, len(req.Messages))
+}
+
+// request builds the Ollama chat request body
+func (p *OllamaProvider) request(req ChatRequest, stream bool) ollamaRequest {
+	body := ollamaRequest{
+		Model:    firstNonEmpty(req.Model, p.model),
+		Messages: toOllamaMessages(req.Messages),
+		Stream:   stream,
+	}
+	// Ollama has no tool_choice; withholding the tools is the only way to forbid calls
+	if req.ToolChoice != "none" {
+		body.Tools = req.Tools
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.JSONSchema != nil {
+		body.Format = req.ResponseFormat.JSONSchema.Schema
+	}
+	return body
+}
+
+// ollamaResult converts an Ollama reply to a chat response. Tool call IDs are numbered after the
+// count of request messages so they are unique within the conversation.
+func ollamaResult(result *ollamaResponse, status int, err error, messages int) (*ChatResponse, error) {
+	if err != nil {
+		if status != http.StatusOK && status != 0 {
+			return nil, fmt.Errorf("Ollama API returned HTTP %d", status)
+		}
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("Ollama API error: %s", result.Error)
+	}
+
+	message := ChatMessage{Role: "assistant"}
+	if result.Message.Content != "" || len(result.Message.ToolCalls) == 0 {
+		content := result.Message.Content
+		message.Content = &content
+	}
+	for i, call := range result.Message.ToolCalls {
+		arguments := string(call.Function.Arguments)
+		if arguments == "" || arguments == "null" {
+			arguments = "{}"
+		}
+		message.ToolCalls = append(message.ToolCalls, ToolCall{
+			ID:       fmt.Sprintf("call_%d_%d", messages, i+1),
+			Type:     "function",
+			Function: FunctionDetails{Name: call.Function.Name, Arguments: arguments},
+		})
+	}
+
+	finishReason := result.DoneReason
+	if len(message.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+	return &ChatResponse{
+		Choices: []ChatChoice{{Message: message, FinishReason: finishReason}},
+		Usage: &Usage{
+			PromptTokens:     result.PromptEvalCount,
+			CompletionTokens: result.EvalCount,
+			TotalTokens:      result.PromptEvalCount + result.EvalCount,
+		},
+	}, nil
+}
+
+// toOllamaMessages converts chat messages, sending tool call arguments as JSON objects
+func toOllamaMessages(messages []ChatMessage) []ollamaMessage {
+	toolNames := make(map[string]string)
+	converted := make([]ollamaMessage, 0, len(messages))
+	for _, msg := range messages {
+		out := ollamaMessage{Role: msg.Role}
+		if msg.Content != nil {
+			out.Content = *msg.Content
+		}
+		for _, call := range msg.ToolCalls {
+			toolNames[call.ID] = call.Function.Name
+			var oc ollamaToolCall
+			oc.Function.Name = call.Function.Name
+			oc.Function.Arguments = jsonObject(call.Function.Arguments)
+			out.ToolCalls = append(out.ToolCalls, oc)
+		}
+		if msg.Role == "tool" {
+			out.ToolName = toolNames[msg.ToolCallID]
+		}
+		converted = append(converted, out)
+	}
+	return converted
+}
+
+// jsonObject returns encoded arguments as raw JSON, substituting an empty object when invalid
+func jsonObject(arguments string) json.RawMessage {
+	if arguments == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
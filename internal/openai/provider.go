@@ -0,0 +1,471 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Provider names accepted by NewProvider
+const (
+	ProviderOpenAI    = "openai"    // api.openai.com or any OpenAI-compatible server (vLLM, LM Studio, ...)
+	ProviderAzure     = "azure"     // Azure OpenAI deployment
+	ProviderOllama    = "ollama"    // Ollama native chat API
+	ProviderAnthropic = "anthropic" // Anthropic Messages API
+	ProviderOffline   = "offline"   // No language model; analyses use the rule-based analyst
+)
+
+// ErrOffline is returned for chat requests when the offline provider is selected
+var ErrOffline = errors.New("no language model in offline mode")
+
+// Default endpoints and models for each provider
+const (
+	DefaultBaseURL          = "https://api.openai.com/v1"
+	DefaultOllamaBaseURL    = "http://localhost:11434"
+	DefaultAnthropicBaseURL = "https://api.anthropic.com"
+	DefaultAzureAPIVersion  = "2024-06-01"
+
+	DefaultOpenAIModel    = "gpt-4o-mini"
+	DefaultOllamaModel    = "llama3.1"
+	DefaultAnthropicModel = "claude-3-5-haiku-latest"
+
+	defaultMaxTokens = 4096
+)
+
+// LLMProvider sends chat requests to a language model backend. Requests and responses use the
+// OpenAI chat completions shape; other backends translate to and from their own APIs.
+type LLMProvider interface {
+	// Name identifies the backend in messages, e.g. "Ollama"
+	Name() string
+	// Model is the model used when a request does not set one
+	Model() string
+	// Chat sends a chat request and returns the assistant's reply
+	Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+}
+
+// StreamingProvider is an LLMProvider that can deliver the reply while it is being generated
+type StreamingProvider interface {
+	LLMProvider
+	// ChatStream sends a chat request like Chat, passing each fragment of reply text to onText
+	// as it arrives. Streamed tool calls are assembled into the returned response.
+	ChatStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error)
+}
+
+// ProviderConfig selects and configures an LLM provider. Empty fields fall back to the
+// NETSPY_LLM_PROVIDER, NETSPY_LLM_MODEL and NETSPY_LLM_BASE_URL environment variables, then to
+// the provider's own environment variables and defaults.
+type ProviderConfig struct {
+	Provider   string // openai, azure, ollama, anthropic or offline (default: openai)
+	Model      string
+	BaseURL    string
+	APIKey     string
+	APIVersion string // Azure OpenAI api-version
+	MaxTokens  int    // Response token limit, required by Anthropic (default: 4096)
+	// DisableStreaming waits for complete responses, for servers that reject streaming requests
+	DisableStreaming bool
+}
+
+// NewProvider creates the provider described by cfg
+func NewProvider(cfg ProviderConfig) (LLMProvider, error) {
+	provider, err := newProvider(cfg)
+	if err != nil || !cfg.DisableStreaming {
+		return provider, err
+	}
+	return chatOnly{provider}, nil
+}
+
+func newProvider(cfg ProviderConfig) (LLMProvider, error) {
+	name := strings.ToLower(firstNonEmpty(cfg.Provider, os.Getenv("NETSPY_LLM_PROVIDER"), ProviderOpenAI))
+	model := firstNonEmpty(cfg.Model, os.Getenv("NETSPY_LLM_MODEL"))
+	baseURL := firstNonEmpty(cfg.BaseURL, os.Getenv("NETSPY_LLM_BASE_URL"))
+
+	switch name {
+	case ProviderOpenAI:
+		return &OpenAIProvider{
+			name:    "OpenAI",
+			baseURL: trimBaseURL(firstNonEmpty(baseURL, os.Getenv("OPENAI_BASE_URL"), DefaultBaseURL)),
+			apiKey:  firstNonEmpty(cfg.APIKey, os.Getenv("OPENAI_API_KEY")),
+			keyEnv:  "OPENAI_API_KEY",
+			model:   firstNonEmpty(model, DefaultOpenAIModel),
+		}, nil
+	case ProviderAzure:
+		baseURL = firstNonEmpty(baseURL, os.Getenv("AZURE_OPENAI_ENDPOINT"))
+		if baseURL == "" {
+			return nil, fmt.Errorf("azure provider requires a base URL such as https://RESOURCE.openai.azure.com/openai/deployments/DEPLOYMENT")
+		}
+		return &OpenAIProvider{
+			name:       "Azure OpenAI",
+			baseURL:    trimBaseURL(baseURL),
+			apiKey:     firstNonEmpty(cfg.APIKey, os.Getenv("AZURE_OPENAI_API_KEY")),
+			keyEnv:     "AZURE_OPENAI_API_KEY",
+			apiVersion: firstNonEmpty(cfg.APIVersion, DefaultAzureAPIVersion),
+			model:      model, // The deployment in the base URL selects the model
+		}, nil
+	case ProviderOllama:
+		return &OllamaProvider{
+			baseURL: ollamaBaseURL(firstNonEmpty(baseURL, os.Getenv("OLLAMA_HOST"), DefaultOllamaBaseURL)),
+			model:   firstNonEmpty(model, DefaultOllamaModel),
+		}, nil
+	case ProviderAnthropic:
+		maxTokens := cfg.MaxTokens
+		if maxTokens <= 0 {
+			maxTokens = defaultMaxTokens
+		}
+		return &AnthropicProvider{
+			baseURL:   trimBaseURL(firstNonEmpty(baseURL, os.Getenv("ANTHROPIC_BASE_URL"), DefaultAnthropicBaseURL)),
+			apiKey:    firstNonEmpty(cfg.APIKey, os.Getenv("ANTHROPIC_API_KEY")),
+			model:     firstNonEmpty(model, DefaultAnthropicModel),
+			maxTokens: maxTokens,
+		}, nil
+	case ProviderOffline:
+		return offlineProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (use openai, azure, ollama, anthropic or offline)", name)
+	}
+}
+
+// ConfiguredProvider returns the provider described by cfg. An invalid configuration yields a
+// provider whose requests fail with the configuration error, so nothing is sent to a backend
+// the user did not choose.
+func ConfiguredProvider(cfg ProviderConfig) LLMProvider {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return unavailableProvider{err: err}
+	}
+	return provider
+}
+
+// DefaultProvider returns the provider selected by environment variables
+func DefaultProvider() LLMProvider {
+	return ConfiguredProvider(ProviderConfig{})
+}
+
+// CheckProvider returns why provider cannot answer requests without contacting it: ErrOffline
+// for the offline provider, a configuration error, or a missing API key. A nil error does not
+// guarantee the backend is reachable.
+func CheckProvider(provider LLMProvider) error {
+	if checker, ok := provider.(interface{ check() error }); ok {
+		return checker.check()
+	}
+	return nil
+}
+
+// offlineProvider is selected when no language model should be used
+type offlineProvider struct{}
+
+func (p offlineProvider) Name() string  { return "Offline rules" }
+func (p offlineProvider) Model() string { return "" }
+func (p offlineProvider) check() error  { return ErrOffline }
+
+func (p offlineProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	return nil, ErrOffline
+}
+
+// unavailableProvider reports a provider configuration error on every request
+type unavailableProvider struct {
+	err error
+}
+
+func (p unavailableProvider) Name() string  { return "LLM provider" }
+func (p unavailableProvider) Model() string { return "" }
+func (p unavailableProvider) check() error  { return p.err }
+
+func (p unavailableProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	return nil, p.err
+}
+
+// chatOnly hides the streaming support of the provider it wraps
+type chatOnly struct {
+	LLMProvider
+}
+
+func (p chatOnly) check() error {
+	return CheckProvider(p.LLMProvider)
+}
+
+// OpenAIProvider talks to the OpenAI chat completions API or a compatible server such as vLLM,
+// LM Studio or Azure OpenAI
+type OpenAIProvider struct {
+	name       string
+	baseURL    string
+	apiKey     string
+	keyEnv     string
+	apiVersion string // Set for Azure, which authenticates with an api-key header
+	model      string
+}
+
+// NewOpenAIProvider creates a provider for an OpenAI-compatible API at baseURL. The API key may
+// be empty for local servers that do not authenticate.
+func NewOpenAIProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	return &OpenAIProvider{
+		name:    "OpenAI",
+		baseURL: trimBaseURL(firstNonEmpty(baseURL, DefaultBaseURL)),
+		apiKey:  apiKey,
+		keyEnv:  "OPENAI_API_KEY",
+		model:   firstNonEmpty(model, DefaultOpenAIModel),
+	}
+}
+
+// Name returns the backend name
+func (p *OpenAIProvider) Name() string {
+	return p.name
+}
+
+// Model returns the default model
+func (p *OpenAIProvider) Model() string {
+	return p.model
+}
+
+// Chat posts the request to the chat completions endpoint
+func (p *OpenAIProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	endpoint, headers, err := p.prepare(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ChatResponse
+	status, err := postJSON(ctx, endpoint, headers, req, &result)
+	return p.result(&result, status, err)
+}
+
+// chatChunk is one server-sent event of a streamed chat completion
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   *string         `json:"content"`
+			ToolCalls []toolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage    `json:"usage"`
+	Error *APIError `json:"error"`
+}
+
+// toolCallDelta is a fragment of a streamed tool call. The first fragment of each call carries
+// its ID and name; later fragments with the same index add to the arguments.
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// ChatStream posts the request with streaming enabled and assembles the reply from its chunks
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error) {
+	endpoint, headers, err := p.prepare(&req)
+	if err != nil {
+		return nil, err
+	}
+	req.Stream = true
+	if p.apiVersion == "" {
+		// Azure only accepts stream_options in newer API versions
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	resp, err := postRequest(ctx, endpoint, headers, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !isEventStream(resp) {
+		var result ChatResponse
+		status, err := readJSON(resp, &result)
+		response, err := p.result(&result, status, err)
+		if err == nil {
+			deliverText(response, onText)
+		}
+		return response, err
+	}
+
+	var (
+		text      strings.Builder
+		calls     []ToolCall
+		choices   bool
+		finish    string
+		usage     *Usage
+		streamErr error
+	)
+	err = readEvents(resp.Body, func(_ string, data string) error {
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("invalid %s stream chunk: %v", p.name, err)
+		}
+		if chunk.Error != nil {
+			streamErr = fmt.Errorf("%s API error: %s", p.name, chunk.Error.Message)
+			return errStreamDone
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			choices = true
+			if content := choice.Delta.Content; content != nil && *content != "" {
+				text.WriteString(*content)
+				onText(*content)
+			}
+			for _, delta := range choice.Delta.ToolCalls {
+				for len(calls) <= delta.Index {
+					calls = append(calls, ToolCall{Type: "function"})
+				}
+				call := &calls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				if delta.Function.Name != "" {
+					call.Function.Name = delta.Function.Name
+				}
+				call.Function.Arguments += delta.Function.Arguments
+			}
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if streamErr != nil {
+		return nil, streamErr
+	}
+
+	response := &ChatResponse{Usage: usage}
+	if !choices {
+		return response, nil
+	}
+	message := ChatMessage{Role: "assistant"}
+	if text.Len() > 0 || len(calls) == 0 {
+		content := text.String()
+		message.Content = &content
+	}
+	for _, call := range calls {
+		if call.Function.Arguments == "" {
+			call.Function.Arguments = "{}"
+		}
+		message.ToolCalls = append(message.ToolCalls, call)
+	}
+	response.Choices = []ChatChoice{{Message: message, FinishReason: finish}}
+	return response, nil
+}
+
+// prepare fills in the model and returns the endpoint and authentication headers for a request
+func (p *OpenAIProvider) prepare(req *ChatRequest) (string, map[string]string, error) {
+	if err := p.check(); err != nil {
+		return "", nil, err
+	}
+	if req.Model == "" {
+		req.Model = p.model
+	}
+
+	endpoint := p.baseURL + "/chat/completions"
+	headers := map[string]string{}
+	if p.apiVersion != "" {
+		endpoint += "?api-version=" + url.QueryEscape(p.apiVersion)
+		headers["api-key"] = p.apiKey
+	} else if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	return endpoint, headers, nil
+}
+
+// check reports a missing API key. Only the hosted APIs require one; local OpenAI-compatible
+// servers usually do not.
+func (p *OpenAIProvider) check() error {
+	if p.apiKey == "" && (p.baseURL == DefaultBaseURL || p.apiVersion != "") {
+		return fmt.Errorf("%s not set", p.keyEnv)
+	}
+	return nil
+}
+
+// result turns a decoded completion and its HTTP status into the response or an error
+func (p *OpenAIProvider) result(result *ChatResponse, status int, err error) (*ChatResponse, error) {
+	if err != nil {
+		if status != http.StatusOK && status != 0 {
+			return nil, fmt.Errorf("%s API returned HTTP %d", p.name, status)
+		}
+		return nil, err
+	}
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("%s API error: %s", p.name, result.Error.Message)
+	}
+
+	return result, nil
+}
+
+// postJSON sends body as JSON and decodes the response into result. The HTTP status is returned
+// so callers can report error responses that are not JSON.
+func postJSON(ctx context.Context, endpoint string, headers map[string]string, body, result any) (int, error) {
+	resp, err := postRequest(ctx, endpoint, headers, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return readJSON(resp, result)
+}
+
+// postRequest sends body as JSON and returns the response for the caller to read and close
+func postRequest(ctx context.Context, endpoint string, headers map[string]string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// readJSON decodes a response body into result and returns the HTTP status
+func readJSON(resp *http.Response, result any) (int, error) {
+	respData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(respData, result); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+// ollamaBaseURL accepts OLLAMA_HOST style values such as "0.0.0.0:11434" without a scheme
+func ollamaBaseURL(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return trimBaseURL(host)
+}
+
+func trimBaseURL(baseURL string) string {
+	return strings.TrimRight(baseURL, "/")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,384 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// recordingServer answers every request with one of the given bodies in order and keeps the
+// decoded request bodies and headers
+type recordingServer struct {
+	responses []string
+	bodies    []map[string]any
+	headers   []http.Header
+	paths     []string
+}
+
+func newRecordingServer(t *testing.T, responses ...string) (*recordingServer, string) {
+	t.Helper()
+	rs := &recordingServer{responses: responses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		rs.bodies = append(rs.bodies, body)
+		rs.headers = append(rs.headers, r.Header.Clone())
+		rs.paths = append(rs.paths, r.URL.RequestURI())
+		if len(rs.responses) == 0 {
+			http.Error(w, "no response scripted", http.StatusInternalServerError)
+			return
+		}
+		if strings.HasPrefix(rs.responses[0], "event:") || strings.HasPrefix(rs.responses[0], "data:") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		_, _ = io.WriteString(w, rs.responses[0])
+		rs.responses = rs.responses[1:]
+	}))
+	t.Cleanup(server.Close)
+	return rs, server.URL
+}
+
+func clearLLMEnv(t *testing.T) {
+	for _, key := range []string{"NETSPY_LLM_PROVIDER", "NETSPY_LLM_MODEL", "NETSPY_LLM_BASE_URL", "OPENAI_BASE_URL",
+		"OPENAI_API_KEY", "AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_API_KEY", "OLLAMA_HOST", "ANTHROPIC_BASE_URL", "ANTHROPIC_API_KEY"} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestNewProvider_Selection(t *testing.T) {
+	clearLLMEnv(t)
+
+	tests := []struct {
+		cfg   openai.ProviderConfig
+		name  string
+		model string
+	}{
+		{openai.ProviderConfig{}, "OpenAI", openai.DefaultOpenAIModel},
+		{openai.ProviderConfig{Provider: "Ollama"}, "Ollama", openai.DefaultOllamaModel},
+		{openai.ProviderConfig{Provider: "anthropic", Model: "claude-test"}, "Anthropic", "claude-test"},
+		{openai.ProviderConfig{Provider: "azure", BaseURL: "https://example.openai.azure.com/openai/deployments/gpt"}, "Azure OpenAI", ""},
+	}
+	for _, tt := range tests {
+		provider, err := openai.NewProvider(tt.cfg)
+		if err != nil {
+			t.Fatalf("NewProvider(%+v) failed: %v", tt.cfg, err)
+		}
+		if provider.Name() != tt.name || provider.Model() != tt.model {
+			t.Errorf("NewProvider(%+v) = %s/%s, want %s/%s", tt.cfg, provider.Name(), provider.Model(), tt.name, tt.model)
+		}
+	}
+
+	if _, err := openai.NewProvider(openai.ProviderConfig{Provider: "olama"}); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	if _, err := openai.NewProvider(openai.ProviderConfig{Provider: "azure"}); err == nil {
+		t.Error("expected error for azure without a base URL")
+	}
+}
+
+func TestNewProvider_Environment(t *testing.T) {
+	clearLLMEnv(t)
+	t.Setenv("NETSPY_LLM_PROVIDER", "ollama")
+	t.Setenv("NETSPY_LLM_MODEL", "qwen2.5")
+
+	provider, err := openai.NewProvider(openai.ProviderConfig{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	if provider.Name() != "Ollama" || provider.Model() != "qwen2.5" {
+		t.Errorf("expected Ollama/qwen2.5 from environment, got %s/%s", provider.Name(), provider.Model())
+	}
+
+	// Explicit settings take precedence over the environment
+	provider, _ = openai.NewProvider(openai.ProviderConfig{Provider: "openai", Model: "gpt-test"})
+	if provider.Name() != "OpenAI" || provider.Model() != "gpt-test" {
+		t.Errorf("expected OpenAI/gpt-test, got %s/%s", provider.Name(), provider.Model())
+	}
+}
+
+func TestConfiguredProvider_InvalidDoesNotFallBack(t *testing.T) {
+	clearLLMEnv(t)
+	provider := openai.ConfiguredProvider(openai.ProviderConfig{Provider: "olama"})
+
+	_, err := provider.Chat(context.Background(), openai.ChatRequest{})
+	if err == nil || !strings.Contains(err.Error(), `unknown LLM provider "olama"`) {
+		t.Errorf("expected configuration error, got %v", err)
+	}
+}
+
+func TestAzureProvider(t *testing.T) {
+	clearLLMEnv(t)
+	rs, baseURL := newRecordingServer(t, `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	provider, err := openai.NewProvider(openai.ProviderConfig{Provider: "azure", BaseURL: baseURL + "/openai/deployments/gpt/", APIKey: "azure-key"})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	answer, err := openai.AskLLMWithProvider(context.Background(), provider, "summary")
+	if err != nil || answer != "hi" {
+		t.Fatalf("unexpected result %q, %v", answer, err)
+	}
+	if rs.paths[0] != "/openai/deployments/gpt/chat/completions?api-version="+openai.DefaultAzureAPIVersion {
+		t.Errorf("unexpected path %s", rs.paths[0])
+	}
+	if rs.headers[0].Get("api-key") != "azure-key" || rs.headers[0].Get("Authorization") != "" {
+		t.Errorf("expected api-key header only, got %v", rs.headers[0])
+	}
+}
+
+func TestOllamaProvider_ToolCalls(t *testing.T) {
+	rs, baseURL := newRecordingServer(t,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"list_connections","arguments":{"pid":42}}}]},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":5}`,
+		`{"message":{"role":"assistant","content":"curl is fine"},"done":true,"done_reason":"stop"}`,
+	)
+	executor := newFakeExecutor()
+	cm := openai.NewConversationManagerWithProvider(executor, openai.NewOllamaProvider(strings.TrimPrefix(baseURL, "http://"), ""), false)
+	cm.AddSystemMessage("system prompt")
+
+	answer, err := cm.ProcessMessage(context.Background(), "what is curl doing?")
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	if answer != "curl is fine" {
+		t.Errorf("unexpected answer %q", answer)
+	}
+	if len(executor.calls) != 1 || executor.calls[0].Arguments["pid"] != float64(42) {
+		t.Errorf("expected list_connections(pid=42), got %v", executor.calls)
+	}
+
+	first := rs.bodies[0]
+	if first["model"] != openai.DefaultOllamaModel || first["stream"] != false {
+		t.Errorf("unexpected request options %v", first)
+	}
+	if tools, _ := first["tools"].([]any); len(tools) != 2 {
+		t.Errorf("expected 2 tools, got %v", first["tools"])
+	}
+
+	// The follow-up sends arguments as objects and labels the tool result with the function name
+	messages := rs.bodies[1]["messages"].([]any)
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(messages))
+	}
+	assistant := messages[2].(map[string]any)
+	call := assistant["tool_calls"].([]any)[0].(map[string]any)["function"].(map[string]any)
+	if call["arguments"].(map[string]any)["pid"] != float64(42) {
+		t.Errorf("expected object arguments, got %v", call["arguments"])
+	}
+	tool := messages[3].(map[string]any)
+	if tool["role"] != "tool" || tool["tool_name"] != "list_connections" || !strings.Contains(tool["content"].(string), "3 connections") {
+		t.Errorf("unexpected tool message %v", tool)
+	}
+}
+
+func TestOllamaProvider_Stream(t *testing.T) {
+	rs, baseURL := newRecordingServer(t, strings.Join([]string{
+		`{"message":{"role":"assistant","content":"curl "},"done":false}`,
+		`{"message":{"role":"assistant","content":"is fine"},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"list_connections","arguments":{"pid":42}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":5}`,
+	}, "\n"))
+	var fragments []string
+	response, err := openai.NewOllamaProvider(baseURL, "").ChatStream(context.Background(), openai.ChatRequest{}, func(text string) {
+		fragments = append(fragments, text)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	if rs.bodies[0]["stream"] != true {
+		t.Errorf("expected a streaming request, got %v", rs.bodies[0])
+	}
+	if strings.Join(fragments, "|") != "curl |is fine" {
+		t.Errorf("unexpected fragments %q", fragments)
+	}
+	message := response.Choices[0].Message
+	if *message.Content != "curl is fine" || len(message.ToolCalls) != 1 || message.ToolCalls[0].Function.Arguments != `{"pid":42}` {
+		t.Errorf("unexpected message %+v", message)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" || response.Usage.TotalTokens != 35 {
+		t.Errorf("unexpected finish reason or usage %+v", response)
+	}
+}
+
+func TestOllamaProvider_ErrorsAndToolChoiceNone(t *testing.T) {
+	rs, baseURL := newRecordingServer(t, `{"error":"model \"missing\" not found"}`, `{"message":{"role":"assistant","content":"done"}}`)
+	provider := openai.NewOllamaProvider(baseURL, "missing")
+	tools := []openai.Tool{{Type: "function", Function: openai.FunctionDefinition{Name: "list_connections"}}}
+
+	_, err := provider.Chat(context.Background(), openai.ChatRequest{Tools: tools})
+	if err == nil || !strings.Contains(err.Error(), `Ollama API error: model "missing" not found`) {
+		t.Errorf("expected Ollama error, got %v", err)
+	}
+
+	if _, err := provider.Chat(context.Background(), openai.ChatRequest{Tools: tools, ToolChoice: "none"}); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if _, ok := rs.bodies[1]["tools"]; ok {
+		t.Error("expected tools to be withheld when tool_choice is none")
+	}
+}
+
+func TestAnthropicProvider_ToolUse(t *testing.T) {
+	rs, baseURL := newRecordingServer(t,
+		`{"content":[{"type":"text","text":"Checking."},{"type":"tool_use","id":"toolu_1","name":"list_connections","input":{"pid":42}},{"type":"tool_use","id":"toolu_2","name":"list_packet_drops","input":{"scope":"all"}}],"stop_reason":"tool_use","usage":{"input_tokens":100,"output_tokens":20}}`,
+		`{"content":[{"type":"text","text":"One reset drop."}],"stop_reason":"end_turn","usage":{"input_tokens":150,"output_tokens":10}}`,
+	)
+	executor := newFakeExecutor()
+	cm := openai.NewConversationManagerWithProvider(executor, openai.NewAnthropicProvider(baseURL, "anthropic-key", ""), false)
+	cm.AddSystemMessage("system prompt")
+
+	answer, err := cm.ProcessMessage(context.Background(), "any drops?")
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	if answer != "One reset drop." {
+		t.Errorf("unexpected answer %q", answer)
+	}
+	if len(executor.calls) != 2 {
+		t.Errorf("expected 2 tool calls, got %v", executor.calls)
+	}
+
+	if rs.paths[0] != "/v1/messages" || rs.headers[0].Get("x-api-key") != "anthropic-key" || rs.headers[0].Get("anthropic-version") == "" {
+		t.Errorf("unexpected request %s %v", rs.paths[0], rs.headers[0])
+	}
+	first := rs.bodies[0]
+	if first["system"] != "system prompt" || first["model"] != openai.DefaultAnthropicModel || first["max_tokens"] != float64(4096) {
+		t.Errorf("unexpected request options %v", first)
+	}
+	if first["tool_choice"].(map[string]any)["type"] != "auto" {
+		t.Errorf("expected auto tool choice, got %v", first["tool_choice"])
+	}
+	tool := first["tools"].([]any)[0].(map[string]any)
+	if tool["input_schema"] == nil {
+		t.Errorf("expected input_schema, got %v", tool)
+	}
+
+	// Assistant tool_use blocks are followed by one user turn holding both tool results
+	messages := rs.bodies[1]["messages"].([]any)
+	if len(messages) != 3 {
+		t.Fatalf("expected user, assistant and user messages, got %d", len(messages))
+	}
+	assistant := messages[1].(map[string]any)["content"].([]any)
+	if len(assistant) != 3 || assistant[1].(map[string]any)["type"] != "tool_use" || assistant[1].(map[string]any)["id"] != "toolu_1" {
+		t.Errorf("unexpected assistant blocks %v", assistant)
+	}
+	results := messages[2].(map[string]any)
+	blocks := results["content"].([]any)
+	if results["role"] != "user" || len(blocks) != 2 {
+		t.Fatalf("expected 2 tool results in a user turn, got %v", results)
+	}
+	for i, id := range []string{"toolu_1", "toolu_2"} {
+		block := blocks[i].(map[string]any)
+		if block["type"] != "tool_result" || block["tool_use_id"] != id {
+			t.Errorf("unexpected tool result %v", block)
+		}
+	}
+}
+
+func TestAnthropicProvider_Errors(t *testing.T) {
+	clearLLMEnv(t)
+	if _, err := openai.NewAnthropicProvider("", "", "").Chat(context.Background(), openai.ChatRequest{}); err == nil || !strings.Contains(err.Error(), "ANTHROPIC_API_KEY not set") {
+		t.Errorf("expected missing key error, got %v", err)
+	}
+
+	_, baseURL := newRecordingServer(t, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	_, err := openai.NewAnthropicProvider(baseURL, "key", "").Chat(context.Background(), openai.ChatRequest{})
+	if err == nil || !strings.Contains(err.Error(), "Anthropic API error: Overloaded") {
+		t.Errorf("expected Anthropic error, got %v", err)
+	}
+}
+
+func TestAnthropicProvider_Stream(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"content":[],"usage":{"input_tokens":100,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Check"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ing."}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"list_connections","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"pid\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"42}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}
+	var body strings.Builder
+	for _, event := range events {
+		body.WriteString("event: message\ndata: " + event + "\n\n")
+	}
+	rs, baseURL := newRecordingServer(t, body.String())
+
+	var text strings.Builder
+	response, err := openai.NewAnthropicProvider(baseURL, "key", "").ChatStream(context.Background(), openai.ChatRequest{}, func(fragment string) {
+		text.WriteString(fragment)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	if rs.bodies[0]["stream"] != true {
+		t.Errorf("expected a streaming request, got %v", rs.bodies[0])
+	}
+	message := response.Choices[0].Message
+	if text.String() != "Checking." || *message.Content != "Checking." {
+		t.Errorf("unexpected text %q / %q", text.String(), *message.Content)
+	}
+	if len(message.ToolCalls) != 1 || message.ToolCalls[0].ID != "toolu_1" || message.ToolCalls[0].Function.Arguments != `{"pid":42}` {
+		t.Errorf("expected the tool input to be assembled, got %+v", message.ToolCalls)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" || response.Usage.TotalTokens != 120 {
+		t.Errorf("unexpected finish reason or usage %+v", response)
+	}
+
+	_, baseURL = newRecordingServer(t, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	_, err = openai.NewAnthropicProvider(baseURL, "key", "").ChatStream(context.Background(), openai.ChatRequest{}, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "Anthropic API error: Overloaded") {
+		t.Errorf("expected the streamed error, got %v", err)
+	}
+}
+
+func TestOpenAIProvider_StreamFallsBackToJSON(t *testing.T) {
+	// Some OpenAI-compatible servers ignore "stream" and answer with a complete response
+	_, baseURL := newRecordingServer(t, `{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	var text string
+	response, err := openai.NewOpenAIProvider(baseURL, "", "").ChatStream(context.Background(), openai.ChatRequest{}, func(fragment string) {
+		text += fragment
+	})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	if text != "hi" || *response.Choices[0].Message.Content != "hi" {
+		t.Errorf("expected the whole answer as one fragment, got %q", text)
+	}
+}
+
+func TestNewProvider_DisableStreaming(t *testing.T) {
+	clearLLMEnv(t)
+	provider, err := openai.NewProvider(openai.ProviderConfig{Provider: "ollama"})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	if _, ok := provider.(openai.StreamingProvider); !ok {
+		t.Error("expected Ollama to support streaming")
+	}
+
+	provider, err = openai.NewProvider(openai.ProviderConfig{Provider: "ollama", DisableStreaming: true})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	if _, ok := provider.(openai.StreamingProvider); ok {
+		t.Error("expected DisableStreaming to hide streaming support")
+	}
+	if provider.Name() != "Ollama" || provider.Model() != openai.DefaultOllamaModel {
+		t.Errorf("unexpected provider %s/%s", provider.Name(), provider.Model())
+	}
+}
@@ -0,0 +1,272 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+// Finding severities, matching the anomaly detector
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// maxReportAttempts bounds the requests made for a valid findings report
+const maxReportAttempts = 3
+
+// reportSchemaName names the findings schema in requests
+const reportSchemaName = "network_findings"
+
+// Report is a findings report extracted from an analysis, suitable for filing as tickets
+type Report struct {
+	Summary  string    `json:"summary"`
+	Findings []Finding `json:"findings"`
+	// Incomplete says which limit cut the analysis short, in which case findings may be missing
+	Incomplete string `json:"incomplete,omitempty"`
+	// Fallback says why the offline rule-based analyst wrote the report instead of the
+	// configured provider
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Finding is one problem found by an analysis. Process and Destination are empty and PID is 0
+// when a finding is not specific to one.
+type Finding struct {
+	Title           string     `json:"title"`
+	Severity        string     `json:"severity"`
+	Description     string     `json:"description"`
+	Process         string     `json:"process"`
+	PID             int        `json:"pid"`
+	Destination     string     `json:"destination"`
+	Evidence        []Evidence `json:"evidence"`
+	Recommendations []string   `json:"recommendations"`
+}
+
+// Evidence cites the result of a tool call made during the analysis
+type Evidence struct {
+	ToolCallID string `json:"tool_call_id"`
+	Tool       string `json:"tool"`
+	Arguments  string `json:"arguments,omitempty"` // Filled in from the run, not by the model
+	Detail     string `json:"detail"`
+}
+
+// ReportSchema is the JSON schema findings reports must match. It follows the rules of
+// OpenAI strict structured outputs: every property is required and no others are allowed.
+const ReportSchema = `{
+  "type": "object",
+  "properties": {
+    "summary": {"type": "string", "description": "One or two sentences on the overall state of the network"},
+    "findings": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "title": {"type": "string", "description": "Short ticket title"},
+          "severity": {"type": "string", "enum": ["low", "medium", "high"]},
+          "description": {"type": "string", "description": "What is wrong and its impact"},
+          "process": {"type": "string", "description": "Affected process name, empty when not specific to one"},
+          "pid": {"type": "integer", "description": "Affected process ID, 0 when unknown"},
+          "destination": {"type": "string", "description": "Affected destination as IP:port or host:port, empty when not specific to one"},
+          "evidence": {
+            "type": "array",
+            "description": "Tool results supporting the finding",
+            "items": {
+              "type": "object",
+              "properties": {
+                "tool_call_id": {"type": "string", "description": "ID of the tool call whose result is cited"},
+                "tool": {"type": "string", "description": "Name of the tool that was called"},
+                "detail": {"type": "string", "description": "What the tool result shows"}
+              },
+              "required": ["tool_call_id", "tool", "detail"],
+              "additionalProperties": false
+            }
+          },
+          "recommendations": {"type": "array", "items": {"type": "string"}}
+        },
+        "required": ["title", "severity", "description", "process", "pid", "destination", "evidence", "recommendations"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["summary", "findings"],
+  "additionalProperties": false
+}`
+
+// resolvedReportSchema validates report replies
+var resolvedReportSchema = func() *jsonschema.Resolved {
+	var schema jsonschema.Schema
+	if err := json.Unmarshal([]byte(ReportSchema), &schema); err != nil {
+		panic(fmt.Sprintf("invalid report schema: %v", err))
+	}
+	resolved, err := schema.Resolve(nil)
+	if err != nil {
+		panic(fmt.Sprintf("invalid report schema: %v", err))
+	}
+	return resolved
+}()
+
+// reportFormat requests replies matching ReportSchema
+func reportFormat() *ResponseFormat {
+	return &ResponseFormat{
+		Type: "json_schema",
+		JSONSchema: &JSONSchema{
+			Name:        reportSchemaName,
+			Description: "Findings of a network analysis",
+			Strict:      true,
+			Schema:      json.RawMessage(ReportSchema),
+		},
+	}
+}
+
+// ExtractReport asks the model to restate the last answer as a findings report. Replies that
+// do not match the schema or cite tool calls the run did not make are sent back with the
+// problems, up to maxReportAttempts requests. The conversation history is left unchanged.
+func (cm *ConversationManager) ExtractReport(ctx context.Context, run *RunResult) (*Report, error) {
+	messages := append([]ChatMessage(nil), cm.messages...)
+	instruction := reportInstruction(run)
+	messages = append(messages, ChatMessage{Role: "user", Content: &instruction})
+
+	defer func() {
+		run.DurationMS = time.Since(run.StartedAt).Milliseconds()
+	}()
+	for attempt := 1; ; attempt++ {
+		req := ChatRequest{
+			Model:          cm.model,
+			Messages:       messages,
+			ResponseFormat: reportFormat(),
+		}
+		response, err := cm.recordRequest(ctx, req, reportRequest, run)
+		if err != nil {
+			return nil, fmt.Errorf("failed to request findings report: %v", err)
+		}
+		if response.Usage != nil {
+			run.Usage.add(*response.Usage)
+		}
+
+		reply := ""
+		if len(response.Choices) > 0 && response.Choices[0].Message.Content != nil {
+			reply = *response.Choices[0].Message.Content
+		}
+		report, err := ParseReport(reply, run)
+		if err == nil {
+			report.Incomplete = run.Describe()
+			run.Report = report
+			return report, nil
+		}
+		run.LLMRequests[len(run.LLMRequests)-1].Invalid = err.Error()
+		if attempt == maxReportAttempts {
+			return nil, fmt.Errorf("no valid findings report after %d attempts: %v", attempt, err)
+		}
+
+		correction := fmt.Sprintf("The report was rejected: %v. Reply with the complete corrected JSON report.", err)
+		messages = append(messages,
+			ChatMessage{Role: "assistant", Content: &reply},
+			ChatMessage{Role: "user", Content: &correction},
+		)
+	}
+}
+
+// reportInstruction asks for the report and lists the tool results it may cite
+func reportInstruction(run *RunResult) string {
+	var b strings.Builder
+	b.WriteString("Report the findings of your analysis as JSON matching the " + reportSchemaName + " schema. ")
+	b.WriteString("Base every finding on the tool results above and cite them as evidence by tool_call_id; findings without evidence are rejected. ")
+	b.WriteString("Use severity high for outages, security exposure or data loss, medium for degraded or suspicious behavior and low for minor issues. ")
+	b.WriteString("Use an empty process or destination when a finding is not specific to one and pid 0 when it is unknown. ")
+	b.WriteString("Return an empty findings list when nothing is wrong.\n\n")
+
+	cited := false
+	for _, exec := range run.ToolExecutions {
+		if exec.Skipped {
+			continue
+		}
+		if !cited {
+			b.WriteString("Tool results you can cite:\n")
+			cited = true
+		}
+		fmt.Fprintf(&b, "- %s: %s(%s)\n", exec.ID, exec.Name, traceArguments(exec.Arguments))
+	}
+	if !cited {
+		b.WriteString("No tools were called, so there is no evidence to cite and the findings list must be empty.\n")
+	}
+	return b.String()
+}
+
+// ParseReport decodes a findings report and validates it against ReportSchema and the run:
+// every finding needs evidence, and evidence must cite a tool call the run executed. Findings
+// are returned most severe first, with the arguments of each cited call filled in.
+func ParseReport(reply string, run *RunResult) (*Report, error) {
+	reply = stripCodeFence(reply)
+	var instance any
+	if err := json.Unmarshal([]byte(reply), &instance); err != nil {
+		return nil, fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+	if err := resolvedReportSchema.Validate(instance); err != nil {
+		return nil, fmt.Errorf("reply does not match the schema: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal([]byte(reply), &report); err != nil {
+		return nil, fmt.Errorf("reply does not match the schema: %v", err)
+	}
+
+	executed := make(map[string]ToolExecution)
+	if run != nil {
+		for _, exec := range run.ToolExecutions {
+			if !exec.Skipped {
+				executed[exec.ID] = exec
+			}
+		}
+	}
+	var problems []string
+	for i := range report.Findings {
+		finding := &report.Findings[i]
+		if len(finding.Evidence) == 0 {
+			problems = append(problems, fmt.Sprintf("finding %d (%q) has no evidence", i+1, finding.Title))
+		}
+		for j := range finding.Evidence {
+			evidence := &finding.Evidence[j]
+			exec, ok := executed[evidence.ToolCallID]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("finding %d cites tool call %q, which was not made", i+1, evidence.ToolCallID))
+			case exec.Name != evidence.Tool:
+				problems = append(problems, fmt.Sprintf("finding %d cites tool call %q as %s, but it called %s", i+1, evidence.ToolCallID, evidence.Tool, exec.Name))
+			default:
+				evidence.Arguments = exec.Arguments
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	sortFindings(report.Findings)
+	return &report, nil
+}
+
+// sortFindings orders findings most severe first, keeping the order of equal ones
+func sortFindings(findings []Finding) {
+	rank := map[string]int{SeverityHigh: 0, SeverityMedium: 1, SeverityLow: 2}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return rank[findings[i].Severity] < rank[findings[j].Severity]
+	})
+}
+
+// stripCodeFence removes a Markdown code fence some models wrap around JSON
+func stripCodeFence(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if !strings.HasPrefix(reply, "```") {
+		return reply
+	}
+	reply = strings.TrimPrefix(reply, "```")
+	if newline := strings.IndexByte(reply, '\n'); newline >= 0 {
+		reply = reply[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(reply), "```"))
+}
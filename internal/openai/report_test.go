@@ -0,0 +1,167 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+const validReport = `{"summary":"nginx is dropping packets.","findings":[
+	{"title":"Slow DNS","severity":"low","description":"Lookups take 2s","process":"","pid":0,"destination":"10.0.0.2:53","evidence":[{"tool_call_id":"a","tool":"list_connections","detail":"3 slow lookups"}],"recommendations":["Check the resolver"]},
+	{"title":"nginx resets","severity":"high","description":"37 TCP resets","process":"nginx","pid":1234,"destination":"10.0.0.5:5432","evidence":[{"tool_call_id":"b","tool":"list_packet_drops","detail":"37 TCP_RESET drops"}],"recommendations":["Check postgres max_connections"]}
+]}`
+
+// analysedConversation answers one question with two tool calls, followed by the given report replies
+func analysedConversation(t *testing.T, replies ...string) (*openai.ConversationManager, *fakeopenai.Server, *openai.RunResult) {
+	t.Helper()
+	turns := []fakeopenai.Turn{
+		{ToolCalls: []fakeopenai.ToolCall{
+			{ID: "a", Name: "list_connections", Arguments: `{"process_name":"nginx"}`},
+			{ID: "b", Name: "list_packet_drops"},
+		}},
+		{Content: "nginx is dropping packets."},
+	}
+	for _, reply := range replies {
+		turns = append(turns, fakeopenai.Turn{Content: reply, PromptTokens: 10, CompletionTokens: 5})
+	}
+	cm, fake := newConversation(t, newFakeExecutor(), turns...)
+	run, err := cm.Run(context.Background(), "why is nginx failing?")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	return cm, fake, run
+}
+
+func TestExtractReport(t *testing.T) {
+	cm, fake, run := analysedConversation(t, "```json\n"+validReport+"\n```")
+	history := len(cm.GetConversationHistory())
+
+	report, err := cm.ExtractReport(context.Background(), run)
+	if err != nil {
+		t.Fatalf("ExtractReport failed: %v", err)
+	}
+	if len(report.Findings) != 2 || report.Findings[0].Severity != "high" || report.Findings[0].PID != 1234 {
+		t.Fatalf("expected the high severity finding first, got %+v", report.Findings)
+	}
+	if evidence := report.Findings[1].Evidence[0]; evidence.Arguments != `{"process_name":"nginx"}` {
+		t.Errorf("expected evidence to carry the cited call's arguments, got %+v", evidence)
+	}
+	if run.Report != report || report.Incomplete != "" {
+		t.Errorf("expected a complete report on the run, got %+v", run.Report)
+	}
+	if last := run.LLMRequests[len(run.LLMRequests)-1]; !last.Report || last.Invalid != "" {
+		t.Errorf("expected a recorded report request, got %+v", last)
+	}
+	if len(cm.GetConversationHistory()) != history {
+		t.Error("expected the conversation history to be left unchanged")
+	}
+
+	req := fake.Requests()[2]
+	var format openai.ResponseFormat
+	if err := json.Unmarshal(req.ResponseFormat, &format); err != nil || format.Type != "json_schema" || !format.JSONSchema.Strict {
+		t.Errorf("expected a strict json_schema response format, got %s", req.ResponseFormat)
+	}
+	if len(req.Tools) != 0 {
+		t.Errorf("expected no tools in the report request, got %v", req.ToolNames())
+	}
+	instruction := req.Messages[len(req.Messages)-1].Text()
+	if !strings.Contains(instruction, `- a: list_connections({"process_name":"nginx"})`) || !strings.Contains(instruction, "- b: list_packet_drops()") {
+		t.Errorf("expected the citable tool calls to be listed, got %q", instruction)
+	}
+}
+
+func TestExtractReport_RetriesInvalidReplies(t *testing.T) {
+	unknownCall := strings.Replace(validReport, `"tool_call_id":"b"`, `"tool_call_id":"z"`, 1)
+	cm, fake, run := analysedConversation(t, "Here are the findings: nginx is broken", unknownCall, validReport)
+
+	report, err := cm.ExtractReport(context.Background(), run)
+	if err != nil {
+		t.Fatalf("ExtractReport failed: %v", err)
+	}
+	if len(report.Findings) != 2 {
+		t.Errorf("expected the corrected report, got %+v", report)
+	}
+
+	requests := run.LLMRequests[len(run.LLMRequests)-3:]
+	if !strings.Contains(requests[0].Invalid, "not valid JSON") || !strings.Contains(requests[1].Invalid, `tool call "z", which was not made`) || requests[2].Invalid != "" {
+		t.Errorf("expected the rejections to be recorded, got %+v", requests)
+	}
+	retry := fake.Requests()[4].Messages
+	correction := retry[len(retry)-1].Text()
+	if retry[len(retry)-2].Text() != unknownCall || !strings.Contains(correction, "The report was rejected") {
+		t.Errorf("expected the rejected reply and its problems to be sent back, got %q", correction)
+	}
+	if run.Usage.TotalTokens != 45 {
+		t.Errorf("expected report requests to count toward usage, got %d", run.Usage.TotalTokens)
+	}
+	if trace := openai.FormatTrace(run); !strings.Contains(trace, "Report request") || !strings.Contains(trace, "⚠️ invalid report: reply is not valid JSON") {
+		t.Errorf("expected report requests in the trace, got:\n%s", trace)
+	}
+}
+
+func TestExtractReport_GivesUp(t *testing.T) {
+	badSeverity := strings.Replace(validReport, `"severity":"low"`, `"severity":"urgent"`, 1)
+	cm, _, run := analysedConversation(t, badSeverity, badSeverity, badSeverity)
+
+	_, err := cm.ExtractReport(context.Background(), run)
+	if err == nil || !strings.Contains(err.Error(), "no valid findings report after 3 attempts") || !strings.Contains(err.Error(), "schema") {
+		t.Errorf("expected a schema error after 3 attempts, got %v", err)
+	}
+	if run.Report != nil {
+		t.Errorf("expected no report on the run, got %+v", run.Report)
+	}
+}
+
+func TestParseReport_RequiresEvidence(t *testing.T) {
+	run := &openai.RunResult{ToolExecutions: []openai.ToolExecution{
+		{ID: "a", Name: "list_connections"},
+		{ID: "s", Name: "list_packet_drops", Skipped: true},
+	}}
+	for name, reply := range map[string]string{
+		"missing evidence": `{"summary":"","findings":[{"title":"t","severity":"low","description":"","process":"","pid":0,"destination":"","evidence":[],"recommendations":[]}]}`,
+		"skipped call":     `{"summary":"","findings":[{"title":"t","severity":"low","description":"","process":"","pid":0,"destination":"","evidence":[{"tool_call_id":"s","tool":"list_packet_drops","detail":""}],"recommendations":[]}]}`,
+		"wrong tool":       `{"summary":"","findings":[{"title":"t","severity":"low","description":"","process":"","pid":0,"destination":"","evidence":[{"tool_call_id":"a","tool":"list_packet_drops","detail":""}],"recommendations":[]}]}`,
+		"extra property":   `{"summary":"","findings":[],"confidence":"high"}`,
+	} {
+		if _, err := openai.ParseReport(reply, run); err == nil {
+			t.Errorf("%s: expected the report to be rejected", name)
+		}
+	}
+	if report, err := openai.ParseReport(`{"summary":"All quiet.","findings":[]}`, run); err != nil || len(report.Findings) != 0 {
+		t.Errorf("expected an empty report to be accepted, got %+v, %v", report, err)
+	}
+}
+
+func TestReportFormat_Providers(t *testing.T) {
+	format := &openai.ResponseFormat{Type: "json_schema", JSONSchema: &openai.JSONSchema{
+		Name: "network_findings", Strict: true, Schema: json.RawMessage(openai.ReportSchema),
+	}}
+	content := "findings"
+	req := openai.ChatRequest{Messages: []openai.ChatMessage{{Role: "user", Content: &content}}, ResponseFormat: format}
+
+	rs, baseURL := newRecordingServer(t, `{"message":{"role":"assistant","content":"{\"summary\":\"ok\",\"findings\":[]}"},"done":true,"done_reason":"stop"}`)
+	if _, err := openai.NewOllamaProvider(baseURL, "llama3.1").Chat(context.Background(), req); err != nil {
+		t.Fatalf("Ollama Chat failed: %v", err)
+	}
+	if schema, ok := rs.bodies[0]["format"].(map[string]any); !ok || schema["type"] != "object" {
+		t.Errorf("expected the schema as Ollama's format, got %v", rs.bodies[0]["format"])
+	}
+
+	rs, baseURL = newRecordingServer(t, `{"content":[{"type":"text","text":"Here you go."},{"type":"tool_use","id":"toolu_1","name":"network_findings","input":{"summary":"ok","findings":[]}}],"stop_reason":"tool_use","usage":{"input_tokens":10,"output_tokens":5}}`)
+	response, err := openai.NewAnthropicProvider(baseURL, "key", "").Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Anthropic Chat failed: %v", err)
+	}
+	choice := rs.bodies[0]["tool_choice"].(map[string]any)
+	if choice["type"] != "tool" || choice["name"] != "network_findings" {
+		t.Errorf("expected the report tool to be forced, got %v", choice)
+	}
+	message := response.Choices[0].Message
+	if message.Content == nil || *message.Content != `{"summary":"ok","findings":[]}` || len(message.ToolCalls) != 0 || response.Choices[0].FinishReason != "stop" {
+		t.Errorf("expected the tool input as the reply, got %+v", response.Choices[0])
+	}
+}
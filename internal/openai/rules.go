@@ -0,0 +1,596 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/utils"
+)
+
+// Thresholds of the offline rules
+const (
+	// dropRateHigh and dropRateMedium rate packet drops per connection attempt
+	dropRateHigh   = 0.10
+	dropRateMedium = 0.02
+	// failureRateHigh and failureRateMedium rate the share of a process's connects that failed
+	failureRateHigh   = 0.5
+	failureRateMedium = 0.2
+	// minFailures is how many failed connects make a process worth reporting
+	minFailures = 3
+	// baselineScoreHigh is the baseline deviation score reported as high severity
+	baselineScoreHigh = 60
+	// defaultRuleDuration is the window of queries that do not name one, in seconds
+	defaultRuleDuration = 60
+	// ruleTopDestinations bounds the destinations listed by a comprehensive analysis
+	ruleTopDestinations = 5
+)
+
+// RuleBasedAnalyst analyses network behaviour without a language model, for sites where none
+// is available. It runs the data tools directly and applies fixed rules for drop rates,
+// failure rates, unusual destinations and bursts, answering with a templated report.
+type RuleBasedAnalyst struct {
+	functions *FunctionCallManager
+	stream    StreamHandler
+	lastRun   *RunResult
+	report    *Report
+}
+
+// NewRuleBasedAnalyst creates an offline analyst that runs tools through mcpExecutor
+func NewRuleBasedAnalyst(mcpExecutor MCPToolExecutor, verbose bool) *RuleBasedAnalyst {
+	return &RuleBasedAnalyst{
+		functions: NewFunctionCallManagerWithFilter(mcpExecutor, ToolFilter{}, verbose),
+	}
+}
+
+// ruleScope selects the processes and time window a rule-based analysis looks at
+type ruleScope struct {
+	processName string
+	pid         int
+	duration    int
+}
+
+// arguments returns the tool arguments selecting the scope
+func (s ruleScope) arguments() map[string]any {
+	arguments := map[string]any{"duration": s.duration}
+	if s.processName != "" {
+		arguments["process_name"] = s.processName
+	}
+	if s.pid > 0 {
+		arguments["pid"] = s.pid
+	}
+	return arguments
+}
+
+func (s ruleScope) String() string {
+	switch {
+	case s.processName != "":
+		return fmt.Sprintf("process '%s'", s.processName)
+	case s.pid > 0:
+		return fmt.Sprintf("PID %d", s.pid)
+	default:
+		return "all processes"
+	}
+}
+
+// ruleData holds the tool results the rules are applied to. The IDs of checks that did not
+// return data are empty.
+type ruleData struct {
+	summary     utils.NetworkSummary
+	summaryID   string
+	drops       netclient.PacketDropSummaryOutput
+	dropsID     string
+	patterns    utils.PatternReport
+	patternsID  string
+	anomalies   utils.AnomalyReport
+	anomaliesID string
+	baseline    utils.BaselineReport
+	baselineID  string
+	// notes explain checks that did not return data
+	notes []string
+}
+
+// AnalyzeNetworkQuery cannot interpret the question without a language model, so it answers
+// with the network health check
+func (ra *RuleBasedAnalyst) AnalyzeNetworkQuery(ctx context.Context, query string) (string, error) {
+	title := fmt.Sprintf("Network health check for all processes (last %ds)", defaultRuleDuration)
+	return ra.analyze(ctx, query, title, ruleScope{duration: defaultRuleDuration}, false)
+}
+
+// AnalyzeProcess checks the network behaviour of one process, or of all when neither a name
+// nor a PID is given
+func (ra *RuleBasedAnalyst) AnalyzeProcess(ctx context.Context, processName string, pid int, duration int) (string, error) {
+	scope := ruleScope{processName: processName, pid: pid, duration: duration}
+	query := fmt.Sprintf("Analyze %s over the last %d seconds", scope, duration)
+	title := fmt.Sprintf("Network analysis of %s (last %ds)", scope, duration)
+	return ra.analyze(ctx, query, title, scope, false)
+}
+
+// GetNetworkHealth checks drops, failures, unusual destinations and bursts of all processes
+func (ra *RuleBasedAnalyst) GetNetworkHealth(ctx context.Context, duration int) (string, error) {
+	query := fmt.Sprintf("Network health over the last %d seconds", duration)
+	title := fmt.Sprintf("Network health check for all processes (last %ds)", duration)
+	return ra.analyze(ctx, query, title, ruleScope{duration: duration}, false)
+}
+
+// GetComprehensiveAnalysis runs the health check plus the baseline comparison and lists the
+// traffic breakdown
+func (ra *RuleBasedAnalyst) GetComprehensiveAnalysis(ctx context.Context, duration int) (string, error) {
+	query := fmt.Sprintf("Comprehensive analysis over the last %d seconds", duration)
+	title := fmt.Sprintf("Comprehensive network analysis of all processes (last %ds)", duration)
+	return ra.analyze(ctx, query, title, ruleScope{duration: duration}, true)
+}
+
+// ExtractReport returns the findings of the most recent analysis. They are the ones the
+// templated answer was rendered from, citing the tool calls of the checks.
+func (ra *RuleBasedAnalyst) ExtractReport(ctx context.Context) (*Report, error) {
+	if ra.lastRun == nil || ra.report == nil {
+		return nil, fmt.Errorf("no analysis to report on")
+	}
+	ra.lastRun.Report = ra.report
+	return ra.report, nil
+}
+
+// LastRun returns the checks made by the most recent analysis
+func (ra *RuleBasedAnalyst) LastRun() *RunResult {
+	return ra.lastRun
+}
+
+// SetStreamHandler sets a handler that receives the checks and the report as they happen
+func (ra *RuleBasedAnalyst) SetStreamHandler(handler StreamHandler) {
+	ra.stream = handler
+}
+
+// SetToolFilter selects the MCP tools the checks may call; checks of hidden tools are skipped
+func (ra *RuleBasedAnalyst) SetToolFilter(filter ToolFilter) {
+	ra.functions.SetToolFilter(filter)
+}
+
+// SetExecutionOptions sets how long each check may take
+func (ra *RuleBasedAnalyst) SetExecutionOptions(opts ExecutionOptions) {
+	ra.functions.SetExecutionOptions(opts)
+}
+
+func (ra *RuleBasedAnalyst) emit(event StreamEvent) {
+	if ra.stream != nil {
+		ra.stream(event)
+	}
+}
+
+// analyze runs the checks for scope, applies the rules and renders the report
+func (ra *RuleBasedAnalyst) analyze(ctx context.Context, query, title string, scope ruleScope, comprehensive bool) (string, error) {
+	run := &RunResult{
+		Query:      query,
+		Provider:   offlineProvider{}.Name(),
+		StartedAt:  time.Now(),
+		StopReason: StopComplete,
+	}
+	ra.lastRun, ra.report = run, nil
+	defer func() {
+		run.DurationMS = time.Since(run.StartedAt).Milliseconds()
+	}()
+
+	var data ruleData
+	arguments := scope.arguments()
+	data.summaryID = ra.check(ctx, run, &data, "get_network_summary", arguments, &data.summary)
+	data.dropsID = ra.check(ctx, run, &data, "get_packet_drop_summary", arguments, &data.drops)
+	data.patternsID = ra.check(ctx, run, &data, "analyze_patterns", arguments, &data.patterns)
+	data.anomaliesID = ra.check(ctx, run, &data, "detect_anomalies", arguments, &data.anomalies)
+	if comprehensive {
+		data.baselineID = ra.check(ctx, run, &data, "compare_to_baseline", arguments, &data.baseline)
+	}
+	if data.summaryID == "" && data.dropsID == "" && data.patternsID == "" && data.anomaliesID == "" {
+		return "", fmt.Errorf("no telemetry available: %s", strings.Join(data.notes, "; "))
+	}
+
+	report := &Report{Findings: applyRules(scope, &data, run)}
+	sortFindings(report.Findings)
+	report.Summary = ruleSummary(scope, report.Findings)
+	ra.report = report
+
+	run.Content = formatRuleReport(title, &data, report, comprehensive)
+	ra.emit(StreamEvent{Type: StreamText, Text: run.Content})
+	return run.Content, nil
+}
+
+// check runs one data tool and decodes its structured result into out. It returns the call ID
+// to cite as evidence, or an empty string when the tool was hidden, failed or returned no data.
+func (ra *RuleBasedAnalyst) check(ctx context.Context, run *RunResult, data *ruleData, name string, arguments map[string]any, out any) string {
+	encoded, _ := json.Marshal(arguments)
+	exec := ToolExecution{
+		ID:        fmt.Sprintf("check_%d", len(run.ToolExecutions)+1),
+		Name:      name,
+		Arguments: string(encoded),
+	}
+	ra.emit(StreamEvent{Type: StreamToolCall, Tool: name, Arguments: exec.Arguments})
+
+	if !ra.functions.filter.Allows(name) {
+		exec.Skipped = true
+		run.ToolExecutions = append(run.ToolExecutions, exec)
+		ra.emit(StreamEvent{Type: StreamToolResult, Tool: name, Execution: &exec})
+		data.notes = append(data.notes, fmt.Sprintf("%s: not allowed by the tool filter", name))
+		return ""
+	}
+
+	started := time.Now()
+	result, err := ra.functions.runTool(ctx, name, arguments)
+	exec.LatencyMS = time.Since(started).Milliseconds()
+	run.ToolCalls++
+
+	var problem string
+	switch {
+	case err != nil:
+		exec.TimedOut = ra.functions.execution.CallTimeout > 0 && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+		problem = err.Error()
+	case result.StructuredContent == nil:
+		problem = firstLine(resultText(result))
+	default:
+		if err := decodeStructured(result.StructuredContent, out); err != nil {
+			problem = err.Error()
+		}
+	}
+	if result != nil {
+		exec.ResultBytes = len(resultText(result))
+	}
+	run.ToolExecutions = append(run.ToolExecutions, exec)
+	ra.emit(StreamEvent{Type: StreamToolResult, Tool: name, Execution: &exec})
+
+	if problem != "" {
+		data.notes = append(data.notes, fmt.Sprintf("%s: %s", name, problem))
+		return ""
+	}
+	return exec.ID
+}
+
+// decodeStructured converts structured tool content, a Go value locally or decoded JSON from a
+// remote server, into out
+func decodeStructured(content any, out any) error {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("invalid structured result: %v", err)
+	}
+	if err := json.Unmarshal(encoded, out); err != nil {
+		return fmt.Errorf("unexpected structured result: %v", err)
+	}
+	return nil
+}
+
+// resultText joins the text content of a tool result
+func resultText(result *mcp.CallToolResult) string {
+	var parts []string
+	for _, content := range result.Content {
+		if text, ok := content.(*mcp.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if line == "" {
+		return "no data returned"
+	}
+	return line
+}
+
+// applyRules turns the tool results into findings, each citing the checks it is based on
+func applyRules(scope ruleScope, data *ruleData, run *RunResult) []Finding {
+	cite := func(id, detail string) Evidence {
+		for _, exec := range run.ToolExecutions {
+			if exec.ID == id {
+				return Evidence{ToolCallID: id, Tool: exec.Name, Arguments: exec.Arguments, Detail: detail}
+			}
+		}
+		return Evidence{ToolCallID: id, Detail: detail}
+	}
+
+	var findings []Finding
+	if data.dropsID != "" && data.drops.Count > 0 {
+		findings = append(findings, dropFinding(scope, data, cite))
+	}
+
+	if data.patternsID != "" {
+		for _, f := range data.patterns.Failures {
+			if f.Failures < minFailures {
+				continue
+			}
+			severity := SeverityLow
+			switch {
+			case f.Rate() >= failureRateHigh:
+				severity = SeverityHigh
+			case f.Rate() >= failureRateMedium:
+				severity = SeverityMedium
+			}
+			findings = append(findings, Finding{
+				Title:       fmt.Sprintf("Failing connections from %s", f.Command),
+				Severity:    severity,
+				Description: fmt.Sprintf("%d of %d connection attempts failed (%.0f%%), mostly to %s.", f.Failures, f.Attempts, 100*f.Rate(), f.Destination),
+				Process:     f.Command,
+				PID:         int(f.PID),
+				Destination: f.Destination,
+				Evidence:    []Evidence{cite(data.patternsID, fmt.Sprintf("%d failed connects by PID %d", f.Failures, f.PID))},
+				Recommendations: []string{
+					fmt.Sprintf("Check that the service at %s is running and accepting connections", f.Destination),
+					"Review firewall rules, network policies and DNS records on the path",
+				},
+			})
+		}
+
+		for _, p := range data.patterns.MetadataAccess {
+			findings = append(findings, Finding{
+				Title:       fmt.Sprintf("Cloud metadata endpoint contacted by %s", p.Command),
+				Severity:    SeverityMedium,
+				Description: fmt.Sprintf("%s made %d connections to the cloud metadata endpoint, which hands out instance credentials.", p.Command, p.Count),
+				Process:     p.Command,
+				PID:         int(p.PID),
+				Evidence:    []Evidence{cite(data.patternsID, fmt.Sprintf("%d cloud-metadata connections", p.Count))},
+				Recommendations: []string{
+					"Confirm the process needs instance credentials",
+					"Otherwise block metadata access, e.g. with IMDSv2 and a hop limit of 1 or a network policy",
+				},
+			})
+		}
+
+		for _, u := range data.patterns.UnusualPorts {
+			port := fmt.Sprintf("%d/%s", u.Port, strings.ToLower(u.Protocol))
+			if u.Service != "" {
+				port = fmt.Sprintf("%s (%s)", port, u.Service)
+			}
+			findings = append(findings, Finding{
+				Title:           fmt.Sprintf("Unusual port %s used by %s", port, u.Command),
+				Severity:        SeverityMedium,
+				Description:     fmt.Sprintf("%d connections to %s: %s.", u.Count, u.Destination, u.Reason),
+				Process:         u.Command,
+				PID:             int(u.PID),
+				Destination:     u.Destination,
+				Evidence:        []Evidence{cite(data.patternsID, u.Reason)},
+				Recommendations: []string{fmt.Sprintf("Confirm that %s is expected to talk to %s", u.Command, u.Destination)},
+			})
+		}
+
+		for _, b := range data.patterns.Beacons {
+			findings = append(findings, Finding{
+				Title:       fmt.Sprintf("Periodic connections from %s", b.Command),
+				Severity:    SeverityMedium,
+				Description: fmt.Sprintf("%d connections to %s at a regular interval of %.0fs (periodicity score %.2f).", b.Count, b.Destination, b.PeriodSeconds, b.PeriodicityScore),
+				Process:     b.Command,
+				PID:         int(b.PID),
+				Destination: b.Destination,
+				Evidence:    []Evidence{cite(data.patternsID, fmt.Sprintf("beacon every %.0fs", b.PeriodSeconds))},
+				Recommendations: []string{
+					"Verify the destination is an expected health check, poller or update service",
+					"Investigate unexplained beacons as possible command-and-control traffic",
+				},
+			})
+		}
+	}
+
+	if data.anomaliesID != "" {
+		for _, a := range data.anomalies.Anomalies {
+			findings = append(findings, anomalyFinding(a, cite(data.anomaliesID, a.Description)))
+		}
+	}
+
+	if data.baselineID != "" {
+		for _, d := range data.baseline.Processes {
+			if d.Status != analysis.StatusDeviating {
+				continue
+			}
+			severity := SeverityMedium
+			if d.Score >= baselineScoreHigh {
+				severity = SeverityHigh
+			}
+			description := fmt.Sprintf("Deviation score %d of 100 against %d learned windows (%.1f connections/min, usually %.1f-%.1f).",
+				d.Score, d.Snapshots, d.Rate, d.BaselineMinRate, d.BaselineMaxRate)
+			if len(d.NewDestinations) > 0 {
+				description += fmt.Sprintf(" New destinations: %s.", strings.Join(d.NewDestinations, ", "))
+			}
+			destination := ""
+			if len(d.NewDestinations) == 1 {
+				destination = d.NewDestinations[0]
+			}
+			findings = append(findings, Finding{
+				Title:           fmt.Sprintf("%s deviates from its baseline", d.Command),
+				Severity:        severity,
+				Description:     description,
+				Process:         d.Command,
+				Destination:     destination,
+				Evidence:        []Evidence{cite(data.baselineID, fmt.Sprintf("score %d", d.Score))},
+				Recommendations: []string{"Check recent deploys and configuration changes of the process, then learn the new behaviour if it is expected"},
+			})
+		}
+	}
+	return findings
+}
+
+// dropFinding rates packet drops against the connection attempts of the same window
+func dropFinding(scope ruleScope, data *ruleData, cite func(id, detail string) Evidence) Finding {
+	drops := data.drops.Count
+	evidence := []Evidence{cite(data.dropsID, fmt.Sprintf("%d packet drops", drops))}
+
+	severity := SeverityLow
+	description := fmt.Sprintf("%d packet drops in the last %ds", drops, scope.duration)
+	if data.summaryID != "" && data.summary.Count > 0 {
+		rate := float64(drops) / float64(data.summary.Count)
+		switch {
+		case rate >= dropRateHigh:
+			severity = SeverityHigh
+		case rate >= dropRateMedium:
+			severity = SeverityMedium
+		}
+		description += fmt.Sprintf(", %.1f per 100 connection attempts.", 100*rate)
+		evidence = append(evidence, cite(data.summaryID, fmt.Sprintf("%d connection attempts", data.summary.Count)))
+	} else {
+		// Drops without connections of their own are usually inbound traffic being refused
+		severity = SeverityMedium
+		description += " with no outbound connection attempts to compare against."
+	}
+
+	return Finding{
+		Title:       fmt.Sprintf("Packet drops for %s", scope),
+		Severity:    severity,
+		Description: description,
+		Process:     scope.processName,
+		PID:         scope.pid,
+		Evidence:    evidence,
+		Recommendations: []string{
+			"List the drop reasons with list_packet_drops: resets point at the peer, netfilter drops at local firewall rules",
+			"Check interface errors and socket buffer overflows with ip -s link and netstat -s",
+		},
+	}
+}
+
+// anomalyFinding restates an anomaly detector result as a finding
+func anomalyFinding(a analysis.Anomaly, evidence Evidence) Finding {
+	finding := Finding{
+		Severity:    string(a.Severity),
+		Description: a.Description + ".",
+		Process:     a.Command,
+		PID:         int(a.PID),
+		Evidence:    []Evidence{evidence},
+	}
+	switch a.Type {
+	case analysis.AnomalyBurst:
+		finding.Title = fmt.Sprintf("Connection burst from %s", a.Command)
+		finding.Recommendations = []string{"Check whether a job, retry loop or traffic spike explains the burst"}
+	case analysis.AnomalyConnectionStorm:
+		finding.Title = fmt.Sprintf("Retry storm from %s", a.Command)
+		finding.Recommendations = []string{"Fix the failing dependency and add backoff to the client's retries"}
+	case analysis.AnomalyVerticalScan:
+		finding.Title = fmt.Sprintf("Port scan of %s by %s", a.Target, a.Command)
+		finding.Destination = a.Target
+		finding.Recommendations = []string{"Confirm the scan is authorized; otherwise isolate the host and investigate the process"}
+	case analysis.AnomalyHorizontalScan:
+		finding.Title = fmt.Sprintf("Host sweep on %s by %s", a.Target, a.Command)
+		finding.Recommendations = []string{"Confirm the sweep is authorized; otherwise isolate the host and investigate the process"}
+	default:
+		finding.Title = fmt.Sprintf("%s anomaly in %s", a.Type, a.Command)
+	}
+	return finding
+}
+
+// ruleSummary counts findings by severity
+func ruleSummary(scope ruleScope, findings []Finding) string {
+	if len(findings) == 0 {
+		return fmt.Sprintf("No issues found for %s in the last %ds.", scope, scope.duration)
+	}
+	counts := make(map[string]int)
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	var parts []string
+	for _, severity := range []string{SeverityHigh, SeverityMedium, SeverityLow} {
+		if counts[severity] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[severity], severity))
+		}
+	}
+	noun := "findings"
+	if len(findings) == 1 {
+		noun = "finding"
+	}
+	return fmt.Sprintf("%d %s (%s) for %s in the last %ds.", len(findings), noun, strings.Join(parts, ", "), scope, scope.duration)
+}
+
+// ruleIcons mark findings by severity in the templated report
+var ruleIcons = map[string]string{SeverityHigh: "🔴", SeverityMedium: "🟠", SeverityLow: "🟡"}
+
+// formatRuleReport renders the templated Markdown answer of a rule-based analysis
+func formatRuleReport(title string, data *ruleData, report *Report, comprehensive bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", title)
+	b.WriteString("_Offline rule-based analysis: no language model was used._\n\n")
+
+	var activity []string
+	if data.summaryID != "" {
+		activity = append(activity, fmt.Sprintf("%d connection attempts", data.summary.Count))
+	}
+	if data.patternsID != "" {
+		failed := 0
+		for _, f := range data.patterns.Failures {
+			failed += f.Failures
+		}
+		activity = append(activity, fmt.Sprintf("%d failed connects", failed))
+	}
+	if data.dropsID != "" {
+		activity = append(activity, fmt.Sprintf("%d packet drops", data.drops.Count))
+	}
+	if len(activity) > 0 {
+		fmt.Fprintf(&b, "**Activity:** %s.\n\n", strings.Join(activity, ", "))
+	}
+	fmt.Fprintf(&b, "**Summary:** %s\n", report.Summary)
+
+	if len(report.Findings) > 0 {
+		b.WriteString("\n### Findings\n")
+		for i, f := range report.Findings {
+			fmt.Fprintf(&b, "\n%d. %s **%s: %s**", i+1, ruleIcons[f.Severity], strings.ToUpper(f.Severity[:1])+f.Severity[1:], f.Title)
+			if affected := affectedTarget(f); affected != "" {
+				fmt.Fprintf(&b, " (%s)", affected)
+			}
+			fmt.Fprintf(&b, "\n   %s\n", f.Description)
+			for _, e := range f.Evidence {
+				fmt.Fprintf(&b, "   - Evidence: %s (%s): %s\n", e.Tool, e.ToolCallID, e.Detail)
+			}
+			for _, r := range f.Recommendations {
+				fmt.Fprintf(&b, "   - Recommendation: %s\n", r)
+			}
+		}
+	}
+
+	if comprehensive && data.patternsID != "" {
+		b.WriteString("\n### Traffic\n\n")
+		if destinations := data.patterns.TopDestinations; len(destinations) > 0 {
+			if len(destinations) > ruleTopDestinations {
+				destinations = destinations[:ruleTopDestinations]
+			}
+			var top []string
+			for _, d := range destinations {
+				top = append(top, fmt.Sprintf("%s (%d)", d.Key, d.Count))
+			}
+			fmt.Fprintf(&b, "- Top destinations: %s\n", strings.Join(top, ", "))
+		}
+		for _, section := range []struct {
+			name   string
+			counts []utils.GroupCount
+		}{{"Protocols", data.patterns.Protocols}, {"Scopes", data.patterns.Scopes}, {"Services", data.patterns.Services}} {
+			if len(section.counts) == 0 {
+				continue
+			}
+			var parts []string
+			for _, c := range section.counts {
+				parts = append(parts, fmt.Sprintf("%s (%d)", c.Key, c.Count))
+			}
+			fmt.Fprintf(&b, "- %s: %s\n", section.name, strings.Join(parts, ", "))
+		}
+	}
+
+	if len(data.notes) > 0 {
+		b.WriteString("\n### Checks without data\n\n")
+		for _, note := range data.notes {
+			fmt.Fprintf(&b, "- %s\n", note)
+		}
+	}
+	return b.String()
+}
+
+// affectedTarget describes the process and destination of a finding
+func affectedTarget(f Finding) string {
+	process := f.Process
+	if f.PID > 0 {
+		process = strings.TrimSpace(fmt.Sprintf("%s PID %d", f.Process, f.PID))
+	}
+	switch {
+	case process != "" && f.Destination != "":
+		return process + " → " + f.Destination
+	case process != "":
+		return process
+	default:
+		return f.Destination
+	}
+}
@@ -0,0 +1,186 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/openai"
+	"github.com/srodi/netspy/internal/utils"
+)
+
+// newRuleExecutor returns an executor whose data tools report an unhealthy network: many
+// drops, a process failing most connects, a burst and cloud metadata access
+func newRuleExecutor() *fakeExecutor {
+	executor := newFakeExecutor()
+	executor.structured = map[string]any{
+		"get_network_summary":     utils.NetworkSummary{Target: "all processes", DurationSeconds: 300, Count: 100},
+		"get_packet_drop_summary": netclient.PacketDropSummaryOutput{Count: 30, DurationSeconds: 300},
+		"analyze_patterns": utils.PatternReport{
+			Failures: []utils.ProcessFailures{
+				{PID: 42, Command: "curl", Attempts: 6, Failures: 5, Destination: "10.0.0.5:5432"},
+				{PID: 7, Command: "dig", Attempts: 40, Failures: 2, Destination: "10.0.0.2:53"},
+			},
+			MetadataAccess: []utils.ProcessCount{{PID: 9, Command: "agent", Count: 3}},
+		},
+		"detect_anomalies": utils.AnomalyReport{Anomalies: []analysis.Anomaly{
+			{Type: analysis.AnomalyBurst, Severity: analysis.SeverityLow, PID: 42, Command: "curl", Target: "all destinations", Description: "120 connections in 10s"},
+		}},
+		"compare_to_baseline": utils.BaselineReport{Processes: []analysis.Deviation{
+			{Command: "curl", Status: analysis.StatusDeviating, Score: 75, NewDestinations: []string{"203.0.113.9:443"}},
+			{Command: "nginx", Status: analysis.StatusNormal},
+		}},
+	}
+	return executor
+}
+
+func TestRuleBasedAnalyst_NetworkHealth(t *testing.T) {
+	executor := newRuleExecutor()
+	analyst := openai.NewRuleBasedAnalyst(executor, false)
+	var events []openai.StreamEvent
+	analyst.SetStreamHandler(func(event openai.StreamEvent) { events = append(events, event) })
+
+	answer, err := analyst.GetNetworkHealth(context.Background(), 300)
+	if err != nil {
+		t.Fatalf("GetNetworkHealth failed: %v", err)
+	}
+	for _, want := range []string{"no language model was used", "100 connection attempts, 7 failed connects, 30 packet drops", "Failing connections from curl", "Recommendation:"} {
+		if !strings.Contains(answer, want) {
+			t.Errorf("expected %q in the answer, got:\n%s", want, answer)
+		}
+	}
+	if strings.Contains(answer, "dig") {
+		t.Error("expected processes with fewer than 3 failures to be ignored")
+	}
+
+	var tools []string
+	for _, call := range executor.calls {
+		tools = append(tools, call.Tool)
+		if call.Arguments["duration"] != 300 {
+			t.Errorf("expected %s to cover the requested window, got %v", call.Tool, call.Arguments)
+		}
+	}
+	if strings.Join(tools, ",") != "get_network_summary,get_packet_drop_summary,analyze_patterns,detect_anomalies" {
+		t.Errorf("unexpected checks: %v", tools)
+	}
+	if len(events) != 9 || events[len(events)-1].Text != answer {
+		t.Errorf("expected a call and result per check followed by the answer, got %d events", len(events))
+	}
+
+	report, err := analyst.ExtractReport(context.Background())
+	if err != nil {
+		t.Fatalf("ExtractReport failed: %v", err)
+	}
+	var severities []string
+	for _, f := range report.Findings {
+		severities = append(severities, f.Severity)
+	}
+	// drops 30%, curl failing 83%, metadata access, burst
+	if strings.Join(severities, ",") != "high,high,medium,low" {
+		t.Errorf("unexpected findings %+v", report.Findings)
+	}
+	if !strings.HasPrefix(report.Summary, "4 findings (2 high, 1 medium, 1 low)") {
+		t.Errorf("unexpected summary %q", report.Summary)
+	}
+
+	// The report must pass the same validation as model-written ones, which carry no arguments
+	run := analyst.LastRun()
+	cited := *report
+	cited.Findings = nil
+	for _, f := range report.Findings {
+		f.Evidence = append([]openai.Evidence(nil), f.Evidence...)
+		for i := range f.Evidence {
+			f.Evidence[i].Arguments = ""
+		}
+		cited.Findings = append(cited.Findings, f)
+	}
+	data, _ := json.Marshal(cited)
+	if _, err := openai.ParseReport(string(data), run); err != nil {
+		t.Errorf("expected a valid findings report, got %v", err)
+	}
+	if run.Report != report || run.Provider != "Offline rules" || len(run.LLMRequests) != 0 {
+		t.Errorf("unexpected run %+v", run)
+	}
+	if trace := openai.FormatTrace(run); !strings.Contains(trace, "Rule-based checks") || !strings.Contains(trace, "└─ detect_anomalies") {
+		t.Errorf("expected the checks in the trace, got:\n%s", trace)
+	}
+}
+
+func TestRuleBasedAnalyst_ComprehensiveAndMissingChecks(t *testing.T) {
+	executor := newRuleExecutor()
+	executor.errors["analyze_patterns"] = errors.New("eBPF server unreachable")
+	analyst := openai.NewRuleBasedAnalyst(executor, false)
+	analyst.SetToolFilter(openai.ToolFilter{Deny: []string{"detect_anomalies"}})
+
+	answer, err := analyst.GetComprehensiveAnalysis(context.Background(), 60)
+	if err != nil {
+		t.Fatalf("GetComprehensiveAnalysis failed: %v", err)
+	}
+	for _, want := range []string{"curl deviates from its baseline", "analyze_patterns: eBPF server unreachable", "detect_anomalies: not allowed by the tool filter"} {
+		if !strings.Contains(answer, want) {
+			t.Errorf("expected %q in the answer, got:\n%s", want, answer)
+		}
+	}
+
+	report, _ := analyst.ExtractReport(context.Background())
+	if len(report.Findings) != 2 || report.Findings[1].Severity != "high" || report.Findings[1].Destination != "203.0.113.9:443" {
+		t.Errorf("expected the drops and a high baseline deviation, got %+v", report.Findings)
+	}
+	run := analyst.LastRun()
+	if run.ToolCalls != 4 || !run.ToolExecutions[3].Skipped {
+		t.Errorf("expected the hidden check to be skipped, got %+v", run.ToolExecutions)
+	}
+}
+
+func TestRuleBasedAnalyst_Process(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.structured = map[string]any{
+		"get_network_summary": map[string]any{"count": 12},
+	}
+	analyst := openai.NewRuleBasedAnalyst(executor, false)
+
+	answer, err := analyst.AnalyzeProcess(context.Background(), "nginx", 0, 60)
+	if err != nil {
+		t.Fatalf("AnalyzeProcess failed: %v", err)
+	}
+	if !strings.Contains(answer, "No issues found for process 'nginx'") {
+		t.Errorf("expected a clean report, got:\n%s", answer)
+	}
+	if executor.calls[0].Arguments["process_name"] != "nginx" {
+		t.Errorf("expected the checks to be limited to the process, got %v", executor.calls[0].Arguments)
+	}
+
+	executor.structured = nil
+	if _, err := analyst.AnalyzeProcess(context.Background(), "nginx", 0, 60); err == nil || !strings.Contains(err.Error(), "no telemetry available") {
+		t.Errorf("expected an error without any data, got %v", err)
+	}
+	if _, err := analyst.ExtractReport(context.Background()); err == nil {
+		t.Error("expected no report for a failed analysis")
+	}
+}
+
+func TestCheckProvider(t *testing.T) {
+	clearLLMEnv(t)
+
+	offline, err := openai.NewProvider(openai.ProviderConfig{Provider: "offline"})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	if err := openai.CheckProvider(offline); !errors.Is(err, openai.ErrOffline) {
+		t.Errorf("expected ErrOffline, got %v", err)
+	}
+	if _, err := offline.Chat(context.Background(), openai.ChatRequest{}); !errors.Is(err, openai.ErrOffline) {
+		t.Errorf("expected offline Chat to fail with ErrOffline, got %v", err)
+	}
+
+	if err := openai.CheckProvider(openai.DefaultProvider()); err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY") {
+		t.Errorf("expected the missing OpenAI key to be reported, got %v", err)
+	}
+	if err := openai.CheckProvider(openai.NewOllamaProvider("", "")); err != nil {
+		t.Errorf("expected Ollama to need no key, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package openai
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxStreamLine bounds a single line of a streamed response
+const maxStreamLine = 1 << 20
+
+// errStreamDone ends reading a stream before the server closes it
+var errStreamDone = errors.New("stream done")
+
+// isEventStream reports whether a successful response carries server-sent events. Servers
+// that ignore the stream parameter answer with a normal JSON body instead.
+func isEventStream(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// readEvents calls fn with the name and data of each server-sent event in r until the stream
+// ends or fn returns errStreamDone
+func readEvents(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		err := fn(event, strings.Join(data, "\n"))
+		event, data = "", nil
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return ignoreDone(err)
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, used by some servers as a keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %v", err)
+	}
+	// A final event without a trailing blank line still counts
+	return ignoreDone(dispatch())
+}
+
+// readLines calls fn with each non-empty line of a newline-delimited JSON stream
+func readLines(r io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return ignoreDone(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %v", err)
+	}
+	return nil
+}
+
+func ignoreDone(err error) error {
+	if err == errStreamDone {
+		return nil
+	}
+	return err
+}
+
+// deliverText passes the text of a response that arrived in one piece to onText
+func deliverText(response *ChatResponse, onText func(string)) {
+	if len(response.Choices) == 0 {
+		return
+	}
+	if content := response.Choices[0].Message.Content; content != nil && *content != "" {
+		onText(*content)
+	}
+}
@@ -0,0 +1,39 @@
+package openai
+
+// StreamEventType identifies what a StreamEvent reports
+type StreamEventType string
+
+const (
+	StreamText       StreamEventType = "text"        // Assistant text arrived; Text holds the new fragment
+	StreamToolCall   StreamEventType = "tool_call"   // The model requested a tool that is about to run
+	StreamToolResult StreamEventType = "tool_result" // A requested tool finished or was skipped
+)
+
+// StreamEvent reports progress while a message is being answered
+type StreamEvent struct {
+	Type      StreamEventType
+	Text      string
+	Tool      string
+	Arguments string
+	// Execution describes a finished or skipped tool call for StreamToolResult events
+	Execution *ToolExecution
+}
+
+// StreamHandler receives stream events in the order they happen. It is called from the
+// goroutine running the conversation and should return quickly.
+type StreamHandler func(StreamEvent)
+
+// emit sends an event to the stream handler, if one is set
+func (cm *ConversationManager) emit(event StreamEvent) {
+	if cm.stream != nil {
+		cm.stream(event)
+	}
+}
+
+// emitToolResults reports the tool executions recorded since index from
+func (cm *ConversationManager) emitToolResults(run *RunResult, from int) {
+	for i := from; i < len(run.ToolExecutions); i++ {
+		exec := run.ToolExecutions[i]
+		cm.emit(StreamEvent{Type: StreamToolResult, Tool: exec.Name, Arguments: exec.Arguments, Execution: &exec})
+	}
+}
@@ -0,0 +1,131 @@
+package openai_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+func TestRun_StreamsTextAndToolActivity(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{Content: "Checking.", ToolCalls: []fakeopenai.ToolCall{{ID: "a", Name: "list_connections"}, {ID: "b", Name: "list_packet_drops"}}},
+		fakeopenai.Turn{Content: "All good."},
+	)
+	cm.SetLimits(openai.Limits{MaxToolCalls: 1})
+
+	var events []string
+	cm.SetStreamHandler(func(event openai.StreamEvent) {
+		switch event.Type {
+		case openai.StreamText:
+			events = append(events, "text:"+event.Text)
+		case openai.StreamToolCall:
+			events = append(events, "call:"+event.Tool)
+		case openai.StreamToolResult:
+			status := "done"
+			if event.Execution.Skipped {
+				status = "skipped"
+			}
+			events = append(events, "result:"+event.Tool+":"+status)
+		}
+	})
+
+	if _, err := cm.Run(context.Background(), "question"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := []string{
+		"text:Checking.",
+		"call:list_connections",
+		"result:list_connections:done",
+		"result:list_packet_drops:skipped",
+		"text:All ",
+		"text:good.",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+}
+
+func TestRun_StreamsToolCallDeltas(t *testing.T) {
+	executor := newFakeExecutor()
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "a", Name: "list_connections", Arguments: `{"pid":1234,"max_events":20}`}}, PromptTokens: 100, CompletionTokens: 10},
+		fakeopenai.Turn{Content: "curl made 3 connections", PromptTokens: 150, CompletionTokens: 20},
+	)
+	var text strings.Builder
+	cm.SetStreamHandler(func(event openai.StreamEvent) {
+		if event.Type == openai.StreamText {
+			text.WriteString(event.Text)
+		}
+	})
+
+	run, err := cm.Run(context.Background(), "what did curl do?")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if text.String() != "curl made 3 connections" || run.Content != "curl made 3 connections" {
+		t.Errorf("expected the streamed text to match the answer, got %q and %q", text.String(), run.Content)
+	}
+	if len(executor.calls) != 1 || executor.calls[0].Arguments["pid"] != float64(1234) || executor.calls[0].Arguments["max_events"] != float64(20) {
+		t.Errorf("expected the streamed arguments to be assembled, got %+v", executor.calls)
+	}
+	if run.Usage.TotalTokens != 280 || !run.LLMRequests[0].Streamed || run.LLMRequests[0].FinishReason != "tool_calls" {
+		t.Errorf("expected usage and finish reasons from the stream, got %+v", run)
+	}
+	for _, req := range fake.Requests() {
+		if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("expected streamed requests with usage, got %+v", req)
+		}
+	}
+}
+
+func TestRun_DoesNotStreamWithoutHandler(t *testing.T) {
+	cm, fake := newConversation(t, newFakeExecutor(), fakeopenai.Turn{Content: "all good"})
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if fake.Requests()[0].Stream || run.LLMRequests[0].Streamed {
+		t.Error("expected a plain request when nobody listens to the stream")
+	}
+}
+
+func TestRun_StreamErrorChunk(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(), fakeopenai.Turn{Error: "model overloaded"})
+	cm.SetStreamHandler(func(openai.StreamEvent) {})
+
+	_, err := cm.Run(context.Background(), "question")
+	if err == nil || !strings.Contains(err.Error(), "model overloaded") {
+		t.Errorf("expected the streamed error, got %v", err)
+	}
+}
+
+func TestConversation_FollowUpKeepsContext(t *testing.T) {
+	cm, fake := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{Content: "curl made 3 connections"},
+		fakeopenai.Turn{Content: "all to port 443"},
+	)
+	cm.AddSystemMessage("system prompt")
+
+	if _, err := cm.ProcessMessage(context.Background(), "what did curl do?"); err != nil {
+		t.Fatalf("first message failed: %v", err)
+	}
+	if _, err := cm.ProcessMessage(context.Background(), "which ports?"); err != nil {
+		t.Fatalf("follow-up failed: %v", err)
+	}
+	var texts []string
+	for _, message := range fake.Requests()[1].Messages {
+		texts = append(texts, message.Text())
+	}
+	if want := []string{"system prompt", "what did curl do?", "curl made 3 connections", "which ports?"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("expected the follow-up to carry the earlier turn, got %v", texts)
+	}
+
+	cm.ClearConversation()
+	if cm.LastRun() != nil || len(cm.GetConversationHistory()) != 0 {
+		t.Error("expected ClearConversation to drop the history and last run")
+	}
+}
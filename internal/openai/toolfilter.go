@@ -0,0 +1,59 @@
+package openai
+
+import (
+	"path"
+	"strings"
+)
+
+// Consumers of function calling, used to select per-consumer tool filters
+const (
+	ConsumerContextualAnalysis = "contextual_analysis"
+)
+
+// DefaultDeniedTools are excluded from function calling unless a filter sets its own deny list.
+// They start LLM conversations themselves, so exposing them lets an analyst call itself.
+var DefaultDeniedTools = []string{"contextual_analysis", "ai_insights"}
+
+// ToolFilter selects the MCP tools exposed to the model. Names may use path.Match wildcards,
+// e.g. "list_*". Deny takes precedence over Allow.
+type ToolFilter struct {
+	// Allow lists the tools to expose (default: all)
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists tools to hide (default: DefaultDeniedTools; an empty list hides nothing)
+	Deny []string `json:"deny"`
+}
+
+// Allows reports whether the filter exposes the named tool
+func (f ToolFilter) Allows(name string) bool {
+	deny := f.Deny
+	if deny == nil {
+		deny = DefaultDeniedTools
+	}
+	if matchesAny(deny, name) {
+		return false
+	}
+	return len(f.Allow) == 0 || matchesAny(f.Allow, name)
+}
+
+// ParseToolList splits a comma-separated list of tool names or patterns
+func ParseToolList(list string) []string {
+	tools := make([]string, 0)
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tools = append(tools, name)
+		}
+	}
+	return tools
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package openai_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+func TestToolFilter_Allows(t *testing.T) {
+	tests := []struct {
+		filter openai.ToolFilter
+		tool   string
+		want   bool
+	}{
+		{openai.ToolFilter{}, "list_connections", true},
+		{openai.ToolFilter{}, "contextual_analysis", false},
+		{openai.ToolFilter{}, "ai_insights", false},
+		{openai.ToolFilter{Deny: []string{}}, "contextual_analysis", true},
+		{openai.ToolFilter{Allow: []string{"list_*"}}, "list_packet_drops", true},
+		{openai.ToolFilter{Allow: []string{"list_*"}}, "get_network_summary", false},
+		{openai.ToolFilter{Allow: []string{"*"}}, "ai_insights", false},
+		{openai.ToolFilter{Allow: []string{"list_*"}, Deny: []string{"list_packet_drops"}}, "list_packet_drops", false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Allows(tt.tool); got != tt.want {
+			t.Errorf("%+v.Allows(%q) = %v, want %v", tt.filter, tt.tool, got, tt.want)
+		}
+	}
+}
+
+func TestParseToolList(t *testing.T) {
+	got := openai.ParseToolList(" list_connections, ,analyze_*,")
+	if want := []string{"list_connections", "analyze_*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseToolList = %v, want %v", got, want)
+	}
+}
+
+func newExecutorWithMetaTools() *fakeExecutor {
+	executor := newFakeExecutor()
+	for _, name := range []string{"contextual_analysis", "ai_insights"} {
+		executor.tools[name] = &mcp.Tool{Name: name, Description: "LLM tool"}
+		executor.outputs[name] = "nested analysis"
+	}
+	return executor
+}
+
+func functionNames(fm *openai.FunctionCallManager) []string {
+	var names []string
+	for _, fn := range fm.GetFunctions() {
+		names = append(names, fn.Name)
+	}
+	return names
+}
+
+func TestFunctionCallManager_ExcludesLLMToolsByDefault(t *testing.T) {
+	fm := openai.NewFunctionCallManager(newExecutorWithMetaTools(), false)
+	if got, want := functionNames(fm), []string{"list_connections", "list_packet_drops"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	fm.SetToolFilter(openai.ToolFilter{Allow: []string{"*_analysis", "list_connections"}, Deny: []string{}})
+	if got, want := functionNames(fm), []string{"contextual_analysis", "list_connections"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after SetToolFilter, got %v", want, got)
+	}
+}
+
+func TestConversation_DeniedToolIsNotExecuted(t *testing.T) {
+	executor := newExecutorWithMetaTools()
+	cm, fake := newConversation(t, executor,
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "nested", Name: "contextual_analysis", Arguments: `{"query": "again"}`}}},
+		fakeopenai.Turn{Content: "answer"},
+	)
+
+	if _, err := cm.ProcessMessage(context.Background(), "question"); err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	if len(executor.calls) != 0 {
+		t.Errorf("expected denied tool not to run, got %v", executor.calls)
+	}
+	for _, name := range fake.Requests()[0].ToolNames() {
+		if name == "contextual_analysis" || name == "ai_insights" {
+			t.Errorf("expected %s to be withheld from the model", name)
+		}
+	}
+	messages := fake.Requests()[1].Messages
+	assertToolResult(t, messages[len(messages)-1], "nested", "unknown function: contextual_analysis")
+}
+
+func TestAnalyst_ToolFilter(t *testing.T) {
+	executor := newExecutorWithMetaTools()
+	fake, baseURL := newFakeOpenAI(t, fakeopenai.Turn{Content: "ok"})
+	analyst := openai.NewContextualNetworkAnalystWithProvider(executor, openai.NewOpenAIProvider(baseURL, "key", ""), false)
+	analyst.SetToolFilter(openai.ToolFilter{Allow: []string{"list_packet_drops"}})
+
+	if _, err := analyst.AnalyzeNetworkQuery(context.Background(), "drops?"); err != nil {
+		t.Fatalf("AnalyzeNetworkQuery failed: %v", err)
+	}
+	if got := fake.Requests()[0].ToolNames(); !reflect.DeepEqual(got, []string{"list_packet_drops"}) {
+		t.Errorf("expected only list_packet_drops, got %v", got)
+	}
+}
@@ -0,0 +1,134 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxTraceArguments caps the tool arguments shown per line of a formatted trace
+const maxTraceArguments = 120
+
+// FormatTrace renders the trace of a run as a tree of LLM requests and the tool calls each
+// one asked for
+func FormatTrace(run *RunResult) string {
+	if run == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	model := run.Provider
+	if run.Model != "" {
+		model += " " + run.Model
+	}
+	fmt.Fprintf(&b, "🔎 Analysis trace (%s)\n", model)
+	fmt.Fprintf(&b, "   %d LLM requests, %d tool calls in %s, %d tokens (%d prompt, %d completion), stop: %s\n",
+		len(run.LLMRequests), run.ToolCalls, time.Duration(run.DurationMS)*time.Millisecond,
+		run.Usage.TotalTokens, run.Usage.PromptTokens, run.Usage.CompletionTokens, run.StopReason)
+	if run.SummarizedMessages > 0 {
+		fmt.Fprintf(&b, "   %d earlier messages replaced by a summary\n", run.SummarizedMessages)
+	}
+
+	for _, req := range run.LLMRequests {
+		switch {
+		case req.Summary:
+			fmt.Fprintf(&b, "\n%d. Summary request (%d messages, ~%d tokens) %dms", req.Round, req.Messages, req.EstimatedTokens, req.LatencyMS)
+		case req.Report:
+			fmt.Fprintf(&b, "\n%d. Report request (%d messages, ~%d tokens) %dms", req.Round, req.Messages, req.EstimatedTokens, req.LatencyMS)
+		default:
+			fmt.Fprintf(&b, "\n%d. LLM request (tool_choice %s, %d messages, ~%d tokens) %dms", req.Round, req.ToolChoice, req.Messages, req.EstimatedTokens, req.LatencyMS)
+		}
+		if req.Usage != nil {
+			fmt.Fprintf(&b, ", %d tokens", req.Usage.TotalTokens)
+		}
+		if req.Error != "" {
+			fmt.Fprintf(&b, "\n   ❌ %s\n", req.Error)
+			continue
+		}
+		fmt.Fprintf(&b, ", finish: %s\n", firstNonEmpty(req.FinishReason, "unknown"))
+		if req.Invalid != "" {
+			fmt.Fprintf(&b, "   ⚠️ invalid report: %s\n", req.Invalid)
+		}
+
+		writeExecutions(&b, run.ToolExecutions, req.Round)
+	}
+
+	// Rule-based analyses run their checks without any LLM request
+	if len(run.LLMRequests) == 0 && len(run.ToolExecutions) > 0 {
+		b.WriteString("\nRule-based checks\n")
+		writeExecutions(&b, run.ToolExecutions, 0)
+	}
+	return b.String()
+}
+
+// writeExecutions writes the tool calls of one round as branches of the trace tree
+func writeExecutions(b *strings.Builder, all []ToolExecution, round int) {
+	var executions []ToolExecution
+	for _, exec := range all {
+		if exec.Round == round {
+			executions = append(executions, exec)
+		}
+	}
+	for i, exec := range executions {
+		branch := "├─"
+		if i == len(executions)-1 {
+			branch = "└─"
+		}
+		fmt.Fprintf(b, "   %s %s(%s) ", branch, exec.Name, traceArguments(exec.Arguments))
+		switch {
+		case exec.Skipped:
+			b.WriteString("skipped\n")
+		case exec.TimedOut:
+			fmt.Fprintf(b, "timed out after %dms\n", exec.LatencyMS)
+		case exec.Truncated:
+			fmt.Fprintf(b, "→ %d bytes (truncated) in %dms\n", exec.ResultBytes, exec.LatencyMS)
+		default:
+			fmt.Fprintf(b, "→ %d bytes in %dms\n", exec.ResultBytes, exec.LatencyMS)
+		}
+	}
+}
+
+// traceArguments compacts JSON arguments onto one line and shortens long ones
+func traceArguments(arguments string) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(arguments)); err == nil {
+		arguments = compact.String()
+	}
+	if arguments == "{}" {
+		return ""
+	}
+	if len(arguments) > maxTraceArguments {
+		arguments = arguments[:maxTraceArguments] + "…"
+	}
+	return arguments
+}
+
+// SaveTrace writes the trace of a run to path as indented JSON
+func SaveTrace(path string, run *RunResult) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trace: %v", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to create trace directory: %v", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write trace: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write trace: %v", err)
+	}
+	return nil
+}
+
+// TraceFileName returns a file name for the trace of a run that sorts by start time
+func TraceFileName(run *RunResult) string {
+	return fmt.Sprintf("trace-%s.json", run.StartedAt.UTC().Format("20060102T150405.000000000Z"))
+}
@@ -0,0 +1,149 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/fakeopenai"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+func TestRun_RecordsTrace(t *testing.T) {
+	cm, fake := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{
+			ToolCalls:    []fakeopenai.ToolCall{{ID: "a", Name: "list_connections", Arguments: `{"pid": 42}`}, {ID: "b", Name: "list_packet_drops"}},
+			PromptTokens: 100, CompletionTokens: 20,
+		},
+		fakeopenai.Turn{Content: "answer", PromptTokens: 150, CompletionTokens: 30},
+	)
+
+	run, err := cm.Run(context.Background(), "why is curl slow?")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if run.Query != "why is curl slow?" || run.Provider != "OpenAI" || run.Model != openai.DefaultOpenAIModel || run.StartedAt.IsZero() {
+		t.Errorf("unexpected run header %+v", run)
+	}
+
+	if len(run.LLMRequests) != 2 {
+		t.Fatalf("expected 2 LLM requests, got %+v", run.LLMRequests)
+	}
+	first, second := run.LLMRequests[0], run.LLMRequests[1]
+	if first.Round != 1 || first.ToolChoice != "auto" || first.FinishReason != "tool_calls" || first.ToolCalls != 2 || first.Usage.TotalTokens != 120 {
+		t.Errorf("unexpected first request %+v", first)
+	}
+	if second.Round != 2 || second.FinishReason != "stop" || second.Messages != first.Messages+3 || second.Usage.TotalTokens != 180 {
+		t.Errorf("unexpected second request %+v", second)
+	}
+
+	// The result size is what the model received
+	messages := fake.Requests()[1].Messages
+	exec := run.ToolExecutions[0]
+	if exec.Round != 1 || exec.Arguments != `{"pid": 42}` || exec.ResultBytes != len(messages[len(messages)-2].Text()) || exec.Skipped {
+		t.Errorf("unexpected tool execution %+v", exec)
+	}
+}
+
+func TestRun_TraceRecordsSkippedCalls(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{ID: "a", Name: "list_connections"}, {ID: "b", Name: "list_packet_drops"}}},
+		fakeopenai.Turn{Content: "partial"},
+	)
+	cm.SetLimits(openai.Limits{MaxToolCalls: 1})
+
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(run.ToolExecutions) != 2 || run.ToolExecutions[0].Skipped || !run.ToolExecutions[1].Skipped {
+		t.Errorf("expected the second call to be recorded as skipped, got %+v", run.ToolExecutions)
+	}
+	if run.LLMRequests[1].ToolChoice != "none" {
+		t.Errorf("expected forced final request, got %+v", run.LLMRequests[1])
+	}
+}
+
+func TestRun_TraceRecordsFailedRequest(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}},
+		fakeopenai.Turn{Error: "model overloaded"},
+	)
+
+	if _, err := cm.Run(context.Background(), "question"); err == nil {
+		t.Fatal("expected an error")
+	}
+	run := cm.LastRun()
+	if len(run.LLMRequests) != 2 || !strings.Contains(run.LLMRequests[1].Error, "model overloaded") {
+		t.Errorf("expected the failed request in the trace, got %+v", run.LLMRequests)
+	}
+	if len(run.ToolExecutions) != 1 {
+		t.Errorf("expected the executed call in the trace, got %+v", run.ToolExecutions)
+	}
+}
+
+func TestFormatTrace(t *testing.T) {
+	run := &openai.RunResult{
+		Provider:   "Ollama",
+		Model:      "llama3.1",
+		StopReason: openai.StopComplete,
+		ToolCalls:  1,
+		DurationMS: 1500,
+		Usage:      openai.Usage{PromptTokens: 90, CompletionTokens: 10, TotalTokens: 100},
+		LLMRequests: []openai.LLMRequest{
+			{Round: 1, ToolChoice: "auto", Messages: 2, EstimatedTokens: 850, LatencyMS: 400, FinishReason: "tool_calls", ToolCalls: 2},
+			{Round: 2, ToolChoice: "none", Messages: 5, EstimatedTokens: 1400, LatencyMS: 600, FinishReason: "stop"},
+		},
+		ToolExecutions: []openai.ToolExecution{
+			{Round: 1, Name: "list_connections", Arguments: "{\n  \"pid\": 42\n}", ResultBytes: 2048, LatencyMS: 35},
+			{Round: 1, Name: "list_packet_drops", Arguments: "{}", Skipped: true},
+		},
+	}
+
+	got := openai.FormatTrace(run)
+	for _, want := range []string{
+		"🔎 Analysis trace (Ollama llama3.1)",
+		"2 LLM requests, 1 tool calls in 1.5s, 100 tokens (90 prompt, 10 completion), stop: complete",
+		"1. LLM request (tool_choice auto, 2 messages, ~850 tokens) 400ms, finish: tool_calls",
+		`├─ list_connections({"pid":42}) → 2048 bytes in 35ms`,
+		"└─ list_packet_drops() skipped",
+		"2. LLM request (tool_choice none, 5 messages, ~1400 tokens) 600ms, finish: stop",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected trace to contain %q, got:\n%s", want, got)
+		}
+	}
+	if openai.FormatTrace(nil) != "" {
+		t.Error("expected an empty trace for a nil run")
+	}
+}
+
+func TestSaveTrace(t *testing.T) {
+	cm, _ := newConversation(t, newFakeExecutor(),
+		fakeopenai.Turn{ToolCalls: []fakeopenai.ToolCall{{Name: "list_connections"}}},
+		fakeopenai.Turn{Content: "answer"},
+	)
+	run, err := cm.Run(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit", openai.TraceFileName(run))
+	if err := openai.SaveTrace(path, run); err != nil {
+		t.Fatalf("SaveTrace failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read trace: %v", err)
+	}
+	var saved openai.RunResult
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("trace is not valid JSON: %v", err)
+	}
+	if saved.Query != "question" || saved.Content != "answer" || len(saved.LLMRequests) != 2 || len(saved.ToolExecutions) != 1 {
+		t.Errorf("unexpected saved trace %+v", saved)
+	}
+}
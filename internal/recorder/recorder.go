@@ -0,0 +1,445 @@
+// Package recorder polls the eBPF server and records connections and packet drops to the history store
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/store"
+)
+
+// Source is the part of the eBPF server client the recorder polls
+type Source interface {
+	ListConnections(ctx context.Context, pid *int, limit *int) (netclient.ListConnectionsOutput, error)
+	ListPacketDrops(ctx context.Context) (netclient.PacketDropListOutput, error)
+}
+
+// Sink stores recorded snapshots
+type Sink interface {
+	Append(snapshot store.Snapshot) error
+	Compact(now time.Time) error
+}
+
+// Options configures polling, backoff and health reporting
+type Options struct {
+	// Interval is the time between polls
+	Interval time.Duration
+	// MaxBackoff caps the delay between polls while the eBPF server is unreachable
+	MaxBackoff time.Duration
+	// CompactEvery is how often closed history segments are compacted
+	CompactEvery time.Duration
+	// HealthFile receives the recorder health as JSON after every poll (optional)
+	HealthFile string
+	// Annotate enriches events before they are stored, e.g. with pod attribution (optional)
+	Annotate func(events []netclient.ConnectionEvent)
+	Verbose  bool
+}
+
+// DefaultOptions polls every 10 seconds and compacts hourly
+func DefaultOptions() Options {
+	return Options{
+		Interval:     10 * time.Second,
+		MaxBackoff:   time.Minute,
+		CompactEvery: time.Hour,
+	}
+}
+
+// Recorder health states
+const (
+	StatusStarting  = "starting"
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+	StatusStopped   = "stopped"
+	// StatusStale marks a health file that has not been updated for unhealthyAfter intervals,
+	// usually because the recorder is no longer running
+	StatusStale = "stale"
+)
+
+// unhealthyAfter is the number of missed intervals after which the recorder reports unhealthy
+const unhealthyAfter = 3
+
+// Health reports the recorder's progress and how far behind it is
+type Health struct {
+	Status              string    `json:"status"`
+	StartedAt           time.Time `json:"started_at"`
+	LastPoll            time.Time `json:"last_poll,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Polls               int64     `json:"polls"`
+	Failures            int64     `json:"failures"`
+	ConnectionsRecorded int64     `json:"connections_recorded"`
+	DropsRecorded       int64     `json:"drops_recorded"`
+	DuplicatesSkipped   int64     `json:"duplicates_skipped"`
+	NewestEvent         time.Time `json:"newest_event,omitempty"`
+	// LagSeconds is the time since the last successful poll
+	LagSeconds float64 `json:"lag_seconds"`
+	// EventLagSeconds is the age of the newest recorded event
+	EventLagSeconds float64 `json:"event_lag_seconds,omitempty"`
+	// IntervalSeconds is the polling interval, used to judge the lag of a saved health file
+	IntervalSeconds float64 `json:"interval_seconds,omitempty"`
+}
+
+// Recorder polls a source on an interval and appends new events to a sink
+type Recorder struct {
+	source Source
+	sink   Sink
+	opts   Options
+
+	mu          sync.Mutex
+	health      Health
+	seen        map[string]time.Time // Dedupe keys of recently recorded events
+	lastCompact time.Time
+	now         func() time.Time
+}
+
+// New creates a recorder
+func New(source Source, sink Sink, opts Options) *Recorder {
+	defaults := DefaultOptions()
+	if opts.Interval <= 0 {
+		opts.Interval = defaults.Interval
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaults.MaxBackoff
+	}
+	if opts.CompactEvery <= 0 {
+		opts.CompactEvery = defaults.CompactEvery
+	}
+
+	r := &Recorder{
+		source: source,
+		sink:   sink,
+		opts:   opts,
+		seen:   make(map[string]time.Time),
+		now:    time.Now,
+	}
+	r.health = Health{Status: StatusStarting, StartedAt: r.now(), IntervalSeconds: opts.Interval.Seconds()}
+	r.lastCompact = r.now()
+	return r
+}
+
+// Run polls until the context is cancelled. Poll failures, such as an eBPF server restart, are
+// retried with exponential backoff instead of stopping the recorder.
+func (r *Recorder) Run(ctx context.Context) error {
+	defer r.stop()
+
+	for {
+		delay := r.opts.Interval
+		if err := r.Poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			delay = r.backoff()
+			log.Printf("Recorder: poll failed (retrying in %s): %v", delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// Poll fetches connections and drops once and records the ones not seen before
+func (r *Recorder) Poll(ctx context.Context) error {
+	now := r.now()
+	err := r.poll(ctx, now)
+
+	r.mu.Lock()
+	r.health.Polls++
+	r.health.LastPoll = now
+	if err != nil {
+		r.health.Failures++
+		r.health.ConsecutiveFailures++
+		r.health.LastError = err.Error()
+	} else {
+		r.health.ConsecutiveFailures = 0
+		r.health.LastError = ""
+		r.health.LastSuccess = now
+	}
+	r.mu.Unlock()
+
+	r.writeHealth()
+	return err
+}
+
+func (r *Recorder) poll(ctx context.Context, now time.Time) error {
+	connections, err := r.source.ListConnections(ctx, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list connections: %v", err)
+	}
+	drops, err := r.source.ListPacketDrops(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list packet drops: %v", err)
+	}
+
+	snapshot := store.Snapshot{Time: now}
+	var duplicates int64
+	var newest time.Time
+	var keys []string
+
+	r.mu.Lock()
+	for _, infos := range connections.EventsByPID {
+		for _, info := range infos {
+			key := connectionKey(info)
+			if !r.markSeen(key, now) {
+				duplicates++
+				continue
+			}
+			keys = append(keys, key)
+			event := info.ToConnectionEvent()
+			if event.WallTime.After(newest) {
+				newest = event.WallTime
+			}
+			snapshot.Connections = append(snapshot.Connections, event)
+		}
+	}
+	for _, infos := range drops.EventsByPID {
+		for _, drop := range infos {
+			key := dropKey(drop)
+			if !r.markSeen(key, now) {
+				duplicates++
+				continue
+			}
+			keys = append(keys, key)
+			snapshot.Drops = append(snapshot.Drops, drop)
+		}
+	}
+	r.pruneSeen(now)
+	r.mu.Unlock()
+
+	if r.opts.Annotate != nil && len(snapshot.Connections) > 0 {
+		r.opts.Annotate(snapshot.Connections)
+	}
+
+	if len(snapshot.Connections) > 0 || len(snapshot.Drops) > 0 {
+		if err := r.sink.Append(snapshot); err != nil {
+			// Forget the keys so the events are retried on the next poll
+			r.mu.Lock()
+			for _, key := range keys {
+				delete(r.seen, key)
+			}
+			r.mu.Unlock()
+			return fmt.Errorf("failed to record snapshot: %v", err)
+		}
+	}
+
+	if r.opts.Verbose {
+		log.Printf("Recorder: recorded %d connections and %d drops (%d duplicates skipped)",
+			len(snapshot.Connections), len(snapshot.Drops), duplicates)
+	}
+
+	r.mu.Lock()
+	r.health.ConnectionsRecorded += int64(len(snapshot.Connections))
+	r.health.DropsRecorded += int64(len(snapshot.Drops))
+	r.health.DuplicatesSkipped += duplicates
+	if newest.After(r.health.NewestEvent) {
+		r.health.NewestEvent = newest
+	}
+	compact := now.Sub(r.lastCompact) >= r.opts.CompactEvery
+	if compact {
+		r.lastCompact = now
+	}
+	r.mu.Unlock()
+
+	if compact {
+		if err := r.sink.Compact(now); err != nil {
+			log.Printf("Recorder: compaction failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Health returns the recorder's current health
+func (r *Recorder) Health() Health {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	health := r.health
+	now := r.now()
+	if !health.LastSuccess.IsZero() {
+		health.LagSeconds = now.Sub(health.LastSuccess).Seconds()
+	} else {
+		health.LagSeconds = now.Sub(health.StartedAt).Seconds()
+	}
+	if !health.NewestEvent.IsZero() {
+		health.EventLagSeconds = now.Sub(health.NewestEvent).Seconds()
+	}
+
+	if health.Status != StatusStopped {
+		switch {
+		case health.Polls == 0:
+			health.Status = StatusStarting
+		case health.LagSeconds > unhealthyAfter*r.opts.Interval.Seconds():
+			health.Status = StatusUnhealthy
+		case health.ConsecutiveFailures > 0:
+			health.Status = StatusDegraded
+		default:
+			health.Status = StatusHealthy
+		}
+	}
+	return health
+}
+
+// backoff returns the delay before the next poll after consecutive failures
+func (r *Recorder) backoff() time.Duration {
+	r.mu.Lock()
+	failures := r.health.ConsecutiveFailures
+	r.mu.Unlock()
+
+	delay := r.opts.Interval
+	for i := 1; i < failures && delay < r.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.opts.MaxBackoff {
+		delay = r.opts.MaxBackoff
+	}
+	return delay
+}
+
+// stop marks the recorder as stopped and writes the final health
+func (r *Recorder) stop() {
+	r.mu.Lock()
+	r.health.Status = StatusStopped
+	r.mu.Unlock()
+	r.writeHealth()
+}
+
+// writeHealth atomically writes the health file, if configured
+func (r *Recorder) writeHealth() {
+	if r.opts.HealthFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(r.Health(), "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.opts.HealthFile), 0o700); err != nil {
+		log.Printf("Recorder: failed to write health file: %v", err)
+		return
+	}
+	tmp := r.opts.HealthFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("Recorder: failed to write health file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, r.opts.HealthFile); err != nil {
+		log.Printf("Recorder: failed to write health file: %v", err)
+	}
+}
+
+// markSeen records a dedupe key, returning false if it was already seen
+func (r *Recorder) markSeen(key string, now time.Time) bool {
+	if _, exists := r.seen[key]; exists {
+		r.seen[key] = now
+		return false
+	}
+	r.seen[key] = now
+	return true
+}
+
+// pruneSeen forgets keys that have not been returned by the server for a while, bounding memory
+func (r *Recorder) pruneSeen(now time.Time) {
+	ttl := 10 * r.opts.Interval
+	if ttl < 10*time.Minute {
+		ttl = 10 * time.Minute
+	}
+	for key, lastSeen := range r.seen {
+		if now.Sub(lastSeen) > ttl {
+			delete(r.seen, key)
+		}
+	}
+}
+
+// connectionKey identifies a connection event by its server ID and timestamp. The timestamp keeps
+// keys unique when the server reuses IDs after a restart.
+func connectionKey(info netclient.ConnectionInfo) string {
+	if info.ID != "" {
+		return fmt.Sprintf("c|%s|%s|%v", info.ID, info.Time, info.Timestamp)
+	}
+	return fmt.Sprintf("c|%d|%s|%v|%s|%d", info.PID, info.Time, info.Timestamp, info.Destination, info.ReturnCode)
+}
+
+// dropKey identifies a packet drop event, which has no server ID
+func dropKey(drop netclient.PacketDropInfo) string {
+	return fmt.Sprintf("d|%d|%s|%v|%s", drop.PID, drop.Command, drop.Timestamp, drop.Reason)
+}
+
+// Handler serves /healthz (200 while healthy or degraded, 503 otherwise) and /status (health as JSON)
+func (r *Recorder) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		health := r.Health()
+		if health.OK() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintf(w, "%s lag=%.0fs\n", health.Status, health.LagSeconds)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(r.Health())
+	})
+	return mux
+}
+
+// OK reports whether the status counts as up for health checks
+func (h Health) OK() bool {
+	switch h.Status {
+	case StatusHealthy, StatusDegraded, StatusStarting:
+		return true
+	}
+	return false
+}
+
+// Current recomputes the lags of health read from a file as of now. A recorder that has not
+// succeeded for unhealthyAfter of its intervals is reported stale, since a running one keeps
+// the file up to date.
+func (h Health) Current(now time.Time) Health {
+	since := h.LastSuccess
+	if since.IsZero() {
+		since = h.StartedAt
+	}
+	h.LagSeconds = now.Sub(since).Seconds()
+	if !h.NewestEvent.IsZero() {
+		h.EventLagSeconds = now.Sub(h.NewestEvent).Seconds()
+	}
+
+	interval := h.IntervalSeconds
+	if interval <= 0 {
+		interval = DefaultOptions().Interval.Seconds()
+	}
+	if h.Status != StatusStopped && h.LagSeconds > unhealthyAfter*interval {
+		h.Status = StatusStale
+	}
+	return h
+}
+
+// ReadHealth reads a health file written by a running recorder
+func ReadHealth(path string) (Health, error) {
+	var health Health
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return health, fmt.Errorf("failed to read recorder health: %v", err)
+	}
+	if err := json.Unmarshal(data, &health); err != nil {
+		return health, fmt.Errorf("failed to parse recorder health %s: %v", path, err)
+	}
+	return health, nil
+}
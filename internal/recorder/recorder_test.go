@@ -0,0 +1,229 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/store"
+)
+
+// fakeSource returns canned events and can simulate an unreachable server
+type fakeSource struct {
+	mu          sync.Mutex
+	connections []netclient.ConnectionInfo
+	drops       []netclient.PacketDropInfo
+	err         error
+}
+
+func (f *fakeSource) ListConnections(ctx context.Context, pid *int, limit *int) (netclient.ListConnectionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return netclient.ListConnectionsOutput{}, f.err
+	}
+	return netclient.ListConnectionsOutput{
+		EventsByPID: map[string][]netclient.ConnectionInfo{"100": append([]netclient.ConnectionInfo(nil), f.connections...)},
+	}, nil
+}
+
+func (f *fakeSource) ListPacketDrops(ctx context.Context) (netclient.PacketDropListOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return netclient.PacketDropListOutput{}, f.err
+	}
+	return netclient.PacketDropListOutput{
+		EventsByPID: map[string][]netclient.PacketDropInfo{"100": append([]netclient.PacketDropInfo(nil), f.drops...)},
+	}, nil
+}
+
+func (f *fakeSource) set(connections []netclient.ConnectionInfo, drops []netclient.PacketDropInfo, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connections, f.drops, f.err = connections, drops, err
+}
+
+func connectionInfo(id string, at time.Time) netclient.ConnectionInfo {
+	return netclient.ConnectionInfo{
+		ID:          id,
+		PID:         100,
+		Command:     "curl",
+		Destination: "1.1.1.1:443",
+		Protocol:    "TCP",
+		Time:        at.Format(time.RFC3339Nano),
+	}
+}
+
+func newTestRecorder(t *testing.T, source Source) (*Recorder, *store.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	history, err := store.Open(filepath.Join(dir, "history"), store.DefaultOptions())
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.HealthFile = filepath.Join(dir, "health.json")
+	return New(source, history, opts), history
+}
+
+func TestPollDeduplicates(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	source := &fakeSource{}
+	source.set([]netclient.ConnectionInfo{
+		connectionInfo("1", now.Add(-2*time.Second)),
+		connectionInfo("2", now.Add(-time.Second)),
+	}, []netclient.PacketDropInfo{{PID: 100, Command: "curl", Reason: "NO_SOCKET", Timestamp: 1}}, nil)
+
+	r, history := newTestRecorder(t, source)
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	// The second poll sees the same events plus one new one, and a reused ID with a new timestamp
+	source.set([]netclient.ConnectionInfo{
+		connectionInfo("1", now.Add(-2*time.Second)),
+		connectionInfo("2", now.Add(-time.Second)),
+		connectionInfo("3", now),
+		connectionInfo("1", now),
+	}, []netclient.PacketDropInfo{{PID: 100, Command: "curl", Reason: "NO_SOCKET", Timestamp: 1}}, nil)
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	result, err := history.Query(store.Query{Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Connections) != 4 {
+		t.Errorf("expected 4 recorded connections, got %d", len(result.Connections))
+	}
+
+	health := r.Health()
+	if health.ConnectionsRecorded != 4 || health.DropsRecorded != 1 || health.DuplicatesSkipped != 3 {
+		t.Errorf("unexpected counters: %+v", health)
+	}
+	if health.Status != StatusHealthy {
+		t.Errorf("expected healthy, got %s", health.Status)
+	}
+}
+
+func TestPollRecoversAfterServerRestart(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	source := &fakeSource{}
+	source.set(nil, nil, errors.New("connection refused"))
+
+	r, _ := newTestRecorder(t, source)
+	for i := 0; i < 3; i++ {
+		if err := r.Poll(context.Background()); err == nil {
+			t.Fatal("expected poll error")
+		}
+	}
+	health := r.Health()
+	if health.Status != StatusDegraded || health.ConsecutiveFailures != 3 || health.LastError == "" {
+		t.Errorf("unexpected health while server is down: %+v", health)
+	}
+	if delay := r.backoff(); delay != 4*r.opts.Interval {
+		t.Errorf("expected backoff of %s, got %s", 4*r.opts.Interval, delay)
+	}
+
+	source.set([]netclient.ConnectionInfo{connectionInfo("1", now)}, nil, nil)
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("poll failed after restart: %v", err)
+	}
+	health = r.Health()
+	if health.Status != StatusHealthy || health.ConsecutiveFailures != 0 || health.ConnectionsRecorded != 1 {
+		t.Errorf("unexpected health after recovery: %+v", health)
+	}
+}
+
+func TestHealthLag(t *testing.T) {
+	source := &fakeSource{}
+	r, _ := newTestRecorder(t, source)
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	// Pretend the recorder has been stuck for longer than three intervals
+	start := time.Now()
+	r.now = func() time.Time { return start.Add(time.Minute) }
+	health := r.Health()
+	if health.Status != StatusUnhealthy {
+		t.Errorf("expected unhealthy, got %s", health.Status)
+	}
+	if health.LagSeconds < 59 {
+		t.Errorf("expected lag of about 60s, got %.1f", health.LagSeconds)
+	}
+
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", recorder.Code)
+	}
+}
+
+func TestHealthCurrent(t *testing.T) {
+	source := &fakeSource{}
+	r, _ := newTestRecorder(t, source)
+	if err := r.Poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	saved, err := ReadHealth(r.opts.HealthFile)
+	if err != nil {
+		t.Fatalf("read health failed: %v", err)
+	}
+	if saved.Status != StatusHealthy || saved.IntervalSeconds != 10 {
+		t.Fatalf("unexpected saved health: %+v", saved)
+	}
+
+	if health := saved.Current(saved.LastSuccess.Add(20 * time.Second)); health.Status != StatusHealthy || !health.OK() {
+		t.Errorf("expected a recent file to stay healthy, got %s", health.Status)
+	}
+
+	// The recorder died after its last poll and left the healthy status behind
+	health := saved.Current(saved.LastSuccess.Add(time.Hour))
+	if health.Status != StatusStale || health.OK() {
+		t.Errorf("expected stale, got %s", health.Status)
+	}
+	if health.LagSeconds != 3600 {
+		t.Errorf("expected the lag to be recomputed, got %.1f", health.LagSeconds)
+	}
+
+	saved.Status = StatusStopped
+	if health := saved.Current(saved.LastSuccess.Add(time.Hour)); health.Status != StatusStopped {
+		t.Errorf("expected a stopped recorder to stay stopped, got %s", health.Status)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	source := &fakeSource{}
+	r, _ := newTestRecorder(t, source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("recorder did not stop")
+	}
+
+	health, err := ReadHealth(r.opts.HealthFile)
+	if err != nil {
+		t.Fatalf("read health failed: %v", err)
+	}
+	if health.Status != StatusStopped {
+		t.Errorf("expected stopped status in health file, got %s", health.Status)
+	}
+}
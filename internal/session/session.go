@@ -0,0 +1,217 @@
+// Package session persists chat conversations with the AI analyst so an investigation can be
+// resumed later, e.g. by the next engineer after a shift handover
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+// fileExt is the extension of session files in the store directory
+const fileExt = ".json"
+
+// Session is a saved conversation with the settings it ran with and the trace of every answer
+type Session struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"` // The first question
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model,omitempty"`
+
+	// LLM holds the LLM settings in effect when the session was last saved
+	LLM config.LLMConfig `json:"llm"`
+	// Messages is the conversation sent to the model, including tool calls and results
+	Messages []openai.ChatMessage `json:"messages"`
+	// Runs traces each answer in order
+	Runs []*openai.RunResult `json:"runs,omitempty"`
+}
+
+// New creates an empty session with a fresh ID
+func New(provider, model string, llm config.LLMConfig) *Session {
+	now := time.Now().UTC()
+	return &Session{
+		ID:        newID(now),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Provider:  provider,
+		Model:     model,
+		LLM:       llm,
+	}
+}
+
+// newID returns a sortable ID such as 20250102-150405-a1b2
+func newID(now time.Time) string {
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		return now.Format("20060102-150405.000000")
+	}
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Questions counts the user messages in the session
+func (s *Session) Questions() int {
+	count := 0
+	for _, message := range s.Messages {
+		if message.Role == "user" {
+			count++
+		}
+	}
+	return count
+}
+
+// Record stores the conversation after an answer. The first question becomes the title.
+func (s *Session) Record(messages []openai.ChatMessage, run *openai.RunResult) {
+	s.Messages = append([]openai.ChatMessage(nil), messages...)
+	if run != nil {
+		s.Runs = append(s.Runs, run)
+		if s.Title == "" {
+			s.Title = run.Query
+		}
+	}
+	s.UpdatedAt = time.Now().UTC()
+}
+
+// Store is a directory with one JSON file per session
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store in dir; the directory is created on first save
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Dir returns the store directory
+func (st *Store) Dir() string {
+	return st.dir
+}
+
+// Save writes a session, replacing any earlier version
+func (st *Store) Save(s *Session) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	if err := os.MkdirAll(st.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %v", err)
+	}
+	path := st.path(s.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write session: %v", err)
+	}
+	return nil
+}
+
+// Load reads a session by ID or by a prefix that matches exactly one session
+func (st *Store) Load(id string) (*Session, error) {
+	id, err := st.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(st.path(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %v", id, err)
+	}
+	return &s, nil
+}
+
+// List returns all sessions, most recently updated first. Unreadable files are skipped.
+func (st *Store) List() ([]*Session, error) {
+	ids, err := st.ids()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(ids))
+	for _, id := range ids {
+		s, err := st.Load(id)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// Delete removes a session by ID or unique prefix and returns the full ID
+func (st *Store) Delete(id string) (string, error) {
+	id, err := st.resolve(id)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(st.path(id)); err != nil {
+		return "", fmt.Errorf("failed to delete session: %v", err)
+	}
+	return id, nil
+}
+
+// resolve expands a unique ID prefix to the full session ID
+func (st *Store) resolve(prefix string) (string, error) {
+	if prefix == "" || strings.ContainsAny(prefix, `/\`) {
+		return "", fmt.Errorf("invalid session ID %q", prefix)
+	}
+	ids, err := st.ids()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, id := range ids {
+		if id == prefix {
+			return id, nil
+		}
+		if strings.HasPrefix(id, prefix) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no session %q in %s", prefix, st.dir)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("session ID %q is ambiguous (%s)", prefix, strings.Join(matches, ", "))
+	}
+}
+
+// ids lists the session IDs in the store, or none when the directory does not exist yet
+func (st *Store) ids() ([]string, error) {
+	entries, err := os.ReadDir(st.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directory: %v", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, fileExt) {
+			ids = append(ids, strings.TrimSuffix(name, fileExt))
+		}
+	}
+	return ids, nil
+}
+
+func (st *Store) path(id string) string {
+	return filepath.Join(st.dir, id+fileExt)
+}
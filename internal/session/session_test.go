@@ -0,0 +1,125 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/config"
+	"github.com/srodi/netspy/internal/openai"
+)
+
+func text(s string) *string {
+	return &s
+}
+
+func conversation() []openai.ChatMessage {
+	call := openai.ToolCall{ID: "call_1", Type: "function"}
+	call.Function.Name = "list_packet_drops"
+	call.Function.Arguments = `{"process_name":"nginx"}`
+	return []openai.ChatMessage{
+		{Role: "system", Content: text("You are a network analyst.")},
+		{Role: "user", Content: text("any drops?")},
+		{Role: "assistant", ToolCalls: []openai.ToolCall{call}},
+		{Role: "tool", ToolCallID: "call_1", Content: text("37 TCP_RESET drops")},
+		{Role: "assistant", Content: text("nginx dropped 37 packets.")},
+	}
+}
+
+func TestSessionSaveLoad(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "sessions"))
+	s := New("Ollama", "llama3.1", config.LLMConfig{Provider: "ollama", MaxToolCalls: 5})
+	s.Record(conversation(), &openai.RunResult{Query: "any drops?", Content: "nginx dropped 37 packets.", Usage: openai.Usage{TotalTokens: 120}})
+	if err := store.Save(s); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(store.Dir(), s.ID+".json"))
+	if err != nil {
+		t.Fatalf("expected session file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a private session file, got %v", info.Mode().Perm())
+	}
+
+	loaded, err := store.Load(s.ID)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Title != "any drops?" || loaded.Provider != "Ollama" || loaded.LLM.MaxToolCalls != 5 || loaded.Questions() != 1 {
+		t.Errorf("unexpected session %+v", loaded)
+	}
+	if len(loaded.Messages) != 5 || loaded.Messages[2].ToolCalls[0].Function.Arguments != `{"process_name":"nginx"}` {
+		t.Errorf("expected tool calls to survive the round trip, got %+v", loaded.Messages)
+	}
+	if len(loaded.Runs) != 1 || loaded.Runs[0].Usage.TotalTokens != 120 {
+		t.Errorf("expected the run trace to be saved, got %+v", loaded.Runs)
+	}
+}
+
+func TestSessionRecordKeepsFirstTitle(t *testing.T) {
+	s := New("OpenAI", "gpt-4o-mini", config.LLMConfig{})
+	s.Record(conversation(), &openai.RunResult{Query: "any drops?"})
+	s.Record(append(conversation(), openai.ChatMessage{Role: "user", Content: text("still?")}), &openai.RunResult{Query: "still?"})
+	if s.Title != "any drops?" || len(s.Runs) != 2 || s.Questions() != 2 {
+		t.Errorf("unexpected session after two answers %+v", s)
+	}
+}
+
+func TestStoreResolvesPrefixes(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for _, id := range []string{"20250102-150405-aaaa", "20250102-150405-bbbb", "20250103-090000-cccc"} {
+		if err := store.Save(&Session{ID: id}); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	if s, err := store.Load("20250103"); err != nil || s.ID != "20250103-090000-cccc" {
+		t.Errorf("expected unique prefix to resolve, got %v, %v", s, err)
+	}
+	if _, err := store.Load("20250102"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected an ambiguous prefix error, got %v", err)
+	}
+	if _, err := store.Load("2024"); err == nil || !strings.Contains(err.Error(), "no session") {
+		t.Errorf("expected a missing session error, got %v", err)
+	}
+	if _, err := store.Load("../secrets"); err == nil || !strings.Contains(err.Error(), "invalid session ID") {
+		t.Errorf("expected path separators to be rejected, got %v", err)
+	}
+}
+
+func TestStoreListAndDelete(t *testing.T) {
+	store := NewStore(t.TempDir())
+	if sessions, err := store.List(); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected an empty list before the first save, got %v, %v", sessions, err)
+	}
+
+	now := time.Now().UTC()
+	older := &Session{ID: "a", UpdatedAt: now.Add(-time.Hour)}
+	newer := &Session{ID: "b", UpdatedAt: now}
+	for _, s := range []*Session{older, newer} {
+		if err := store.Save(s); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(store.Dir(), "broken.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "b" || sessions[1].ID != "a" {
+		t.Errorf("expected newest first without the broken file, got %+v", sessions)
+	}
+
+	if id, err := store.Delete("a"); err != nil || id != "a" {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Load("a"); err == nil {
+		t.Error("expected the deleted session to be gone")
+	}
+}
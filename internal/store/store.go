@@ -0,0 +1,639 @@
+// Package store implements an embedded, file-backed history of connection and packet drop events
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+const (
+	// indexFile is the name of the segment index within the store directory
+	indexFile = "index.json"
+	// segmentDir holds the JSON lines segment files
+	segmentDir = "segments"
+	// indexVersion is the on-disk format version of the index
+	indexVersion = 1
+	// maxIndexedKeys bounds the PIDs and commands indexed per segment; beyond it the segment
+	// is always scanned
+	maxIndexedKeys = 4096
+)
+
+// Options configures retention and segmenting
+type Options struct {
+	// Retention is how long events are kept
+	Retention time.Duration
+	// MaxBytes bounds the total size of segment files; the oldest segments are removed first
+	MaxBytes int64
+	// SegmentDuration is the time span covered by each segment file
+	SegmentDuration time.Duration
+}
+
+// DefaultOptions keeps a week of history in hourly segments, up to 256 MiB
+func DefaultOptions() Options {
+	return Options{
+		Retention:       7 * 24 * time.Hour,
+		MaxBytes:        256 << 20,
+		SegmentDuration: time.Hour,
+	}
+}
+
+// Snapshot is a batch of events recorded at one point in time
+type Snapshot struct {
+	Time        time.Time
+	Connections []netclient.ConnectionEvent
+	Drops       []netclient.PacketDropInfo
+}
+
+// Query selects stored events. Zero times leave the range open and empty filters match everything.
+type Query struct {
+	Start   time.Time
+	End     time.Time
+	PID     *int
+	Command string
+}
+
+// Result holds the events matching a query, in time order
+type Result struct {
+	Connections []netclient.ConnectionEvent
+	Drops       []netclient.PacketDropInfo
+}
+
+// SegmentInfo describes one segment file in the index
+type SegmentInfo struct {
+	Name        string    `json:"name"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Connections int       `json:"connections"`
+	Drops       int       `json:"drops"`
+	Bytes       int64     `json:"bytes"`
+	PIDs        []uint32  `json:"pids,omitempty"`
+	Commands    []string  `json:"commands,omitempty"`
+	Unindexed   bool      `json:"unindexed,omitempty"` // Too many PIDs/commands to index
+	Compacted   bool      `json:"compacted,omitempty"`
+}
+
+// index lists segments in time order
+type index struct {
+	Version  int           `json:"version"`
+	Segments []SegmentInfo `json:"segments"`
+}
+
+// record is one line of a segment file
+type record struct {
+	Time       time.Time                  `json:"t"`
+	Connection *netclient.ConnectionEvent `json:"c,omitempty"`
+	Drop       *netclient.PacketDropInfo  `json:"d,omitempty"`
+}
+
+// Store is a directory of time-bucketed JSON lines segments with an index by time, PID and process
+type Store struct {
+	dir   string
+	opts  Options
+	mu    sync.Mutex
+	index index
+}
+
+// Open opens or creates a store in the given directory
+func Open(dir string, opts Options) (*Store, error) {
+	defaults := DefaultOptions()
+	if opts.Retention <= 0 {
+		opts.Retention = defaults.Retention
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaults.MaxBytes
+	}
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = defaults.SegmentDuration
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, segmentDir), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create history store: %v", err)
+	}
+
+	s := &Store{dir: dir, opts: opts}
+	if err := s.loadIndex(); err != nil {
+		// A lost or corrupt index can always be rebuilt from the segments
+		if err := s.rebuildIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Dir returns the store directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Append writes a snapshot's events to their segments and applies retention
+func (s *Store) Append(snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Pick up segments written by other processes before updating the index
+	if err := s.loadIndex(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	bySegment := make(map[string][]record)
+	for i := range snapshot.Connections {
+		event := snapshot.Connections[i]
+		at := event.WallTime
+		if at.IsZero() {
+			at = snapshot.Time
+		}
+		bySegment[s.segmentName(at)] = append(bySegment[s.segmentName(at)], record{Time: at, Connection: &event})
+	}
+	for i := range snapshot.Drops {
+		drop := snapshot.Drops[i]
+		at := DropTime(drop, snapshot.Time)
+		bySegment[s.segmentName(at)] = append(bySegment[s.segmentName(at)], record{Time: at, Drop: &drop})
+	}
+
+	for name, records := range bySegment {
+		if err := s.appendSegment(name, records); err != nil {
+			return err
+		}
+	}
+
+	s.applyRetention(time.Now())
+	return s.saveIndex()
+}
+
+// Query returns the stored events matching q
+func (s *Store) Query(q Query) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadIndex(); err != nil && !os.IsNotExist(err) {
+		return Result{}, err
+	}
+
+	var result Result
+	var dropTimes []time.Time // Record times of result.Drops, which fall back to the snapshot time
+	for _, info := range s.index.Segments {
+		if !info.overlaps(q.Start, q.End) || !info.mayContain(q.PID, q.Command) {
+			continue
+		}
+		records, err := s.readSegment(info.Name)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, r := range records {
+			if !q.Start.IsZero() && r.Time.Before(q.Start) || !q.End.IsZero() && !r.Time.Before(q.End) {
+				continue
+			}
+			if r.Connection != nil && matches(q, r.Connection.PID, r.Connection.Command) {
+				result.Connections = append(result.Connections, *r.Connection)
+			}
+			if r.Drop != nil && matches(q, r.Drop.PID, r.Drop.Command) {
+				result.Drops = append(result.Drops, *r.Drop)
+				dropTimes = append(dropTimes, r.Time)
+			}
+		}
+	}
+
+	sort.SliceStable(result.Connections, func(i, j int) bool {
+		return result.Connections[i].WallTime.Before(result.Connections[j].WallTime)
+	})
+	sort.Stable(dropsByTime{drops: result.Drops, times: dropTimes})
+	return result, nil
+}
+
+// dropsByTime sorts packet drops by their record times
+type dropsByTime struct {
+	drops []netclient.PacketDropInfo
+	times []time.Time
+}
+
+func (d dropsByTime) Len() int           { return len(d.drops) }
+func (d dropsByTime) Less(i, j int) bool { return d.times[i].Before(d.times[j]) }
+func (d dropsByTime) Swap(i, j int) {
+	d.drops[i], d.drops[j] = d.drops[j], d.drops[i]
+	d.times[i], d.times[j] = d.times[j], d.times[i]
+}
+
+// Oldest returns the time of the oldest stored event, or the zero time for an empty store
+func (s *Store) Oldest() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadIndex()
+	if len(s.index.Segments) == 0 {
+		return time.Time{}
+	}
+	return s.index.Segments[0].Start
+}
+
+// Stats summarizes the store contents
+type Stats struct {
+	Segments    int       `json:"segments"`
+	Connections int       `json:"connections"`
+	Drops       int       `json:"drops"`
+	Bytes       int64     `json:"bytes"`
+	Oldest      time.Time `json:"oldest"`
+	Newest      time.Time `json:"newest"`
+}
+
+// Stats returns totals across all segments
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadIndex()
+
+	var stats Stats
+	for _, info := range s.index.Segments {
+		stats.Segments++
+		stats.Connections += info.Connections
+		stats.Drops += info.Drops
+		stats.Bytes += info.Bytes
+		if stats.Oldest.IsZero() || info.Start.Before(stats.Oldest) {
+			stats.Oldest = info.Start
+		}
+		if info.End.After(stats.Newest) {
+			stats.Newest = info.End
+		}
+	}
+	return stats
+}
+
+// Compact rewrites segments that can no longer receive events: records are sorted, duplicates
+// recorded by overlapping snapshots are removed and events past retention are dropped
+func (s *Store) Compact(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadIndex(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := range s.index.Segments {
+		info := &s.index.Segments[i]
+		if info.Compacted || now.Before(s.segmentStart(info.Name).Add(s.opts.SegmentDuration)) {
+			continue
+		}
+
+		records, err := s.readSegment(info.Name)
+		if err != nil {
+			return err
+		}
+
+		cutoff := now.Add(-s.opts.Retention)
+		seen := make(map[string]bool)
+		var kept []record
+		for _, r := range records {
+			key := recordKey(r)
+			if seen[key] || r.Time.Before(cutoff) {
+				continue
+			}
+			seen[key] = true
+			kept = append(kept, r)
+		}
+		sort.SliceStable(kept, func(a, b int) bool { return kept[a].Time.Before(kept[b].Time) })
+
+		if err := s.writeSegment(info.Name, kept); err != nil {
+			return err
+		}
+		*info = s.describeSegment(info.Name, kept)
+		info.Compacted = true
+	}
+
+	s.applyRetention(now)
+	return s.saveIndex()
+}
+
+// ApplyRetention removes segments past the retention period or beyond the size limit
+func (s *Store) ApplyRetention(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadIndex(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.applyRetention(now)
+	return s.saveIndex()
+}
+
+func (s *Store) applyRetention(now time.Time) {
+	cutoff := now.Add(-s.opts.Retention)
+	var total int64
+	for _, info := range s.index.Segments {
+		total += info.Bytes
+	}
+
+	var kept []SegmentInfo
+	for i, info := range s.index.Segments {
+		// Segments are in time order, so the oldest are removed first when over the size limit
+		if info.End.Before(cutoff) || (total > s.opts.MaxBytes && i < len(s.index.Segments)-1) {
+			os.Remove(s.segmentPath(info.Name))
+			total -= info.Bytes
+			continue
+		}
+		kept = append(kept, info)
+	}
+	s.index.Segments = kept
+}
+
+// segmentName returns the segment holding events at the given time
+func (s *Store) segmentName(at time.Time) string {
+	return at.UTC().Truncate(s.opts.SegmentDuration).Format("20060102T150405Z") + ".jsonl"
+}
+
+// segmentStart parses the start time of a segment from its name
+func (s *Store) segmentStart(name string) time.Time {
+	start, err := time.Parse("20060102T150405Z", strings.TrimSuffix(name, ".jsonl"))
+	if err != nil {
+		return time.Time{}
+	}
+	return start
+}
+
+func (s *Store) segmentPath(name string) string {
+	return filepath.Join(s.dir, segmentDir, name)
+}
+
+// appendSegment appends records to a segment file and updates its index entry
+func (s *Store) appendSegment(name string, records []record) error {
+	f, err := os.OpenFile(s.segmentPath(name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open history segment: %v", err)
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write history segment: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history segment: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write history segment: %v", err)
+	}
+
+	info := s.findSegment(name)
+	if info == nil {
+		s.index.Segments = append(s.index.Segments, SegmentInfo{Name: name})
+		sort.Slice(s.index.Segments, func(i, j int) bool { return s.index.Segments[i].Name < s.index.Segments[j].Name })
+		info = s.findSegment(name)
+	}
+	info.add(records)
+	info.Compacted = false
+	if stat, err := os.Stat(s.segmentPath(name)); err == nil {
+		info.Bytes = stat.Size()
+	}
+	return nil
+}
+
+func (s *Store) findSegment(name string) *SegmentInfo {
+	for i := range s.index.Segments {
+		if s.index.Segments[i].Name == name {
+			return &s.index.Segments[i]
+		}
+	}
+	return nil
+}
+
+// readSegment reads a segment's records. A truncated final line from an interrupted write is skipped.
+func (s *Store) readSegment(name string) ([]record, error) {
+	f, err := os.Open(s.segmentPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history segment: %v", err)
+	}
+	defer f.Close()
+
+	var records []record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history segment %s: %v", name, err)
+	}
+	return records, nil
+}
+
+// writeSegment atomically replaces a segment's contents
+func (s *Store) writeSegment(name string, records []record) error {
+	tmp := s.segmentPath(name) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact history segment: %v", err)
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to compact history segment: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact history segment: %v", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, s.segmentPath(name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact history segment: %v", err)
+	}
+	return nil
+}
+
+// describeSegment builds a fresh index entry for a segment's records
+func (s *Store) describeSegment(name string, records []record) SegmentInfo {
+	info := SegmentInfo{Name: name}
+	info.add(records)
+	if stat, err := os.Stat(s.segmentPath(name)); err == nil {
+		info.Bytes = stat.Size()
+	}
+	return info
+}
+
+func (s *Store) loadIndex() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, indexFile))
+	if err != nil {
+		return err
+	}
+
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return fmt.Errorf("failed to parse history index: %v", err)
+	}
+	if idx.Version != indexVersion {
+		return fmt.Errorf("unsupported history index version %d", idx.Version)
+	}
+	s.index = idx
+	return nil
+}
+
+func (s *Store) saveIndex() error {
+	s.index.Version = indexVersion
+	data, err := json.Marshal(s.index)
+	if err != nil {
+		return fmt.Errorf("failed to encode history index: %v", err)
+	}
+
+	path := filepath.Join(s.dir, indexFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write history index: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write history index: %v", err)
+	}
+	return nil
+}
+
+// rebuildIndex scans all segment files to recreate the index
+func (s *Store) rebuildIndex() error {
+	entries, err := os.ReadDir(filepath.Join(s.dir, segmentDir))
+	if err != nil {
+		return fmt.Errorf("failed to read history segments: %v", err)
+	}
+
+	s.index = index{Version: indexVersion}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		records, err := s.readSegment(entry.Name())
+		if err != nil {
+			return err
+		}
+		s.index.Segments = append(s.index.Segments, s.describeSegment(entry.Name(), records))
+	}
+	sort.Slice(s.index.Segments, func(i, j int) bool { return s.index.Segments[i].Name < s.index.Segments[j].Name })
+	return s.saveIndex()
+}
+
+// add folds records into the segment's time bounds, counts and PID/process index
+func (info *SegmentInfo) add(records []record) {
+	pids := make(map[uint32]bool)
+	for _, pid := range info.PIDs {
+		pids[pid] = true
+	}
+	commands := make(map[string]bool)
+	for _, command := range info.Commands {
+		commands[command] = true
+	}
+
+	for _, r := range records {
+		if info.Start.IsZero() || r.Time.Before(info.Start) {
+			info.Start = r.Time
+		}
+		if r.Time.After(info.End) {
+			info.End = r.Time
+		}
+		if r.Connection != nil {
+			info.Connections++
+			pids[r.Connection.PID] = true
+			commands[r.Connection.Command] = true
+		}
+		if r.Drop != nil {
+			info.Drops++
+			pids[r.Drop.PID] = true
+			commands[r.Drop.Command] = true
+		}
+	}
+
+	if info.Unindexed || len(pids) > maxIndexedKeys || len(commands) > maxIndexedKeys {
+		info.Unindexed = true
+		info.PIDs = nil
+		info.Commands = nil
+		return
+	}
+
+	info.PIDs = info.PIDs[:0]
+	for pid := range pids {
+		info.PIDs = append(info.PIDs, pid)
+	}
+	sort.Slice(info.PIDs, func(i, j int) bool { return info.PIDs[i] < info.PIDs[j] })
+	info.Commands = info.Commands[:0]
+	for command := range commands {
+		info.Commands = append(info.Commands, command)
+	}
+	sort.Strings(info.Commands)
+}
+
+// overlaps reports whether the segment has events in [start, end)
+func (info SegmentInfo) overlaps(start, end time.Time) bool {
+	if !start.IsZero() && info.End.Before(start) {
+		return false
+	}
+	if !end.IsZero() && !info.Start.Before(end) {
+		return false
+	}
+	return true
+}
+
+// mayContain uses the PID and process index to skip segments that cannot match
+func (info SegmentInfo) mayContain(pid *int, command string) bool {
+	if info.Unindexed {
+		return true
+	}
+	if pid != nil {
+		i := sort.Search(len(info.PIDs), func(i int) bool { return info.PIDs[i] >= uint32(*pid) })
+		if i == len(info.PIDs) || info.PIDs[i] != uint32(*pid) {
+			return false
+		}
+	}
+	if command != "" {
+		i := sort.SearchStrings(info.Commands, command)
+		if i == len(info.Commands) || info.Commands[i] != command {
+			return false
+		}
+	}
+	return true
+}
+
+func matches(q Query, pid uint32, command string) bool {
+	return (q.PID == nil || pid == uint32(*q.PID)) && (q.Command == "" || command == q.Command)
+}
+
+// recordKey identifies a record for duplicate removal
+func recordKey(r record) string {
+	if r.Connection != nil {
+		c := r.Connection
+		return fmt.Sprintf("c|%d|%d|%d|%s|%d|%s|%d", c.PID, c.TimestampNS, c.WallTime.UnixNano(), c.DestinationIP, c.DestinationPort, c.Protocol, c.ReturnCode)
+	}
+	if r.Drop != nil {
+		return fmt.Sprintf("d|%d|%s|%v|%s", r.Drop.PID, r.Drop.Command, r.Drop.Timestamp, r.Drop.Reason)
+	}
+	return ""
+}
+
+// DropTime returns when a packet drop happened. Drop timestamps that are not wall-clock
+// nanoseconds (e.g. time since boot) fall back to the given time.
+func DropTime(drop netclient.PacketDropInfo, fallback time.Time) time.Time {
+	at := time.Unix(0, int64(drop.Timestamp))
+	if at.Year() < 2000 {
+		return fallback
+	}
+	return at
+}
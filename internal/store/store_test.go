@@ -0,0 +1,246 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+func connection(pid uint32, command, ip string, at time.Time) netclient.ConnectionEvent {
+	return netclient.ConnectionEvent{
+		PID:             pid,
+		Command:         command,
+		DestinationIP:   ip,
+		DestinationPort: 443,
+		Protocol:        "TCP",
+		TimestampNS:     uint64(at.UnixNano()),
+		WallTime:        at,
+	}
+}
+
+func openTestStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	return s
+}
+
+func TestStoreAppendQuery(t *testing.T) {
+	s := openTestStore(t, DefaultOptions())
+	now := time.Now().UTC().Truncate(time.Second)
+
+	snapshot := Snapshot{
+		Time: now,
+		Connections: []netclient.ConnectionEvent{
+			connection(10, "curl", "1.1.1.1", now.Add(-3*time.Hour)),
+			connection(10, "curl", "1.1.1.2", now.Add(-2*time.Hour)),
+			connection(20, "nginx", "10.0.0.5", now.Add(-90*time.Minute)),
+			connection(20, "nginx", "10.0.0.5", now.Add(-time.Minute)),
+		},
+		Drops: []netclient.PacketDropInfo{
+			{PID: 20, Command: "nginx", Reason: "NO_SOCKET", Timestamp: float64(now.Add(-time.Minute).UnixNano())},
+		},
+	}
+	if err := s.Append(snapshot); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	all, err := s.Query(Query{})
+	if err != nil || len(all.Connections) != 4 || len(all.Drops) != 1 {
+		t.Fatalf("expected all events, got %+v, %v", all, err)
+	}
+	if !all.Connections[0].WallTime.Equal(now.Add(-3 * time.Hour)) {
+		t.Errorf("expected results in time order, got %v first", all.Connections[0].WallTime)
+	}
+
+	recent, _ := s.Query(Query{Start: now.Add(-100 * time.Minute), End: now})
+	if len(recent.Connections) != 2 {
+		t.Errorf("expected 2 events in the last 100 minutes, got %d", len(recent.Connections))
+	}
+
+	pid := 10
+	byPID, _ := s.Query(Query{PID: &pid})
+	if len(byPID.Connections) != 2 || len(byPID.Drops) != 0 {
+		t.Errorf("expected curl's events only, got %+v", byPID)
+	}
+
+	byCommand, _ := s.Query(Query{Command: "nginx", Start: now.Add(-10 * time.Minute)})
+	if len(byCommand.Connections) != 1 || len(byCommand.Drops) != 1 {
+		t.Errorf("expected recent nginx events, got %+v", byCommand)
+	}
+
+	stats := s.Stats()
+	if stats.Connections != 4 || stats.Drops != 1 || stats.Segments < 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if !s.Oldest().Equal(now.Add(-3 * time.Hour)) {
+		t.Errorf("unexpected oldest event time %v", s.Oldest())
+	}
+}
+
+func TestStoreQueryOrdersDrops(t *testing.T) {
+	s := openTestStore(t, DefaultOptions())
+	now := time.Now().UTC().Truncate(time.Second)
+	drop := func(reason string, at time.Time) netclient.PacketDropInfo {
+		return netclient.PacketDropInfo{PID: 20, Command: "nginx", Reason: reason, Timestamp: float64(at.UnixNano())}
+	}
+
+	// The second snapshot reports drops older than the first one, in two different segments
+	snapshots := []Snapshot{
+		{Time: now, Drops: []netclient.PacketDropInfo{drop("latest", now.Add(-time.Minute))}},
+		{Time: now, Drops: []netclient.PacketDropInfo{
+			drop("middle", now.Add(-2*time.Minute)),
+			drop("oldest", now.Add(-3*time.Hour)),
+		}},
+	}
+	for _, snapshot := range snapshots {
+		if err := s.Append(snapshot); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	result, err := s.Query(Query{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var reasons []string
+	for _, d := range result.Drops {
+		reasons = append(reasons, d.Reason)
+	}
+	if strings.Join(reasons, ",") != "oldest,middle,latest" {
+		t.Errorf("expected drops in time order, got %v", reasons)
+	}
+	if stats := s.Stats(); stats.Segments < 2 {
+		t.Errorf("expected the drops to span segments, got %+v", stats)
+	}
+}
+
+func TestSegmentIndexPruning(t *testing.T) {
+	info := SegmentInfo{PIDs: []uint32{10, 20}, Commands: []string{"curl", "nginx"}}
+	pid := 10
+	other := 30
+	if !info.mayContain(&pid, "curl") || info.mayContain(&other, "") || info.mayContain(nil, "sshd") {
+		t.Error("unexpected PID/process index match")
+	}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	info.Start, info.End = start, start.Add(time.Hour)
+	if info.overlaps(start.Add(2*time.Hour), time.Time{}) || info.overlaps(time.Time{}, start) || !info.overlaps(start.Add(time.Minute), start.Add(2*time.Minute)) {
+		t.Error("unexpected time index match")
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	s := openTestStore(t, DefaultOptions())
+	now := time.Now().UTC()
+	old := now.Add(-2 * time.Hour)
+
+	// Overlapping snapshots record the same events twice
+	events := []netclient.ConnectionEvent{
+		connection(10, "curl", "1.1.1.2", old.Add(time.Second)),
+		connection(10, "curl", "1.1.1.1", old),
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Append(Snapshot{Time: now, Connections: events}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Compact(now); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	result, _ := s.Query(Query{})
+	if len(result.Connections) != 2 {
+		t.Fatalf("expected duplicates to be removed, got %d events", len(result.Connections))
+	}
+	if result.Connections[0].DestinationIP != "1.1.1.1" {
+		t.Errorf("expected sorted events, got %+v", result.Connections)
+	}
+	if stats := s.Stats(); stats.Connections != 2 {
+		t.Errorf("expected index to reflect compaction, got %+v", stats)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	s := openTestStore(t, Options{Retention: 24 * time.Hour})
+	now := time.Now().UTC()
+
+	err := s.Append(Snapshot{Time: now, Connections: []netclient.ConnectionEvent{
+		connection(10, "curl", "1.1.1.1", now.Add(-48*time.Hour)),
+		connection(10, "curl", "1.1.1.2", now.Add(-time.Hour)),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := s.Query(Query{})
+	if len(result.Connections) != 1 || result.Connections[0].DestinationIP != "1.1.1.2" {
+		t.Errorf("expected expired events to be removed, got %+v", result.Connections)
+	}
+	segments, _ := os.ReadDir(filepath.Join(s.Dir(), segmentDir))
+	if len(segments) != 1 {
+		t.Errorf("expected expired segment file to be deleted, got %d files", len(segments))
+	}
+}
+
+func TestStoreSizeLimit(t *testing.T) {
+	s := openTestStore(t, Options{MaxBytes: 1})
+	now := time.Now().UTC()
+
+	for i := 3; i > 0; i-- {
+		at := now.Add(-time.Duration(i) * time.Hour)
+		if err := s.Append(Snapshot{Time: at, Connections: []netclient.ConnectionEvent{connection(10, "curl", "1.1.1.1", at)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the newest segment survives a size limit smaller than one segment
+	if stats := s.Stats(); stats.Segments != 1 || !stats.Oldest.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected only the newest segment, got %+v", stats)
+	}
+}
+
+func TestStoreRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	if err := s.Append(Snapshot{Time: now, Connections: []netclient.ConnectionEvent{connection(10, "curl", "1.1.1.1", now)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the index and leave a torn write at the end of the segment
+	os.WriteFile(filepath.Join(dir, indexFile), []byte("{not json"), 0o600)
+	f, _ := os.OpenFile(s.segmentPath(s.segmentName(now)), os.O_APPEND|os.O_WRONLY, 0o600)
+	f.WriteString(`{"t":"2025-01-01T00:00:00Z","c":{"pid":`)
+	f.Close()
+
+	reopened, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	pid := 10
+	result, err := reopened.Query(Query{PID: &pid})
+	if err != nil || len(result.Connections) != 1 {
+		t.Errorf("expected event to survive index rebuild, got %+v, %v", result, err)
+	}
+}
+
+func TestDropTime(t *testing.T) {
+	fallback := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	wall := fallback.Add(time.Hour)
+	if got := DropTime(netclient.PacketDropInfo{Timestamp: float64(wall.UnixNano())}, fallback); got.Sub(wall).Abs() > time.Millisecond {
+		t.Errorf("expected wall-clock drop time, got %v", got)
+	}
+	if got := DropTime(netclient.PacketDropInfo{Timestamp: 5e9}, fallback); !got.Equal(fallback) {
+		t.Errorf("expected fallback for boot-relative timestamps, got %v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/srodi/netspy/internal/analysis"
+)
+
+// AnomalyReport is the structured form of a detect_anomalies result
+type AnomalyReport struct {
+	TotalEvents int                `json:"total_events"`
+	MinSeverity string             `json:"min_severity"`
+	Anomalies   []analysis.Anomaly `json:"anomalies"`
+}
+
+// severityIcons marks findings by severity in text output
+var severityIcons = map[analysis.Severity]string{
+	analysis.SeverityHigh:   "🔴",
+	analysis.SeverityMedium: "🟠",
+	analysis.SeverityLow:    "🟡",
+}
+
+// FormatAnomalyReport renders anomaly findings with their supporting evidence
+func FormatAnomalyReport(report AnomalyReport) string {
+	if len(report.Anomalies) == 0 {
+		return fmt.Sprintf("No anomalies detected in %d connection events (minimum severity: %s)", report.TotalEvents, report.MinSeverity)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Anomaly Detection: %d findings in %d connection events\n", len(report.Anomalies), report.TotalEvents))
+	for _, a := range report.Anomalies {
+		sb.WriteString(fmt.Sprintf("\n%s [%s] %s: %s (PID %d) %s\n",
+			severityIcons[a.Severity], strings.ToUpper(string(a.Severity)), a.Type, a.Command, a.PID, a.Description))
+		sb.WriteString(fmt.Sprintf("  Target: %s\n", a.Target))
+		sb.WriteString(fmt.Sprintf("  Window: %s → %s\n", a.Start.Format("15:04:05.000"), a.End.Format("15:04:05.000")))
+
+		evidence := []string{fmt.Sprintf("%d events", a.Evidence.Events)}
+		if a.Evidence.DistinctIPs > 0 {
+			evidence = append(evidence, fmt.Sprintf("%d distinct IPs", a.Evidence.DistinctIPs))
+		}
+		if a.Evidence.DistinctPorts > 0 {
+			evidence = append(evidence, fmt.Sprintf("%d distinct ports", a.Evidence.DistinctPorts))
+		}
+		if a.Evidence.Failures > 0 {
+			evidence = append(evidence, fmt.Sprintf("%d failed", a.Evidence.Failures))
+		}
+		if a.Evidence.BaselineRate > 0 {
+			evidence = append(evidence, fmt.Sprintf("%.1f/s vs baseline %.2f/s", a.Evidence.Rate, a.Evidence.BaselineRate))
+		}
+		if len(a.Evidence.ErrorCodes) > 0 {
+			codes := make([]string, len(a.Evidence.ErrorCodes))
+			for i, code := range a.Evidence.ErrorCodes {
+				codes[i] = fmt.Sprintf("%d", code)
+			}
+			evidence = append(evidence, "error codes "+strings.Join(codes, ", "))
+		}
+		sb.WriteString(fmt.Sprintf("  Evidence: %s\n", strings.Join(evidence, ", ")))
+		if len(a.Evidence.Samples) > 0 {
+			sb.WriteString(fmt.Sprintf("  Sample destinations: %s\n", strings.Join(a.Evidence.Samples, ", ")))
+		}
+	}
+	return sb.String()
+}
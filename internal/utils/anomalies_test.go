@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/analysis"
+)
+
+func TestFormatAnomalyReport(t *testing.T) {
+	empty := FormatAnomalyReport(AnomalyReport{TotalEvents: 12, MinSeverity: "medium"})
+	if !strings.HasPrefix(empty, "No anomalies detected in 12 connection events") {
+		t.Errorf("unexpected empty report: %s", empty)
+	}
+
+	start := time.Date(2025, 1, 1, 14, 2, 11, 0, time.UTC)
+	report := AnomalyReport{
+		TotalEvents: 40,
+		MinSeverity: "low",
+		Anomalies: []analysis.Anomaly{{
+			Type:        analysis.AnomalyConnectionStorm,
+			Severity:    analysis.SeverityHigh,
+			PID:         400,
+			Command:     "client",
+			Target:      "all destinations",
+			Description: "40 connection attempts in 7.8s, 40 of them failed",
+			Evidence: analysis.Evidence{
+				Events:      40,
+				DistinctIPs: 1,
+				Failures:    40,
+				ErrorCodes:  []int32{-111, -110},
+				Samples:     []string{"10.0.0.7:8080"},
+			},
+			Start: start,
+			End:   start.Add(7800 * time.Millisecond),
+		}},
+	}
+
+	output := FormatAnomalyReport(report)
+	for _, expected := range []string{
+		"1 findings in 40 connection events",
+		"[HIGH] connection_storm: client (PID 400)",
+		"Evidence: 40 events, 1 distinct IPs, 40 failed, error codes -111, -110",
+		"Sample destinations: 10.0.0.7:8080",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
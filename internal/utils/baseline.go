@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/store"
+)
+
+// maxListedDeviations bounds the new destinations shown per process in text output
+const maxListedDeviations = 5
+
+// BaselineReport is the structured form of a compare_to_baseline result
+type BaselineReport struct {
+	DurationSeconds int  `json:"duration_seconds"`
+	Learned         bool `json:"learned"`
+	// HistoryWindows counts the recorded windows learned before comparing
+	HistoryWindows int                  `json:"history_windows,omitempty"`
+	Processes      []analysis.Deviation `json:"processes"`
+}
+
+// statusIcons marks processes by baseline comparison status in text output
+var statusIcons = map[string]string{
+	analysis.StatusDeviating:  "🔴",
+	analysis.StatusNewProcess: "🆕",
+	analysis.StatusLearning:   "⏳",
+	analysis.StatusNormal:     "✅",
+}
+
+// FormatBaselineReport renders per-process baseline deviations
+func FormatBaselineReport(report BaselineReport) string {
+	var history string
+	if report.HistoryWindows > 0 {
+		history = fmt.Sprintf("Learned %d windows from recorded history.\n", report.HistoryWindows)
+	}
+	if len(report.Processes) == 0 {
+		return history + fmt.Sprintf("No network activity in the last %d seconds to compare against the baseline", report.DurationSeconds)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(history)
+	sb.WriteString(fmt.Sprintf("Baseline Comparison (last %d seconds):\n", report.DurationSeconds))
+	for _, d := range report.Processes {
+		sb.WriteString(fmt.Sprintf("\n%s %s — score %d (%s", statusIcons[d.Status], d.Command, d.Score, strings.ReplaceAll(d.Status, "_", " ")))
+		if d.Snapshots > 0 {
+			sb.WriteString(fmt.Sprintf(", %d snapshots", d.Snapshots))
+		}
+		sb.WriteString(")\n")
+
+		if d.Status == analysis.StatusNewProcess {
+			sb.WriteString(fmt.Sprintf("    Not seen before: %d connections (%.1f/min)\n", d.Connections, d.Rate))
+			continue
+		}
+
+		rate := fmt.Sprintf("    Rate: %.1f/min (baseline %.1f–%.1f/min)", d.Rate, d.BaselineMinRate, d.BaselineMaxRate)
+		if d.RateSpike {
+			rate += " ⚠️ spike"
+		}
+		sb.WriteString(rate + "\n")
+		if d.DropSpike {
+			sb.WriteString(fmt.Sprintf("    Drops: %.1f/min ⚠️ spike\n", d.DropRate))
+		}
+		if len(d.NewDestinations) > 0 {
+			sb.WriteString(fmt.Sprintf("    New destinations (%d): %s\n", len(d.NewDestinations), truncatedList(d.NewDestinations, maxListedDeviations)))
+		}
+		if len(d.NewPorts) > 0 {
+			sb.WriteString(fmt.Sprintf("    New ports: %s\n", truncatedList(d.NewPorts, maxListedDeviations)))
+		}
+		if len(d.NewFailureModes) > 0 {
+			sb.WriteString(fmt.Sprintf("    New failure modes: %s\n", strings.Join(d.NewFailureModes, ", ")))
+		}
+	}
+
+	if report.Learned {
+		sb.WriteString("\nThis window has been added to the baseline.\n")
+	}
+	return sb.String()
+}
+
+// HistoryWindows splits recorded events into consecutive windows of the given length starting
+// at start. Only whole windows ending by end are returned; events outside them are ignored.
+func HistoryWindows(result store.Result, start, end time.Time, length time.Duration) []analysis.Window {
+	if length <= 0 || !start.Before(end) {
+		return nil
+	}
+	count := int(end.Sub(start) / length)
+	windows := make([]analysis.Window, count)
+	for i := range windows {
+		windows[i].Start = start.Add(time.Duration(i) * length)
+		windows[i].End = windows[i].Start.Add(length)
+	}
+	index := func(at time.Time) int {
+		if at.Before(start) {
+			return -1
+		}
+		if i := int(at.Sub(start) / length); i < count {
+			return i
+		}
+		return -1
+	}
+
+	for _, event := range result.Connections {
+		if i := index(event.WallTime); i >= 0 {
+			windows[i].Connections = append(windows[i].Connections, event)
+		}
+	}
+	for _, drop := range result.Drops {
+		if i := index(store.DropTime(drop, time.Time{})); i >= 0 {
+			windows[i].Drops = append(windows[i].Drops, drop)
+		}
+	}
+	return windows
+}
+
+// FilterDropsSince keeps packet drops that occurred at or after the given time. Drops whose
+// timestamp is not wall-clock time (e.g. time since boot) are kept.
+func FilterDropsSince(drops []netclient.PacketDropInfo, since time.Time) []netclient.PacketDropInfo {
+	var filtered []netclient.PacketDropInfo
+	for _, drop := range drops {
+		if !store.DropTime(drop, since).Before(since) {
+			filtered = append(filtered, drop)
+		}
+	}
+	return filtered
+}
+
+func truncatedList(items []string, limit int) string {
+	if len(items) <= limit {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:limit], ", "), len(items)-limit)
+}
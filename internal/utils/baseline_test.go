@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/netclient"
+	"github.com/srodi/netspy/internal/store"
+)
+
+func TestFormatBaselineReport(t *testing.T) {
+	report := BaselineReport{
+		DurationSeconds: 60,
+		Learned:         true,
+		Processes: []analysis.Deviation{
+			{
+				Command:         "nginx",
+				Status:          analysis.StatusDeviating,
+				Score:           72,
+				Snapshots:       12,
+				Rate:            60,
+				BaselineMinRate: 10,
+				BaselineMaxRate: 20,
+				RateSpike:       true,
+				NewDestinations: []string{"1.1.1.1:6667", "1.1.1.2:6667", "1.1.1.3:6667", "1.1.1.4:6667", "1.1.1.5:6667", "1.1.1.6:6667"},
+				NewPorts:        []string{"6667/tcp"},
+				NewFailureModes: []string{"connect error -111"},
+			},
+			{Command: "xmrig", Status: analysis.StatusNewProcess, Score: 50, Connections: 3, Rate: 3},
+		},
+	}
+
+	output := FormatBaselineReport(report)
+	for _, expected := range []string{
+		"nginx — score 72 (deviating, 12 snapshots)",
+		"Rate: 60.0/min (baseline 10.0–20.0/min) ⚠️ spike",
+		"New destinations (6): 1.1.1.1:6667, 1.1.1.2:6667, 1.1.1.3:6667, 1.1.1.4:6667, 1.1.1.5:6667 and 1 more",
+		"New ports: 6667/tcp",
+		"New failure modes: connect error -111",
+		"xmrig — score 50 (new process)",
+		"added to the baseline",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestFilterDropsSince(t *testing.T) {
+	since := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	drops := []netclient.PacketDropInfo{
+		{Command: "old", Timestamp: float64(since.Add(-time.Minute).UnixNano())},
+		{Command: "new", Timestamp: float64(since.Add(time.Minute).UnixNano())},
+		{Command: "boot-relative", Timestamp: 123456789},
+	}
+
+	filtered := FilterDropsSince(drops, since)
+	if len(filtered) != 2 || filtered[0].Command != "new" || filtered[1].Command != "boot-relative" {
+		t.Errorf("unexpected filtered drops: %+v", filtered)
+	}
+}
+
+func TestHistoryWindows(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) time.Time { return start.Add(offset) }
+	result := store.Result{
+		Connections: []netclient.ConnectionEvent{
+			{Command: "early", WallTime: at(-time.Second)},
+			{Command: "first", WallTime: at(30 * time.Second)},
+			{Command: "second", WallTime: at(90 * time.Second)},
+			{Command: "partial", WallTime: at(150 * time.Second)},
+		},
+		Drops: []netclient.PacketDropInfo{
+			{Command: "second", Timestamp: float64(at(61 * time.Second).UnixNano())},
+			{Command: "boot-relative", Timestamp: 123456789},
+		},
+	}
+
+	// 2.5 minutes of history make two whole one-minute windows
+	windows := HistoryWindows(result, start, at(150*time.Second), time.Minute)
+	if len(windows) != 2 || !windows[1].Start.Equal(at(time.Minute)) || !windows[1].End.Equal(at(2*time.Minute)) {
+		t.Fatalf("unexpected windows: %+v", windows)
+	}
+	if len(windows[0].Connections) != 1 || windows[0].Connections[0].Command != "first" || len(windows[0].Drops) != 0 {
+		t.Errorf("unexpected first window: %+v", windows[0])
+	}
+	if len(windows[1].Connections) != 1 || len(windows[1].Drops) != 1 || windows[1].Drops[0].Command != "second" {
+		t.Errorf("unexpected second window: %+v", windows[1])
+	}
+
+	baseline := analysis.NewBaseline()
+	for _, window := range windows {
+		baseline.Learn(window)
+	}
+	if baseline.Processes["second"] == nil || baseline.Processes["partial"] != nil {
+		t.Errorf("expected only whole windows to be learned, got %v", baseline.Processes)
+	}
+}
@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// Rate changes below these thresholds are not reported by DiffWindows
+const (
+	rateChangeFactor  = 1.5 // Relative change in either direction
+	rateChangeMinimum = 1.0 // Absolute change in connections per minute
+)
+
+// WindowSummary describes one side of a window comparison
+type WindowSummary struct {
+	Label       string        `json:"label"`
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end"`
+	Connections int           `json:"connections"`
+	Drops       int           `json:"drops"`
+	Patterns    PatternReport `json:"patterns"`
+}
+
+// DestinationChange is a destination that appeared or vanished between windows
+type DestinationChange struct {
+	Destination string   `json:"destination"`
+	Hostname    string   `json:"hostname,omitempty"`
+	Count       int      `json:"count"`
+	Processes   []string `json:"processes"`
+}
+
+// RateChange is a per-process connection rate that changed between windows
+type RateChange struct {
+	Command       string  `json:"command"`
+	BeforeCount   int     `json:"before_count"`
+	AfterCount    int     `json:"after_count"`
+	BeforeRate    float64 `json:"before_rate_per_minute"`
+	AfterRate     float64 `json:"after_rate_per_minute"`
+	ChangePercent float64 `json:"change_percent,omitempty"` // Unset for processes absent from the before window
+}
+
+// WindowDiff is the structured form of a compare_windows result
+type WindowDiff struct {
+	Before                 WindowSummary       `json:"before"`
+	After                  WindowSummary       `json:"after"`
+	NewDestinations        []DestinationChange `json:"new_destinations"`
+	VanishedDestinations   []DestinationChange `json:"vanished_destinations"`
+	RateChanges            []RateChange        `json:"rate_changes"`
+	NewDropReasons         []GroupCount        `json:"new_drop_reasons"`
+	NewFailingDestinations []DestinationChange `json:"new_failing_destinations"`
+}
+
+// windowAggregate holds the per-window counts a diff is computed from
+type windowAggregate struct {
+	destinations map[string]*DestinationChange
+	failing      map[string]*DestinationChange
+	processes    map[string]int
+	dropReasons  map[string]int
+}
+
+// DiffWindows compares two windows, reporting destinations that appeared or vanished, processes
+// whose connection rate changed, and drop reasons and failing destinations not seen before
+func DiffWindows(beforeLabel string, before analysis.Window, afterLabel string, after analysis.Window) WindowDiff {
+	beforeAgg := aggregateWindow(before)
+	afterAgg := aggregateWindow(after)
+
+	diff := WindowDiff{
+		Before:                 summarizeWindow(beforeLabel, before),
+		After:                  summarizeWindow(afterLabel, after),
+		NewDestinations:        missingFrom(afterAgg.destinations, beforeAgg.destinations),
+		VanishedDestinations:   missingFrom(beforeAgg.destinations, afterAgg.destinations),
+		NewFailingDestinations: missingFrom(afterAgg.failing, beforeAgg.failing),
+	}
+
+	for reason, count := range afterAgg.dropReasons {
+		if beforeAgg.dropReasons[reason] == 0 {
+			diff.NewDropReasons = append(diff.NewDropReasons, GroupCount{Key: reason, Count: count})
+		}
+	}
+	diff.NewDropReasons = sortedGroupCounts(diff.NewDropReasons)
+
+	commands := make(map[string]bool)
+	for command := range beforeAgg.processes {
+		commands[command] = true
+	}
+	for command := range afterAgg.processes {
+		commands[command] = true
+	}
+	for command := range commands {
+		change := RateChange{
+			Command:     command,
+			BeforeCount: beforeAgg.processes[command],
+			AfterCount:  afterAgg.processes[command],
+			BeforeRate:  float64(beforeAgg.processes[command]) / before.Minutes(),
+			AfterRate:   float64(afterAgg.processes[command]) / after.Minutes(),
+		}
+		if change.BeforeRate > 0 {
+			change.ChangePercent = (change.AfterRate - change.BeforeRate) / change.BeforeRate * 100
+		}
+		if significantRateChange(change) {
+			diff.RateChanges = append(diff.RateChanges, change)
+		}
+	}
+	sort.Slice(diff.RateChanges, func(i, j int) bool {
+		di := math.Abs(diff.RateChanges[i].AfterRate - diff.RateChanges[i].BeforeRate)
+		dj := math.Abs(diff.RateChanges[j].AfterRate - diff.RateChanges[j].BeforeRate)
+		if di != dj {
+			return di > dj
+		}
+		return diff.RateChanges[i].Command < diff.RateChanges[j].Command
+	})
+
+	return diff
+}
+
+// HasChanges reports whether the diff found any difference
+func (d WindowDiff) HasChanges() bool {
+	return len(d.NewDestinations) > 0 || len(d.VanishedDestinations) > 0 || len(d.RateChanges) > 0 ||
+		len(d.NewDropReasons) > 0 || len(d.NewFailingDestinations) > 0
+}
+
+// FormatWindowDiff renders a window comparison as text
+func FormatWindowDiff(diff WindowDiff) string {
+	var sb strings.Builder
+	sb.WriteString("Window Comparison:\n")
+	for _, side := range []WindowSummary{diff.Before, diff.After} {
+		sb.WriteString(fmt.Sprintf("  %-7s %s → %s: %d connections, %d drops\n", side.Label+":",
+			side.Start.Local().Format("2006-01-02 15:04:05"), side.End.Local().Format("15:04:05"), side.Connections, side.Drops))
+	}
+
+	if !diff.HasChanges() {
+		sb.WriteString("\n✅ No new or vanished destinations, rate changes, new drop reasons or new failures\n")
+		return sb.String()
+	}
+
+	writeDestinationChanges(&sb, "🆕 New destinations", diff.NewDestinations)
+	writeDestinationChanges(&sb, "👻 Vanished destinations", diff.VanishedDestinations)
+
+	if len(diff.RateChanges) > 0 {
+		sb.WriteString(fmt.Sprintf("\n📈 Rate changes (%d processes):\n", len(diff.RateChanges)))
+		for i, change := range diff.RateChanges {
+			if i == topListLimit {
+				sb.WriteString(fmt.Sprintf("    ... and %d more\n", len(diff.RateChanges)-topListLimit))
+				break
+			}
+			line := fmt.Sprintf("    %s: %.1f/min → %.1f/min", change.Command, change.BeforeRate, change.AfterRate)
+			switch {
+			case change.BeforeCount == 0:
+				line += " (new)"
+			case change.AfterCount == 0:
+				line += " (stopped)"
+			default:
+				line += fmt.Sprintf(" (%+.0f%%)", change.ChangePercent)
+			}
+			sb.WriteString(line + "\n")
+		}
+	}
+
+	if len(diff.NewDropReasons) > 0 {
+		sb.WriteString(fmt.Sprintf("\n🕳️  New drop reasons: %s\n", formatInlineCounts(diff.NewDropReasons)))
+	}
+
+	writeDestinationChanges(&sb, "❌ New failing destinations", diff.NewFailingDestinations)
+	return sb.String()
+}
+
+func writeDestinationChanges(sb *strings.Builder, title string, changes []DestinationChange) {
+	if len(changes) == 0 {
+		return
+	}
+	sb.WriteString(fmt.Sprintf("\n%s (%d):\n", title, len(changes)))
+	for i, change := range changes {
+		if i == topListLimit {
+			sb.WriteString(fmt.Sprintf("    ... and %d more\n", len(changes)-topListLimit))
+			break
+		}
+		dest := change.Destination
+		if change.Hostname != "" {
+			dest = fmt.Sprintf("%s (%s)", dest, change.Hostname)
+		}
+		sb.WriteString(fmt.Sprintf("    %s — %d connections by %s\n", dest, change.Count, truncatedList(change.Processes, 3)))
+	}
+}
+
+// summarizeWindow builds the pattern report for one side of a comparison
+func summarizeWindow(label string, window analysis.Window) WindowSummary {
+	return WindowSummary{
+		Label:       label,
+		Start:       window.Start,
+		End:         window.End,
+		Connections: len(window.Connections),
+		Drops:       len(window.Drops),
+		Patterns:    BuildPatternReport(window.Connections),
+	}
+}
+
+// aggregateWindow counts destinations, failing destinations, processes and drop reasons.
+// Destinations are keyed by address and port so differing hostname resolution does not
+// register as a change.
+func aggregateWindow(window analysis.Window) windowAggregate {
+	agg := windowAggregate{
+		destinations: make(map[string]*DestinationChange),
+		failing:      make(map[string]*DestinationChange),
+		processes:    make(map[string]int),
+		dropReasons:  make(map[string]int),
+	}
+
+	for _, event := range window.Connections {
+		agg.processes[event.Command]++
+		if event.DestinationIP == "" {
+			continue
+		}
+		dest := fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)
+		addDestination(agg.destinations, dest, event)
+		if analysis.IsFailure(event) {
+			addDestination(agg.failing, dest, event)
+		}
+	}
+	for _, drop := range window.Drops {
+		agg.dropReasons[drop.Reason]++
+	}
+	return agg
+}
+
+func addDestination(destinations map[string]*DestinationChange, dest string, event netclient.ConnectionEvent) {
+	change, ok := destinations[dest]
+	if !ok {
+		change = &DestinationChange{Destination: dest}
+		destinations[dest] = change
+	}
+	change.Count++
+	if change.Hostname == "" {
+		change.Hostname = event.Hostname
+	}
+	for _, process := range change.Processes {
+		if process == event.Command {
+			return
+		}
+	}
+	change.Processes = append(change.Processes, event.Command)
+}
+
+// missingFrom returns the destinations in from that other does not have, busiest first
+func missingFrom(from, other map[string]*DestinationChange) []DestinationChange {
+	var missing []DestinationChange
+	for dest, change := range from {
+		if _, ok := other[dest]; !ok {
+			sort.Strings(change.Processes)
+			missing = append(missing, *change)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Count != missing[j].Count {
+			return missing[i].Count > missing[j].Count
+		}
+		return missing[i].Destination < missing[j].Destination
+	})
+	return missing
+}
+
+// significantRateChange filters out small rate fluctuations
+func significantRateChange(change RateChange) bool {
+	if math.Abs(change.AfterRate-change.BeforeRate) < rateChangeMinimum {
+		return false
+	}
+	if change.BeforeRate == 0 || change.AfterRate == 0 {
+		return true
+	}
+	ratio := change.AfterRate / change.BeforeRate
+	return ratio >= rateChangeFactor || ratio <= 1/rateChangeFactor
+}
+
+func sortedGroupCounts(groups []GroupCount) []GroupCount {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+func diffEvents(command, ip string, port uint16, rc int32, count int, start time.Time) []netclient.ConnectionEvent {
+	events := make([]netclient.ConnectionEvent, count)
+	for i := range events {
+		at := start.Add(time.Duration(i) * time.Second)
+		events[i] = netclient.ConnectionEvent{
+			PID:             100,
+			Command:         command,
+			DestinationIP:   ip,
+			DestinationPort: port,
+			Protocol:        "TCP",
+			ReturnCode:      rc,
+			WallTime:        at,
+			TimestampNS:     uint64(at.UnixNano()),
+		}
+	}
+	return events
+}
+
+func TestDiffWindows(t *testing.T) {
+	deploy := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	before := analysis.Window{Start: deploy.Add(-10 * time.Minute), End: deploy}
+	after := analysis.Window{Start: deploy, End: deploy.Add(10 * time.Minute)}
+
+	before.Connections = append(before.Connections, diffEvents("api", "10.0.0.5", 5432, 0, 100, before.Start)...)
+	before.Connections = append(before.Connections, diffEvents("api", "10.0.0.9", 6379, 0, 20, before.Start)...)
+	before.Connections = append(before.Connections, diffEvents("cron", "10.0.0.7", 443, 0, 5, before.Start)...)
+	before.Drops = []netclient.PacketDropInfo{{PID: 100, Command: "api", Reason: "NO_SOCKET"}}
+
+	after.Connections = append(after.Connections, diffEvents("api", "10.0.0.5", 5432, 0, 300, after.Start)...)
+	after.Connections = append(after.Connections, diffEvents("api", "10.0.0.12", 6379, -111, 30, after.Start)...)
+	after.Connections = append(after.Connections, diffEvents("cron", "10.0.0.7", 443, 0, 6, after.Start)...)
+	after.Drops = []netclient.PacketDropInfo{
+		{PID: 100, Command: "api", Reason: "NO_SOCKET"},
+		{PID: 100, Command: "api", Reason: "TCP_RESET"},
+		{PID: 100, Command: "api", Reason: "TCP_RESET"},
+	}
+
+	diff := DiffWindows("before", before, "after", after)
+
+	if len(diff.NewDestinations) != 1 || diff.NewDestinations[0].Destination != "10.0.0.12:6379" {
+		t.Errorf("unexpected new destinations: %+v", diff.NewDestinations)
+	}
+	if len(diff.VanishedDestinations) != 1 || diff.VanishedDestinations[0].Destination != "10.0.0.9:6379" {
+		t.Errorf("unexpected vanished destinations: %+v", diff.VanishedDestinations)
+	}
+	if len(diff.NewFailingDestinations) != 1 || diff.NewFailingDestinations[0].Count != 30 {
+		t.Errorf("unexpected failing destinations: %+v", diff.NewFailingDestinations)
+	}
+	if len(diff.NewDropReasons) != 1 || diff.NewDropReasons[0] != (GroupCount{Key: "TCP_RESET", Count: 2}) {
+		t.Errorf("unexpected drop reasons: %+v", diff.NewDropReasons)
+	}
+
+	// api went from 12/min to 33/min; cron's small change is ignored
+	if len(diff.RateChanges) != 1 || diff.RateChanges[0].Command != "api" || diff.RateChanges[0].ChangePercent != 175 {
+		t.Errorf("unexpected rate changes: %+v", diff.RateChanges)
+	}
+	if diff.Before.Patterns.TotalEvents != 125 || diff.After.Connections != 336 {
+		t.Errorf("unexpected window summaries: %+v %+v", diff.Before, diff.After)
+	}
+
+	output := FormatWindowDiff(diff)
+	for _, expected := range []string{
+		"New destinations (1):",
+		"10.0.0.12:6379 — 30 connections by api",
+		"Vanished destinations (1):",
+		"api: 12.0/min → 33.0/min (+175%)",
+		"New drop reasons: TCP_RESET (2)",
+		"New failing destinations (1):",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestDiffWindowsUnchanged(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := analysis.Window{Start: start, End: start.Add(time.Minute), Connections: diffEvents("api", "10.0.0.5", 5432, 0, 10, start)}
+
+	diff := DiffWindows("before", window, "after", window)
+	if diff.HasChanges() {
+		t.Errorf("expected no changes, got %+v", diff)
+	}
+	if !strings.Contains(FormatWindowDiff(diff), "No new or vanished destinations") {
+		t.Error("expected unchanged message")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/srodi/netspy/internal/enrich"
 	"github.com/srodi/netspy/internal/netclient"
 )
 
@@ -64,6 +65,12 @@ func FormatConnectionEvents(events []netclient.ConnectionEvent, maxEvents int) s
 		} else {
 			destStr = "(local socket)"
 		}
+		if event.Hostname != "" {
+			destStr = fmt.Sprintf("%s (%s)", event.Hostname, destStr)
+		}
+		if EventScope(event) == string(enrich.ScopeCloudMetadata) {
+			destStr += " ⚠️ cloud-metadata"
+		}
 
 		// Clean up protocol names
 		protocol := event.Protocol
@@ -91,53 +98,5 @@ func AnalyzeConnectionPatterns(events []netclient.ConnectionEvent) string {
 		return "No patterns to analyze"
 	}
 
-	// Count destinations and protocols
-	destinations := make(map[string]int)
-	protocols := make(map[string]int)
-
-	for _, event := range events {
-		dest := fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)
-		destinations[dest]++
-		protocols[event.Protocol]++
-	}
-
-	var sb strings.Builder
-	sb.WriteString("Connection Analysis:\n")
-
-	// Top destinations
-	if len(destinations) > 0 {
-		sb.WriteString("  Top destinations:\n")
-		type destCount struct {
-			dest  string
-			count int
-		}
-		var sorted []destCount
-		for dest, count := range destinations {
-			sorted = append(sorted, destCount{dest, count})
-		}
-		sort.Slice(sorted, func(i, j int) bool {
-			return sorted[i].count > sorted[j].count
-		})
-
-		limit := 10
-		if len(sorted) < limit {
-			limit = len(sorted)
-		}
-		for i := 0; i < limit; i++ {
-			sb.WriteString(fmt.Sprintf("    %s (%d connections)\n", sorted[i].dest, sorted[i].count))
-		}
-	}
-
-	// Protocol distribution
-	if len(protocols) > 0 {
-		sb.WriteString("  Protocols: ")
-		var protoStrs []string
-		for proto, count := range protocols {
-			protoStrs = append(protoStrs, fmt.Sprintf("%s (%d)", proto, count))
-		}
-		sb.WriteString(strings.Join(protoStrs, ", "))
-		sb.WriteString("\n")
-	}
-
-	return sb.String()
+	return FormatPatternReport(BuildPatternReport(events))
 }
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -69,3 +70,20 @@ func TestAnalyzeConnectionPatterns(t *testing.T) {
 		t.Errorf("expected no patterns message, got: %s", out)
 	}
 }
+
+func TestAnalyzeConnectionPatterns_Hostnames(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		makeEvent(1, "curl", "140.82.112.3", 443, "tcp", 100),
+		makeEvent(1, "curl", "140.82.112.4", 443, "tcp", 200),
+	}
+	events[0].Hostname, events[0].Domain = "lb-1.github.com", "github.com"
+	events[1].Hostname, events[1].Domain = "lb-2.github.com", "github.com"
+
+	out := AnalyzeConnectionPatterns(events)
+	if !strings.Contains(out, "Top domains:\n    github.com (2 connections)") {
+		t.Errorf("expected destinations grouped by domain, got: %s", out)
+	}
+	if !strings.Contains(out, "140.82.112.3:443 (lb-1.github.com)") {
+		t.Errorf("expected hostname in destination listing, got: %s", out)
+	}
+}
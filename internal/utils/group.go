@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/srodi/netspy/internal/enrich"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// unattributedGroup labels events with no value for the grouping key (e.g. not in a pod)
+const unattributedGroup = "(host)"
+
+// unresolvedGroup labels events whose destination has no known hostname, ASN or country
+const unresolvedGroup = "(unresolved)"
+
+// GroupCount is the number of connection events sharing a grouping key
+type GroupCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// ValidGroupBy lists the supported group_by values
+var ValidGroupBy = []string{"namespace", "pod", "container", "process", "hostname", "domain", "asn", "country", "service"}
+
+// GroupConnectionEvents counts events by workload, process or destination attribute, largest group first
+func GroupConnectionEvents(events []netclient.ConnectionEvent, groupBy string) ([]GroupCount, error) {
+	var keyFn func(netclient.ConnectionEvent) string
+	missing := unattributedGroup
+
+	switch groupBy {
+	case "namespace":
+		keyFn = func(e netclient.ConnectionEvent) string { return e.Namespace }
+	case "pod":
+		keyFn = func(e netclient.ConnectionEvent) string {
+			if e.Pod == "" {
+				return ""
+			}
+			return e.Namespace + "/" + e.Pod
+		}
+	case "container":
+		keyFn = func(e netclient.ConnectionEvent) string {
+			if e.Pod != "" && e.Container != "" {
+				return e.Namespace + "/" + e.Pod + "/" + e.Container
+			}
+			if e.ContainerID != "" {
+				return shortContainerID(e.ContainerID)
+			}
+			return ""
+		}
+	case "process":
+		keyFn = func(e netclient.ConnectionEvent) string { return e.Command }
+	case "hostname":
+		keyFn = func(e netclient.ConnectionEvent) string { return e.Hostname }
+		missing = unresolvedGroup
+	case "domain":
+		keyFn = func(e netclient.ConnectionEvent) string { return e.Domain }
+		missing = unresolvedGroup
+	case "asn":
+		keyFn = func(e netclient.ConnectionEvent) string { return enrich.FormatASN(e.ASN, e.ASOrg) }
+		missing = unresolvedGroup
+	case "country":
+		keyFn = func(e netclient.ConnectionEvent) string { return e.Country }
+		missing = unresolvedGroup
+	case "service":
+		keyFn = EventService
+		missing = unresolvedGroup
+	default:
+		return nil, fmt.Errorf("invalid group_by '%s' (expected one of: %s)", groupBy, strings.Join(ValidGroupBy, ", "))
+	}
+
+	counts := make(map[string]int)
+	for _, event := range events {
+		key := keyFn(event)
+		if key == "" {
+			key = missing
+		}
+		counts[key]++
+	}
+
+	return sortedCounts(counts), nil
+}
+
+// FormatGroupCounts renders grouped connection counts as an indented list
+func FormatGroupCounts(groupBy string, groups []GroupCount) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("  By %s:\n", groupBy))
+	for _, group := range groups {
+		sb.WriteString(fmt.Sprintf("    %s (%d connections)\n", group.Key, group.Count))
+	}
+	return sb.String()
+}
+
+// FilterByWorkload keeps events attributed to the given Kubernetes namespace and/or pod
+func FilterByWorkload(events []netclient.ConnectionEvent, namespace, pod string) []netclient.ConnectionEvent {
+	if namespace == "" && pod == "" {
+		return events
+	}
+
+	var filtered []netclient.ConnectionEvent
+	for _, event := range events {
+		if namespace != "" && event.Namespace != namespace {
+			continue
+		}
+		if pod != "" && event.Pod != pod {
+			continue
+		}
+		filtered = append(filtered, event)
+	}
+	return filtered
+}
+
+// FilterSince keeps events that occurred at or after the given time
+func FilterSince(events []netclient.ConnectionEvent, since time.Time) []netclient.ConnectionEvent {
+	var filtered []netclient.ConnectionEvent
+	for _, event := range events {
+		if !event.WallTime.Before(since) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
+
+// shortContainerID abbreviates a container ID the way container runtimes display it
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
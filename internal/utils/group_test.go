@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+func TestGroupConnectionEvents(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		{Command: "api", Namespace: "payments", Pod: "api-1", Container: "api"},
+		{Command: "api", Namespace: "payments", Pod: "api-1", Container: "api"},
+		{Command: "worker", Namespace: "payments", Pod: "worker-1", Container: "worker"},
+		{Command: "sshd"},
+	}
+
+	groups, err := GroupConnectionEvents(events, "namespace")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Key != "payments" || groups[0].Count != 3 || groups[1].Key != unattributedGroup {
+		t.Errorf("unexpected namespace groups: %+v", groups)
+	}
+
+	groups, err = GroupConnectionEvents(events, "pod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if groups[0].Key != "payments/api-1" || groups[0].Count != 2 {
+		t.Errorf("unexpected pod groups: %+v", groups)
+	}
+
+	if _, err := GroupConnectionEvents(events, "color"); err == nil {
+		t.Error("expected error for invalid group_by")
+	}
+}
+
+func TestFilterByWorkload(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		{Namespace: "payments", Pod: "api-1"},
+		{Namespace: "payments", Pod: "worker-1"},
+		{Namespace: "web", Pod: "nginx-0"},
+	}
+
+	if got := FilterByWorkload(events, "payments", ""); len(got) != 2 {
+		t.Errorf("expected 2 events in namespace, got %d", len(got))
+	}
+	if got := FilterByWorkload(events, "payments", "api-1"); len(got) != 1 {
+		t.Errorf("expected 1 event for pod, got %d", len(got))
+	}
+	if got := FilterByWorkload(events, "", ""); len(got) != 3 {
+		t.Errorf("expected no filtering, got %d", len(got))
+	}
+}
+
+func TestFilterSince(t *testing.T) {
+	now := time.Now()
+	events := []netclient.ConnectionEvent{
+		{WallTime: now.Add(-2 * time.Minute)},
+		{WallTime: now.Add(-10 * time.Second)},
+	}
+	if got := FilterSince(events, now.Add(-time.Minute)); len(got) != 1 {
+		t.Errorf("expected 1 recent event, got %d", len(got))
+	}
+}
+
+func TestGroupConnectionEvents_ASN(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		{DestinationIP: "8.8.8.8", Country: "US", ASN: 15169, ASOrg: "Google LLC"},
+		{DestinationIP: "8.8.4.4", Country: "US", ASN: 15169, ASOrg: "Google LLC"},
+		{DestinationIP: "10.0.0.1"},
+	}
+
+	groups, err := GroupConnectionEvents(events, "asn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if groups[0].Key != "AS15169 Google LLC" || groups[0].Count != 2 || groups[1].Key != unresolvedGroup {
+		t.Errorf("unexpected ASN groups: %+v", groups)
+	}
+
+	out := AnalyzeConnectionPatterns(events)
+	if !strings.Contains(out, "By ASN:\n    AS15169 Google LLC (2 connections)") || !strings.Contains(out, "By country:\n    US (2 connections)") {
+		t.Errorf("expected ASN and country sections, got: %s", out)
+	}
+}
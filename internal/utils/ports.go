@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/srodi/netspy/internal/enrich"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// typicalPorts lists the destination ports common client programs normally connect to.
+// Command names are as reported by the kernel (truncated to 15 characters).
+var typicalPorts = map[string][]uint16{
+	"curl":            {80, 443},
+	"wget":            {80, 443},
+	"apt":             {80, 443},
+	"apt-get":         {80, 443},
+	"dnf":             {80, 443},
+	"yum":             {80, 443},
+	"git-remote-http": {80, 443},
+	"ssh":             {22},
+	"scp":             {22},
+	"sftp":            {22},
+	"systemd-resolve": {53, 853, 5353},
+	"systemd-timesyn": {123},
+	"chronyd":         {123},
+	"ntpd":            {123},
+	"psql":            {5432},
+	"mysql":           {3306},
+	"redis-cli":       {6379},
+}
+
+const (
+	// profileMinEvents is the minimum history before a process's own port profile is trusted
+	profileMinEvents = 10
+	// rarePortShare flags ports carrying less than this share of a process's connections
+	rarePortShare = 0.1
+	// dominantPortShare is the share the most used port needs for the profile to be meaningful
+	dominantPortShare = 0.5
+)
+
+// UnusualPort is a destination port that stands out from a process's typical profile
+type UnusualPort struct {
+	PID         uint32 `json:"pid"`
+	Command     string `json:"command"`
+	Destination string `json:"destination"`
+	Port        uint16 `json:"port"`
+	Protocol    string `json:"protocol"`
+	Service     string `json:"service,omitempty"`
+	Count       int    `json:"count"`
+	Reason      string `json:"reason"`
+}
+
+// EventService returns the service name of an event's destination port, falling back to
+// the built-in registry and then to "port/proto" for unknown ports. Local sockets yield "".
+func EventService(event netclient.ConnectionEvent) string {
+	if event.Service != "" {
+		return event.Service
+	}
+	if event.DestinationPort == 0 {
+		return ""
+	}
+	if name := enrich.BuiltinServiceName(event.DestinationPort, event.Protocol); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%d/%s", event.DestinationPort, strings.ToLower(event.Protocol))
+}
+
+// DetectUnusualPorts highlights ports a process does not normally use: ports outside the known
+// profile of common clients, rarely used ports of processes with a dominant port, and high
+// ephemeral-range ports with no registered service
+func DetectUnusualPorts(events []netclient.ConnectionEvent) []UnusualPort {
+	type portKey struct {
+		port     uint16
+		protocol string
+	}
+
+	byCommand := make(map[string][]netclient.ConnectionEvent)
+	for _, event := range events {
+		if event.DestinationPort == 0 {
+			continue
+		}
+		byCommand[event.Command] = append(byCommand[event.Command], event)
+	}
+
+	var unusual []UnusualPort
+	for command, commandEvents := range byCommand {
+		counts := make(map[portKey]int)
+		samples := make(map[portKey]netclient.ConnectionEvent)
+		for _, event := range commandEvents {
+			key := portKey{event.DestinationPort, event.Protocol}
+			counts[key]++
+			if _, exists := samples[key]; !exists {
+				samples[key] = event
+			}
+		}
+
+		// Find the dominant port for processes without a known profile
+		var dominant portKey
+		for key, count := range counts {
+			if count > counts[dominant] || (count == counts[dominant] && key.port < dominant.port) {
+				dominant = key
+			}
+		}
+		total := len(commandEvents)
+		dominantShare := float64(counts[dominant]) / float64(total)
+		expected, hasProfile := typicalPorts[command]
+
+		for key, count := range counts {
+			var reason string
+			service := EventService(samples[key])
+			switch {
+			case hasProfile && !containsPort(expected, key.port):
+				reason = fmt.Sprintf("not typical for %s (usually %s)", command, formatPorts(expected))
+			case !hasProfile && total >= profileMinEvents && key != dominant &&
+				dominantShare >= dominantPortShare && float64(count)/float64(total) < rarePortShare:
+				reason = fmt.Sprintf("rare for %s (%d of %d connections; mostly port %d)", command, count, total, dominant.port)
+			case key.port >= enrich.EphemeralPortStart && samples[key].Service == "" &&
+				enrich.BuiltinServiceName(key.port, key.protocol) == "":
+				reason = "high port with no registered service"
+			default:
+				continue
+			}
+
+			sample := samples[key]
+			unusual = append(unusual, UnusualPort{
+				PID:         sample.PID,
+				Command:     command,
+				Destination: fmt.Sprintf("%s:%d", sample.DestinationIP, key.port),
+				Port:        key.port,
+				Protocol:    key.protocol,
+				Service:     service,
+				Count:       count,
+				Reason:      reason,
+			})
+		}
+	}
+
+	sort.Slice(unusual, func(i, j int) bool {
+		if unusual[i].Command != unusual[j].Command {
+			return unusual[i].Command < unusual[j].Command
+		}
+		return unusual[i].Port < unusual[j].Port
+	})
+	return unusual
+}
+
+// FormatUnusualPorts renders highlighted ports as an indented list
+func FormatUnusualPorts(unusual []UnusualPort) string {
+	if len(unusual) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("  ⚠️  Unusual ports:\n")
+	for _, u := range unusual {
+		sb.WriteString(fmt.Sprintf("    %s (PID %d) → %s %s (%d connections): %s\n",
+			u.Command, u.PID, u.Destination, u.Service, u.Count, u.Reason))
+	}
+	return sb.String()
+}
+
+func containsPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func formatPorts(ports []uint16) string {
+	parts := make([]string, 0, len(ports))
+	for _, p := range ports {
+		parts = append(parts, fmt.Sprintf("%d", p))
+	}
+	return strings.Join(parts, ", ")
+}
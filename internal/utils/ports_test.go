@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+func TestDetectUnusualPorts(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	// curl has a known profile: 80 and 443 are expected, 6667 is not
+	events = append(events,
+		makeEvent(10, "curl", "93.184.216.34", 443, "TCP", 100),
+		makeEvent(10, "curl", "93.184.216.34", 80, "TCP", 200),
+		makeEvent(10, "curl", "203.0.113.7", 6667, "TCP", 300),
+	)
+	// worker talks to postgres almost exclusively, with one outlier
+	for i := 0; i < 19; i++ {
+		events = append(events, makeEvent(20, "worker", "10.0.0.5", 5432, "TCP", uint64(1000+i)))
+	}
+	events = append(events, makeEvent(20, "worker", "10.0.0.9", 25, "TCP", 2000))
+	// a high port with no known service
+	events = append(events, makeEvent(30, "agent", "198.51.100.2", 55123, "TCP", 3000))
+
+	unusual := DetectUnusualPorts(events)
+	if len(unusual) != 3 {
+		t.Fatalf("expected 3 unusual ports, got %d: %+v", len(unusual), unusual)
+	}
+
+	expected := []struct {
+		command string
+		port    uint16
+		reason  string
+	}{
+		{"agent", 55123, "high port"},
+		{"curl", 6667, "not typical for curl"},
+		{"worker", 25, "rare for worker"},
+	}
+	for i, e := range expected {
+		u := unusual[i]
+		if u.Command != e.command || u.Port != e.port || !strings.Contains(u.Reason, e.reason) {
+			t.Errorf("unusual[%d] = %+v, expected %s port %d (%s)", i, u, e.command, e.port, e.reason)
+		}
+	}
+	if unusual[1].Service != "IRC" {
+		t.Errorf("expected 6667 to be labelled IRC, got %q", unusual[1].Service)
+	}
+}
+
+func TestBuildPatternReport_Services(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		makeEvent(10, "curl", "93.184.216.34", 443, "TCP", 100),
+		makeEvent(10, "curl", "93.184.216.34", 443, "TCP", 200),
+		makeEvent(11, "resolver", "8.8.8.8", 53, "UDP", 300),
+		makeEvent(12, "app", "10.0.0.1", 8088, "TCP", 400),
+		makeEvent(13, "snapd", "", 0, "Unknown(0)", 500),
+	}
+	events[3].Service = "internal-api"
+
+	report := BuildPatternReport(events)
+	output := FormatPatternReport(report)
+	if !strings.Contains(output, "By service: HTTPS (2), DNS (1), internal-api (1)") {
+		t.Errorf("expected service breakdown in output:\n%s", output)
+	}
+}
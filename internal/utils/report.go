@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/srodi/netspy/internal/analysis"
+	"github.com/srodi/netspy/internal/enrich"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+// topListLimit bounds the entries shown per section of the text report
+const topListLimit = 10
+
+// PatternReport is the structured form of a connection pattern analysis
+type PatternReport struct {
+	TotalEvents     int               `json:"total_events"`
+	TopDestinations []GroupCount      `json:"top_destinations"`
+	Protocols       []GroupCount      `json:"protocols"`
+	Scopes          []GroupCount      `json:"scopes"`
+	MetadataAccess  []ProcessCount    `json:"metadata_access,omitempty"`
+	Services        []GroupCount      `json:"services,omitempty"`
+	UnusualPorts    []UnusualPort     `json:"unusual_ports,omitempty"`
+	Failures        []ProcessFailures `json:"failures,omitempty"`
+	Beacons         []analysis.Beacon `json:"beacons,omitempty"`
+	Domains         []GroupCount      `json:"domains,omitempty"`
+	Hostnames       []GroupCount      `json:"hostnames,omitempty"`
+	ASNs            []GroupCount      `json:"asns,omitempty"`
+	Countries       []GroupCount      `json:"countries,omitempty"`
+	GroupBy         string            `json:"group_by,omitempty"`
+	Groups          []GroupCount      `json:"groups,omitempty"`
+}
+
+// ProcessCount is the number of connections made by one process
+type ProcessCount struct {
+	PID     uint32 `json:"pid"`
+	Command string `json:"command"`
+	Count   int    `json:"count"`
+}
+
+// ProcessFailures counts the failed connect calls of one process
+type ProcessFailures struct {
+	PID      uint32 `json:"pid"`
+	Command  string `json:"command"`
+	Attempts int    `json:"attempts"`
+	Failures int    `json:"failures"`
+	// Destination is where most of the failed connections went
+	Destination string `json:"destination"`
+}
+
+// Rate returns the share of connection attempts that failed
+func (f ProcessFailures) Rate() float64 {
+	if f.Attempts == 0 {
+		return 0
+	}
+	return float64(f.Failures) / float64(f.Attempts)
+}
+
+// BuildPatternReport aggregates connection events by destination, protocol, scope and
+// any enrichment present on the events
+func BuildPatternReport(events []netclient.ConnectionEvent) PatternReport {
+	destinations := make(map[string]int)
+	protocols := make(map[string]int)
+	scopes := make(map[string]int)
+	hostnames := make(map[string]int)
+	domains := make(map[string]int)
+	asns := make(map[string]int)
+	countries := make(map[string]int)
+	metadata := make(map[ProcessCount]int)
+	services := make(map[string]int)
+
+	for _, event := range events {
+		dest := fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)
+		if event.Hostname != "" {
+			dest = fmt.Sprintf("%s (%s)", dest, event.Hostname)
+			hostnames[event.Hostname]++
+		}
+		if event.Domain != "" {
+			domains[event.Domain]++
+		}
+		if event.ASN != 0 || event.ASOrg != "" {
+			asns[enrich.FormatASN(event.ASN, event.ASOrg)]++
+		}
+		if event.Country != "" {
+			countries[event.Country]++
+		}
+		destinations[dest]++
+		protocols[event.Protocol]++
+		if service := EventService(event); service != "" {
+			services[service]++
+		}
+
+		scope := EventScope(event)
+		scopes[scope]++
+		if scope == string(enrich.ScopeCloudMetadata) {
+			metadata[ProcessCount{PID: event.PID, Command: event.Command}]++
+		}
+	}
+
+	report := PatternReport{
+		TotalEvents:     len(events),
+		TopDestinations: sortedCounts(destinations),
+		Protocols:       sortedCounts(protocols),
+		Scopes:          sortedCounts(scopes),
+		Services:        sortedCounts(services),
+		UnusualPorts:    DetectUnusualPorts(events),
+		Beacons:         analysis.DetectBeacons(events, analysis.DefaultBeaconOptions()),
+		Failures:        countProcessFailures(events),
+		Domains:         sortedCounts(domains),
+		Hostnames:       sortedCounts(hostnames),
+		ASNs:            sortedCounts(asns),
+		Countries:       sortedCounts(countries),
+	}
+
+	for process, count := range metadata {
+		process.Count = count
+		report.MetadataAccess = append(report.MetadataAccess, process)
+	}
+	sort.Slice(report.MetadataAccess, func(i, j int) bool {
+		return report.MetadataAccess[i].Count > report.MetadataAccess[j].Count
+	})
+
+	return report
+}
+
+// FormatPatternReport renders a pattern report as the text shown by analyze_patterns
+func FormatPatternReport(report PatternReport) string {
+	var sb strings.Builder
+	sb.WriteString("Connection Analysis:\n")
+
+	// Top destinations
+	if len(report.TopDestinations) > 0 {
+		sb.WriteString("  Top destinations:\n")
+		writeGroupList(&sb, report.TopDestinations, topListLimit)
+	}
+
+	// Protocol distribution
+	if len(report.Protocols) > 0 {
+		sb.WriteString("  Protocols: ")
+		sb.WriteString(formatInlineCounts(report.Protocols))
+		sb.WriteString("\n")
+	}
+
+	// Destination classes, with cloud metadata access called out
+	if len(report.Scopes) > 0 {
+		sb.WriteString("  By scope: ")
+		sb.WriteString(formatInlineCounts(report.Scopes))
+		sb.WriteString("\n")
+	}
+	sb.WriteString(FormatMetadataAccess(report.MetadataAccess))
+
+	// Per-service breakdown and ports that stand out for their process
+	if len(report.Services) > 0 {
+		sb.WriteString("  By service: ")
+		sb.WriteString(formatInlineCounts(report.Services))
+		sb.WriteString("\n")
+	}
+	sb.WriteString(FormatUnusualPorts(report.UnusualPorts))
+	sb.WriteString(FormatFailures(report.Failures))
+
+	// Regular connections to one destination, typical of C2 beacons and health checks
+	sb.WriteString(FormatBeacons(report.Beacons))
+
+	// Hostname and registered domain grouping, when destinations were enriched
+	if len(report.Domains) > 0 {
+		sb.WriteString("  Top domains:\n")
+		writeGroupList(&sb, report.Domains, topListLimit)
+	}
+	if len(report.Hostnames) > 0 {
+		sb.WriteString("  Top hostnames:\n")
+		writeGroupList(&sb, report.Hostnames, topListLimit)
+	}
+
+	// Offline GeoIP/ASN grouping, when a geo database is configured
+	if len(report.ASNs) > 0 {
+		sb.WriteString("  By ASN:\n")
+		writeGroupList(&sb, report.ASNs, topListLimit)
+	}
+	if len(report.Countries) > 0 {
+		sb.WriteString("  By country:\n")
+		writeGroupList(&sb, report.Countries, topListLimit)
+	}
+
+	if report.GroupBy != "" && len(report.Groups) > 0 {
+		sb.WriteString(FormatGroupCounts(report.GroupBy, report.Groups))
+	}
+
+	return sb.String()
+}
+
+// FormatBeacons renders likely beacons with their period and timing statistics
+func FormatBeacons(beacons []analysis.Beacon) string {
+	if len(beacons) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("  🔁 Likely beacons (periodic connections):\n")
+	for _, b := range beacons {
+		destination := b.Destination
+		if b.Hostname != "" {
+			destination = fmt.Sprintf("%s (%s)", destination, b.Hostname)
+		}
+		sb.WriteString(fmt.Sprintf("    %s (PID %d) → %s every %s (%d connections, mean %.1fs, jitter ±%.1fs, score %.2f)\n",
+			b.Command, b.PID, destination, formatPeriod(b.PeriodSeconds), b.Count, b.MeanInterval, b.JitterSeconds, b.PeriodicityScore))
+	}
+	return sb.String()
+}
+
+// formatPeriod renders a period in seconds as a rounded duration such as "1m0s"
+func formatPeriod(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(100 * time.Millisecond).String()
+}
+
+// NetworkSummary is the structured form of a get_network_summary result
+type NetworkSummary struct {
+	Target          string         `json:"target"`
+	DurationSeconds int            `json:"duration_seconds"`
+	Count           int            `json:"count"`
+	Scopes          []GroupCount   `json:"scopes,omitempty"`
+	MetadataAccess  []ProcessCount `json:"metadata_access,omitempty"`
+	GroupBy         string         `json:"group_by,omitempty"`
+	Groups          []GroupCount   `json:"groups,omitempty"`
+}
+
+// FormatScopeBreakdown renders per-class destination counts for a summary
+func FormatScopeBreakdown(scopes []GroupCount, metadataAccess []ProcessCount) string {
+	if len(scopes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("  By scope: %s\n", formatInlineCounts(scopes)) + FormatMetadataAccess(metadataAccess)
+}
+
+// countProcessFailures returns the processes with failed connect calls, most failures first
+func countProcessFailures(events []netclient.ConnectionEvent) []ProcessFailures {
+	type process struct {
+		pid     uint32
+		command string
+	}
+	byProcess := make(map[process]*ProcessFailures)
+	failedDestinations := make(map[process]map[string]int)
+	for _, event := range events {
+		key := process{event.PID, event.Command}
+		counts, ok := byProcess[key]
+		if !ok {
+			counts = &ProcessFailures{PID: event.PID, Command: event.Command}
+			byProcess[key] = counts
+			failedDestinations[key] = make(map[string]int)
+		}
+		counts.Attempts++
+		if analysis.IsFailure(event) {
+			counts.Failures++
+			failedDestinations[key][fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)]++
+		}
+	}
+
+	var failures []ProcessFailures
+	for key, counts := range byProcess {
+		if counts.Failures == 0 {
+			continue
+		}
+		counts.Destination = sortedCounts(failedDestinations[key])[0].Key
+		failures = append(failures, *counts)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Failures != failures[j].Failures {
+			return failures[i].Failures > failures[j].Failures
+		}
+		return failures[i].PID < failures[j].PID
+	})
+	return failures
+}
+
+// FormatFailures lists processes whose connections failed
+func FormatFailures(failures []ProcessFailures) string {
+	if len(failures) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("  ❌ Failed connections:\n")
+	for i, f := range failures {
+		if i == topListLimit {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("    %s (PID %d): %d of %d attempts failed (%.0f%%), mostly to %s\n",
+			f.Command, f.PID, f.Failures, f.Attempts, 100*f.Rate(), f.Destination))
+	}
+	return sb.String()
+}
+
+// FormatMetadataAccess warns about processes that contacted a cloud metadata endpoint
+func FormatMetadataAccess(processes []ProcessCount) string {
+	if len(processes) == 0 {
+		return ""
+	}
+
+	var parts []string
+	for _, p := range processes {
+		parts = append(parts, fmt.Sprintf("%s (PID %d, %d connections)", p.Command, p.PID, p.Count))
+	}
+	return fmt.Sprintf("  ⚠️  Cloud metadata endpoint contacted by: %s\n", strings.Join(parts, ", "))
+}
+
+// EventScope returns the destination class of an event, classifying it if not yet annotated
+func EventScope(event netclient.ConnectionEvent) string {
+	if event.Scope != "" {
+		return event.Scope
+	}
+	return string(enrich.ClassifyIP(event.DestinationIP))
+}
+
+// FilterByScope keeps events whose destination class is in the given list
+func FilterByScope(events []netclient.ConnectionEvent, scopes []enrich.Scope) []netclient.ConnectionEvent {
+	if len(scopes) == 0 {
+		return events
+	}
+
+	var filtered []netclient.ConnectionEvent
+	for _, event := range events {
+		scope := EventScope(event)
+		for _, s := range scopes {
+			if scope == string(s) {
+				filtered = append(filtered, event)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// sortedCounts converts a count map to a list ordered by count, then key
+func sortedCounts(counts map[string]int) []GroupCount {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	groups := make([]GroupCount, 0, len(counts))
+	for key, count := range counts {
+		groups = append(groups, GroupCount{Key: key, Count: count})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
+
+// writeGroupList writes up to limit counts as an indented list
+func writeGroupList(sb *strings.Builder, groups []GroupCount, limit int) {
+	if len(groups) < limit {
+		limit = len(groups)
+	}
+	for i := 0; i < limit; i++ {
+		sb.WriteString(fmt.Sprintf("    %s (%d connections)\n", groups[i].Key, groups[i].Count))
+	}
+}
+
+// formatInlineCounts renders counts on one line, e.g. "TCP (9), UDP (12)"
+func formatInlineCounts(groups []GroupCount) string {
+	parts := make([]string, 0, len(groups))
+	for _, group := range groups {
+		parts = append(parts, fmt.Sprintf("%s (%d)", group.Key, group.Count))
+	}
+	return strings.Join(parts, ", ")
+}
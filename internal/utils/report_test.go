@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srodi/netspy/internal/enrich"
+	"github.com/srodi/netspy/internal/netclient"
+)
+
+func TestBuildPatternReport_Scopes(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		makeEvent(10, "curl", "169.254.169.254", 80, "TCP", 100),
+		makeEvent(10, "curl", "169.254.169.254", 80, "TCP", 200),
+		makeEvent(11, "nginx", "127.0.0.1", 8080, "TCP", 300),
+		makeEvent(12, "app", "8.8.8.8", 443, "TCP", 400),
+		makeEvent(13, "snapd", "", 0, "Unknown(0)", 500),
+	}
+
+	report := BuildPatternReport(events)
+	scopes := make(map[string]int)
+	for _, scope := range report.Scopes {
+		scopes[scope.Key] = scope.Count
+	}
+	if scopes["cloud-metadata"] != 2 || scopes["loopback"] != 1 || scopes["public"] != 1 || scopes["unix"] != 1 {
+		t.Errorf("unexpected scope counts: %+v", report.Scopes)
+	}
+
+	if len(report.MetadataAccess) != 1 || report.MetadataAccess[0].Command != "curl" || report.MetadataAccess[0].Count != 2 {
+		t.Errorf("expected curl to be flagged for metadata access, got %+v", report.MetadataAccess)
+	}
+
+	out := FormatPatternReport(report)
+	if !strings.Contains(out, "Cloud metadata endpoint contacted by: curl (PID 10, 2 connections)") {
+		t.Errorf("expected metadata warning, got: %s", out)
+	}
+}
+
+func TestBuildPatternReport_Failures(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		makeEvent(20, "api", "10.0.0.5", 5432, "TCP", 100),
+		makeEvent(20, "api", "10.0.0.5", 5432, "TCP", 200),
+		makeEvent(20, "api", "10.0.0.6", 443, "TCP", 300),
+		makeEvent(20, "api", "10.0.0.7", 443, "TCP", 400),
+		makeEvent(21, "curl", "8.8.8.8", 443, "TCP", 500),
+	}
+	events[0].ReturnCode = -111 // ECONNREFUSED
+	events[1].ReturnCode = -111
+	events[2].ReturnCode = -110 // ETIMEDOUT
+	events[3].ReturnCode = -115 // EINPROGRESS is not a failure
+
+	report := BuildPatternReport(events)
+	if len(report.Failures) != 1 {
+		t.Fatalf("expected only api to have failures, got %+v", report.Failures)
+	}
+	f := report.Failures[0]
+	if f.Command != "api" || f.Attempts != 4 || f.Failures != 3 || f.Destination != "10.0.0.5:5432" || f.Rate() != 0.75 {
+		t.Errorf("unexpected failures %+v", f)
+	}
+
+	out := FormatPatternReport(report)
+	if !strings.Contains(out, "api (PID 20): 3 of 4 attempts failed (75%), mostly to 10.0.0.5:5432") {
+		t.Errorf("expected failed connections in the report, got: %s", out)
+	}
+}
+
+func TestFilterByScope(t *testing.T) {
+	events := []netclient.ConnectionEvent{
+		makeEvent(1, "a", "10.0.0.1", 80, "TCP", 1),
+		makeEvent(2, "b", "1.1.1.1", 53, "UDP", 2),
+		makeEvent(3, "c", "127.0.0.1", 80, "TCP", 3),
+	}
+
+	filtered := FilterByScope(events, []enrich.Scope{enrich.ScopePublic, enrich.ScopeLoopback})
+	if len(filtered) != 2 || filtered[0].Command != "b" || filtered[1].Command != "c" {
+		t.Errorf("unexpected filtered events: %+v", filtered)
+	}
+	if got := FilterByScope(events, nil); len(got) != 3 {
+		t.Errorf("expected no filtering without scopes, got %d", len(got))
+	}
+}
+
+func TestFormatPatternReport_Beacons(t *testing.T) {
+	var events []netclient.ConnectionEvent
+	for i := 0; i < 10; i++ {
+		events = append(events, makeEvent(42, "implant", "203.0.113.10", 443, "TCP", uint64(i)*uint64(30*time.Second)))
+	}
+	events = append(events, makeEvent(50, "curl", "93.184.216.34", 443, "TCP", 1))
+
+	report := BuildPatternReport(events)
+	if len(report.Beacons) != 1 || report.Beacons[0].Command != "implant" {
+		t.Fatalf("expected implant beacon, got %+v", report.Beacons)
+	}
+
+	output := FormatPatternReport(report)
+	if !strings.Contains(output, "implant (PID 42) → 203.0.113.10:443 every 30s") {
+		t.Errorf("expected beacon with its period in output:\n%s", output)
+	}
+}